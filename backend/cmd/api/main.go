@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/warren/finance-app/internal/database"
 	"github.com/warren/finance-app/internal/handlers"
 	"github.com/warren/finance-app/internal/middleware"
+	"github.com/warren/finance-app/internal/services"
+	"github.com/warren/finance-app/internal/storage"
 )
 
 func main() {
@@ -23,6 +27,22 @@ func main() {
 	}
 	defer database.Close()
 
+	// Original statement files
+	if err := storage.Init(); err != nil {
+		log.Fatalf("Failed to set up file storage: %v", err)
+	}
+
+	// Background job runner (statement imports, re-parses and recurring detection)
+	workers, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil || workers < 1 {
+		workers = 2
+	}
+	handlers.RegisterJobHandlers()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	services.StartJobRunner(ctx, workers)
+	services.StartRecurringScheduler(ctx)
+
 	// Setup router
 	r := gin.Default()
 
@@ -80,17 +100,99 @@ func main() {
 		api.DELETE("/transactions", handlers.DeleteTransactionsBatch)
 		api.GET("/transactions/:id/tags", handlers.GetTagsForTransaction)
 		api.PUT("/transactions/:id/tags", handlers.SetTransactionTags)
+		api.GET("/transactions/:id/splits", handlers.GetTransactionSplits)
+		api.PUT("/transactions/:id/splits", handlers.SetTransactionSplits)
 		api.POST("/transactions/link", handlers.LinkTransactions)
+		api.GET("/transactions/:id/links", handlers.GetTransactionLinks)
 		api.DELETE("/transactions/:id/link", handlers.UnlinkTransaction)
+		api.PUT("/transaction-links/:id", handlers.UpdateTransactionLink)
+		api.DELETE("/transaction-links/:id", handlers.DeleteTransactionLink)
 
 		// Dashboard
 		api.GET("/dashboard", handlers.GetDashboard)
 
+		// Bank layouts
+		api.GET("/bank-configs", handlers.GetBankConfigs)
+		api.POST("/bank-configs", handlers.CreateBankConfig)
+		api.GET("/bank-configs/:id", handlers.GetBankConfig)
+		api.PUT("/bank-configs/:id", handlers.UpdateBankConfig)
+		api.DELETE("/bank-configs/:id", handlers.DeleteBankConfig)
+
 		// Import
 		api.GET("/banks", handlers.GetBanks)
+		api.POST("/import/preview", handlers.PreviewImport)
 		api.POST("/import/upload", handlers.UploadFile)
 		api.POST("/import/confirm", handlers.ConfirmImport)
 		api.GET("/imports", handlers.GetImports)
+		api.GET("/imports/:id", handlers.GetImport)
+		api.GET("/imports/:id/rows", handlers.GetImportRows)
+		api.PUT("/imports/:id/rows/:rowId", handlers.UpdateImportRow)
+		api.POST("/imports/:id/rows/status", handlers.SetImportRowsStatus)
+		api.POST("/imports/:id/commit", handlers.CommitImport)
+		api.GET("/imports/:id/transactions", handlers.GetImportTransactions)
+		api.GET("/imports/:id/file", handlers.DownloadImportFile)
+		api.POST("/imports/:id/revert", handlers.RevertImport)
+		api.POST("/imports/:id/reparse", handlers.ReparseImport)
+		api.POST("/imports/:id/reparse/:jobId/apply", handlers.ApplyReparse)
+
+		// Rules
+		api.GET("/rules", handlers.GetRules)
+		api.POST("/rules", handlers.CreateRule)
+		api.POST("/rules/preview", handlers.PreviewRules)
+		api.POST("/rules/run", handlers.RunRules)
+		api.GET("/rules/:id", handlers.GetRule)
+		api.PUT("/rules/:id", handlers.UpdateRule)
+		api.DELETE("/rules/:id", handlers.DeleteRule)
+
+		// Merchants
+		api.GET("/merchants", handlers.GetMerchants)
+		api.POST("/merchants", handlers.CreateMerchant)
+		api.GET("/merchants/spending", handlers.GetMerchantSpending)
+		api.POST("/merchants/resolve", handlers.ResolveMerchants)
+		api.GET("/merchants/:id", handlers.GetMerchant)
+		api.PUT("/merchants/:id", handlers.UpdateMerchant)
+		api.DELETE("/merchants/:id", handlers.DeleteMerchant)
+		api.POST("/merchants/:id/merge", handlers.MergeMerchants)
+
+		// Transfers between own accounts
+		api.GET("/transfers", handlers.GetTransfers)
+		api.POST("/transfers", handlers.CreateTransfer)
+		api.POST("/transfers/detect", handlers.DetectTransfers)
+		api.POST("/transfers/:id/confirm", handlers.ConfirmTransfer)
+		api.POST("/transfers/:id/reject", handlers.RejectTransfer)
+
+		// People and shared expenses
+		api.GET("/people", handlers.GetPeople)
+		api.POST("/people", handlers.CreatePerson)
+		api.GET("/people/:id", handlers.GetPerson)
+		api.PUT("/people/:id", handlers.UpdatePerson)
+		api.DELETE("/people/:id", handlers.DeletePerson)
+		api.GET("/people/:id/history", handlers.GetPersonHistory)
+		api.POST("/people/:id/shared-expenses", handlers.CreateSharedExpense)
+		api.POST("/people/:id/settlements", handlers.CreateSettlement)
+		api.DELETE("/shared-expenses/:id", handlers.DeleteSharedExpense)
+		api.DELETE("/settlements/:id", handlers.DeleteSettlement)
+		api.GET("/transactions/:id/shares", handlers.GetTransactionShares)
+		api.PUT("/transactions/:id/shares", handlers.SetTransactionShares)
+
+		// Recurring transactions
+		api.GET("/recurring", handlers.GetRecurringTemplates)
+		api.POST("/recurring", handlers.CreateRecurringTemplate)
+		api.GET("/recurring/upcoming", handlers.GetUpcomingRecurring)
+		api.POST("/recurring/detect", handlers.DetectRecurring)
+		api.POST("/recurring/run", handlers.RunRecurring)
+		api.GET("/recurring/:id", handlers.GetRecurringTemplate)
+		api.PUT("/recurring/:id", handlers.UpdateRecurringTemplate)
+		api.DELETE("/recurring/:id", handlers.DeleteRecurringTemplate)
+		api.POST("/recurring/:id/accept", handlers.ActivateRecurringTemplate)
+		api.POST("/recurring/:id/pause", handlers.PauseRecurringTemplate)
+		api.POST("/recurring/:id/dismiss", handlers.DismissRecurringTemplate)
+		api.GET("/recurring/:id/occurrences", handlers.GetRecurringOccurrences)
+		api.POST("/recurring-occurrences/:id/skip", handlers.SkipRecurringOccurrence)
+
+		// Background jobs
+		api.GET("/jobs/:id", handlers.GetJob)
+		api.GET("/jobs/:id/events", handlers.StreamJob)
 	}
 
 	// Get port from environment or default
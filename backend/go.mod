@@ -10,6 +10,8 @@ require (
 	github.com/lib/pq v1.10.9
 	github.com/xuri/excelize/v2 v2.8.0
 	golang.org/x/crypto v0.46.0
+	golang.org/x/text v0.32.0
+	google.golang.org/api v0.258.0
 )
 
 require (
@@ -54,8 +56,6 @@ require (
 	golang.org/x/net v0.48.0 // indirect
 	golang.org/x/oauth2 v0.34.0 // indirect
 	golang.org/x/sys v0.39.0 // indirect
-	golang.org/x/text v0.32.0 // indirect
-	google.golang.org/api v0.258.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20251213004720-97cd9d5aeac2 // indirect
 	google.golang.org/grpc v1.77.0 // indirect
 	google.golang.org/protobuf v1.36.11 // indirect
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/warren/finance-app/internal/services"
+)
+
+type BankConfigRequest struct {
+	Name           string   `json:"name" binding:"required"`
+	HeaderRow      int      `json:"header_row"`
+	DateCol        int      `json:"date_col"`
+	DescriptionCol int      `json:"description_col"`
+	AmountCol      *int     `json:"amount_col"`
+	DebitCol       *int     `json:"debit_col"`
+	CreditCol      *int     `json:"credit_col"`
+	TypeCol        *int     `json:"type_col"`
+	BalanceCol     *int     `json:"balance_col"`
+	AmountMode     string   `json:"amount_mode"`
+	DateFormat     string   `json:"date_format"`
+	NumberFormat   string   `json:"number_format"`
+	CurrencySymbol string   `json:"currency_symbol"`
+	Currency       string   `json:"currency"`
+	SignConvention string   `json:"sign_convention"`
+	SkipPatterns   []string `json:"skip_patterns"`
+}
+
+// toBankConfig converts the request into a normalized layout; unset optional columns become -1
+func (r BankConfigRequest) toBankConfig() (services.BankConfig, error) {
+	optionalCol := func(col *int) int {
+		if col == nil {
+			return -1
+		}
+		return *col
+	}
+
+	config := services.BankConfig{
+		Name:           r.Name,
+		HeaderRow:      r.HeaderRow,
+		DateCol:        r.DateCol,
+		DescriptionCol: r.DescriptionCol,
+		AmountCol:      optionalCol(r.AmountCol),
+		DebitCol:       optionalCol(r.DebitCol),
+		CreditCol:      optionalCol(r.CreditCol),
+		TypeCol:        optionalCol(r.TypeCol),
+		BalanceCol:     optionalCol(r.BalanceCol),
+		AmountMode:     r.AmountMode,
+		DateFormat:     r.DateFormat,
+		NumberFormat:   r.NumberFormat,
+		CurrencySymbol: r.CurrencySymbol,
+		Currency:       r.Currency,
+		SignConvention: r.SignConvention,
+		SkipPatterns:   r.SkipPatterns,
+	}
+	err := config.Normalize()
+	return config, err
+}
+
+// GetBankConfigs returns the user's custom bank layouts
+func GetBankConfigs(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	configs, err := services.ListBankConfigs(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching bank layouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, configs)
+}
+
+// GetBankConfig returns a single custom bank layout
+func GetBankConfig(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	configID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bank layout ID"})
+		return
+	}
+
+	config, err := services.GetBankConfig(userID, strconv.Itoa(configID))
+	if err == services.ErrBankConfigNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bank layout not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching bank layout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, config)
+}
+
+// CreateBankConfig creates a new custom bank layout
+func CreateBankConfig(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req BankConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	config, err := req.toBankConfig()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := services.CreateBankConfig(userID, config)
+	if err == services.ErrBankConfigExists {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating bank layout"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateBankConfig updates an existing custom bank layout
+func UpdateBankConfig(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	configID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bank layout ID"})
+		return
+	}
+
+	var req BankConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	config, err := req.toBankConfig()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := services.UpdateBankConfig(userID, configID, config)
+	if err == services.ErrBankConfigNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bank layout not found"})
+		return
+	}
+	if err == services.ErrBankConfigExists {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating bank layout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteBankConfig deletes a custom bank layout
+func DeleteBankConfig(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	configID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bank layout ID"})
+		return
+	}
+
+	err = services.DeleteBankConfig(userID, configID)
+	if err == services.ErrBankConfigNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bank layout not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting bank layout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Bank layout deleted"})
+}
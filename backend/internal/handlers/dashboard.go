@@ -9,6 +9,7 @@ import (
 	"github.com/lib/pq"
 	"github.com/warren/finance-app/internal/database"
 	"github.com/warren/finance-app/internal/models"
+	"github.com/warren/finance-app/internal/services"
 )
 
 func GetDashboard(c *gin.Context) {
@@ -36,9 +37,13 @@ func GetDashboard(c *gin.Context) {
 		accountTypeFilter = " AND a.account_type = '" + accountType + "'"
 	}
 
+	// Transfers between own accounts (confirmed transfers or flagged by a rule)
+	// and ignored transactions don't count towards income, expenses or tag totals
+	countedFilter := " AND NOT t.is_transfer AND NOT t.ignored"
+
 	// Build linked filter - when not including linked, we calculate net amounts
-	// For linked transactions: we only count the difference (expense - reimbursement)
-	// We process linked pairs by only counting the expense side with adjusted amount
+	// Each transaction counts only the part of its amount not allocated to
+	// reimbursement links (expense - reimbursements, income - what it reimbursed)
 	var totalsQuery string
 	if includeLinked {
 		// Show all transactions at full value
@@ -49,45 +54,29 @@ func GetDashboard(c *gin.Context) {
 				COUNT(*) as transaction_count
 			FROM transactions t
 			LEFT JOIN accounts a ON t.account_id = a.id
-			WHERE t.user_id = $1 AND t.date BETWEEN $2 AND $3` + accountTypeFilter
+			WHERE t.user_id = $1 AND t.date BETWEEN $2 AND $3` + accountTypeFilter + countedFilter
 	} else {
 		// Show net amounts for linked transactions
 		// For unlinked: count normally
-		// For linked pairs: only count the net difference (expense - income)
-		// We handle this by: unlinked transactions + (expense amount - linked income amount) for linked pairs
+		// For linked: count what's left after the allocations, and only when something is left
 		totalsQuery = `
 			WITH linked_pairs AS (
-				-- Get all linked expense transactions with their reimbursement
+				-- Net amount of every transaction after its link allocations
 				SELECT
-					e.id as expense_id,
-					e.amount as expense_amount,
-					i.amount as income_amount,
-					e.currency as currency,
-					GREATEST(e.amount - i.amount, 0) as net_expense,
-					GREATEST(i.amount - e.amount, 0) as net_income
-				FROM transactions e
-				JOIN transactions i ON e.linked_to = i.id
-				WHERE e.user_id = $1
-				  AND e.type = 'expense'
-				  AND i.type = 'income'
-				  AND e.date BETWEEN $2 AND $3
-			),
-			unlinked AS (
-				-- Get unlinked transactions
-				SELECT t.type, t.amount
+					t.type,
+					GREATEST(t.amount - COALESCE(lt.allocated, 0), 0) as net_amount,
+					lt.transaction_id IS NOT NULL as linked
 				FROM transactions t
 				LEFT JOIN accounts a ON t.account_id = a.id
+				LEFT JOIN transaction_link_totals lt ON lt.transaction_id = t.id
 				WHERE t.user_id = $1
-				  AND t.date BETWEEN $2 AND $3
-				  AND t.linked_to IS NULL` + accountTypeFilter + `
+				  AND t.date BETWEEN $2 AND $3` + accountTypeFilter + countedFilter + `
 			)
 			SELECT
-				COALESCE((SELECT SUM(amount) FROM unlinked WHERE type = 'income'), 0) +
-				COALESCE((SELECT SUM(net_income) FROM linked_pairs), 0) as total_income,
-				COALESCE((SELECT SUM(amount) FROM unlinked WHERE type = 'expense'), 0) +
-				COALESCE((SELECT SUM(net_expense) FROM linked_pairs), 0) as total_expense,
-				(SELECT COUNT(*) FROM unlinked) +
-				(SELECT COUNT(*) FROM linked_pairs WHERE net_expense > 0 OR net_income > 0) as transaction_count`
+				COALESCE(SUM(CASE WHEN type = 'income' THEN net_amount ELSE 0 END), 0) as total_income,
+				COALESCE(SUM(CASE WHEN type = 'expense' THEN net_amount ELSE 0 END), 0) as total_expense,
+				COUNT(*) FILTER (WHERE NOT linked OR net_amount > 0) as transaction_count
+			FROM linked_pairs`
 	}
 
 	err := database.DB.QueryRow(totalsQuery, userID, startDate, endDate).Scan(&summary.TotalIncome, &summary.TotalExpense, &summary.TransactionCount)
@@ -103,24 +92,29 @@ func GetDashboard(c *gin.Context) {
 	// Get breakdown by tag - group by tag AND type so each tag can appear once per transaction type
 	// Also calculate totals by currency (PEN and USD)
 	// When not including linked, exclude fully linked transactions from tag summary
+	// and count partly linked ones in proportion to what's left of them
 	linkedFilter := ""
+	tagAmount := "tt.amount"
 	if !includeLinked {
-		linkedFilter = " AND t.linked_to IS NULL"
+		linkedFilter = " AND t.amount > COALESCE(lt.allocated, 0)"
+		tagAmount = "tt.amount * (t.amount - COALESCE(lt.allocated, 0)) / t.amount"
 	}
 
+	// Split transactions count each split under its own tags
 	tagQuery := `
 		SELECT
 			tg.id, tg.name, tg.color,
-			COALESCE(SUM(t.amount), 0) as total,
-			COALESCE(SUM(CASE WHEN t.currency = 'PEN' THEN t.amount ELSE 0 END), 0) as total_pen,
-			COALESCE(SUM(CASE WHEN t.currency = 'USD' THEN t.amount ELSE 0 END), 0) as total_usd,
+			COALESCE(SUM(` + tagAmount + `), 0) as total,
+			COALESCE(SUM(CASE WHEN t.currency = 'PEN' THEN ` + tagAmount + ` ELSE 0 END), 0) as total_pen,
+			COALESCE(SUM(CASE WHEN t.currency = 'USD' THEN ` + tagAmount + ` ELSE 0 END), 0) as total_usd,
 			COUNT(DISTINCT t.id) as count,
 			t.type
 		FROM tags tg
-		JOIN transaction_tags tt ON tg.id = tt.tag_id
+		JOIN transaction_tag_amounts tt ON tg.id = tt.tag_id
 		JOIN transactions t ON tt.transaction_id = t.id
 		LEFT JOIN accounts a ON t.account_id = a.id
-		WHERE tg.user_id = $1 AND t.date BETWEEN $2 AND $3` + accountTypeFilter + linkedFilter + `
+		LEFT JOIN transaction_link_totals lt ON lt.transaction_id = t.id
+		WHERE tg.user_id = $1 AND t.date BETWEEN $2 AND $3` + accountTypeFilter + linkedFilter + countedFilter + `
 		GROUP BY tg.id, tg.name, tg.color, t.type
 		HAVING COUNT(DISTINCT t.id) > 0
 		ORDER BY total DESC`
@@ -148,9 +142,10 @@ func GetDashboard(c *gin.Context) {
 	// Get recent transactions (when not including linked, filter them out)
 	recentQuery := `
 		SELECT t.id, t.user_id, t.description, t.detail, t.amount, t.currency, t.type,
-		       t.date, t.source, t.linked_to, t.created_at, t.updated_at
+		       t.date, t.source, COALESCE(lt.allocated, 0), t.created_at, t.updated_at
 		FROM transactions t
 		LEFT JOIN accounts a ON t.account_id = a.id
+		LEFT JOIN transaction_link_totals lt ON lt.transaction_id = t.id
 		WHERE t.user_id = $1 AND t.date BETWEEN $2 AND $3` + accountTypeFilter + linkedFilter + `
 		ORDER BY t.date DESC, t.created_at DESC
 		LIMIT 10`
@@ -168,7 +163,7 @@ func GetDashboard(c *gin.Context) {
 		var t models.Transaction
 		if err := recentRows.Scan(
 			&t.ID, &t.UserID, &t.Description, &t.Detail, &t.Amount, &t.Currency, &t.Type,
-			&t.Date, &t.Source, &t.LinkedTo, &t.CreatedAt, &t.UpdatedAt,
+			&t.Date, &t.Source, &t.LinkedAmount, &t.CreatedAt, &t.UpdatedAt,
 		); err != nil {
 			continue
 		}
@@ -207,5 +202,11 @@ func GetDashboard(c *gin.Context) {
 		summary.RecentTx = []models.Transaction{}
 	}
 
+	// Recurring charges and incomes due in the coming days
+	summary.Upcoming, _ = services.UpcomingRecurring(userID, time.Now(), services.RecurringUpcomingDays)
+	if summary.Upcoming == nil {
+		summary.Upcoming = []models.UpcomingCharge{}
+	}
+
 	c.JSON(http.StatusOK, summary)
 }
@@ -1,36 +1,72 @@
 package handlers
 
 import (
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lib/pq"
 	"github.com/warren/finance-app/internal/database"
 	"github.com/warren/finance-app/internal/services"
+	"github.com/warren/finance-app/internal/storage"
 )
 
 // TransactionWithSuggestion includes parsed transaction with tag suggestions
 type TransactionWithSuggestion struct {
-	Description      string  `json:"description"`
-	Detail           *string `json:"detail"`
-	Amount           float64 `json:"amount"`
-	Currency         string  `json:"currency"`
-	Type             string  `json:"type"`
-	Date             string  `json:"date"`
-	RawText          string  `json:"raw_text"`
-	SuggestedTagIDs  []int   `json:"suggested_tag_ids"`
-	SuggestedDetail  *string `json:"suggested_detail"`
-	IsDuplicate      bool    `json:"is_duplicate"`
-	ExistingTagIDs   []int   `json:"existing_tag_ids"`
+	Description     string   `json:"description"`
+	Detail          *string  `json:"detail"`
+	Amount          float64  `json:"amount"`
+	Currency        string   `json:"currency"`
+	Type            string   `json:"type"`
+	Date            string   `json:"date"`
+	RawText         string   `json:"raw_text"`
+	ExternalID      string   `json:"external_id,omitempty"`
+	ValueDate       string   `json:"value_date,omitempty"`
+	Counterparty    string   `json:"counterparty,omitempty"`
+	Reference       string   `json:"reference,omitempty"`
+	Balance         *float64 `json:"balance,omitempty"`
+	BalanceMismatch bool     `json:"balance_mismatch,omitempty"`
+	Row             int      `json:"row,omitempty"`
+	Error           string   `json:"error,omitempty"`
+	Sheet           string   `json:"sheet,omitempty"`
+	AccountID       int      `json:"account_id,omitempty"`
+	SuggestedTagIDs []int    `json:"suggested_tag_ids"`
+	SuggestedDetail *string  `json:"suggested_detail"`
+	IsDuplicate     bool     `json:"is_duplicate"`
+	ExistingTagIDs  []int    `json:"existing_tag_ids"`
+	RowID           int      `json:"row_id"` // Staged import row
+	Status          string   `json:"status"` // Staged row status: pending, duplicate or error
+
+	// Saved transactions that may be this same movement, best first, and the
+	// score (0-1) of the one matched to this row. IsDuplicate is set from
+	// services.DuplicateThreshold.
+	DuplicateConfidence float64                       `json:"duplicate_confidence"`
+	DuplicateCandidates []services.DuplicateCandidate `json:"duplicate_candidates"`
+
+	// Set by the user's rules (see services.ApplyRules)
+	RuleIDs    []int `json:"rule_ids"`
+	IsTransfer bool  `json:"is_transfer"`
+	Ignored    bool  `json:"ignored"` // Staged as skipped
+
+	// Ranked by the tag classifier when no rule or earlier transaction suggests tags
+	TagPredictions []services.TagPrediction `json:"tag_predictions,omitempty"`
+
+	// Known merchant of the description; new merchants are created on commit
+	MerchantID   *int   `json:"merchant_id,omitempty"`
+	MerchantName string `json:"merchant_name,omitempty"`
 }
 
 // GetBanks returns list of supported banks
 func GetBanks(c *gin.Context) {
-	banks := services.GetSupportedBanks()
+	userID := c.GetInt("user_id")
+	banks := services.GetSupportedBanks(userID)
 	c.JSON(http.StatusOK, banks)
 }
 
@@ -51,17 +87,31 @@ func UploadFile(c *gin.Context) {
 	}
 
 	// Check if account is a credit card (for sign inversion)
-	var accountType string
-	var accountBank *string
-	err := database.DB.QueryRow(`SELECT account_type, bank FROM accounts WHERE id = $1 AND user_id = $2`,
-		accountID, userID).Scan(&accountType, &accountBank)
+	accID, err := strconv.Atoi(accountID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account"})
+		return
+	}
+	invertSigns, err := accountInvertsSigns(userID, accID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account"})
 		return
 	}
 
-	// Determine if we need to invert signs (credit cards from BBVA)
-	invertSigns := accountType == "credit" && accountBank != nil && *accountBank == "BBVA"
+	// Workbook sheets to import, each optionally into its own account
+	sheets, allSheets, err := sheetImports(c, userID, invertSigns)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Explicit column mapping from the preview step overrides the bank layout
+	saveLayout := strings.TrimSpace(c.PostForm("save_layout"))
+	mapping, dateFormat, numberFormat, err := layoutOverrides(c, saveLayout)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	file, err := c.FormFile("file")
 	if err != nil {
@@ -78,66 +128,336 @@ func UploadFile(c *gin.Context) {
 		".png":  true,
 		".jpg":  true,
 		".jpeg": true,
+		".ofx":  true,
+		".qfx":  true,
+		".xml":  true,
+		".sta":  true,
+		".940":  true,
+		".pdf":  true,
 	}
 
 	if !validExts[ext] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file type. Supported: xlsx, xls, csv, png, jpg, jpeg"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file type. Supported: xlsx, xls, csv, ofx, qfx, xml (camt.053/052), sta/940 (MT940), pdf, png, jpg, jpeg"})
 		return
 	}
 
-	// Create uploads directory
-	uploadsDir := "./uploads"
-	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating uploads directory"})
+	// Archive the original statement; the job reads it back from the blob store
+	fileHash, fileSize, err := archiveUpload(c, file)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving file"})
 		return
 	}
 
-	// Save file
-	filename := filepath.Join(uploadsDir, file.Filename)
-	if err := c.SaveUploadedFile(file, filename); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving file"})
+	// The same file imported before is refused unless force=true. Statements
+	// with the same rows are caught by the job once the file is parsed.
+	force := c.PostForm("force") == "true"
+	if !force {
+		matches, err := services.FindStatementMatches(userID, accID, fileHash, services.StatementFingerprint{}, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking previous imports"})
+			return
+		}
+		if len(matches) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":             duplicateStatementError(matches[0]),
+				"statement_matches": matches,
+			})
+			return
+		}
+	}
+
+	params := importJobParams{
+		FileHash:    fileHash,
+		FileName:    filepath.Base(file.Filename),
+		FileSize:    fileSize,
+		BankID:      bankID,
+		AccountID:   accID,
+		InvertSigns: invertSigns,
+		Sheets:      sheets,
+		AllSheets:   allSheets,
+		Force:       force,
+	}
+	if ext == ".xlsx" || ext == ".xls" || ext == ".csv" {
+		layout, err := spreadsheetLayout(userID, bankID, mapping, dateFormat, numberFormat)
+		if err == services.ErrBankConfigNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bank layout not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		params.Layout = &layout
+		params.Mapping = mapping
+		params.DateFormat = dateFormat
+		params.NumberFormat = numberFormat
+		params.SaveMapping = mapping != nil && saveLayout != ""
+	}
+
+	async := c.PostForm("async") == "true"
+	job, err := services.EnqueueJob(userID, importJobKind, params, async)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error queueing import"})
+		return
+	}
+
+	// Large statements: answer right away and let the client follow the job
+	if async {
+		c.JSON(http.StatusAccepted, gin.H{
+			"job_id":  job.ID,
+			"status":  job.Status,
+			"message": "File queued for processing",
+		})
+		return
+	}
+
+	job, err = services.RunJobInline(c.Request.Context(), job)
+	if err == services.ErrJobStillRunning {
+		c.JSON(http.StatusAccepted, gin.H{
+			"job_id":  job.ID,
+			"status":  services.JobRunning,
+			"message": "File is still being processed",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error processing file"})
+		return
+	}
+	if job.Status == services.JobFailed {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": *job.Error})
 		return
 	}
 
-	// Process based on file type
-	var transactions []services.ParsedTransaction
-	var importID int
+	// A statement already imported is reported as a conflict
+	var result struct {
+		Status string `json:"status"`
+	}
+	json.Unmarshal(job.Result, &result)
+	if result.Status == importRejected {
+		c.Data(http.StatusConflict, "application/json; charset=utf-8", job.Result)
+		return
+	}
 
-	isImage := ext == ".png" || ext == ".jpg" || ext == ".jpeg"
+	c.Data(http.StatusOK, "application/json; charset=utf-8", job.Result)
+}
+
+// duplicateStatementError describes the earlier import of the same statement
+func duplicateStatementError(match services.StatementMatch) string {
+	return fmt.Sprintf("This statement was already imported (import %d, %s, on %s); upload it with force=true to import it again",
+		match.ImportID, match.Filename, match.CreatedAt.Format("2006-01-02"))
+}
 
-	if isImage {
-		transactions, importID, err = services.ProcessImageFile(filename, userID)
-	} else {
-		transactions, importID, err = services.ProcessExcelFile(filename, userID, bankID, invertSigns)
+// archiveUpload stores an uploaded file in the blob store and returns its SHA-256 and size
+func archiveUpload(c *gin.Context, file *multipart.FileHeader) (string, int64, error) {
+	tmpDir, err := os.MkdirTemp("", "upload-")
+	if err != nil {
+		return "", 0, err
 	}
+	defer os.RemoveAll(tmpDir)
 
+	path := filepath.Join(tmpDir, filepath.Base(file.Filename))
+	if err := c.SaveUploadedFile(file, path); err != nil {
+		return "", 0, err
+	}
+	return storage.SaveFile(storage.Blobs, path)
+}
+
+// accountInvertsSigns reports whether imports into the account need their signs
+// inverted (BBVA credit cards). Fails when the account doesn't belong to the user.
+func accountInvertsSigns(userID int, accountID int) (bool, error) {
+	var accountType string
+	var accountBank *string
+	err := database.DB.QueryRow(`SELECT account_type, bank FROM accounts WHERE id = $1 AND user_id = $2`,
+		accountID, userID).Scan(&accountType, &accountBank)
+	if err != nil {
+		return false, err
+	}
+	return accountType == "credit" && accountBank != nil && *accountBank == "BBVA", nil
+}
+
+// sheetImports reads the optional "sheets" field: "all", or a JSON list of
+// {sheet, account_id, currency}. Sheets mapped to another account take that
+// account's sign convention. Returns nil when only the first sheet is wanted.
+func sheetImports(c *gin.Context, userID int, invertSigns bool) ([]services.SheetImport, bool, error) {
+	raw := strings.TrimSpace(c.PostForm("sheets"))
+	if raw == "" {
+		return nil, false, nil
+	}
+	if raw == "all" {
+		return nil, true, nil
+	}
+
+	var sheets []services.SheetImport
+	if err := json.Unmarshal([]byte(raw), &sheets); err != nil || len(sheets) == 0 {
+		return nil, false, fmt.Errorf("invalid sheets")
+	}
+
+	for i := range sheets {
+		sheets[i].InvertSigns = invertSigns
+		if sheets[i].AccountID == 0 {
+			continue
+		}
+		invert, err := accountInvertsSigns(userID, sheets[i].AccountID)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid account for sheet %q", sheets[i].Sheet)
+		}
+		sheets[i].InvertSigns = invert
+	}
+	return sheets, false, nil
+}
+
+// allWorkbookSheets selects every sheet of the workbook for the upload's account
+func allWorkbookSheets(filePath string, invertSigns bool) ([]services.SheetImport, error) {
+	names, err := services.ListSheets(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var sheets []services.SheetImport
+	for _, name := range names {
+		sheets = append(sheets, services.SheetImport{Sheet: name, InvertSigns: invertSigns})
+	}
+	return sheets, nil
+}
+
+// layoutOverrides reads the optional column mapping sent after a preview and the
+// per-import date_format/number_format fields. The formats are applied to the
+// mapping too, so a saved layout keeps them.
+func layoutOverrides(c *gin.Context, name string) (*services.BankConfig, string, string, error) {
+	dateFormat := strings.TrimSpace(c.PostForm("date_format"))
+	numberFormat := strings.TrimSpace(c.PostForm("number_format"))
+	if err := services.ValidateDateFormat(dateFormat); err != nil {
+		return nil, "", "", err
+	}
+	if err := services.ValidateNumberFormat(numberFormat); err != nil {
+		return nil, "", "", err
+	}
+
+	raw := c.PostForm("mapping")
+	if raw == "" {
+		return nil, dateFormat, numberFormat, nil
+	}
+
+	var req BankConfigRequest
+	if err := json.Unmarshal([]byte(raw), &req); err != nil {
+		return nil, "", "", fmt.Errorf("invalid mapping")
+	}
+	req.Name = name
+	if req.Name == "" {
+		req.Name = "Mapeo manual"
+	}
+	if dateFormat != "" {
+		req.DateFormat = dateFormat
+	}
+	if numberFormat != "" {
+		req.NumberFormat = numberFormat
+	}
+
+	config, err := req.toBankConfig()
+	if err != nil {
+		return nil, "", "", err
+	}
+	return &config, dateFormat, numberFormat, nil
+}
+
+// spreadsheetLayout picks the explicit mapping or the bank's layout and
+// applies the per-import formats
+func spreadsheetLayout(userID int, bankID string, mapping *services.BankConfig, dateFormat, numberFormat string) (services.BankConfig, error) {
+	if mapping != nil {
+		return *mapping, nil
+	}
+
+	config, err := services.GetBankConfig(userID, bankID)
+	if err != nil {
+		return config, err
+	}
+	if dateFormat != "" {
+		config.DateFormat = dateFormat
+	}
+	if numberFormat != "" {
+		config.NumberFormat = numberFormat
+	}
+	return config, nil
+}
+
+// PreviewImport returns the first rows of a spreadsheet and the column mapping
+// the import would use, so the user can correct it before parsing
+func PreviewImport(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	bankID := c.PostForm("bank")
+	if bankID == "" {
+		bankID = "generic"
+	}
+
+	limit, err := strconv.Atoi(c.DefaultPostForm("rows", "20"))
+	if err != nil || limit < 1 {
+		limit = 20
+	}
+	if limit > 200 {
+		limit = 200
+	}
+
+	mapping, dateFormat, numberFormat, err := layoutOverrides(c, "")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	layout, err := spreadsheetLayout(userID, bankID, mapping, dateFormat, numberFormat)
+	if err == services.ErrBankConfigNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bank layout not found"})
+		return
+	}
 	if err != nil {
-		os.Remove(filename)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Clean up file
-	os.Remove(filename)
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
 
-	// Enhance transactions with suggestions and duplicate detection
-	enhancedTransactions := enhanceTransactionsWithSuggestions(userID, transactions)
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if ext != ".xlsx" && ext != ".xls" && ext != ".csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Preview is only available for xlsx, xls and csv files"})
+		return
+	}
 
-	// Include account_id in response for confirm step
-	_ = accountID // Will be used in confirm
+	uploadsDir := "./uploads"
+	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating uploads directory"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"import_id":    importID,
-		"transactions": enhancedTransactions,
-		"count":        len(enhancedTransactions),
-		"bank":         bankID,
-		"message":      "File processed. Please assign categories to transactions.",
-	})
+	tmp, err := os.CreateTemp(uploadsDir, "preview-*"+ext)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving file"})
+		return
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := c.SaveUploadedFile(file, tmp.Name()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving file"})
+		return
+	}
+
+	preview, err := services.PreviewSpreadsheet(tmp.Name(), c.PostForm("sheet"), layout, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, preview)
 }
 
 // enhanceTransactionsWithSuggestions checks for duplicates and suggests tags/details
 // Optimized version: uses batch queries instead of per-transaction queries
-func enhanceTransactionsWithSuggestions(userID int, transactions []services.ParsedTransaction) []TransactionWithSuggestion {
+func enhanceTransactionsWithSuggestions(userID int, accountID int, transactions []services.ParsedTransaction) []TransactionWithSuggestion {
 	if len(transactions) == 0 {
 		return []TransactionWithSuggestion{}
 	}
@@ -154,6 +474,16 @@ func enhanceTransactionsWithSuggestions(userID int, transactions []services.Pars
 			Type:            tx.Type,
 			Date:            tx.Date,
 			RawText:         tx.RawText,
+			ExternalID:      tx.ExternalID,
+			ValueDate:       tx.ValueDate,
+			Counterparty:    tx.Counterparty,
+			Reference:       tx.Reference,
+			Balance:         tx.Balance,
+			BalanceMismatch: tx.BalanceMismatch,
+			Row:             tx.Row,
+			Error:           tx.Error,
+			Sheet:           tx.Sheet,
+			AccountID:       tx.AccountID,
 			IsDuplicate:     false,
 			SuggestedTagIDs: []int{},
 			SuggestedDetail: nil,
@@ -161,28 +491,109 @@ func enhanceTransactionsWithSuggestions(userID int, transactions []services.Pars
 		}
 	}
 
-	// Build a map for quick lookup of existing transactions (for duplicate detection)
-	existingTxMap := loadExistingTransactions(userID, transactions)
+	// Saved transactions near each row's date with the same amount, scored as
+	// possible duplicates of the rows
+	duplicateMatches := services.MatchDuplicates(transactions, accountID, loadDuplicateCandidates(userID, transactions))
+
+	// Bank-assigned IDs (OFX FITID) are a reliable duplicate key when present
+	existingExternalMap := loadExistingExternalIDs(userID, accountID, transactions)
 
 	// Load suggestions based on exact description match (detail + tags)
 	suggestionMap := loadSuggestionsByDescription(userID, transactions)
 
+	// The user's rules take precedence over suggestions copied from past transactions
+	rules, err := services.LoadEnabledRules(userID)
+	if err != nil {
+		rules = nil
+	}
+
+	// Tags learned from the user's history, for rows nothing else suggests tags for
+	classifier, err := services.TagModel(userID)
+	if err != nil {
+		classifier = nil
+	}
+
+	merchants, err := services.NewMerchantResolver(userID)
+	if err != nil {
+		merchants = nil
+	}
+
 	// Process each transaction using the preloaded data
 	for i, tx := range transactions {
-		key := buildTxKey(tx.Description, tx.Amount, tx.Date)
 		descKey := strings.ToLower(strings.TrimSpace(tx.Description))
+		match := duplicateMatches[i]
+		result[i].DuplicateCandidates = match.Candidates
+		result[i].RuleIDs = []int{}
 
-		if existing, ok := existingTxMap[key]; ok {
-			// Exact duplicate (same description, amount, date)
+		if existing, ok := existingExternalMap[tx.ExternalID]; ok && tx.ExternalID != "" {
+			// Same bank-assigned ID already imported into this account
 			result[i].IsDuplicate = true
+			result[i].DuplicateConfidence = 1
+			result[i].DuplicateCandidates = []services.DuplicateCandidate{{
+				TransactionID: existing.ID,
+				Score:         1,
+				Description:   existing.Description,
+				Date:          existing.Date,
+				AccountID:     &accountID,
+			}}
 			result[i].ExistingTagIDs = existing.TagIDs
 			result[i].SuggestedTagIDs = existing.TagIDs
+		} else if match.Best != nil && match.Confidence >= services.DuplicateThreshold {
+			// Same movement already saved, possibly with a shifted date or truncated description
+			result[i].IsDuplicate = true
+			result[i].DuplicateConfidence = match.Confidence
+			result[i].ExistingTagIDs = match.Best.TagIDs
+			result[i].SuggestedTagIDs = match.Best.TagIDs
 		} else {
+			result[i].DuplicateConfidence = match.Confidence
+
 			// Not a duplicate - look for suggestions based on description
 			if suggestion, ok := suggestionMap[descKey]; ok {
 				result[i].SuggestedTagIDs = suggestion.TagIDs
 				result[i].SuggestedDetail = suggestion.Detail
 			}
+
+			if merchants != nil {
+				if merchant := merchants.Match(tx.Description); merchant != nil {
+					result[i].MerchantID = &merchant.ID
+					result[i].MerchantName = merchant.Name
+					if len(result[i].SuggestedTagIDs) == 0 {
+						result[i].SuggestedTagIDs = merchant.DefaultTagIDs
+					}
+				}
+			}
+
+			if tx.Error == "" {
+				rowAccountID := accountID
+				if tx.AccountID != 0 {
+					rowAccountID = tx.AccountID
+				}
+				outcome := services.ApplyRules(rules, services.RuleSubject{
+					Description: tx.Description,
+					Amount:      tx.Amount,
+					Type:        tx.Type,
+					AccountID:   rowAccountID,
+					Currency:    tx.Currency,
+					Date:        tx.Date,
+				})
+				if outcome.Matched() {
+					result[i].RuleIDs = outcome.RuleIDs
+					result[i].SuggestedTagIDs = uniqueInts(append(outcome.TagIDs, result[i].SuggestedTagIDs...))
+					if outcome.Detail != nil {
+						result[i].SuggestedDetail = outcome.Detail
+					}
+					result[i].IsTransfer = outcome.MarkTransfer
+					result[i].Ignored = outcome.Ignore
+				}
+
+				if len(result[i].SuggestedTagIDs) == 0 && classifier != nil {
+					predictions := classifier.Predict(tx.Description, tx.Amount, tx.Type, rowAccountID)
+					result[i].TagPredictions = predictions
+					if len(predictions) > 0 && predictions[0].Probability >= services.TagPredictionThreshold {
+						result[i].SuggestedTagIDs = []int{predictions[0].TagID}
+					}
+				}
+			}
 		}
 	}
 
@@ -191,66 +602,104 @@ func enhanceTransactionsWithSuggestions(userID int, transactions []services.Pars
 
 // existingTxInfo holds info about an existing transaction
 type existingTxInfo struct {
-	ID     int
-	TagIDs []int
-}
-
-// buildTxKey creates a unique key for transaction matching
-func buildTxKey(desc string, amount float64, date string) string {
-	return strings.ToLower(strings.TrimSpace(desc)) + "|" + strconv.FormatFloat(amount, 'f', 2, 64) + "|" + date
+	ID          int
+	Description string
+	Date        string
+	TagIDs      []int
 }
 
-// loadExistingTransactions loads all potentially duplicate transactions in one query
-func loadExistingTransactions(userID int, transactions []services.ParsedTransaction) map[string]existingTxInfo {
-	result := make(map[string]existingTxInfo)
+// loadDuplicateCandidates loads the user's transactions with the amount of
+// one of the incoming rows, within the duplicate date window of their dates
+func loadDuplicateCandidates(userID int, transactions []services.ParsedTransaction) []services.ExistingTransaction {
+	result := []services.ExistingTransaction{}
 
-	if len(transactions) == 0 {
-		return result
-	}
-
-	// Get date range from transactions
+	// Get date range and amounts from transactions
 	var minDate, maxDate string
+	var amounts []float64
 	for _, tx := range transactions {
+		if tx.Error != "" || tx.Date == "" {
+			continue
+		}
 		if minDate == "" || tx.Date < minDate {
 			minDate = tx.Date
 		}
 		if maxDate == "" || tx.Date > maxDate {
 			maxDate = tx.Date
 		}
+		amounts = append(amounts, tx.Amount)
+	}
+	if len(amounts) == 0 {
+		return result
 	}
 
-	// Query all transactions in date range with their tags
 	rows, err := database.DB.Query(`
-		SELECT t.id, LOWER(TRIM(t.description)), ROUND(t.amount::numeric, 2), t.date::text,
+		SELECT t.id, t.account_id, t.description, t.amount, t.currency, t.type, to_char(t.date, 'YYYY-MM-DD'),
 		       COALESCE(array_agg(tt.tag_id) FILTER (WHERE tt.tag_id IS NOT NULL), ARRAY[]::int[])
 		FROM transactions t
 		LEFT JOIN transaction_tags tt ON t.id = tt.transaction_id
-		WHERE t.user_id = $1 AND t.date BETWEEN $2::date AND $3::date
-		GROUP BY t.id, t.description, t.amount, t.date
-	`, userID, minDate, maxDate)
-
+		WHERE t.user_id = $1
+		  AND t.date BETWEEN $2::date - $4 * INTERVAL '1 day' AND $3::date + $4 * INTERVAL '1 day'
+		  AND ROUND(t.amount::numeric, 2) = ANY(ARRAY(SELECT ROUND(a::numeric, 2) FROM unnest($5::float8[]) a))
+		GROUP BY t.id
+	`, userID, minDate, maxDate, services.DuplicateDateWindow, pq.Array(amounts))
 	if err != nil {
 		return result
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var id int
-		var desc string
-		var amount float64
-		var date string
+		var e services.ExistingTransaction
 		var tagIDs pq.Int64Array
 
-		if err := rows.Scan(&id, &desc, &amount, &date, &tagIDs); err != nil {
+		if err := rows.Scan(&e.ID, &e.AccountID, &e.Description, &e.Amount, &e.Currency, &e.Type, &e.Date, &tagIDs); err != nil {
 			continue
 		}
+		e.TagIDs = int64sToInts(tagIDs)
+		result = append(result, e)
+	}
 
-		key := desc + "|" + strconv.FormatFloat(amount, 'f', 2, 64) + "|" + date
-		intTagIDs := make([]int, len(tagIDs))
-		for i, tid := range tagIDs {
-			intTagIDs[i] = int(tid)
+	return result
+}
+
+// loadExistingExternalIDs loads transactions of the account whose external ID
+// matches one of the incoming transactions
+func loadExistingExternalIDs(userID int, accountID int, transactions []services.ParsedTransaction) map[string]existingTxInfo {
+	result := make(map[string]existingTxInfo)
+
+	var externalIDs []string
+	for _, tx := range transactions {
+		if tx.ExternalID != "" {
+			externalIDs = append(externalIDs, tx.ExternalID)
+		}
+	}
+	if len(externalIDs) == 0 {
+		return result
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT t.id, t.external_id, t.description, to_char(t.date, 'YYYY-MM-DD'),
+		       COALESCE(array_agg(tt.tag_id) FILTER (WHERE tt.tag_id IS NOT NULL), ARRAY[]::int[])
+		FROM transactions t
+		LEFT JOIN transaction_tags tt ON t.id = tt.transaction_id
+		WHERE t.user_id = $1 AND t.account_id = $2 AND t.external_id = ANY($3)
+		GROUP BY t.id
+	`, userID, accountID, pq.Array(externalIDs))
+	if err != nil {
+		return result
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var info existingTxInfo
+		var externalID string
+		var tagIDs pq.Int64Array
+
+		if err := rows.Scan(&info.ID, &externalID, &info.Description, &info.Date, &tagIDs); err != nil {
+			continue
 		}
-		result[key] = existingTxInfo{ID: id, TagIDs: intTagIDs}
+
+		info.TagIDs = int64sToInts(tagIDs)
+		result[externalID] = info
 	}
 
 	return result
@@ -332,123 +781,10 @@ func loadSuggestionsByDescription(userID int, transactions []services.ParsedTran
 	return result
 }
 
-// getTagsForTransaction returns tag IDs for a transaction
-func getTagsForTransaction(transactionID int) []int {
-	rows, err := database.DB.Query(`
-		SELECT tag_id FROM transaction_tags WHERE transaction_id = $1
-	`, transactionID)
-	if err != nil {
-		return []int{}
-	}
-	defer rows.Close()
-
-	var tagIDs []int
-	for rows.Next() {
-		var tagID int
-		if err := rows.Scan(&tagID); err == nil {
-			tagIDs = append(tagIDs, tagID)
-		}
-	}
-	if tagIDs == nil {
-		return []int{}
-	}
-	return tagIDs
-}
-
-// extractDescriptionPrefix extracts a meaningful prefix from description for fuzzy matching
-// e.g., "MDOPAGO*MERCADO PAGO" -> "mdopago"
-// e.g., "(P) PAGO WEB DESACOPL" -> "pago"
-func extractDescriptionPrefix(desc string) string {
-	desc = strings.ToLower(strings.TrimSpace(desc))
-
-	// Remove common prefixes like "(P)" or "(C)"
-	if strings.HasPrefix(desc, "(") {
-		idx := strings.Index(desc, ")")
-		if idx > 0 && idx < 5 {
-			desc = strings.TrimSpace(desc[idx+1:])
-		}
-	}
-
-	// Get first word (split by space or special chars)
-	words := strings.FieldsFunc(desc, func(r rune) bool {
-		return r == ' ' || r == '*' || r == '-' || r == '_' || r == '.'
-	})
-
-	if len(words) == 0 {
-		return ""
-	}
-
-	// Return first significant word (at least 4 chars)
-	for _, word := range words {
-		if len(word) >= 4 {
-			return word
-		}
-	}
-
-	return words[0]
-}
-
-// findTagSuggestions finds tags based on similar past transactions
-func findTagSuggestions(userID int, description string, txType string) []int {
-	// Normalize description for comparison
-	descLower := strings.ToLower(description)
-
-	// Extract key words from description (first word usually identifies the merchant)
-	words := strings.Fields(descLower)
-	if len(words) == 0 {
-		return []int{}
-	}
-
-	// Try to find exact match first
-	var txID int
-	err := database.DB.QueryRow(`
-		SELECT t.id FROM transactions t
-		JOIN transaction_tags tt ON t.id = tt.transaction_id
-		WHERE t.user_id = $1 AND LOWER(t.description) = $2
-		ORDER BY t.created_at DESC
-		LIMIT 1
-	`, userID, descLower).Scan(&txID)
-
-	if err == nil {
-		return getTagsForTransaction(txID)
-	}
-
-	// Try to find by first word (merchant name)
-	firstWord := words[0]
-	if len(firstWord) >= 3 {
-		err = database.DB.QueryRow(`
-			SELECT t.id FROM transactions t
-			JOIN transaction_tags tt ON t.id = tt.transaction_id
-			WHERE t.user_id = $1 AND LOWER(t.description) LIKE $2 AND t.type = $3
-			ORDER BY t.created_at DESC
-			LIMIT 1
-		`, userID, firstWord+"%", txType).Scan(&txID)
-
-		if err == nil {
-			return getTagsForTransaction(txID)
-		}
-	}
-
-	// Try partial match with any significant word
-	for _, word := range words {
-		if len(word) >= 4 {
-			err = database.DB.QueryRow(`
-				SELECT t.id FROM transactions t
-				JOIN transaction_tags tt ON t.id = tt.transaction_id
-				WHERE t.user_id = $1 AND LOWER(t.description) LIKE $2 AND t.type = $3
-				ORDER BY t.created_at DESC
-				LIMIT 1
-			`, userID, "%"+word+"%", txType).Scan(&txID)
-
-			if err == nil {
-				return getTagsForTransaction(txID)
-			}
-		}
-	}
-
-	return []int{}
-}
-
+// ConfirmImport applies the client's reviewed rows to the staged import in one
+// request and commits it. Kept for clients that review the whole upload at once;
+// each transaction must carry the row_id returned by the upload, and the parts
+// of a row split on the client carry the row_id of that row.
 func ConfirmImport(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
@@ -456,6 +792,7 @@ func ConfirmImport(c *gin.Context) {
 		ImportID     int `json:"import_id" binding:"required"`
 		AccountID    int `json:"account_id" binding:"required"`
 		Transactions []struct {
+			RowID       int     `json:"row_id"`
 			Description string  `json:"description"`
 			Detail      *string `json:"detail"`
 			Amount      float64 `json:"amount"`
@@ -463,7 +800,7 @@ func ConfirmImport(c *gin.Context) {
 			Type        string  `json:"type"`
 			Date        string  `json:"date"`
 			TagIDs      []int   `json:"tag_ids"`
-			RawText     string  `json:"raw_text"`
+			AccountID   int     `json:"account_id"` // Per-sheet account; 0 uses the import's account
 			IsDuplicate bool    `json:"is_duplicate"`
 		} `json:"transactions" binding:"required"`
 	}
@@ -473,69 +810,43 @@ func ConfirmImport(c *gin.Context) {
 		return
 	}
 
-	// Verify account belongs to user
-	var accountExists bool
-	database.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM accounts WHERE id = $1 AND user_id = $2)`,
-		req.AccountID, userID).Scan(&accountExists)
-	if !accountExists {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account"})
+	status, _, err := loadUserImport(database.DB, userID, req.ImportID, false)
+	if err == errImportNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
 		return
 	}
-
-	// Filter out duplicates first
-	var toInsert []struct {
-		Description string
-		Detail      *string
-		Amount      float64
-		Currency    string
-		Type        string
-		Date        string
-		TagIDs      []int
-		RawText     string
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching import"})
+		return
+	}
+	if status != "staged" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Import is not awaiting review"})
+		return
 	}
 
-	skippedCount := 0
+	// Verify account belongs to user, as well as the accounts of sheets mapped elsewhere
+	accountIDs := map[int]bool{req.AccountID: true}
 	for _, tx := range req.Transactions {
-		if tx.IsDuplicate {
-			skippedCount++
-			continue
+		if tx.RowID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "row_id is required for every transaction"})
+			return
 		}
-		currency := tx.Currency
-		if currency == "" {
-			currency = "PEN"
+		if tx.AccountID != 0 {
+			accountIDs[tx.AccountID] = true
 		}
-		toInsert = append(toInsert, struct {
-			Description string
-			Detail      *string
-			Amount      float64
-			Currency    string
-			Type        string
-			Date        string
-			TagIDs      []int
-			RawText     string
-		}{
-			Description: tx.Description,
-			Detail:      tx.Detail,
-			Amount:      tx.Amount,
-			Currency:    currency,
-			Type:        tx.Type,
-			Date:        tx.Date,
-			TagIDs:      tx.TagIDs,
-			RawText:     tx.RawText,
-		})
 	}
-
-	if len(toInsert) == 0 {
-		c.JSON(http.StatusOK, gin.H{
-			"message": "No transactions to save",
-			"saved":   0,
-			"skipped": skippedCount,
-			"total":   len(req.Transactions),
-		})
+	ids := make([]int64, 0, len(accountIDs))
+	for id := range accountIDs {
+		ids = append(ids, int64(id))
+	}
+	var owned int
+	database.DB.QueryRow(`SELECT COUNT(*) FROM accounts WHERE user_id = $1 AND id = ANY($2)`,
+		userID, pq.Array(ids)).Scan(&owned)
+	if owned != len(ids) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account"})
 		return
 	}
 
-	// Use a database transaction for atomicity
 	dbTx, err := database.DB.Begin()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting transaction"})
@@ -543,53 +854,66 @@ func ConfirmImport(c *gin.Context) {
 	}
 	defer dbTx.Rollback()
 
-	// Get valid tag IDs for this user (to avoid per-insert validation)
-	validTagRows, err := database.DB.Query(`SELECT id FROM tags WHERE user_id = $1`, userID)
-	validTags := make(map[int]bool)
-	if err == nil {
-		defer validTagRows.Close()
-		for validTagRows.Next() {
-			var tagID int
-			if err := validTagRows.Scan(&tagID); err == nil {
-				validTags[tagID] = true
-			}
-		}
-	}
-
-	// Insert all transactions and collect their IDs
-	savedCount := 0
-	type txWithTags struct {
-		ID     int
-		TagIDs []int
+	if _, err := dbTx.Exec(`UPDATE imports SET account_id = $1 WHERE id = $2`, req.AccountID, req.ImportID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving transactions"})
+		return
 	}
-	var insertedTxs []txWithTags
 
-	for _, tx := range toInsert {
-		var txID int
-		err := dbTx.QueryRow(
-			`INSERT INTO transactions (user_id, account_id, description, detail, amount, currency, type, date, source, raw_text)
-			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'import', $9)
-			 RETURNING id`,
-			userID, req.AccountID, tx.Description, tx.Detail, tx.Amount, tx.Currency, tx.Type, tx.Date, tx.RawText,
-		).Scan(&txID)
+	invalidDates := 0
+	seenRows := make(map[int]bool)
+	for _, tx := range req.Transactions {
+		// Rows split on the client come back as several transactions with the
+		// same row_id: the first part updates the staged row, the others are
+		// staged as copies of it
+		split := seenRows[tx.RowID]
+		seenRows[tx.RowID] = true
 
-		if err == nil {
-			savedCount++
-			if len(tx.TagIDs) > 0 {
-				insertedTxs = append(insertedTxs, txWithTags{ID: txID, TagIDs: tx.TagIDs})
+		if tx.IsDuplicate && split {
+			continue
+		} else if tx.IsDuplicate {
+			// Known duplicates keep their status, anything else the user left out is skipped
+			_, err = dbTx.Exec(`UPDATE import_rows SET status = 'skipped', updated_at = NOW()
+				WHERE id = $1 AND import_id = $2 AND status <> 'duplicate'`, tx.RowID, req.ImportID)
+		} else if _, parseErr := time.Parse("2006-01-02", tx.Date); parseErr != nil {
+			// Rows whose date couldn't be parsed must be fixed by the user, never stamped with a guess
+			invalidDates++
+			continue
+		} else {
+			currency := tx.Currency
+			if currency == "" {
+				currency = "PEN"
 			}
-		}
-	}
-
-	// Batch insert all transaction_tags
-	if len(insertedTxs) > 0 {
-		for _, itx := range insertedTxs {
-			for _, tagID := range itx.TagIDs {
-				if validTags[tagID] {
-					dbTx.Exec(`INSERT INTO transaction_tags (transaction_id, tag_id) VALUES ($1, $2)`,
-						itx.ID, tagID)
+			if split {
+				// The bank's ID stays with the first part so the others aren't taken for duplicates
+				_, err = dbTx.Exec(`
+					INSERT INTO import_rows (import_id, position, status, account_id, description, detail, amount, currency,
+					                         type, date, value_date, raw_text, counterparty, reference, sheet, source_row,
+					                         tag_ids, suggested_tag_ids, is_transfer, rule_ids, merchant_id)
+					SELECT import_id, position, 'accepted', COALESCE(NULLIF($8, 0), account_id), $1, $2, $3, $4,
+					       $5, $6::date, value_date, raw_text, counterparty, reference, sheet, source_row,
+					       COALESCE($7::integer[], '{}'), suggested_tag_ids, is_transfer, rule_ids, merchant_id
+					FROM import_rows
+					WHERE id = $9 AND import_id = $10`,
+					tx.Description, tx.Detail, tx.Amount, currency, tx.Type, tx.Date, pq.Array(tx.TagIDs), tx.AccountID,
+					tx.RowID, req.ImportID)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving transactions"})
+					return
 				}
+				continue
 			}
+			_, err = dbTx.Exec(`
+				UPDATE import_rows
+				SET description = $1, detail = $2, amount = $3, currency = $4, type = $5, date = $6::date,
+				    tag_ids = COALESCE($7::integer[], '{}'), account_id = COALESCE(NULLIF($8, 0), account_id),
+				    status = 'accepted', error = NULL, updated_at = NOW()
+				WHERE id = $9 AND import_id = $10`,
+				tx.Description, tx.Detail, tx.Amount, currency, tx.Type, tx.Date, pq.Array(tx.TagIDs), tx.AccountID,
+				tx.RowID, req.ImportID)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving transactions"})
+			return
 		}
 	}
 
@@ -598,17 +922,22 @@ func ConfirmImport(c *gin.Context) {
 		return
 	}
 
-	// Update import record
-	database.DB.Exec(
-		`UPDATE imports SET status = 'completed', processed_transactions = $1 WHERE id = $2`,
-		savedCount, req.ImportID,
-	)
+	savedCount, skippedCount, err := commitImportRows(userID, req.ImportID)
+	if err == errImportCommitted {
+		c.JSON(http.StatusConflict, gin.H{"error": "Import is not awaiting review"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving transactions"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Transactions saved successfully",
-		"saved":   savedCount,
-		"skipped": skippedCount,
-		"total":   len(req.Transactions),
+		"message":       "Transactions saved successfully",
+		"saved":         savedCount,
+		"skipped":       skippedCount - invalidDates,
+		"invalid_dates": invalidDates,
+		"total":         len(req.Transactions),
 	})
 }
 
@@ -616,7 +945,8 @@ func GetImports(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
 	rows, err := database.DB.Query(`
-		SELECT id, filename, file_type, status, total_transactions, processed_transactions, created_at
+		SELECT id, filename, file_type, status, total_transactions, processed_transactions, created_at,
+		       file_hash IS NOT NULL
 		FROM imports
 		WHERE user_id = $1
 		ORDER BY created_at DESC
@@ -639,8 +969,9 @@ func GetImports(c *gin.Context) {
 			TotalTransactions     int
 			ProcessedTransactions int
 			CreatedAt             string
+			HasFile               bool
 		}
-		if err := rows.Scan(&imp.ID, &imp.Filename, &imp.FileType, &imp.Status, &imp.TotalTransactions, &imp.ProcessedTransactions, &imp.CreatedAt); err != nil {
+		if err := rows.Scan(&imp.ID, &imp.Filename, &imp.FileType, &imp.Status, &imp.TotalTransactions, &imp.ProcessedTransactions, &imp.CreatedAt, &imp.HasFile); err != nil {
 			continue
 		}
 		imports = append(imports, map[string]interface{}{
@@ -651,6 +982,7 @@ func GetImports(c *gin.Context) {
 			"total_transactions":     imp.TotalTransactions,
 			"processed_transactions": imp.ProcessedTransactions,
 			"created_at":             imp.CreatedAt,
+			"has_file":               imp.HasFile,
 		})
 	}
 
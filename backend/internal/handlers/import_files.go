@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"database/sql"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/warren/finance-app/internal/database"
+	"github.com/warren/finance-app/internal/storage"
+)
+
+// DownloadImportFile returns the original statement file of an import
+func DownloadImportFile(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var filename string
+	var fileHash *string
+	var fileSize *int64
+	err := database.DB.QueryRow(`SELECT filename, file_hash, file_size FROM imports WHERE id = $1 AND user_id = $2`,
+		c.Param("id"), userID).Scan(&filename, &fileHash, &fileSize)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching import"})
+		return
+	}
+	if fileHash == nil {
+		// Imports from before the archive existed
+		c.JSON(http.StatusNotFound, gin.H{"error": "The original file of this import was not kept"})
+		return
+	}
+
+	blob, err := storage.Blobs.Open(*fileHash)
+	if err == storage.ErrBlobNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import file not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reading import file"})
+		return
+	}
+	defer blob.Close()
+
+	name := filepath.Base(filename)
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	c.Header("Content-Type", contentType)
+	c.Header("ETag", strconv.Quote(*fileHash))
+	if fileSize != nil {
+		c.Header("Content-Length", strconv.FormatInt(*fileSize, 10))
+	}
+	c.Status(http.StatusOK)
+	io.Copy(c.Writer, blob)
+}
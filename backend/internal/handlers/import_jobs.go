@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/warren/finance-app/internal/database"
+	"github.com/warren/finance-app/internal/services"
+	"github.com/warren/finance-app/internal/storage"
+)
+
+// importJobKind is the job kind that parses and stages an uploaded statement
+const importJobKind = "import"
+
+// importRejected is the result status of an upload refused as a statement
+// that was already imported
+const importRejected = "rejected"
+
+// stagingProgressEvery is how many staged rows go between progress updates
+const stagingProgressEvery = 200
+
+// importJobParams is an upload, validated by UploadFile, waiting to be processed
+type importJobParams struct {
+	FileHash     string                 `json:"file_hash"` // Original statement in the blob store
+	FileName     string                 `json:"file_name"`
+	FileSize     int64                  `json:"file_size"`
+	BankID       string                 `json:"bank"`
+	AccountID    int                    `json:"account_id"`
+	InvertSigns  bool                   `json:"invert_signs"`
+	Sheets       []services.SheetImport `json:"sheets,omitempty"`
+	AllSheets    bool                   `json:"all_sheets,omitempty"`
+	Layout       *services.BankConfig   `json:"layout,omitempty"`  // Resolved spreadsheet layout
+	Mapping      *services.BankConfig   `json:"mapping,omitempty"` // Explicit column mapping, nil for the bank's layout
+	DateFormat   string                 `json:"date_format,omitempty"`
+	NumberFormat string                 `json:"number_format,omitempty"`
+	SaveMapping  bool                   `json:"save_mapping,omitempty"` // Store Layout as a reusable bank layout
+	Force        bool                   `json:"force,omitempty"`        // Import even if the statement was already imported
+}
+
+// RegisterJobHandlers registers the background jobs run by the handlers package
+func RegisterJobHandlers() {
+	services.RegisterJobHandler(importJobKind, runImportJob)
+	services.RegisterJobHandler(reparseJobKind, runReparseJob)
+	services.RegisterJobHandler(recurringJobKind, runRecurringJob)
+}
+
+// setImportJobProgress records the job's progress. Progress is only shown to
+// the user, so a failed update is logged and the import goes on.
+func setImportJobProgress(job *services.Job, stage string, progress int, total int) {
+	if err := job.SetProgress(stage, progress, total); err != nil {
+		log.Printf("jobs: error updating progress of job %d: %v", job.ID, err)
+	}
+}
+
+// runImportJob parses the uploaded file, looks up duplicates and suggestions
+// and stages the rows for review. Its result is the upload response. A
+// requeued job re-stages the import its previous attempt created.
+func runImportJob(job *services.Job) (interface{}, error) {
+	var params importJobParams
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		return nil, fmt.Errorf("invalid job params: %w", err)
+	}
+
+	filename, cleanup, err := storage.FetchFile(storage.Blobs, params.FileHash, params.FileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading statement file: %w", err)
+	}
+	defer cleanup()
+
+	userID := job.UserID
+	setImportJobProgress(job, "parsing", 0, 0)
+
+	// Process based on file type
+	var transactions []services.ParsedTransaction
+	var balanceChecks []services.BalanceCheck
+	var importID int
+
+	if job.ImportID != nil {
+		// A requeued job resumes the import its previous attempt created
+		// instead of creating another one
+		importID = *job.ImportID
+		var layout services.BankConfig
+		if params.Layout != nil {
+			layout = *params.Layout
+		}
+		transactions, balanceChecks, err = services.ParseStatementFile(filename, services.StatementOptions{
+			BankID:      params.BankID,
+			Layout:      layout,
+			Sheets:      params.Sheets,
+			AllSheets:   params.AllSheets,
+			InvertSigns: params.InvertSigns,
+		})
+		if err == nil {
+			var committed bool
+			err = database.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM import_rows WHERE import_id = $1 AND transaction_id IS NOT NULL)`,
+				importID).Scan(&committed)
+			if err == nil && committed {
+				return nil, fmt.Errorf("import %d already has committed rows", importID)
+			}
+		}
+	} else {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".png", ".jpg", ".jpeg":
+			transactions, importID, err = services.ProcessImageFile(filename, userID)
+		case ".ofx", ".qfx":
+			// OFX amounts are already signed from the account holder's perspective
+			transactions, importID, err = services.ProcessOFXFile(filename, userID)
+		case ".xml":
+			transactions, balanceChecks, importID, err = services.ProcessCAMTFile(filename, userID)
+		case ".sta", ".940":
+			transactions, balanceChecks, importID, err = services.ProcessMT940File(filename, userID)
+		case ".pdf":
+			transactions, importID, err = services.ProcessPDFFile(filename, userID, params.BankID, params.InvertSigns)
+		default:
+			sheets := params.Sheets
+			if params.AllSheets {
+				sheets, err = allWorkbookSheets(filename, params.InvertSigns)
+			}
+			if err == nil {
+				if sheets == nil {
+					sheets = []services.SheetImport{{InvertSigns: params.InvertSigns}}
+				}
+				transactions, balanceChecks, importID, err = services.ProcessWorkbook(filename, userID, *params.Layout, sheets)
+			}
+		}
+		// Link the import right away so a requeued job finds it
+		if err == nil {
+			if err = job.SetImport(importID); err != nil {
+				err = fmt.Errorf("error linking import to job: %w", err)
+			}
+		}
+	}
+	if err != nil {
+		if importID != 0 {
+			database.DB.Exec(`UPDATE imports SET status = 'failed' WHERE id = $1`, importID)
+		}
+		return nil, err
+	}
+
+	// Same statement already imported: drop this import unless forced.
+	// Overlapping periods are only reported.
+	fingerprint := services.FingerprintStatement(params.AccountID, transactions)
+	matches, err := services.FindStatementMatches(userID, params.AccountID, params.FileHash, fingerprint, importID)
+	if err != nil {
+		database.DB.Exec(`UPDATE imports SET status = 'failed' WHERE id = $1`, importID)
+		return nil, fmt.Errorf("error checking previous imports: %w", err)
+	}
+	if !params.Force {
+		for _, match := range matches {
+			if match.SameStatement() {
+				database.DB.Exec(`DELETE FROM imports WHERE id = $1`, importID)
+				return map[string]interface{}{
+					"status":            importRejected,
+					"error":             duplicateStatementError(match),
+					"statement_matches": matches,
+					"fingerprint":       fingerprint,
+				}, nil
+			}
+		}
+	}
+
+	// Parsers record the temporary path; keep the user's file name, the archived
+	// copy, its fingerprint and how it was parsed, so it can be re-parsed later.
+	// The name is cut to the size of imports.filename.
+	storedName := params.FileName
+	if runes := []rune(storedName); len(runes) > 255 {
+		storedName = string(runes[:255])
+	}
+	_, err = database.DB.Exec(`
+		UPDATE imports SET filename = $1, file_hash = $2, file_size = $3, parse_params = $4, row_hash = NULLIF($5, ''),
+		    period_start = NULLIF($6, '')::date, period_end = NULLIF($7, '')::date
+		WHERE id = $8`,
+		storedName, params.FileHash, params.FileSize, string(job.Params), fingerprint.RowHash,
+		fingerprint.PeriodStart, fingerprint.PeriodEnd, importID)
+	if err != nil {
+		database.DB.Exec(`UPDATE imports SET status = 'failed' WHERE id = $1`, importID)
+		return nil, fmt.Errorf("error saving import details: %w", err)
+	}
+
+	var rowErrors []services.RowError
+	for _, tx := range transactions {
+		if tx.Error != "" {
+			rowErrors = append(rowErrors, services.RowError{Row: tx.Row, Sheet: tx.Sheet, Error: tx.Error})
+		}
+	}
+	if rowErrors == nil {
+		rowErrors = []services.RowError{}
+	}
+	if err := job.SetRowErrors(rowErrors); err != nil {
+		database.DB.Exec(`UPDATE imports SET status = 'failed' WHERE id = $1`, importID)
+		return nil, fmt.Errorf("error saving row errors: %w", err)
+	}
+
+	// Enhance transactions with suggestions and duplicate detection
+	setImportJobProgress(job, "matching", 0, len(transactions))
+	enhancedTransactions := enhanceTransactionsWithSuggestions(userID, params.AccountID, transactions)
+
+	// Keep the parsed rows on the server so the review survives reloads
+	setImportJobProgress(job, "staging", 0, len(enhancedTransactions))
+	err = stageImportRows(importID, params.AccountID, enhancedTransactions, func(staged int) {
+		if staged%stagingProgressEvery == 0 {
+			setImportJobProgress(job, "staging", staged, len(enhancedTransactions))
+		}
+	})
+	if err != nil {
+		database.DB.Exec(`UPDATE imports SET status = 'failed' WHERE id = $1`, importID)
+		return nil, fmt.Errorf("error staging import rows: %w", err)
+	}
+	setImportJobProgress(job, "done", len(enhancedTransactions), len(enhancedTransactions))
+
+	duplicates := 0
+	for _, tx := range enhancedTransactions {
+		if tx.IsDuplicate {
+			duplicates++
+		}
+	}
+
+	response := map[string]interface{}{
+		"import_id":    importID,
+		"transactions": enhancedTransactions,
+		"count":        len(enhancedTransactions),
+		"duplicates":   duplicates,
+		"row_errors":   rowErrors,
+		"bank":         params.BankID,
+		"status":       "staged",
+		"message":      "File processed. Please assign categories to transactions.",
+	}
+	response["fingerprint"] = fingerprint
+	if len(matches) > 0 {
+		response["statement_matches"] = matches
+	}
+	// Structured statements and spreadsheets with a balance column can be verified
+	if balanceChecks != nil {
+		response["balance_checks"] = balanceChecks
+	}
+
+	// Keep the corrected mapping as a reusable layout
+	if params.SaveMapping {
+		saved, err := services.CreateBankConfig(userID, *params.Layout)
+		if err != nil {
+			response["layout_error"] = "Error saving bank layout"
+		} else {
+			response["saved_layout"] = saved
+		}
+	}
+
+	return response, nil
+}
@@ -0,0 +1,785 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/warren/finance-app/internal/database"
+	"github.com/warren/finance-app/internal/services"
+	"github.com/warren/finance-app/internal/storage"
+)
+
+// reparseJobKind is the job kind that re-parses an archived statement and
+// compares it with the transactions committed from it
+const reparseJobKind = "reparse"
+
+// Re-parse change kinds
+const (
+	ChangeNew     = "new"     // Row the previous parse missed or couldn't read
+	ChangeChanged = "changed" // Committed transaction whose values now differ
+	ChangeMissing = "missing" // Committed transaction the statement no longer yields
+)
+
+var errStatementNotKept = errors.New("statement file not kept")
+
+// reparseJobParams is an import to re-parse with the options to use
+type reparseJobParams struct {
+	ImportID int             `json:"import_id"`
+	Options  importJobParams `json:"options"`
+}
+
+// ReparseRequest optionally changes how the statement is parsed. Without it
+// the import's original options are used with the current parser and layout.
+type ReparseRequest struct {
+	Bank         string             `json:"bank"`
+	Mapping      *BankConfigRequest `json:"mapping"`
+	DateFormat   string             `json:"date_format"`
+	NumberFormat string             `json:"number_format"`
+}
+
+// ReparseValues are the compared values of a committed transaction
+type ReparseValues struct {
+	Description string  `json:"description"`
+	Amount      float64 `json:"amount"`
+	Currency    string  `json:"currency"`
+	Type        string  `json:"type"`
+	Date        string  `json:"date"`
+	ValueDate   string  `json:"value_date,omitempty"`
+}
+
+// ReparseChange is a difference between the re-parsed statement and the import
+type ReparseChange struct {
+	ID            int                         `json:"id"` // Used to select the change when applying
+	Kind          string                      `json:"kind"`
+	Fields        []string                    `json:"fields,omitempty"` // Changed values: date, amount, type, currency, description, value_date
+	TransactionID *int                        `json:"transaction_id,omitempty"`
+	RowID         *int                        `json:"row_id,omitempty"` // Staged row of the import
+	Edited        bool                        `json:"edited,omitempty"` // Transaction was edited after the import
+	Current       *ReparseValues              `json:"current,omitempty"`
+	Parsed        *services.ParsedTransaction `json:"parsed,omitempty"`
+}
+
+// ReparseResult is the outcome of a re-parse job
+type ReparseResult struct {
+	ImportID      int                     `json:"import_id"`
+	Options       importJobParams         `json:"options"`
+	Changes       []ReparseChange         `json:"changes"`
+	Summary       map[string]int          `json:"summary"` // new, changed, missing, unchanged, ignored
+	RowErrors     []services.RowError     `json:"row_errors"`
+	BalanceChecks []services.BalanceCheck `json:"balance_checks,omitempty"`
+}
+
+// reparseRow is a staged row of the import with its committed transaction
+type reparseRow struct {
+	RowID         int
+	Position      int
+	Status        string
+	Sheet         string
+	SourceRow     int
+	ExternalID    string
+	TransactionID *int
+	Edited        bool
+	Current       *ReparseValues // nil when no transaction was committed from the row
+}
+
+// ReparseImport runs a completed import's archived statement through the
+// current parser, optionally with another bank layout or mapping, and returns
+// the differences with its transactions. Nothing changes until ApplyReparse.
+// With ?async=true it answers 202 with the job to follow.
+func ReparseImport(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	importID, status, ok := importParam(c, userID)
+	if !ok {
+		return
+	}
+	if status != "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only completed imports can be re-parsed"})
+		return
+	}
+
+	var req ReparseRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	options, err := importParseOptions(userID, importID)
+	if err == errStatementNotKept {
+		c.JSON(http.StatusConflict, gin.H{"error": "The original statement of this import was not kept"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching import"})
+		return
+	}
+	if err := applyReparseOverrides(userID, &options, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	async := c.Query("async") == "true"
+	job, err := services.EnqueueJob(userID, reparseJobKind, reparseJobParams{ImportID: importID, Options: options}, async)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error queueing re-parse"})
+		return
+	}
+
+	if async {
+		c.JSON(http.StatusAccepted, gin.H{
+			"job_id":  job.ID,
+			"status":  job.Status,
+			"message": "Statement queued for re-parsing",
+		})
+		return
+	}
+
+	job, err = services.RunJobInline(c.Request.Context(), job)
+	if err == services.ErrJobStillRunning {
+		c.JSON(http.StatusAccepted, gin.H{
+			"job_id":  job.ID,
+			"status":  services.JobRunning,
+			"message": "Statement is still being re-parsed",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error re-parsing statement"})
+		return
+	}
+	if job.Status == services.JobFailed {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": *job.Error})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", job.Result)
+}
+
+// importParseOptions returns the options the import was parsed with. Imports
+// from before they were recorded get the defaults for their account.
+func importParseOptions(userID int, importID int) (importJobParams, error) {
+	var options importJobParams
+	var filename string
+	var fileHash, parseParams sql.NullString
+	var fileSize, accountID sql.NullInt64
+
+	err := database.DB.QueryRow(`
+		SELECT filename, file_hash, file_size, account_id, parse_params FROM imports
+		WHERE id = $1 AND user_id = $2`, importID, userID,
+	).Scan(&filename, &fileHash, &fileSize, &accountID, &parseParams)
+	if err == sql.ErrNoRows {
+		return options, errImportNotFound
+	}
+	if err != nil {
+		return options, err
+	}
+	if !fileHash.Valid {
+		return options, errStatementNotKept
+	}
+
+	if parseParams.Valid {
+		if err := json.Unmarshal([]byte(parseParams.String), &options); err != nil {
+			return options, fmt.Errorf("invalid parse params: %w", err)
+		}
+	} else {
+		options.BankID = "generic"
+		options.AccountID = int(accountID.Int64)
+		options.InvertSigns, _ = accountInvertsSigns(userID, options.AccountID)
+	}
+
+	options.FileHash = fileHash.String
+	options.FileName = filename
+	options.FileSize = fileSize.Int64
+	options.SaveMapping = false
+	return options, nil
+}
+
+// applyReparseOverrides applies the requested bank, mapping and formats and
+// resolves the spreadsheet layout again, so layout fixes are picked up
+func applyReparseOverrides(userID int, options *importJobParams, req ReparseRequest) error {
+	if err := services.ValidateDateFormat(req.DateFormat); err != nil {
+		return err
+	}
+	if err := services.ValidateNumberFormat(req.NumberFormat); err != nil {
+		return err
+	}
+
+	if req.Bank != "" {
+		options.BankID = req.Bank
+		options.Mapping = nil
+	}
+	if req.DateFormat != "" {
+		options.DateFormat = req.DateFormat
+	}
+	if req.NumberFormat != "" {
+		options.NumberFormat = req.NumberFormat
+	}
+
+	if req.Mapping != nil {
+		if req.Mapping.Name == "" {
+			req.Mapping.Name = "Mapeo manual"
+		}
+		config, err := req.Mapping.toBankConfig()
+		if err != nil {
+			return err
+		}
+		options.Mapping = &config
+	}
+	// The formats apply to an explicit mapping too, as on upload
+	if options.Mapping != nil {
+		if options.DateFormat != "" {
+			options.Mapping.DateFormat = options.DateFormat
+		}
+		if options.NumberFormat != "" {
+			options.Mapping.NumberFormat = options.NumberFormat
+		}
+	}
+
+	ext := strings.ToLower(filepath.Ext(options.FileName))
+	if ext != ".xlsx" && ext != ".xls" && ext != ".csv" {
+		return nil
+	}
+	layout, err := spreadsheetLayout(userID, options.BankID, options.Mapping, options.DateFormat, options.NumberFormat)
+	if err != nil {
+		return err
+	}
+	options.Layout = &layout
+	return nil
+}
+
+// runReparseJob parses the archived statement and diffs it against the import
+func runReparseJob(job *services.Job) (interface{}, error) {
+	var params reparseJobParams
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		return nil, fmt.Errorf("invalid job params: %w", err)
+	}
+	options := params.Options
+	job.SetImport(params.ImportID)
+
+	filename, cleanup, err := storage.FetchFile(storage.Blobs, options.FileHash, options.FileName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading statement file: %w", err)
+	}
+	defer cleanup()
+
+	job.SetProgress("parsing", 0, 0)
+	var layout services.BankConfig
+	if options.Layout != nil {
+		layout = *options.Layout
+	}
+	transactions, balanceChecks, err := services.ParseStatementFile(filename, services.StatementOptions{
+		BankID:      options.BankID,
+		Layout:      layout,
+		Sheets:      options.Sheets,
+		AllSheets:   options.AllSheets,
+		InvertSigns: options.InvertSigns,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rowErrors := []services.RowError{}
+	for _, tx := range transactions {
+		if tx.Error != "" {
+			rowErrors = append(rowErrors, services.RowError{Row: tx.Row, Sheet: tx.Sheet, Error: tx.Error})
+		}
+	}
+	job.SetRowErrors(rowErrors)
+
+	job.SetProgress("comparing", 0, len(transactions))
+	rows, err := loadReparseRows(job.UserID, params.ImportID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading import rows: %w", err)
+	}
+	changes, summary := diffReparse(transactions, rows)
+	job.SetProgress("done", len(transactions), len(transactions))
+
+	return ReparseResult{
+		ImportID:      params.ImportID,
+		Options:       options,
+		Changes:       changes,
+		Summary:       summary,
+		RowErrors:     rowErrors,
+		BalanceChecks: balanceChecks,
+	}, nil
+}
+
+// loadReparseRows returns the import's staged rows in order, with the current
+// values of the transactions committed from them
+func loadReparseRows(userID int, importID int) ([]reparseRow, error) {
+	rows, err := database.DB.Query(`
+		SELECT ir.id, ir.position, ir.status, COALESCE(ir.sheet, ''), COALESCE(ir.source_row, 0), COALESCE(ir.external_id, ''),
+		       t.id, t.updated_at > t.created_at, t.description, t.amount, t.currency, t.type,
+		       to_char(t.date, 'YYYY-MM-DD'), COALESCE(to_char(t.value_date, 'YYYY-MM-DD'), '')
+		FROM import_rows ir
+		LEFT JOIN transactions t ON t.id = ir.transaction_id AND t.user_id = $2
+		WHERE ir.import_id = $1
+		ORDER BY ir.position`, importID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []reparseRow
+	for rows.Next() {
+		var row reparseRow
+		var txID sql.NullInt64
+		var edited sql.NullBool
+		var description, currency, txType, date, valueDate sql.NullString
+		var amount sql.NullFloat64
+
+		err := rows.Scan(&row.RowID, &row.Position, &row.Status, &row.Sheet, &row.SourceRow, &row.ExternalID,
+			&txID, &edited, &description, &amount, &currency, &txType, &date, &valueDate)
+		if err != nil {
+			return nil, err
+		}
+		if txID.Valid {
+			id := int(txID.Int64)
+			row.TransactionID = &id
+			row.Edited = edited.Bool
+			row.Current = &ReparseValues{
+				Description: description.String,
+				Amount:      amount.Float64,
+				Currency:    currency.String,
+				Type:        txType.String,
+				Date:        date.String,
+				ValueDate:   valueDate.String,
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// diffReparse matches the parsed rows to the staged ones by external ID, then
+// by sheet and spreadsheet row, and otherwise by position (PDF and image
+// statements have neither). Rows the user skipped or that were duplicates
+// are ignored.
+func diffReparse(parsed []services.ParsedTransaction, rows []reparseRow) ([]ReparseChange, map[string]int) {
+	byExternalID := make(map[string]int)
+	bySource := make(map[string]int)
+	byPosition := make(map[int]int)
+	for i, row := range rows {
+		if row.ExternalID != "" {
+			byExternalID[row.ExternalID] = i
+		}
+		if row.SourceRow > 0 {
+			bySource[fmt.Sprintf("%s|%d", row.Sheet, row.SourceRow)] = i
+		}
+		byPosition[row.Position] = i
+	}
+
+	summary := map[string]int{ChangeNew: 0, ChangeChanged: 0, ChangeMissing: 0, "unchanged": 0, "ignored": 0}
+	changes := []ReparseChange{}
+	add := func(change ReparseChange) {
+		change.ID = len(changes) + 1
+		changes = append(changes, change)
+		summary[change.Kind]++
+	}
+
+	matched := make([]bool, len(rows))
+	for i := range parsed {
+		tx := &parsed[i]
+
+		var idx int
+		var ok bool
+		switch {
+		case tx.ExternalID != "":
+			idx, ok = byExternalID[tx.ExternalID]
+		case tx.Row > 0:
+			idx, ok = bySource[fmt.Sprintf("%s|%d", tx.Sheet, tx.Row)]
+		default:
+			idx, ok = byPosition[i+1]
+		}
+		if ok && matched[idx] {
+			ok = false
+		}
+
+		if !ok {
+			if tx.Error == "" {
+				add(ReparseChange{Kind: ChangeNew, Parsed: tx})
+			}
+			continue
+		}
+		matched[idx] = true
+		row := rows[idx]
+
+		// Still unreadable: keep whatever was committed
+		if tx.Error != "" {
+			continue
+		}
+
+		if row.Current == nil {
+			if row.Status == RowSkipped || row.Status == RowDuplicate {
+				summary["ignored"]++
+				continue
+			}
+			add(ReparseChange{Kind: ChangeNew, RowID: &row.RowID, Parsed: tx})
+			continue
+		}
+
+		fields := changedFields(*row.Current, *tx)
+		if len(fields) == 0 {
+			summary["unchanged"]++
+			continue
+		}
+		add(ReparseChange{
+			Kind:          ChangeChanged,
+			Fields:        fields,
+			TransactionID: row.TransactionID,
+			RowID:         &row.RowID,
+			Edited:        row.Edited,
+			Current:       row.Current,
+			Parsed:        tx,
+		})
+	}
+
+	for i, row := range rows {
+		if matched[i] || row.Current == nil {
+			continue
+		}
+		add(ReparseChange{
+			Kind:          ChangeMissing,
+			TransactionID: row.TransactionID,
+			RowID:         &rows[i].RowID,
+			Edited:        row.Edited,
+			Current:       row.Current,
+		})
+	}
+
+	return changes, summary
+}
+
+// changedFields lists the values of the transaction that differ from the parsed row
+func changedFields(current ReparseValues, parsed services.ParsedTransaction) []string {
+	var fields []string
+	if current.Date != parsed.Date {
+		fields = append(fields, "date")
+	}
+	if math.Abs(current.Amount-parsed.Amount) >= 0.005 {
+		fields = append(fields, "amount")
+	}
+	if current.Type != parsed.Type {
+		fields = append(fields, "type")
+	}
+	if current.Currency != parsed.Currency {
+		fields = append(fields, "currency")
+	}
+	if strings.TrimSpace(current.Description) != strings.TrimSpace(parsed.Description) {
+		fields = append(fields, "description")
+	}
+	if current.ValueDate != parsed.ValueDate {
+		fields = append(fields, "value_date")
+	}
+	return fields
+}
+
+// ApplyReparseRequest selects the changes of a re-parse to apply
+type ApplyReparseRequest struct {
+	ChangeIDs []int `json:"change_ids"`
+	All       bool  `json:"all"`
+}
+
+// ApplyReparse applies the selected changes of a re-parse in one database
+// transaction: changed transactions are corrected, new rows become
+// transactions and missing ones are deleted. Transactions edited since the
+// re-parse started are left alone and reported as conflicts. A re-parse can
+// be applied once; after that the import has to be re-parsed again.
+func ApplyReparse(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	importID, status, ok := importParam(c, userID)
+	if !ok {
+		return
+	}
+	if status != "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only completed imports can be corrected"})
+		return
+	}
+
+	jobID, err := strconv.Atoi(c.Param("jobId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+	job, err := services.GetJob(userID, jobID)
+	if err == services.ErrJobNotFound || (err == nil && (job.Kind != reparseJobKind || job.ImportID == nil || *job.ImportID != importID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Re-parse not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching job"})
+		return
+	}
+	if job.Status != services.JobCompleted || job.StartedAt == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Re-parse has not completed"})
+		return
+	}
+
+	var req ApplyReparseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.All && len(req.ChangeIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "change_ids or all is required"})
+		return
+	}
+	selected := make(map[int]bool)
+	for _, id := range req.ChangeIDs {
+		selected[id] = true
+	}
+
+	var result ReparseResult
+	if err := json.Unmarshal(job.Result, &result); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid re-parse result"})
+		return
+	}
+
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting transaction"})
+		return
+	}
+	defer dbTx.Rollback()
+
+	// Lock the import so two corrections can't run at once
+	status, importAccountID, err := loadUserImport(dbTx, userID, importID, true)
+	if err != nil || status != "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only completed imports can be corrected"})
+		return
+	}
+
+	// Rows touched after the re-parse started mean it no longer describes the import
+	var stale bool
+	err = dbTx.QueryRow(`SELECT EXISTS(SELECT 1 FROM import_rows WHERE import_id = $1 AND updated_at > $2)`,
+		importID, *job.StartedAt).Scan(&stale)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error applying corrections"})
+		return
+	}
+	if stale {
+		c.JSON(http.StatusConflict, gin.H{"error": "The import changed after this re-parse; re-parse it again"})
+		return
+	}
+
+	merchants, err := services.NewMerchantResolver(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error applying corrections"})
+		return
+	}
+
+	applied := map[string]int{ChangeNew: 0, ChangeChanged: 0, ChangeMissing: 0}
+	conflicts := []int{}
+	var touched []int
+	for _, change := range result.Changes {
+		if !req.All && !selected[change.ID] {
+			continue
+		}
+		ok, err := applyReparseChange(dbTx, userID, importID, importAccountID, change, merchants, *job.StartedAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error applying corrections"})
+			return
+		}
+		if !ok {
+			conflicts = append(conflicts, change.ID)
+			continue
+		}
+		applied[change.Kind]++
+		if change.TransactionID != nil {
+			touched = append(touched, *change.TransactionID)
+		}
+	}
+
+	// The import now matches this parse
+	options, _ := json.Marshal(result.Options)
+	_, err = dbTx.Exec(`
+		UPDATE imports SET parse_params = $1,
+		    processed_transactions = (SELECT COUNT(*) FROM transactions WHERE import_id = $2)
+		WHERE id = $2`, string(options), importID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error applying corrections"})
+		return
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error applying corrections"})
+		return
+	}
+	services.UpdateTagModel(userID, touched)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Corrections applied",
+		"updated":   applied[ChangeChanged],
+		"created":   applied[ChangeNew],
+		"deleted":   applied[ChangeMissing],
+		"conflicts": conflicts,
+	})
+}
+
+// applyReparseChange applies one change. It returns false, changing nothing,
+// when the transaction was edited or deleted since the re-parse started, the
+// change breaks the transaction's splits or links or a new row's external ID
+// already exists in the account.
+func applyReparseChange(dbTx *sql.Tx, userID int, importID int, importAccountID *int, change ReparseChange,
+	merchants *services.MerchantResolver, since time.Time) (bool, error) {
+	switch change.Kind {
+	case ChangeChanged:
+		if change.TransactionID == nil || change.Parsed == nil {
+			return false, nil
+		}
+		p := change.Parsed
+
+		// Split transactions keep the amount their splits add up to
+		var splitTotal sql.NullFloat64
+		if err := dbTx.QueryRow(`
+			SELECT SUM(s.amount) FROM transaction_splits s
+			JOIN transactions t ON t.id = s.transaction_id
+			WHERE s.transaction_id = $1 AND t.user_id = $2`, *change.TransactionID, userID).Scan(&splitTotal); err != nil {
+			return false, err
+		}
+		if splitTotal.Valid && math.Abs(splitTotal.Float64-p.Amount) >= 0.005 {
+			return false, nil
+		}
+
+		// Linked transactions keep their type and currency, and at least the
+		// amount allocated to their links
+		var linkedType, linkedCurrency string
+		var allocated float64
+		err := dbTx.QueryRow(`
+			SELECT t.type, t.currency, lt.allocated FROM transactions t
+			JOIN transaction_link_totals lt ON lt.transaction_id = t.id
+			WHERE t.id = $1 AND t.user_id = $2`, *change.TransactionID, userID).Scan(&linkedType, &linkedCurrency, &allocated)
+		if err != nil && err != sql.ErrNoRows {
+			return false, err
+		}
+		if err == nil && (linkedType != p.Type || linkedCurrency != p.Currency || p.Amount < allocated-0.005) {
+			return false, nil
+		}
+
+		result, err := dbTx.Exec(`
+			UPDATE transactions SET description = $1, amount = $2, currency = $3, type = $4, date = $5,
+			    value_date = NULLIF($6, '')::date, updated_at = NOW()
+			WHERE id = $7 AND user_id = $8 AND import_id = $9 AND updated_at <= $10`,
+			p.Description, p.Amount, p.Currency, p.Type, p.Date, p.ValueDate, *change.TransactionID, userID, importID, since)
+		if err != nil {
+			return false, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return false, nil
+		}
+		_, err = dbTx.Exec(`
+			UPDATE import_rows SET description = $1, amount = $2, currency = $3, type = $4, date = $5,
+			    value_date = NULLIF($6, '')::date, error = NULL, updated_at = NOW()
+			WHERE id = $7 AND import_id = $8`,
+			p.Description, p.Amount, p.Currency, p.Type, p.Date, p.ValueDate, *change.RowID, importID)
+		return err == nil, err
+
+	case ChangeNew:
+		if change.Parsed == nil {
+			return false, nil
+		}
+		p := change.Parsed
+		accountID := importAccountID
+		if p.AccountID != 0 {
+			accountID = &p.AccountID
+		}
+
+		// A staged row that got committed in the meantime is not new anymore
+		if change.RowID != nil {
+			var committed bool
+			err := dbTx.QueryRow(`SELECT transaction_id IS NOT NULL FROM import_rows WHERE id = $1 AND import_id = $2`,
+				*change.RowID, importID).Scan(&committed)
+			if err == sql.ErrNoRows || committed {
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+		}
+
+		merchantID, err := merchants.Resolve(dbTx, p.Description)
+		if err != nil {
+			return false, err
+		}
+
+		var txID int
+		err = dbTx.QueryRow(
+			`INSERT INTO transactions (user_id, account_id, description, amount, currency, type, date, source, raw_text,
+			                           external_id, value_date, counterparty, reference, import_id, merchant_id)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, 'import', NULLIF($8, ''), NULLIF($9, ''), NULLIF($10, '')::date,
+			         NULLIF($11, ''), NULLIF($12, ''), $13, $14)
+			 ON CONFLICT (account_id, external_id) WHERE external_id IS NOT NULL DO NOTHING
+			 RETURNING id`,
+			userID, accountID, p.Description, p.Amount, p.Currency, p.Type, p.Date, p.RawText,
+			p.ExternalID, p.ValueDate, p.Counterparty, p.Reference, importID, merchantID,
+		).Scan(&txID)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if change.RowID != nil {
+			_, err = dbTx.Exec(`
+				UPDATE import_rows SET status = 'accepted', error = NULL, description = $1, amount = $2, currency = $3,
+				    type = $4, date = $5, value_date = NULLIF($6, '')::date, transaction_id = $7, updated_at = NOW()
+				WHERE id = $8`,
+				p.Description, p.Amount, p.Currency, p.Type, p.Date, p.ValueDate, txID, *change.RowID)
+			return err == nil, err
+		}
+
+		var rowAccountID *int
+		if p.AccountID != 0 {
+			rowAccountID = &p.AccountID
+		}
+		_, err = dbTx.Exec(`
+			INSERT INTO import_rows (import_id, position, status, account_id, description, amount, currency, type, date,
+			                         value_date, raw_text, external_id, counterparty, reference, balance, sheet, source_row,
+			                         transaction_id)
+			VALUES ($1, (SELECT COALESCE(MAX(position), 0) + 1 FROM import_rows WHERE import_id = $1), 'accepted', $2, $3, $4,
+			        $5, $6, $7, NULLIF($8, '')::date, NULLIF($9, ''), NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, ''), $13,
+			        NULLIF($14, ''), NULLIF($15, 0), $16)`,
+			importID, rowAccountID, p.Description, p.Amount, p.Currency, p.Type, p.Date, p.ValueDate, p.RawText,
+			p.ExternalID, p.Counterparty, p.Reference, p.Balance, p.Sheet, p.Row, txID)
+		return err == nil, err
+
+	case ChangeMissing:
+		if change.TransactionID == nil {
+			return false, nil
+		}
+		var unchanged bool
+		err := dbTx.QueryRow(`
+			SELECT updated_at <= $1 FROM transactions
+			WHERE id = $2 AND user_id = $3 AND import_id = $4
+			FOR UPDATE`, since, *change.TransactionID, userID, importID).Scan(&unchanged)
+		if err == sql.ErrNoRows || (err == nil && !unchanged) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		// Tags and reimbursement links go with the transaction; the other side
+		// of a confirmed transfer counts again
+		if err := services.ReleaseTransfers(dbTx, userID, []int{*change.TransactionID}); err != nil {
+			return false, err
+		}
+		if _, err := dbTx.Exec(`DELETE FROM transactions WHERE id = $1`, *change.TransactionID); err != nil {
+			return false, err
+		}
+		_, err = dbTx.Exec(`UPDATE import_rows SET status = 'skipped', transaction_id = NULL, updated_at = NOW() WHERE id = $1`,
+			*change.RowID)
+		return err == nil, err
+	}
+	return false, nil
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/warren/finance-app/internal/database"
+	"github.com/warren/finance-app/internal/models"
+	"github.com/warren/finance-app/internal/services"
+)
+
+// ImportedTransaction is a transaction created by an import, flagged when the
+// user changed it afterwards (fields, tags or links)
+type ImportedTransaction struct {
+	models.Transaction
+	Edited bool `json:"edited"`
+}
+
+// loadImportTransactions returns the transactions an import created, with their tags
+func loadImportTransactions(userID int, importID int) ([]ImportedTransaction, error) {
+	rows, err := database.DB.Query(`
+		SELECT t.id, t.user_id, t.account_id, t.description, t.detail, t.amount, t.currency, t.type,
+		       t.date, t.source, t.raw_text,
+		       COALESCE((SELECT lt.allocated FROM transaction_link_totals lt WHERE lt.transaction_id = t.id), 0),
+		       t.import_id, t.created_at, t.updated_at,
+		       t.updated_at > t.created_at
+		FROM transactions t
+		WHERE t.import_id = $1 AND t.user_id = $2
+		ORDER BY t.date DESC, t.id`, importID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []ImportedTransaction{}
+	transactionIDs := []int{}
+	for rows.Next() {
+		var t ImportedTransaction
+		err := rows.Scan(&t.ID, &t.UserID, &t.AccountID, &t.Description, &t.Detail, &t.Amount, &t.Currency, &t.Type,
+			&t.Date, &t.Source, &t.RawText, &t.LinkedAmount, &t.ImportID, &t.CreatedAt, &t.UpdatedAt, &t.Edited)
+		if err != nil {
+			continue
+		}
+		t.Tags = []models.Tag{}
+		transactions = append(transactions, t)
+		transactionIDs = append(transactionIDs, t.ID)
+	}
+
+	if len(transactionIDs) == 0 {
+		return transactions, nil
+	}
+
+	tagRows, err := database.DB.Query(`
+		SELECT tt.transaction_id, tg.id, tg.user_id, tg.name, tg.color, tg.created_at
+		FROM transaction_tags tt
+		JOIN tags tg ON tt.tag_id = tg.id
+		WHERE tt.transaction_id = ANY($1)
+	`, pq.Array(transactionIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer tagRows.Close()
+
+	tagMap := make(map[int][]models.Tag)
+	for tagRows.Next() {
+		var txID int
+		var tag models.Tag
+		if err := tagRows.Scan(&txID, &tag.ID, &tag.UserID, &tag.Name, &tag.Color, &tag.CreatedAt); err == nil {
+			tagMap[txID] = append(tagMap[txID], tag)
+		}
+	}
+	for i := range transactions {
+		if tags, ok := tagMap[transactions[i].ID]; ok {
+			transactions[i].Tags = tags
+		}
+	}
+
+	return transactions, nil
+}
+
+// GetImportTransactions lists the transactions created by an import
+func GetImportTransactions(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	importID, _, ok := importParam(c, userID)
+	if !ok {
+		return
+	}
+
+	transactions, err := loadImportTransactions(userID, importID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, transactions)
+}
+
+// RevertImport deletes every transaction an import created, with their tags,
+// and unlinks their linked transactions, in one database transaction.
+// When some were edited after the import it answers 409 with their IDs
+// unless ?force=true is given.
+func RevertImport(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	importID, status, ok := importParam(c, userID)
+	if !ok {
+		return
+	}
+	if status != "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only completed imports can be reverted"})
+		return
+	}
+	force := c.Query("force") == "true"
+
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting transaction"})
+		return
+	}
+	defer dbTx.Rollback()
+
+	// Lock the import and its transactions so nothing changes while reverting
+	status, _, err = loadUserImport(dbTx, userID, importID, true)
+	if err != nil || status != "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only completed imports can be reverted"})
+		return
+	}
+
+	rows, err := dbTx.Query(`
+		SELECT id, updated_at > created_at FROM transactions
+		WHERE import_id = $1 AND user_id = $2
+		FOR UPDATE`, importID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reverting import"})
+		return
+	}
+	var ids, edited []int
+	for rows.Next() {
+		var id int
+		var isEdited bool
+		if err := rows.Scan(&id, &isEdited); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reverting import"})
+			return
+		}
+		ids = append(ids, id)
+		if isEdited {
+			edited = append(edited, id)
+		}
+	}
+	rows.Close()
+
+	if len(edited) > 0 && !force {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":        "Some transactions were edited after the import; pass force=true to revert anyway",
+			"edited_ids":   edited,
+			"edited_count": len(edited),
+			"total":        len(ids),
+		})
+		return
+	}
+
+	// Tags and reimbursement links go with the transactions (ON DELETE CASCADE);
+	// the other sides of confirmed transfers count again
+	if err := services.ReleaseTransfers(dbTx, userID, ids); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reverting import"})
+		return
+	}
+	result, err := dbTx.Exec(`DELETE FROM transactions WHERE id = ANY($1) AND user_id = $2`, pq.Array(ids), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reverting import"})
+		return
+	}
+	deleted, _ := result.RowsAffected()
+
+	_, err = dbTx.Exec(`UPDATE imports SET status = 'reverted', processed_transactions = 0 WHERE id = $1`, importID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reverting import"})
+		return
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reverting import"})
+		return
+	}
+	services.UpdateTagModel(userID, ids)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Import reverted",
+		"deleted": deleted,
+		"edited":  len(edited),
+	})
+}
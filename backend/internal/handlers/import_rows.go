@@ -0,0 +1,622 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/warren/finance-app/internal/database"
+	"github.com/warren/finance-app/internal/models"
+	"github.com/warren/finance-app/internal/services"
+)
+
+// Staged row statuses
+const (
+	RowPending   = "pending"
+	RowAccepted  = "accepted"
+	RowSkipped   = "skipped"
+	RowDuplicate = "duplicate"
+	RowError     = "error"
+)
+
+var (
+	errImportNotFound  = errors.New("import not found")
+	errImportCommitted = errors.New("import already committed")
+)
+
+const importRowColumns = `id, import_id, position, status, error, account_id, description, detail, amount, currency, type,
+	to_char(date, 'YYYY-MM-DD'), to_char(value_date, 'YYYY-MM-DD'), raw_text, external_id, counterparty, reference,
+	balance, balance_mismatch, sheet, source_row, tag_ids, suggested_tag_ids, transaction_id, duplicate_confidence,
+	duplicate_candidate_ids, is_transfer, rule_ids, merchant_id`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanImportRow(row scanner) (models.ImportRow, error) {
+	var r models.ImportRow
+	var tagIDs, suggestedTagIDs, candidateIDs, ruleIDs pq.Int64Array
+
+	err := row.Scan(&r.ID, &r.ImportID, &r.Position, &r.Status, &r.Error, &r.AccountID, &r.Description, &r.Detail,
+		&r.Amount, &r.Currency, &r.Type, &r.Date, &r.ValueDate, &r.RawText, &r.ExternalID, &r.Counterparty,
+		&r.Reference, &r.Balance, &r.BalanceMismatch, &r.Sheet, &r.SourceRow, &tagIDs, &suggestedTagIDs,
+		&r.TransactionID, &r.DuplicateConfidence, &candidateIDs,
+		&r.IsTransfer, &ruleIDs, &r.MerchantID)
+	if err != nil {
+		return r, err
+	}
+
+	r.TagIDs = int64sToInts(tagIDs)
+	r.SuggestedTagIDs = int64sToInts(suggestedTagIDs)
+	r.DuplicateCandidateIDs = int64sToInts(candidateIDs)
+	r.RuleIDs = int64sToInts(ruleIDs)
+	return r, nil
+}
+
+func int64sToInts(values pq.Int64Array) []int {
+	result := make([]int, len(values))
+	for i, v := range values {
+		result[i] = int(v)
+	}
+	return result
+}
+
+// stageImportRows persists the parsed rows of an import for review and marks
+// the import as staged. Suggested tags and details are pre-applied, rows with
+// parse errors or known duplicates get their own status. Sets RowID/Status on
+// the given transactions; progress, when given, is called after each row.
+// Rows staged by an earlier attempt of the import job are replaced.
+func stageImportRows(importID int, accountID int, transactions []TransactionWithSuggestion, progress func(staged int)) error {
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+
+	if _, err := dbTx.Exec(`DELETE FROM import_rows WHERE import_id = $1`, importID); err != nil {
+		return err
+	}
+
+	for i := range transactions {
+		tx := &transactions[i]
+
+		status := RowPending
+		switch {
+		case tx.Error != "":
+			status = RowError
+		case tx.IsDuplicate:
+			status = RowDuplicate
+		case tx.Ignored:
+			status = RowSkipped
+		}
+
+		detail := tx.Detail
+		if detail == nil {
+			detail = tx.SuggestedDetail
+		}
+
+		var rowAccountID *int
+		if tx.AccountID != 0 {
+			rowAccountID = &tx.AccountID
+		}
+
+		candidateIDs := make([]int, len(tx.DuplicateCandidates))
+		for j, candidate := range tx.DuplicateCandidates {
+			candidateIDs[j] = candidate.TransactionID
+		}
+
+		err := dbTx.QueryRow(`
+			INSERT INTO import_rows (import_id, position, status, error, account_id, description, detail, amount, currency,
+			                         type, date, value_date, raw_text, external_id, counterparty, reference, balance,
+			                         balance_mismatch, sheet, source_row, tag_ids, suggested_tag_ids, duplicate_confidence,
+			                         duplicate_candidate_ids, is_transfer, rule_ids, merchant_id)
+			VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, $10, NULLIF($11, '')::date, NULLIF($12, '')::date, $13,
+			        NULLIF($14, ''), NULLIF($15, ''), NULLIF($16, ''), $17, $18, NULLIF($19, ''), NULLIF($20, 0), COALESCE($21::integer[], '{}'), COALESCE($21::integer[], '{}'),
+			        $22, COALESCE($23::integer[], '{}'), $24, COALESCE($25::integer[], '{}'), $26)
+			RETURNING id`,
+			importID, i+1, status, tx.Error, rowAccountID, tx.Description, detail, tx.Amount, tx.Currency,
+			tx.Type, tx.Date, tx.ValueDate, tx.RawText, tx.ExternalID, tx.Counterparty, tx.Reference, tx.Balance,
+			tx.BalanceMismatch, tx.Sheet, tx.Row, pq.Array(tx.SuggestedTagIDs), tx.DuplicateConfidence, pq.Array(candidateIDs),
+			tx.IsTransfer, pq.Array(tx.RuleIDs), tx.MerchantID,
+		).Scan(&tx.RowID)
+		if err != nil {
+			return err
+		}
+		tx.Status = status
+		if progress != nil {
+			progress(i + 1)
+		}
+	}
+
+	_, err = dbTx.Exec(`UPDATE imports SET status = 'staged', account_id = $1, total_transactions = $2 WHERE id = $3`,
+		accountID, len(transactions), importID)
+	if err != nil {
+		return err
+	}
+
+	return dbTx.Commit()
+}
+
+// loadUserImport returns the import's status and account, checking it belongs to the user
+func loadUserImport(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, userID int, importID int, forUpdate bool) (string, *int, error) {
+	query := `SELECT status, account_id FROM imports WHERE id = $1 AND user_id = $2`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+
+	var status string
+	var accountID *int
+	err := q.QueryRow(query, importID, userID).Scan(&status, &accountID)
+	if err == sql.ErrNoRows {
+		return "", nil, errImportNotFound
+	}
+	return status, accountID, err
+}
+
+// importParam parses :id and checks the import belongs to the user, writing the error response otherwise
+func importParam(c *gin.Context, userID int) (int, string, bool) {
+	importID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import ID"})
+		return 0, "", false
+	}
+
+	status, _, err := loadUserImport(database.DB, userID, importID, false)
+	if err == errImportNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return 0, "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching import"})
+		return 0, "", false
+	}
+	return importID, status, true
+}
+
+// GetImport returns an import with its row counts per status
+func GetImport(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var imp models.Import
+	err := database.DB.QueryRow(`
+		SELECT id, user_id, account_id, filename, file_type, file_hash, file_size, row_hash,
+		       to_char(period_start, 'YYYY-MM-DD'), to_char(period_end, 'YYYY-MM-DD'), status, total_transactions,
+		       processed_transactions, created_at
+		FROM imports WHERE id = $1 AND user_id = $2`, c.Param("id"), userID,
+	).Scan(&imp.ID, &imp.UserID, &imp.AccountID, &imp.Filename, &imp.FileType, &imp.FileHash, &imp.FileSize,
+		&imp.RowHash, &imp.PeriodStart, &imp.PeriodEnd, &imp.Status, &imp.TotalTransactions, &imp.ProcessedTransactions,
+		&imp.CreatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching import"})
+		return
+	}
+
+	counts := map[string]int{RowPending: 0, RowAccepted: 0, RowSkipped: 0, RowDuplicate: 0, RowError: 0}
+	rows, err := database.DB.Query(`SELECT status, COUNT(*) FROM import_rows WHERE import_id = $1 GROUP BY status`, imp.ID)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var status string
+			var count int
+			if err := rows.Scan(&status, &count); err == nil {
+				counts[status] = count
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"import":      imp,
+		"row_counts":  counts,
+		"can_commit":  imp.Status == "staged",
+		"is_reviewed": counts[RowPending] == 0,
+	})
+}
+
+// GetImportRows pages through an import's staged rows (params: status, page, limit)
+func GetImportRows(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	importID, _, ok := importParam(c, userID)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	where := `WHERE import_id = $1`
+	args := []interface{}{importID}
+	if status := c.Query("status"); status != "" {
+		where += ` AND status = $2`
+		args = append(args, status)
+	}
+
+	var total int
+	if err := database.DB.QueryRow(`SELECT COUNT(*) FROM import_rows `+where, args...).Scan(&total); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching import rows"})
+		return
+	}
+
+	args = append(args, limit, (page-1)*limit)
+	rows, err := database.DB.Query(`SELECT `+importRowColumns+` FROM import_rows `+where+
+		` ORDER BY position LIMIT $`+strconv.Itoa(len(args)-1)+` OFFSET $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching import rows"})
+		return
+	}
+	defer rows.Close()
+
+	result := []models.ImportRow{}
+	for rows.Next() {
+		row, err := scanImportRow(rows)
+		if err != nil {
+			continue
+		}
+		result = append(result, row)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rows":  result,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+type ImportRowUpdate struct {
+	Description *string  `json:"description"`
+	Detail      *string  `json:"detail"`
+	Amount      *float64 `json:"amount"`
+	Currency    *string  `json:"currency"`
+	Type        *string  `json:"type"`
+	Date        *string  `json:"date"`
+	TagIDs      []int    `json:"tag_ids"`
+	AccountID   *int     `json:"account_id"`
+	Status      *string  `json:"status"` // pending, accepted, skipped
+}
+
+// UpdateImportRow edits a staged row. Fixing the date of an error row makes it pending again.
+func UpdateImportRow(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	importID, status, ok := importParam(c, userID)
+	if !ok {
+		return
+	}
+	if status != "staged" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Import is not awaiting review"})
+		return
+	}
+	rowID, err := strconv.Atoi(c.Param("rowId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid row ID"})
+		return
+	}
+
+	var req ImportRowUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	row, err := scanImportRow(database.DB.QueryRow(
+		`SELECT `+importRowColumns+` FROM import_rows WHERE id = $1 AND import_id = $2`, rowID, importID))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import row not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching import row"})
+		return
+	}
+
+	if req.Description != nil {
+		row.Description = *req.Description
+	}
+	if req.Detail != nil {
+		row.Detail = req.Detail
+	}
+	if req.Amount != nil {
+		if *req.Amount <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be positive"})
+			return
+		}
+		row.Amount = *req.Amount
+	}
+	if req.Currency != nil {
+		row.Currency = *req.Currency
+	}
+	if req.Type != nil {
+		if *req.Type != "income" && *req.Type != "expense" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Type must be 'income' or 'expense'"})
+			return
+		}
+		row.Type = *req.Type
+	}
+	if req.Date != nil {
+		if _, err := time.Parse("2006-01-02", *req.Date); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Date must be YYYY-MM-DD"})
+			return
+		}
+		row.Date = req.Date
+		if row.Status == RowError {
+			row.Status = RowPending
+			row.Error = nil
+		}
+	}
+	if req.TagIDs != nil {
+		row.TagIDs = req.TagIDs
+	}
+	if req.AccountID != nil {
+		var exists bool
+		database.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM accounts WHERE id = $1 AND user_id = $2)`,
+			*req.AccountID, userID).Scan(&exists)
+		if !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account"})
+			return
+		}
+		row.AccountID = req.AccountID
+	}
+	if req.Status != nil {
+		if msg := checkRowStatusChange(row, *req.Status); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		row.Status = *req.Status
+	}
+
+	updated, err := scanImportRow(database.DB.QueryRow(`
+		UPDATE import_rows
+		SET description = $1, detail = $2, amount = $3, currency = $4, type = $5, date = $6::date, tag_ids = $7,
+		    account_id = $8, status = $9, error = $10, updated_at = NOW()
+		WHERE id = $11
+		RETURNING `+importRowColumns,
+		row.Description, row.Detail, row.Amount, row.Currency, row.Type, row.Date, pq.Array(row.TagIDs),
+		row.AccountID, row.Status, row.Error, row.ID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating import row"})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// checkRowStatusChange validates a user-requested status, returning the error message or ""
+func checkRowStatusChange(row models.ImportRow, status string) string {
+	switch status {
+	case RowPending, RowSkipped:
+		return ""
+	case RowAccepted:
+		if row.Date == nil {
+			return "Fix the row's date before accepting it"
+		}
+		return ""
+	}
+	return "Status must be 'pending', 'accepted' or 'skipped'"
+}
+
+// SetImportRowsStatus accepts or skips rows in bulk: the given row_ids, or
+// every row currently in from_status (e.g. accept all pending rows)
+func SetImportRowsStatus(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	importID, status, ok := importParam(c, userID)
+	if !ok {
+		return
+	}
+	if status != "staged" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Import is not awaiting review"})
+		return
+	}
+
+	var req struct {
+		Status     string `json:"status" binding:"required"`
+		RowIDs     []int  `json:"row_ids"`
+		FromStatus string `json:"from_status"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if req.Status != RowPending && req.Status != RowAccepted && req.Status != RowSkipped {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be 'pending', 'accepted' or 'skipped'"})
+		return
+	}
+	if len(req.RowIDs) == 0 && req.FromStatus == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "row_ids or from_status is required"})
+		return
+	}
+
+	// Rows without a valid date can't be accepted
+	query := `UPDATE import_rows SET status = $1, updated_at = NOW() WHERE import_id = $2
+		AND ($1 <> 'accepted' OR date IS NOT NULL)`
+	args := []interface{}{req.Status, importID}
+	if len(req.RowIDs) > 0 {
+		query += ` AND id = ANY($3)`
+		args = append(args, pq.Array(req.RowIDs))
+	} else {
+		query += ` AND status = $3`
+		args = append(args, req.FromStatus)
+	}
+
+	result, err := database.DB.Exec(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating import rows"})
+		return
+	}
+	updated, _ := result.RowsAffected()
+
+	c.JSON(http.StatusOK, gin.H{"updated": updated})
+}
+
+// CommitImport saves the accepted rows of a staged import as transactions
+func CommitImport(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	importID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import ID"})
+		return
+	}
+
+	saved, skipped, err := commitImportRows(userID, importID)
+	if err == errImportNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	}
+	if err == errImportCommitted {
+		c.JSON(http.StatusConflict, gin.H{"error": "Import is not awaiting review"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Transactions saved successfully",
+		"saved":   saved,
+		"skipped": skipped,
+	})
+}
+
+// commitImportRows inserts every accepted row of a staged import in one
+// database transaction. Rows whose external ID already exists in the account
+// become duplicates. Returns the saved count and the rows left out.
+func commitImportRows(userID int, importID int) (int, int, error) {
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer dbTx.Rollback()
+
+	// Lock the import so two commits can't run at once
+	status, importAccountID, err := loadUserImport(dbTx, userID, importID, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	if status != "staged" {
+		return 0, 0, errImportCommitted
+	}
+
+	rows, err := dbTx.Query(`SELECT `+importRowColumns+` FROM import_rows
+		WHERE import_id = $1 AND status = 'accepted' AND transaction_id IS NULL AND date IS NOT NULL
+		ORDER BY position`, importID)
+	if err != nil {
+		return 0, 0, err
+	}
+	var accepted []models.ImportRow
+	for rows.Next() {
+		row, err := scanImportRow(rows)
+		if err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		accepted = append(accepted, row)
+	}
+	rows.Close()
+
+	// Get valid tag IDs for this user (to avoid per-insert validation)
+	validTags := make(map[int]bool)
+	tagRows, err := dbTx.Query(`SELECT id FROM tags WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	for tagRows.Next() {
+		var tagID int
+		if err := tagRows.Scan(&tagID); err == nil {
+			validTags[tagID] = true
+		}
+	}
+	tagRows.Close()
+
+	// Descriptions may have been edited during review, so merchants are
+	// resolved again; new ones are created
+	merchants, err := services.NewMerchantResolver(userID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var savedIDs []int
+	for _, row := range accepted {
+		accountID := row.AccountID
+		if accountID == nil {
+			accountID = importAccountID
+		}
+
+		merchantID, err := merchants.Resolve(dbTx, row.Description)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		// Rows whose external ID already exists in the account are skipped as duplicates
+		var txID int
+		err = dbTx.QueryRow(
+			`INSERT INTO transactions (user_id, account_id, description, detail, amount, currency, type, date, source, raw_text,
+			                           external_id, value_date, counterparty, reference, import_id, is_transfer, marked_transfer, merchant_id)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'import', $9, $10, $11, $12, $13, $14, $15, $15, $16)
+			 ON CONFLICT (account_id, external_id) WHERE external_id IS NOT NULL DO NOTHING
+			 RETURNING id`,
+			userID, accountID, row.Description, row.Detail, row.Amount, row.Currency, row.Type, row.Date, row.RawText,
+			row.ExternalID, row.ValueDate, row.Counterparty, row.Reference, importID, row.IsTransfer, merchantID,
+		).Scan(&txID)
+		if err == sql.ErrNoRows {
+			if _, err := dbTx.Exec(`UPDATE import_rows SET status = 'duplicate', updated_at = NOW() WHERE id = $1`, row.ID); err != nil {
+				return 0, 0, err
+			}
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		for _, tagID := range row.TagIDs {
+			if validTags[tagID] {
+				if _, err := dbTx.Exec(`INSERT INTO transaction_tags (transaction_id, tag_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+					txID, tagID); err != nil {
+					return 0, 0, err
+				}
+			}
+		}
+
+		if _, err := dbTx.Exec(`UPDATE import_rows SET transaction_id = $1, updated_at = NOW() WHERE id = $2`, txID, row.ID); err != nil {
+			return 0, 0, err
+		}
+		savedIDs = append(savedIDs, txID)
+	}
+	saved := len(savedIDs)
+
+	var total int
+	if err := dbTx.QueryRow(`SELECT COUNT(*) FROM import_rows WHERE import_id = $1`, importID).Scan(&total); err != nil {
+		return 0, 0, err
+	}
+
+	if _, err := dbTx.Exec(`UPDATE imports SET status = 'completed', processed_transactions = $1 WHERE id = $2`,
+		saved, importID); err != nil {
+		return 0, 0, err
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	services.UpdateTagModel(userID, savedIDs)
+
+	// Payments between own accounts show up as an expense in one import and an
+	// income in another; suggest them as transfers for the user to confirm
+	_, _ = services.DetectTransfersFor(userID, savedIDs)
+	// Imported charges settle the recurring transactions expected for them
+	_, _ = services.MatchRecurring(userID, savedIDs)
+	return saved, total - saved, nil
+}
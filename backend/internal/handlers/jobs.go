@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/warren/finance-app/internal/services"
+)
+
+// jobEventInterval is how often the event stream checks the job for changes
+const jobEventInterval = time.Second
+
+// jobParam loads the :id job of the user, writing the error response otherwise
+func jobParam(c *gin.Context) (*services.Job, bool) {
+	userID := c.GetInt("user_id")
+	jobID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return nil, false
+	}
+
+	job, err := services.GetJob(userID, jobID)
+	if err == services.ErrJobNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching job"})
+		return nil, false
+	}
+	return job, true
+}
+
+// GetJob returns a background job's status, progress, row errors and, once
+// completed, its result (for imports, the same body as a synchronous upload)
+func GetJob(c *gin.Context) {
+	job, ok := jobParam(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// StreamJob sends the job as server-sent events: a "progress" event whenever
+// it changes and a final "done" event when it completes or fails
+func StreamJob(c *gin.Context) {
+	job, ok := jobParam(c)
+	if !ok {
+		return
+	}
+
+	// Keep proxies like nginx from buffering the stream
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	ticker := time.NewTicker(jobEventInterval)
+	defer ticker.Stop()
+
+	lastStage, lastProgress := "", -1
+	c.Stream(func(w io.Writer) bool {
+		if job.Done() {
+			c.SSEvent("done", job)
+			return false
+		}
+		if job.Stage != lastStage || job.Progress != lastProgress {
+			lastStage, lastProgress = job.Stage, job.Progress
+			c.SSEvent("progress", gin.H{
+				"id":         job.ID,
+				"status":     job.Status,
+				"stage":      job.Stage,
+				"progress":   job.Progress,
+				"total":      job.Total,
+				"import_id":  job.ImportID,
+				"row_errors": len(job.RowErrors),
+			})
+			return true
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-ticker.C:
+		}
+
+		updated, err := services.GetJob(job.UserID, job.ID)
+		if err != nil {
+			c.SSEvent("error", gin.H{"error": "Error fetching job"})
+			return false
+		}
+		job = updated
+		return true
+	})
+}
@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/warren/finance-app/internal/database"
+	"github.com/warren/finance-app/internal/services"
+)
+
+var errInvalidMerchantTags = errors.New("invalid tags")
+
+type MerchantRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Aliases       []string `json:"aliases"`
+	Patterns      []string `json:"patterns"`
+	DefaultTagIDs []int    `json:"default_tag_ids"`
+}
+
+// toMerchant converts the request into a validated merchant, checking that its
+// default tags belong to the user
+func (r MerchantRequest) toMerchant(userID int) (services.Merchant, error) {
+	merchant := services.Merchant{
+		Name:          r.Name,
+		Aliases:       r.Aliases,
+		Patterns:      r.Patterns,
+		DefaultTagIDs: r.DefaultTagIDs,
+	}
+	if err := merchant.Normalize(); err != nil {
+		return merchant, err
+	}
+
+	if len(merchant.DefaultTagIDs) > 0 {
+		var owned int
+		database.DB.QueryRow(`SELECT COUNT(*) FROM tags WHERE user_id = $1 AND id = ANY($2)`,
+			userID, pq.Array(merchant.DefaultTagIDs)).Scan(&owned)
+		if owned != len(merchant.DefaultTagIDs) {
+			return merchant, errInvalidMerchantTags
+		}
+	}
+	return merchant, nil
+}
+
+// GetMerchants returns the user's merchants
+func GetMerchants(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	merchants, err := services.ListMerchants(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching merchants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, merchants)
+}
+
+// GetMerchant returns a single merchant
+func GetMerchant(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	merchantID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+		return
+	}
+
+	merchant, err := services.GetMerchant(userID, merchantID)
+	if err == services.ErrMerchantNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Merchant not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching merchant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, merchant)
+}
+
+// CreateMerchant creates a new merchant
+func CreateMerchant(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req MerchantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	merchant, err := req.toMerchant(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := services.CreateMerchant(userID, merchant)
+	if err == services.ErrMerchantExists {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating merchant"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateMerchant updates an existing merchant
+func UpdateMerchant(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	merchantID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+		return
+	}
+
+	var req MerchantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	merchant, err := req.toMerchant(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := services.UpdateMerchant(userID, merchantID, merchant)
+	if err == services.ErrMerchantNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Merchant not found"})
+		return
+	}
+	if err == services.ErrMerchantExists {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating merchant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteMerchant deletes a merchant, leaving its transactions without one
+func DeleteMerchant(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	merchantID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+		return
+	}
+
+	err = services.DeleteMerchant(userID, merchantID)
+	if err == services.ErrMerchantNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Merchant not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting merchant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Merchant deleted"})
+}
+
+// MergeMerchants folds other merchants into this one, moving their transactions
+func MergeMerchants(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	merchantID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+		return
+	}
+
+	var req struct {
+		MerchantIDs []int `json:"merchant_ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request, 'merchant_ids' array required"})
+		return
+	}
+
+	sourceIDs := uniqueInts(req.MerchantIDs)
+	if len(sourceIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No merchant IDs provided"})
+		return
+	}
+	for _, id := range sourceIDs {
+		if id == merchantID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A merchant can't be merged into itself"})
+			return
+		}
+	}
+
+	merged, err := services.MergeMerchants(userID, merchantID, sourceIDs)
+	if err == services.ErrMerchantNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Merchant not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error merging merchants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, merged)
+}
+
+// MerchantSpending is what the user spent (or received) at a merchant
+type MerchantSpending struct {
+	MerchantID int     `json:"merchant_id"`
+	Name       string  `json:"name"`
+	Total      float64 `json:"total"`
+	TotalPEN   float64 `json:"total_pen"`
+	TotalUSD   float64 `json:"total_usd"`
+	Count      int     `json:"count"`
+	LastDate   string  `json:"last_date"`
+}
+
+// GetMerchantSpending totals the user's transactions per merchant, largest
+// first. Transfers and ignored transactions are left out, as on the dashboard.
+func GetMerchantSpending(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	txType := c.DefaultQuery("type", "expense")
+	if txType != "income" && txType != "expense" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be income or expense"})
+		return
+	}
+
+	query := `
+		SELECT m.id, m.name,
+		       COALESCE(SUM(t.amount), 0),
+		       COALESCE(SUM(CASE WHEN t.currency = 'PEN' THEN t.amount ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN t.currency = 'USD' THEN t.amount ELSE 0 END), 0),
+		       COUNT(t.id), to_char(MAX(t.date), 'YYYY-MM-DD')
+		FROM merchants m
+		JOIN transactions t ON t.merchant_id = m.id
+		WHERE m.user_id = $1 AND t.type = $2 AND NOT t.is_transfer AND NOT t.ignored`
+	args := []interface{}{userID, txType}
+
+	if startDate := c.Query("start_date"); startDate != "" {
+		args = append(args, startDate)
+		query += " AND t.date >= $" + strconv.Itoa(len(args))
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		args = append(args, endDate)
+		query += " AND t.date <= $" + strconv.Itoa(len(args))
+	}
+	if accountID := c.Query("account_id"); accountID != "" {
+		args = append(args, accountID)
+		query += " AND t.account_id = $" + strconv.Itoa(len(args))
+	}
+	query += " GROUP BY m.id, m.name ORDER BY 3 DESC, m.name"
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching merchant spending"})
+		return
+	}
+	defer rows.Close()
+
+	result := []MerchantSpending{}
+	for rows.Next() {
+		var s MerchantSpending
+		if err := rows.Scan(&s.MerchantID, &s.Name, &s.Total, &s.TotalPEN, &s.TotalUSD, &s.Count, &s.LastDate); err != nil {
+			continue
+		}
+		result = append(result, s)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ResolveMerchants assigns a merchant to the user's transactions that have
+// none, creating merchants for descriptions no merchant matches
+func ResolveMerchants(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	rows, err := database.DB.Query(
+		`SELECT id, description FROM transactions WHERE user_id = $1 AND merchant_id IS NULL`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching transactions"})
+		return
+	}
+	type pending struct {
+		id          int
+		description string
+	}
+	var transactions []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.description); err == nil {
+			transactions = append(transactions, p)
+		}
+	}
+	rows.Close()
+
+	resolver, err := services.NewMerchantResolver(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching merchants"})
+		return
+	}
+
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting transaction"})
+		return
+	}
+	defer dbTx.Rollback()
+
+	resolved := 0
+	for _, tx := range transactions {
+		merchantID, err := resolver.Resolve(dbTx, tx.description)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resolving merchants"})
+			return
+		}
+		if merchantID == nil {
+			continue
+		}
+		if _, err := dbTx.Exec(`UPDATE transactions SET merchant_id = $1 WHERE id = $2`, *merchantID, tx.id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resolving merchants"})
+			return
+		}
+		resolved++
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error committing changes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Merchants resolved",
+		"resolved":   resolved,
+		"unresolved": len(transactions) - resolved,
+	})
+}
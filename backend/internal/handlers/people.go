@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/warren/finance-app/internal/services"
+)
+
+type PersonRequest struct {
+	Name string  `json:"name" binding:"required"`
+	Note *string `json:"note"`
+}
+
+type SharedExpenseRequest struct {
+	Direction   string  `json:"direction" binding:"required"` // owes_me, i_owe
+	Amount      float64 `json:"amount" binding:"required"`
+	Currency    string  `json:"currency"`
+	Date        string  `json:"date"`
+	Description string  `json:"description" binding:"required"`
+}
+
+type SettlementRequest struct {
+	Direction     string  `json:"direction" binding:"required"` // received, paid
+	Amount        float64 `json:"amount"`
+	Currency      string  `json:"currency"`
+	Date          string  `json:"date"`
+	TransactionID *int    `json:"transaction_id"`
+	Note          *string `json:"note"`
+}
+
+// personParam reads the person ID from the URL, answering 400 when it isn't a number
+func personParam(c *gin.Context) (int, bool) {
+	personID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid person ID"})
+		return 0, false
+	}
+	return personID, true
+}
+
+// GetPeople returns the user's people with their balances
+func GetPeople(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	people, err := services.ListPeople(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching people"})
+		return
+	}
+
+	c.JSON(http.StatusOK, people)
+}
+
+// GetPerson returns a single person with their balances
+func GetPerson(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	personID, ok := personParam(c)
+	if !ok {
+		return
+	}
+
+	person, err := services.GetPerson(userID, personID)
+	if err == services.ErrPersonNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching person"})
+		return
+	}
+
+	c.JSON(http.StatusOK, person)
+}
+
+// CreatePerson creates a new person
+func CreatePerson(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req PersonRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	person := services.Person{Name: req.Name, Note: req.Note}
+	if err := person.Normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := services.CreatePerson(userID, person)
+	if err == services.ErrPersonExists {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating person"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdatePerson updates an existing person
+func UpdatePerson(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	personID, ok := personParam(c)
+	if !ok {
+		return
+	}
+
+	var req PersonRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	person := services.Person{Name: req.Name, Note: req.Note}
+	if err := person.Normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := services.UpdatePerson(userID, personID, person)
+	if err == services.ErrPersonNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
+		return
+	}
+	if err == services.ErrPersonExists {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating person"})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeletePerson removes a person with their shared expenses and settlements
+func DeletePerson(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	personID, ok := personParam(c)
+	if !ok {
+		return
+	}
+
+	err := services.DeletePerson(userID, personID)
+	if err == services.ErrPersonNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting person"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Person deleted"})
+}
+
+// GetPersonHistory returns the shared expenses and settlements with a person,
+// newest first, with the running balance
+func GetPersonHistory(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	personID, ok := personParam(c)
+	if !ok {
+		return
+	}
+
+	history, err := services.PersonHistory(userID, personID)
+	if err == services.ErrPersonNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// CreateSharedExpense records an expense shared with a person outside the
+// user's transactions (e.g. one the person paid)
+func CreateSharedExpense(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	personID, ok := personParam(c)
+	if !ok {
+		return
+	}
+
+	var req SharedExpenseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Direction, amount and description are required"})
+		return
+	}
+
+	share, err := services.CreateSharedExpense(userID, personID, services.SharedExpense{
+		Direction:   req.Direction,
+		Amount:      req.Amount,
+		Currency:    req.Currency,
+		Date:        req.Date,
+		Description: req.Description,
+	})
+	var invalid *services.InvalidShareError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+		return
+	}
+	if err == services.ErrPersonNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating shared expense"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, share)
+}
+
+// DeleteSharedExpense removes a shared expense
+func DeleteSharedExpense(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	shareID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shared expense ID"})
+		return
+	}
+
+	err = services.DeleteSharedExpense(userID, shareID)
+	if err == services.ErrSharedExpenseNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shared expense not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting shared expense"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Shared expense deleted"})
+}
+
+// GetTransactionShares returns the parts of a transaction owed by or to people
+func GetTransactionShares(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	transactionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+		return
+	}
+
+	shares, err := services.ListTransactionShares(userID, transactionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching shares"})
+		return
+	}
+
+	c.JSON(http.StatusOK, shares)
+}
+
+// SetTransactionShares replaces the parts of a transaction owed by or to
+// people (body: shares with person_id and amount)
+func SetTransactionShares(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	transactionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+		return
+	}
+
+	var req struct {
+		Shares []struct {
+			PersonID int     `json:"person_id" binding:"required"`
+			Amount   float64 `json:"amount" binding:"required"`
+		} `json:"shares"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	shares := make([]services.SharedExpense, len(req.Shares))
+	for i, share := range req.Shares {
+		shares[i] = services.SharedExpense{PersonID: share.PersonID, Amount: share.Amount}
+	}
+
+	result, err := services.SetTransactionShares(userID, transactionID, shares)
+	var invalid *services.InvalidShareError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating shares"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// CreateSettlement records a payment between the user and a person,
+// optionally pointing to the transaction that paid it
+func CreateSettlement(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	personID, ok := personParam(c)
+	if !ok {
+		return
+	}
+
+	var req SettlementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Direction is required"})
+		return
+	}
+
+	settlement, err := services.CreateSettlement(userID, personID, services.Settlement{
+		Direction:     req.Direction,
+		Amount:        req.Amount,
+		Currency:      req.Currency,
+		Date:          req.Date,
+		TransactionID: req.TransactionID,
+		Note:          req.Note,
+	})
+	var invalid *services.InvalidShareError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+		return
+	}
+	if err == services.ErrPersonNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Person not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating settlement"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, settlement)
+}
+
+// DeleteSettlement removes a settlement
+func DeleteSettlement(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	settlementID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid settlement ID"})
+		return
+	}
+
+	err = services.DeleteSettlement(userID, settlementID)
+	if err == services.ErrSettlementNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Settlement not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting settlement"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Settlement deleted"})
+}
@@ -0,0 +1,399 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/warren/finance-app/internal/database"
+	"github.com/warren/finance-app/internal/services"
+)
+
+// recurringJobKind is the job kind that scans the history for recurring transactions
+const recurringJobKind = "recurring_detection"
+
+var (
+	errInvalidRecurringAccount  = errors.New("invalid account")
+	errInvalidRecurringMerchant = errors.New("invalid merchant")
+	errInvalidRecurringTags     = errors.New("invalid tags")
+)
+
+type RecurringRequest struct {
+	Name            string   `json:"name" binding:"required"`
+	Description     string   `json:"description"` // Defaults to the name
+	Amount          float64  `json:"amount" binding:"required"`
+	Currency        string   `json:"currency"`
+	Type            string   `json:"type" binding:"required"`
+	AccountID       *int     `json:"account_id"`
+	MerchantID      *int     `json:"merchant_id"`
+	TagIDs          []int    `json:"tag_ids"`
+	Frequency       string   `json:"frequency" binding:"required"` // weekly, monthly, yearly
+	Every           int      `json:"every"`                        // Defaults to 1
+	DayOfMonth      *int     `json:"day_of_month"`
+	Month           *int     `json:"month"`
+	StartDate       string   `json:"start_date"` // Defaults to today
+	EndDate         *string  `json:"end_date"`
+	Mode            string   `json:"mode"`             // create, expect (default)
+	AmountTolerance *float64 `json:"amount_tolerance"` // Defaults to 0.1
+}
+
+// toTemplate converts the request into a validated template, checking that
+// its account, merchant and tags belong to the user
+func (r RecurringRequest) toTemplate(userID int) (services.RecurringTemplate, error) {
+	tolerance := services.DefaultRecurringTolerance
+	if r.AmountTolerance != nil {
+		tolerance = *r.AmountTolerance
+	}
+
+	template := services.RecurringTemplate{
+		Name:            r.Name,
+		Description:     r.Description,
+		Amount:          r.Amount,
+		Currency:        r.Currency,
+		Type:            r.Type,
+		AccountID:       r.AccountID,
+		MerchantID:      r.MerchantID,
+		TagIDs:          r.TagIDs,
+		Frequency:       r.Frequency,
+		Every:           r.Every,
+		DayOfMonth:      r.DayOfMonth,
+		Month:           r.Month,
+		StartDate:       r.StartDate,
+		EndDate:         r.EndDate,
+		Mode:            r.Mode,
+		AmountTolerance: tolerance,
+	}
+	if err := template.Normalize(); err != nil {
+		return template, err
+	}
+
+	if template.AccountID != nil {
+		var owned bool
+		database.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM accounts WHERE id = $1 AND user_id = $2)`,
+			*template.AccountID, userID).Scan(&owned)
+		if !owned {
+			return template, errInvalidRecurringAccount
+		}
+	}
+	if template.MerchantID != nil {
+		if _, err := services.GetMerchant(userID, *template.MerchantID); err != nil {
+			return template, errInvalidRecurringMerchant
+		}
+	}
+	template.TagIDs = uniqueInts(template.TagIDs)
+	if len(template.TagIDs) > 0 {
+		var owned int
+		database.DB.QueryRow(`SELECT COUNT(*) FROM tags WHERE user_id = $1 AND id = ANY($2)`,
+			userID, pq.Array(template.TagIDs)).Scan(&owned)
+		if owned != len(template.TagIDs) {
+			return template, errInvalidRecurringTags
+		}
+	}
+	return template, nil
+}
+
+// generateRecurring records the due occurrences of the user's templates after
+// a change; the scheduler retries on failure
+func generateRecurring(userID int) {
+	if _, err := services.GenerateRecurring(userID, time.Now()); err != nil {
+		log.Printf("recurring: error generating occurrences for user %d: %v", userID, err)
+	}
+}
+
+// GetRecurringTemplates returns the user's recurring templates (param: status)
+func GetRecurringTemplates(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	status := c.Query("status")
+	if status != "" && status != services.RecurringActive && status != services.RecurringPaused &&
+		status != services.RecurringSuggested && status != services.RecurringDismissed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active, paused, suggested or dismissed"})
+		return
+	}
+
+	templates, err := services.ListRecurringTemplates(userID, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching recurring templates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, templates)
+}
+
+// GetRecurringTemplate returns a single template
+func GetRecurringTemplate(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	templateID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	template, err := services.GetRecurringTemplate(userID, templateID)
+	if err == services.ErrRecurringNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recurring template not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching recurring template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, template)
+}
+
+// CreateRecurringTemplate creates an active template and records its due occurrences
+func CreateRecurringTemplate(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req RecurringRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name, amount, type and frequency are required"})
+		return
+	}
+
+	template, err := req.toTemplate(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := services.CreateRecurringTemplate(userID, template)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating recurring template"})
+		return
+	}
+	generateRecurring(userID)
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateRecurringTemplate updates a template; expected occurrences follow the new schedule
+func UpdateRecurringTemplate(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	templateID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	var req RecurringRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name, amount, type and frequency are required"})
+		return
+	}
+
+	template, err := req.toTemplate(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := services.UpdateRecurringTemplate(userID, templateID, template)
+	if err == services.ErrRecurringNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recurring template not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating recurring template"})
+		return
+	}
+	generateRecurring(userID)
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteRecurringTemplate removes a template; its transactions are kept
+func DeleteRecurringTemplate(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	templateID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	err = services.DeleteRecurringTemplate(userID, templateID)
+	if err == services.ErrRecurringNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recurring template not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting recurring template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Recurring template deleted"})
+}
+
+// ActivateRecurringTemplate accepts a suggested template or resumes a paused one
+func ActivateRecurringTemplate(c *gin.Context) {
+	setRecurringStatus(c, services.RecurringActive)
+}
+
+// PauseRecurringTemplate stops generating a template's occurrences
+func PauseRecurringTemplate(c *gin.Context) {
+	setRecurringStatus(c, services.RecurringPaused)
+}
+
+// DismissRecurringTemplate dismisses a template; detection won't suggest it again
+func DismissRecurringTemplate(c *gin.Context) {
+	setRecurringStatus(c, services.RecurringDismissed)
+}
+
+func setRecurringStatus(c *gin.Context, status string) {
+	userID := c.GetInt("user_id")
+	templateID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	template, err := services.SetRecurringStatus(userID, templateID, status)
+	if err == services.ErrRecurringNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recurring template not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating recurring template"})
+		return
+	}
+	if status == services.RecurringActive {
+		generateRecurring(userID)
+	}
+
+	c.JSON(http.StatusOK, template)
+}
+
+// GetRecurringOccurrences returns the occurrences of a template, newest first
+func GetRecurringOccurrences(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	templateID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	occurrences, err := services.ListRecurringOccurrences(userID, templateID)
+	if err == services.ErrRecurringNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recurring template not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching occurrences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, occurrences)
+}
+
+// SkipRecurringOccurrence marks an expected occurrence as not happening
+func SkipRecurringOccurrence(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	occurrenceID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid occurrence ID"})
+		return
+	}
+
+	occurrence, err := services.SkipOccurrence(userID, occurrenceID)
+	if err == services.ErrOccurrenceNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Expected occurrence not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error skipping occurrence"})
+		return
+	}
+
+	c.JSON(http.StatusOK, occurrence)
+}
+
+// GetUpcomingRecurring returns the recurring transactions due in the next
+// days (param: days, default 30)
+func GetUpcomingRecurring(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	days := services.RecurringUpcomingDays
+	if value := c.Query("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 366 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 366"})
+			return
+		}
+		days = parsed
+	}
+
+	upcoming, err := services.UpcomingRecurring(userID, time.Now(), days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching upcoming transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, upcoming)
+}
+
+// RunRecurring records the user's due occurrences now instead of waiting for the scheduler
+func RunRecurring(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	generated, err := services.GenerateRecurring(userID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating occurrences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"generated": generated})
+}
+
+// DetectRecurring scans the history for recurring transactions and suggests
+// templates for them. With async=true it answers 202 with the job to follow.
+func DetectRecurring(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	async := c.Query("async") == "true"
+	job, err := services.EnqueueJob(userID, recurringJobKind, struct{}{}, async)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error queueing detection"})
+		return
+	}
+
+	if async {
+		c.JSON(http.StatusAccepted, gin.H{
+			"job_id":  job.ID,
+			"status":  job.Status,
+			"message": "Recurring transaction detection queued",
+		})
+		return
+	}
+
+	job, err = services.RunJobInline(c.Request.Context(), job)
+	if err == services.ErrJobStillRunning {
+		c.JSON(http.StatusAccepted, gin.H{
+			"job_id":  job.ID,
+			"status":  services.JobRunning,
+			"message": "Detection is still running",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error detecting recurring transactions"})
+		return
+	}
+	if job.Status == services.JobFailed {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": *job.Error})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", job.Result)
+}
+
+// runRecurringJob scans the user's history for recurring transactions
+func runRecurringJob(job *services.Job) (interface{}, error) {
+	return services.DetectRecurring(job.UserID, time.Now(), func(done int, total int) {
+		job.SetProgress("scanning", done, total)
+	})
+}
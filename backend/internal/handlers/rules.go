@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/warren/finance-app/internal/database"
+	"github.com/warren/finance-app/internal/services"
+)
+
+var (
+	errInvalidRuleAccount = errors.New("invalid account")
+	errInvalidRuleTags    = errors.New("invalid tags")
+)
+
+type RuleRequest struct {
+	Name                string   `json:"name" binding:"required"`
+	Priority            int      `json:"priority"`
+	Enabled             *bool    `json:"enabled"` // Defaults to true
+	StopProcessing      bool     `json:"stop_processing"`
+	DescriptionContains string   `json:"description_contains"`
+	DescriptionRegex    string   `json:"description_regex"`
+	MinAmount           *float64 `json:"min_amount"`
+	MaxAmount           *float64 `json:"max_amount"`
+	Type                string   `json:"type"`
+	AccountID           *int     `json:"account_id"`
+	Currency            string   `json:"currency"`
+	DayFrom             *int     `json:"day_from"`
+	DayTo               *int     `json:"day_to"`
+	TagIDs              []int    `json:"tag_ids"`
+	SetDetail           *string  `json:"set_detail"`
+	MarkTransfer        bool     `json:"mark_transfer"`
+	Ignore              bool     `json:"ignore"`
+}
+
+// toRule converts the request into a validated rule, checking that its
+// account and tags belong to the user
+func (r RuleRequest) toRule(userID int) (services.Rule, error) {
+	enabled := true
+	if r.Enabled != nil {
+		enabled = *r.Enabled
+	}
+
+	rule := services.Rule{
+		Name:                r.Name,
+		Priority:            r.Priority,
+		Enabled:             enabled,
+		StopProcessing:      r.StopProcessing,
+		DescriptionContains: r.DescriptionContains,
+		DescriptionRegex:    r.DescriptionRegex,
+		MinAmount:           r.MinAmount,
+		MaxAmount:           r.MaxAmount,
+		Type:                r.Type,
+		AccountID:           r.AccountID,
+		Currency:            r.Currency,
+		DayFrom:             r.DayFrom,
+		DayTo:               r.DayTo,
+		TagIDs:              r.TagIDs,
+		SetDetail:           r.SetDetail,
+		MarkTransfer:        r.MarkTransfer,
+		Ignore:              r.Ignore,
+	}
+	if err := rule.Normalize(); err != nil {
+		return rule, err
+	}
+
+	if rule.AccountID != nil {
+		var owned bool
+		database.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM accounts WHERE id = $1 AND user_id = $2)`,
+			*rule.AccountID, userID).Scan(&owned)
+		if !owned {
+			return rule, errInvalidRuleAccount
+		}
+	}
+	rule.TagIDs = uniqueInts(rule.TagIDs)
+	if len(rule.TagIDs) > 0 {
+		var owned int
+		database.DB.QueryRow(`SELECT COUNT(*) FROM tags WHERE user_id = $1 AND id = ANY($2)`,
+			userID, pq.Array(rule.TagIDs)).Scan(&owned)
+		if owned != len(rule.TagIDs) {
+			return rule, errInvalidRuleTags
+		}
+	}
+	return rule, nil
+}
+
+// GetRules returns the user's rules in the order they run
+func GetRules(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	rules, err := services.ListRules(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+// GetRule returns a single rule
+func GetRule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ruleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	rule, err := services.GetRule(userID, ruleID)
+	if err == services.ErrRuleNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching rule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+// CreateRule creates a new rule
+func CreateRule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req RuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	rule, err := req.toRule(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := services.CreateRule(userID, rule)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating rule"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateRule updates an existing rule
+func UpdateRule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ruleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	var req RuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	rule, err := req.toRule(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := services.UpdateRule(userID, ruleID, rule)
+	if err == services.ErrRuleNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating rule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteRule deletes a rule
+func DeleteRule(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ruleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	err = services.DeleteRule(userID, ruleID)
+	if err == services.ErrRuleNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting rule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Rule deleted"})
+}
+
+// RunRulesRequest selects the rules and the past transactions to run them on
+type RunRulesRequest struct {
+	RuleIDs   []int  `json:"rule_ids"` // Only these rules, even if disabled; all enabled rules when empty
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	AccountID *int   `json:"account_id"`
+}
+
+// RuleChange is what the rules would change in a transaction
+type RuleChange struct {
+	TransactionID int     `json:"transaction_id"`
+	Description   string  `json:"description"`
+	Amount        float64 `json:"amount"`
+	Type          string  `json:"type"`
+	Date          string  `json:"date"`
+	RuleIDs       []int   `json:"rule_ids"`
+	AddTagIDs     []int   `json:"add_tag_ids"`
+	Detail        *string `json:"detail,omitempty"` // Only set on transactions without a detail
+	MarkTransfer  bool    `json:"mark_transfer,omitempty"`
+	Ignore        bool    `json:"ignore,omitempty"`
+}
+
+// PreviewRules runs the rules over past transactions without saving, and
+// returns what would change
+func PreviewRules(c *gin.Context) {
+	runRules(c, true)
+}
+
+// RunRules runs the rules over past transactions and saves the changes.
+// Rules only add: existing tags stay, details are only filled in when empty.
+func RunRules(c *gin.Context) {
+	runRules(c, false)
+}
+
+func runRules(c *gin.Context, dryRun bool) {
+	userID := c.GetInt("user_id")
+
+	var req RunRulesRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	rules, err := selectRules(userID, req.RuleIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching rules"})
+		return
+	}
+
+	changes, scanned, err := ruleChanges(userID, rules, req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error running rules"})
+		return
+	}
+
+	if !dryRun && len(changes) > 0 {
+		if err := saveRuleChanges(userID, changes); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving rule changes"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"changes": changes,
+		"count":   len(changes),
+		"scanned": scanned,
+		"dry_run": dryRun,
+	})
+}
+
+// selectRules returns the given rules in run order, or every enabled rule
+func selectRules(userID int, ruleIDs []int) ([]services.Rule, error) {
+	if len(ruleIDs) == 0 {
+		return services.LoadEnabledRules(userID)
+	}
+
+	all, err := services.ListRules(userID)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[int]bool)
+	for _, id := range ruleIDs {
+		wanted[id] = true
+	}
+	rules := []services.Rule{}
+	for _, rule := range all {
+		if wanted[rule.ID] {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// ruleChanges runs the rules over the selected transactions and returns those
+// that would change, along with how many were looked at
+func ruleChanges(userID int, rules []services.Rule, req RunRulesRequest) ([]RuleChange, int, error) {
+	changes := []RuleChange{}
+	if len(rules) == 0 {
+		return changes, 0, nil
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT t.id, t.description, t.detail, t.amount, t.currency, t.type, to_char(t.date, 'YYYY-MM-DD'),
+		       COALESCE(t.account_id, 0), t.is_transfer, t.ignored,
+		       COALESCE(array_agg(tt.tag_id) FILTER (WHERE tt.tag_id IS NOT NULL), ARRAY[]::int[])
+		FROM transactions t
+		LEFT JOIN transaction_tags tt ON t.id = tt.transaction_id
+		WHERE t.user_id = $1
+		  AND ($2 = '' OR t.date >= NULLIF($2, '')::date)
+		  AND ($3 = '' OR t.date <= NULLIF($3, '')::date)
+		  AND ($4::integer IS NULL OR t.account_id = $4)
+		GROUP BY t.id
+		ORDER BY t.date, t.id`, userID, req.StartDate, req.EndDate, req.AccountID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	scanned := 0
+	for rows.Next() {
+		var change RuleChange
+		var detail *string
+		var subject services.RuleSubject
+		var isTransfer, ignored bool
+		var tagIDs pq.Int64Array
+
+		err := rows.Scan(&change.TransactionID, &change.Description, &detail, &change.Amount, &subject.Currency,
+			&change.Type, &change.Date, &subject.AccountID, &isTransfer, &ignored, &tagIDs)
+		if err != nil {
+			return nil, 0, err
+		}
+		scanned++
+
+		subject.Description = change.Description
+		subject.Amount = change.Amount
+		subject.Type = change.Type
+		subject.Date = change.Date
+		outcome := services.ApplyRules(rules, subject)
+		if !outcome.Matched() {
+			continue
+		}
+
+		current := make(map[int]bool)
+		for _, id := range tagIDs {
+			current[int(id)] = true
+		}
+		change.RuleIDs = outcome.RuleIDs
+		change.AddTagIDs = []int{}
+		for _, id := range outcome.TagIDs {
+			if !current[id] {
+				change.AddTagIDs = append(change.AddTagIDs, id)
+			}
+		}
+		if outcome.Detail != nil && (detail == nil || *detail == "") {
+			change.Detail = outcome.Detail
+		}
+		change.MarkTransfer = outcome.MarkTransfer && !isTransfer
+		change.Ignore = outcome.Ignore && !ignored
+
+		if len(change.AddTagIDs) > 0 || change.Detail != nil || change.MarkTransfer || change.Ignore {
+			changes = append(changes, change)
+		}
+	}
+	return changes, scanned, rows.Err()
+}
+
+// saveRuleChanges applies the changes in one database transaction
+func saveRuleChanges(userID int, changes []RuleChange) error {
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+
+	for _, change := range changes {
+		for _, tagID := range change.AddTagIDs {
+			_, err := dbTx.Exec(`
+				INSERT INTO transaction_tags (transaction_id, tag_id)
+				SELECT $1, $2
+				WHERE EXISTS (SELECT 1 FROM tags WHERE id = $2 AND user_id = $3)
+				ON CONFLICT DO NOTHING`, change.TransactionID, tagID, userID)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := dbTx.Exec(`
+			UPDATE transactions
+			SET detail = COALESCE(NULLIF(detail, ''), $1), is_transfer = is_transfer OR $2,
+			    marked_transfer = marked_transfer OR $2, ignored = ignored OR $3,
+			    updated_at = NOW()
+			WHERE id = $4 AND user_id = $5`,
+			change.Detail, change.MarkTransfer, change.Ignore, change.TransactionID, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		return err
+	}
+
+	ids := make([]int, len(changes))
+	for i, change := range changes {
+		ids[i] = change.TransactionID
+	}
+	services.UpdateTagModel(userID, ids)
+	return nil
+}
+
+// uniqueInts returns the distinct values, keeping their order
+func uniqueInts(values []int) []int {
+	seen := make(map[int]bool)
+	result := []int{}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
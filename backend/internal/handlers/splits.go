@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/warren/finance-app/internal/database"
+	"github.com/warren/finance-app/internal/models"
+	"github.com/warren/finance-app/internal/services"
+)
+
+type SplitRequest struct {
+	Amount float64 `json:"amount" binding:"required"`
+	Detail *string `json:"detail"`
+	TagIDs []int   `json:"tag_ids"`
+}
+
+// loadSplits returns the splits of the given transactions with their tags, by transaction
+func loadSplits(transactionIDs []int) (map[int][]models.TransactionSplit, error) {
+	result := make(map[int][]models.TransactionSplit)
+	if len(transactionIDs) == 0 {
+		return result, nil
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT s.id, s.transaction_id, s.amount, s.detail
+		FROM transaction_splits s
+		WHERE s.transaction_id = ANY($1)
+		ORDER BY s.transaction_id, s.position`, pq.Array(transactionIDs))
+	if err != nil {
+		return nil, err
+	}
+	var splits []models.TransactionSplit
+	for rows.Next() {
+		var split models.TransactionSplit
+		if err := rows.Scan(&split.ID, &split.TransactionID, &split.Amount, &split.Detail); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		split.Tags = []models.Tag{}
+		splits = append(splits, split)
+	}
+	rows.Close()
+	if len(splits) == 0 {
+		return result, nil
+	}
+
+	splitIDs := make([]int, len(splits))
+	for i, split := range splits {
+		splitIDs[i] = split.ID
+	}
+	tagRows, err := database.DB.Query(`
+		SELECT st.split_id, tg.id, tg.user_id, tg.name, tg.color, tg.created_at
+		FROM split_tags st
+		JOIN tags tg ON st.tag_id = tg.id
+		WHERE st.split_id = ANY($1)`, pq.Array(splitIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer tagRows.Close()
+	tagMap := make(map[int][]models.Tag)
+	for tagRows.Next() {
+		var splitID int
+		var tag models.Tag
+		if err := tagRows.Scan(&splitID, &tag.ID, &tag.UserID, &tag.Name, &tag.Color, &tag.CreatedAt); err == nil {
+			tagMap[splitID] = append(tagMap[splitID], tag)
+		}
+	}
+
+	for _, split := range splits {
+		if tags, ok := tagMap[split.ID]; ok {
+			split.Tags = tags
+		}
+		result[split.TransactionID] = append(result[split.TransactionID], split)
+	}
+	return result, nil
+}
+
+// GetTransactionSplits returns the splits of a transaction
+func GetTransactionSplits(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	transactionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+		return
+	}
+
+	var exists bool
+	err = database.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM transactions WHERE id = $1 AND user_id = $2)`,
+		transactionID, userID).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+
+	splits, err := loadSplits([]int{transactionID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching splits"})
+		return
+	}
+
+	result := splits[transactionID]
+	if result == nil {
+		result = []models.TransactionSplit{}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// SetTransactionSplits replaces the splits of a transaction. They must add up
+// to the transaction's amount; an empty list removes them. The transaction's
+// tags become those of its splits.
+func SetTransactionSplits(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	transactionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+		return
+	}
+
+	var req struct {
+		Splits []SplitRequest `json:"splits"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if len(req.Splits) == 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A transaction is split in at least two parts"})
+		return
+	}
+
+	var allTagIDs []int
+	for i := range req.Splits {
+		split := &req.Splits[i]
+		split.Amount = math.Round(split.Amount*100) / 100
+		if split.Amount <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Split amounts must be positive"})
+			return
+		}
+		split.TagIDs = uniqueInts(split.TagIDs)
+		allTagIDs = append(allTagIDs, split.TagIDs...)
+	}
+	allTagIDs = uniqueInts(allTagIDs)
+	if len(allTagIDs) > 0 {
+		var owned int
+		database.DB.QueryRow(`SELECT COUNT(*) FROM tags WHERE user_id = $1 AND id = ANY($2)`,
+			userID, pq.Array(allTagIDs)).Scan(&owned)
+		if owned != len(allTagIDs) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tags"})
+			return
+		}
+	}
+
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting transaction"})
+		return
+	}
+	defer dbTx.Rollback()
+
+	var amount float64
+	err = dbTx.QueryRow(`SELECT amount FROM transactions WHERE id = $1 AND user_id = $2 FOR UPDATE`,
+		transactionID, userID).Scan(&amount)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching transaction"})
+		return
+	}
+
+	if len(req.Splits) > 0 {
+		total := 0.0
+		for _, split := range req.Splits {
+			total += split.Amount
+		}
+		if math.Abs(total-amount) >= 0.005 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Splits add up to %.2f instead of %.2f", total, amount)})
+			return
+		}
+	}
+
+	if _, err := dbTx.Exec(`DELETE FROM transaction_splits WHERE transaction_id = $1`, transactionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating splits"})
+		return
+	}
+	for i, split := range req.Splits {
+		var splitID int
+		err := dbTx.QueryRow(`
+			INSERT INTO transaction_splits (transaction_id, position, amount, detail)
+			VALUES ($1, $2, $3, NULLIF($4, ''))
+			RETURNING id`, transactionID, i+1, split.Amount, split.Detail).Scan(&splitID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating splits"})
+			return
+		}
+		for _, tagID := range split.TagIDs {
+			if _, err := dbTx.Exec(`INSERT INTO split_tags (split_id, tag_id) VALUES ($1, $2)`, splitID, tagID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating splits"})
+				return
+			}
+		}
+	}
+
+	// The transaction carries the tags of its splits, so lists and filters by tag show it
+	if len(req.Splits) > 0 {
+		_, err = dbTx.Exec(`DELETE FROM transaction_tags WHERE transaction_id = $1`, transactionID)
+		if err == nil && len(allTagIDs) > 0 {
+			_, err = dbTx.Exec(`
+				INSERT INTO transaction_tags (transaction_id, tag_id)
+				SELECT $1, unnest($2::integer[])`, transactionID, pq.Array(allTagIDs))
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating tags"})
+			return
+		}
+	}
+
+	if _, err := dbTx.Exec(`UPDATE transactions SET updated_at = NOW() WHERE id = $1`, transactionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating splits"})
+		return
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error committing changes"})
+		return
+	}
+	services.UpdateTagModel(userID, []int{transactionID})
+
+	splits, err := loadSplits([]int{transactionID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching splits"})
+		return
+	}
+	result := splits[transactionID]
+	if result == nil {
+		result = []models.TransactionSplit{}
+	}
+	c.JSON(http.StatusOK, result)
+}
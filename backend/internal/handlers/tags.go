@@ -7,6 +7,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/warren/finance-app/internal/database"
+	"github.com/warren/finance-app/internal/services"
 )
 
 type Tag struct {
@@ -169,6 +170,9 @@ func DeleteTag(c *gin.Context) {
 		return
 	}
 
+	// The classifier would keep predicting the deleted tag
+	services.ForgetTagModel(userID)
+
 	c.JSON(http.StatusOK, gin.H{"message": "Tag deleted"})
 }
 
@@ -234,6 +238,15 @@ func SetTransactionTags(c *gin.Context) {
 		return
 	}
 
+	// Split transactions take their tags from the splits
+	var split bool
+	database.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM transaction_splits WHERE transaction_id = $1)`,
+		transactionID).Scan(&split)
+	if split {
+		c.JSON(http.StatusConflict, gin.H{"error": "The transaction is split; set the tags of its splits instead"})
+		return
+	}
+
 	// Start transaction
 	tx, err := database.DB.Begin()
 	if err != nil {
@@ -249,6 +262,13 @@ func SetTransactionTags(c *gin.Context) {
 		return
 	}
 
+	// Retagging counts as an edit (e.g. when reverting an import)
+	_, err = tx.Exec(`UPDATE transactions SET updated_at = NOW() WHERE id = $1`, transactionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating tags"})
+		return
+	}
+
 	// Insert new tags (only if they belong to the user)
 	for _, tagID := range req.TagIDs {
 		_, err = tx.Exec(`
@@ -267,5 +287,8 @@ func SetTransactionTags(c *gin.Context) {
 		return
 	}
 
+	// Learn from the new tags right away
+	services.UpdateTagModel(userID, []int{transactionID})
+
 	c.JSON(http.StatusOK, gin.H{"message": "Tags updated"})
 }
@@ -1,6 +1,10 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
@@ -9,6 +13,7 @@ import (
 	"github.com/lib/pq"
 	"github.com/warren/finance-app/internal/database"
 	"github.com/warren/finance-app/internal/models"
+	"github.com/warren/finance-app/internal/services"
 )
 
 func GetTransactions(c *gin.Context) {
@@ -22,13 +27,16 @@ func GetTransactions(c *gin.Context) {
 	tagIDs := c.Query("tag_ids") // comma-separated list of tag IDs
 	accountID := c.Query("account_id")
 	accountType := c.Query("account_type")
+	merchantID := c.Query("merchant_id")
 
 	query := `
 		SELECT t.id, t.user_id, t.description, t.detail, t.amount, t.currency, t.type,
-		       t.date, t.source, t.raw_text, t.created_at, t.updated_at,
-		       t.account_id, a.name, a.account_type
+		       t.date, t.source, t.raw_text, t.is_transfer, t.ignored, t.merchant_id, m.name, t.created_at, t.updated_at,
+		       t.account_id, a.name, a.account_type, COALESCE(lt.allocated, 0)
 		FROM transactions t
 		LEFT JOIN accounts a ON t.account_id = a.id
+		LEFT JOIN merchants m ON t.merchant_id = m.id
+		LEFT JOIN transaction_link_totals lt ON lt.transaction_id = t.id
 		WHERE t.user_id = $1
 	`
 	args := []interface{}{userID}
@@ -49,10 +57,16 @@ func GetTransactions(c *gin.Context) {
 		query += " AND t.type = $" + strconv.Itoa(argCount)
 		args = append(args, txType)
 	}
+	// Tag filters look at the splits of split transactions; each filter keeps
+	// the splits that match it to report the part of the amount under the tags
+	var tagFilters [][]int
 	if tagID != "" {
 		argCount++
-		query += " AND EXISTS (SELECT 1 FROM transaction_tags tt WHERE tt.transaction_id = t.id AND tt.tag_id = $" + strconv.Itoa(argCount) + ")"
+		query += " AND EXISTS (SELECT 1 FROM transaction_tag_amounts tt WHERE tt.transaction_id = t.id AND tt.tag_id = $" + strconv.Itoa(argCount) + ")"
 		args = append(args, tagID)
+		if id, err := strconv.Atoi(tagID); err == nil {
+			tagFilters = append(tagFilters, []int{id})
+		}
 	}
 	if tagIDs != "" {
 		// Parse comma-separated tag IDs
@@ -65,8 +79,9 @@ func GetTransactions(c *gin.Context) {
 		}
 		if len(tagIDInts) > 0 {
 			argCount++
-			query += " AND EXISTS (SELECT 1 FROM transaction_tags tt WHERE tt.transaction_id = t.id AND tt.tag_id = ANY($" + strconv.Itoa(argCount) + "))"
+			query += " AND EXISTS (SELECT 1 FROM transaction_tag_amounts tt WHERE tt.transaction_id = t.id AND tt.tag_id = ANY($" + strconv.Itoa(argCount) + "))"
 			args = append(args, pq.Array(tagIDInts))
+			tagFilters = append(tagFilters, tagIDInts)
 		}
 	}
 	if accountID != "" {
@@ -79,6 +94,11 @@ func GetTransactions(c *gin.Context) {
 		query += " AND a.account_type = $" + strconv.Itoa(argCount)
 		args = append(args, accountType)
 	}
+	if merchantID != "" {
+		argCount++
+		query += " AND t.merchant_id = $" + strconv.Itoa(argCount)
+		args = append(args, merchantID)
+	}
 
 	query += " ORDER BY t.date DESC, t.created_at DESC"
 
@@ -99,8 +119,8 @@ func GetTransactions(c *gin.Context) {
 
 		err := rows.Scan(
 			&t.ID, &t.UserID, &t.Description, &t.Detail, &t.Amount, &t.Currency, &t.Type,
-			&t.Date, &t.Source, &t.RawText, &t.CreatedAt, &t.UpdatedAt,
-			&accountID, &accountName, &accountAccType,
+			&t.Date, &t.Source, &t.RawText, &t.IsTransfer, &t.Ignored, &t.MerchantID, &t.Merchant, &t.CreatedAt, &t.UpdatedAt,
+			&accountID, &accountName, &accountAccType, &t.LinkedAmount,
 		)
 		if err != nil {
 			continue
@@ -144,6 +164,16 @@ func GetTransactions(c *gin.Context) {
 				}
 			}
 		}
+
+		if splits, err := loadSplits(transactionIDs); err == nil {
+			for i := range transactions {
+				transactions[i].Splits = splits[transactions[i].ID]
+				if len(tagFilters) > 0 {
+					amount := tagFilterAmount(transactions[i], tagFilters)
+					transactions[i].TagAmount = &amount
+				}
+			}
+		}
 	}
 
 	if transactions == nil {
@@ -153,6 +183,38 @@ func GetTransactions(c *gin.Context) {
 	c.JSON(http.StatusOK, transactions)
 }
 
+// tagFilterAmount returns the part of a transaction's amount under the tag
+// filters: the splits having a tag of every filter, or the whole amount when
+// the transaction isn't split
+func tagFilterAmount(t models.Transaction, tagFilters [][]int) float64 {
+	if len(t.Splits) == 0 {
+		return t.Amount
+	}
+
+	total := 0.0
+	for _, split := range t.Splits {
+		matches := true
+		for _, filter := range tagFilters {
+			found := false
+			for _, tag := range split.Tags {
+				for _, id := range filter {
+					if tag.ID == id {
+						found = true
+					}
+				}
+			}
+			if !found {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			total += split.Amount
+		}
+	}
+	return total
+}
+
 func CreateTransaction(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
@@ -168,6 +230,54 @@ func CreateTransaction(c *gin.Context) {
 		currency = "PEN"
 	}
 
+	// Merchant from the description unless given; its default tags apply when
+	// the request has none. A new merchant is created with the transaction.
+	merchantID := req.MerchantID
+	var newMerchant *services.MerchantResolver
+	if merchantID != nil {
+		merchant, err := services.GetMerchant(userID, *merchantID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant"})
+			return
+		}
+		if len(req.TagIDs) == 0 {
+			req.TagIDs = merchant.DefaultTagIDs
+		}
+	} else if merchants, err := services.NewMerchantResolver(userID); err == nil {
+		if merchant := merchants.Match(req.Description); merchant != nil {
+			merchantID = &merchant.ID
+			if len(req.TagIDs) == 0 {
+				req.TagIDs = merchant.DefaultTagIDs
+			}
+		} else {
+			newMerchant = merchants
+		}
+	}
+
+	// The user's rules add tags and fill in what the request leaves unset
+	detail := req.Detail
+	isTransfer, ignored := false, false
+	if rules, err := services.LoadEnabledRules(userID); err == nil {
+		outcome := services.ApplyRules(rules, services.RuleSubject{
+			Description: req.Description,
+			Amount:      req.Amount,
+			Type:        req.Type,
+			Currency:    currency,
+			Date:        req.Date,
+		})
+		req.TagIDs = uniqueInts(append(req.TagIDs, outcome.TagIDs...))
+		if detail == nil {
+			detail = outcome.Detail
+		}
+		isTransfer, ignored = outcome.MarkTransfer, outcome.Ignore
+	}
+	if req.IsTransfer != nil {
+		isTransfer = *req.IsTransfer
+	}
+	if req.Ignored != nil {
+		ignored = *req.Ignored
+	}
+
 	// Start transaction
 	tx, err := database.DB.Begin()
 	if err != nil {
@@ -176,13 +286,20 @@ func CreateTransaction(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
+	if newMerchant != nil {
+		if merchantID, err = newMerchant.Resolve(tx, req.Description); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating transaction"})
+			return
+		}
+	}
+
 	var t models.Transaction
 	err = tx.QueryRow(
-		`INSERT INTO transactions (user_id, description, detail, amount, currency, type, date, source)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, 'manual')
-		 RETURNING id, user_id, description, detail, amount, currency, type, date, source, created_at, updated_at`,
-		userID, req.Description, req.Detail, req.Amount, currency, req.Type, req.Date,
-	).Scan(&t.ID, &t.UserID, &t.Description, &t.Detail, &t.Amount, &t.Currency, &t.Type, &t.Date, &t.Source, &t.CreatedAt, &t.UpdatedAt)
+		`INSERT INTO transactions (user_id, description, detail, amount, currency, type, date, source, is_transfer, marked_transfer, ignored, merchant_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, 'manual', $8, $8, $9, $10)
+		 RETURNING id, user_id, description, detail, amount, currency, type, date, source, is_transfer, ignored, merchant_id, created_at, updated_at`,
+		userID, req.Description, detail, req.Amount, currency, req.Type, req.Date, isTransfer, ignored, merchantID,
+	).Scan(&t.ID, &t.UserID, &t.Description, &t.Detail, &t.Amount, &t.Currency, &t.Type, &t.Date, &t.Source, &t.IsTransfer, &t.Ignored, &t.MerchantID, &t.CreatedAt, &t.UpdatedAt)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating transaction"})
@@ -206,6 +323,8 @@ func CreateTransaction(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error committing transaction"})
 		return
 	}
+	services.UpdateTagModel(userID, []int{t.ID})
+	services.MatchRecurring(userID, []int{t.ID})
 
 	// Fetch tags for response
 	if len(req.TagIDs) > 0 {
@@ -245,6 +364,43 @@ func UpdateTransaction(c *gin.Context) {
 		currency = "PEN"
 	}
 
+	if req.MerchantID != nil {
+		if _, err := services.GetMerchant(userID, *req.MerchantID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant"})
+			return
+		}
+	}
+
+	// Split transactions keep the amount their splits add up to
+	var splitTotal sql.NullFloat64
+	database.DB.QueryRow(`
+		SELECT SUM(s.amount) FROM transaction_splits s
+		JOIN transactions t ON t.id = s.transaction_id
+		WHERE s.transaction_id = $1 AND t.user_id = $2`, txID, userID).Scan(&splitTotal)
+	if splitTotal.Valid && math.Abs(splitTotal.Float64-req.Amount) >= 0.005 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The amount must equal the sum of the transaction's splits; update the splits first"})
+		return
+	}
+
+	// Linked transactions keep their type and currency, and at least the amount
+	// allocated to their links
+	var linkedType, linkedCurrency string
+	var allocated float64
+	err := database.DB.QueryRow(`
+		SELECT t.type, t.currency, lt.allocated FROM transactions t
+		JOIN transaction_link_totals lt ON lt.transaction_id = t.id
+		WHERE t.id = $1 AND t.user_id = $2`, txID, userID).Scan(&linkedType, &linkedCurrency, &allocated)
+	if err == nil {
+		if linkedType != req.Type || linkedCurrency != currency {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A linked transaction can't change its type or currency; unlink it first"})
+			return
+		}
+		if req.Amount < allocated-0.005 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%.2f of the amount is linked to reimbursements; update the links first", allocated)})
+			return
+		}
+	}
+
 	// Start transaction
 	tx, err := database.DB.Begin()
 	if err != nil {
@@ -256,29 +412,39 @@ func UpdateTransaction(c *gin.Context) {
 	var t models.Transaction
 	err = tx.QueryRow(
 		`UPDATE transactions
-		 SET description = $1, detail = $2, amount = $3, currency = $4, type = $5, date = $6, updated_at = NOW()
+		 SET description = $1, detail = $2, amount = $3, currency = $4, type = $5, date = $6,
+		     marked_transfer = COALESCE($9, marked_transfer),
+		     is_transfer = COALESCE($9, marked_transfer) OR EXISTS (
+		         SELECT 1 FROM transfers tr
+		         WHERE tr.status = 'confirmed' AND transactions.id IN (tr.from_transaction_id, tr.to_transaction_id)
+		     ),
+		     ignored = COALESCE($10, ignored),
+		     merchant_id = COALESCE($11, merchant_id), updated_at = NOW()
 		 WHERE id = $7 AND user_id = $8
-		 RETURNING id, user_id, description, detail, amount, currency, type, date, source, created_at, updated_at`,
-		req.Description, req.Detail, req.Amount, currency, req.Type, req.Date, txID, userID,
-	).Scan(&t.ID, &t.UserID, &t.Description, &t.Detail, &t.Amount, &t.Currency, &t.Type, &t.Date, &t.Source, &t.CreatedAt, &t.UpdatedAt)
+		 RETURNING id, user_id, description, detail, amount, currency, type, date, source, is_transfer, ignored, merchant_id, created_at, updated_at`,
+		req.Description, req.Detail, req.Amount, currency, req.Type, req.Date, txID, userID, req.IsTransfer, req.Ignored, req.MerchantID,
+	).Scan(&t.ID, &t.UserID, &t.Description, &t.Detail, &t.Amount, &t.Currency, &t.Type, &t.Date, &t.Source, &t.IsTransfer, &t.Ignored, &t.MerchantID, &t.CreatedAt, &t.UpdatedAt)
 
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
 		return
 	}
 
-	// Update tags - delete existing and insert new
-	_, _ = tx.Exec(`DELETE FROM transaction_tags WHERE transaction_id = $1`, t.ID)
-
+	// Update tags - delete existing and insert new. Split transactions take
+	// their tags from the splits, so tag_ids is ignored for them.
 	t.Tags = []models.Tag{}
-	for _, tagID := range req.TagIDs {
-		_, err = tx.Exec(`
-			INSERT INTO transaction_tags (transaction_id, tag_id)
-			SELECT $1, $2
-			WHERE EXISTS (SELECT 1 FROM tags WHERE id = $2 AND user_id = $3)
-		`, t.ID, tagID, userID)
-		if err != nil {
-			continue
+	if !splitTotal.Valid {
+		_, _ = tx.Exec(`DELETE FROM transaction_tags WHERE transaction_id = $1`, t.ID)
+
+		for _, tagID := range req.TagIDs {
+			_, err = tx.Exec(`
+				INSERT INTO transaction_tags (transaction_id, tag_id)
+				SELECT $1, $2
+				WHERE EXISTS (SELECT 1 FROM tags WHERE id = $2 AND user_id = $3)
+			`, t.ID, tagID, userID)
+			if err != nil {
+				continue
+			}
 		}
 	}
 
@@ -286,6 +452,7 @@ func UpdateTransaction(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error committing transaction"})
 		return
 	}
+	services.UpdateTagModel(userID, []int{t.ID})
 
 	// Fetch tags for response
 	tagRows, err := database.DB.Query(`
@@ -309,11 +476,27 @@ func UpdateTransaction(c *gin.Context) {
 
 func DeleteTransaction(c *gin.Context) {
 	userID := c.GetInt("user_id")
-	txID := c.Param("id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+		return
+	}
+
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting transaction"})
+		return
+	}
+	defer dbTx.Rollback()
 
-	result, err := database.DB.Exec(
+	// The other side of a confirmed transfer counts again once this one is gone
+	if err := services.ReleaseTransfers(dbTx, userID, []int{id}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting transaction"})
+		return
+	}
+	result, err := dbTx.Exec(
 		"DELETE FROM transactions WHERE id = $1 AND user_id = $2",
-		txID, userID,
+		id, userID,
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting transaction"})
@@ -325,6 +508,11 @@ func DeleteTransaction(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
 		return
 	}
+	if err := dbTx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting transaction"})
+		return
+	}
+	services.UpdateTagModel(userID, []int{id})
 
 	c.JSON(http.StatusOK, gin.H{"message": "Transaction deleted"})
 }
@@ -346,28 +534,49 @@ func DeleteTransactionsBatch(c *gin.Context) {
 		return
 	}
 
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting transaction"})
+		return
+	}
+	defer dbTx.Rollback()
+
+	// The other sides of confirmed transfers count again once these are gone
+	if err := services.ReleaseTransfers(dbTx, userID, req.IDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting transactions"})
+		return
+	}
+
 	// Build query with placeholders
 	query := "DELETE FROM transactions WHERE user_id = $1 AND id = ANY($2)"
-	result, err := database.DB.Exec(query, userID, pq.Array(req.IDs))
+	result, err := dbTx.Exec(query, userID, pq.Array(req.IDs))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting transactions"})
 		return
 	}
+	if err := dbTx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting transactions"})
+		return
+	}
 
 	rowsAffected, _ := result.RowsAffected()
+	services.UpdateTagModel(userID, req.IDs)
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Transactions deleted",
 		"deleted": rowsAffected,
 	})
 }
 
-// LinkTransactions links two transactions together (expense + reimbursement)
+// LinkTransactions links an expense with an income that reimburses it. The
+// optional amount is the part of both covered by the link; by default, as much
+// as both have left.
 func LinkTransactions(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
 	var req struct {
-		TransactionID1 int `json:"transaction_id_1" binding:"required"`
-		TransactionID2 int `json:"transaction_id_2" binding:"required"`
+		TransactionID1 int      `json:"transaction_id_1" binding:"required"`
+		TransactionID2 int      `json:"transaction_id_2" binding:"required"`
+		Amount         *float64 `json:"amount"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -375,120 +584,125 @@ func LinkTransactions(c *gin.Context) {
 		return
 	}
 
-	if req.TransactionID1 == req.TransactionID2 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot link a transaction to itself"})
+	link, err := services.CreateTransactionLink(userID, req.TransactionID1, req.TransactionID2, req.Amount)
+	var invalid *services.InvalidLinkError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
 		return
 	}
-
-	// Verify both transactions belong to user and get their types
-	var tx1Type, tx2Type string
-	var tx1Linked, tx2Linked *int
-
-	err := database.DB.QueryRow(`
-		SELECT type, linked_to FROM transactions WHERE id = $1 AND user_id = $2
-	`, req.TransactionID1, userID).Scan(&tx1Type, &tx1Linked)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction 1 not found"})
+	if err == services.ErrLinkExists {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		return
 	}
-
-	err = database.DB.QueryRow(`
-		SELECT type, linked_to FROM transactions WHERE id = $1 AND user_id = $2
-	`, req.TransactionID2, userID).Scan(&tx2Type, &tx2Linked)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction 2 not found"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error linking transactions"})
 		return
 	}
 
-	// Check if either is already linked
-	if tx1Linked != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Transaction 1 is already linked to another transaction"})
-		return
-	}
-	if tx2Linked != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Transaction 2 is already linked to another transaction"})
+	c.JSON(http.StatusCreated, link)
+}
+
+// GetTransactionLinks returns the reimbursement links of a transaction
+func GetTransactionLinks(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	txID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
 		return
 	}
 
-	// Check that they are different types (expense + income)
-	if tx1Type == tx2Type {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot link two transactions of the same type. One must be expense, one must be income."})
+	var exists bool
+	err = database.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM transactions WHERE id = $1 AND user_id = $2)`,
+		txID, userID).Scan(&exists)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
 		return
 	}
 
-	// Link them bidirectionally
-	tx, err := database.DB.Begin()
+	links, err := services.ListTransactionLinks(userID, txID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting transaction"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching links"})
 		return
 	}
-	defer tx.Rollback()
 
-	_, err = tx.Exec(`UPDATE transactions SET linked_to = $1 WHERE id = $2`, req.TransactionID2, req.TransactionID1)
+	c.JSON(http.StatusOK, links)
+}
+
+// UpdateTransactionLink changes the amount a link allocates
+func UpdateTransactionLink(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	linkID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error linking transactions"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link ID"})
 		return
 	}
 
-	_, err = tx.Exec(`UPDATE transactions SET linked_to = $1 WHERE id = $2`, req.TransactionID1, req.TransactionID2)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error linking transactions"})
+	var req struct {
+		Amount float64 `json:"amount" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Amount is required"})
 		return
 	}
 
-	if err = tx.Commit(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error committing transaction"})
+	link, err := services.UpdateTransactionLink(userID, linkID, req.Amount)
+	var invalid *services.InvalidLinkError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+		return
+	}
+	if err == services.ErrLinkNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating link"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Transactions linked successfully"})
+	c.JSON(http.StatusOK, link)
 }
 
-// UnlinkTransaction removes the link between two transactions
-func UnlinkTransaction(c *gin.Context) {
+// DeleteTransactionLink removes a single link
+func DeleteTransactionLink(c *gin.Context) {
 	userID := c.GetInt("user_id")
-	txID := c.Param("id")
-
-	// Get the linked transaction ID
-	var linkedTo *int
-	err := database.DB.QueryRow(`
-		SELECT linked_to FROM transactions WHERE id = $1 AND user_id = $2
-	`, txID, userID).Scan(&linkedTo)
-
+	linkID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link ID"})
 		return
 	}
 
-	if linkedTo == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Transaction is not linked"})
+	err = services.DeleteTransactionLink(userID, linkID)
+	if err == services.ErrLinkNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
 		return
 	}
-
-	// Unlink both sides
-	tx, err := database.DB.Begin()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting transaction"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting link"})
 		return
 	}
-	defer tx.Rollback()
 
-	_, err = tx.Exec(`UPDATE transactions SET linked_to = NULL WHERE id = $1`, txID)
+	c.JSON(http.StatusOK, gin.H{"message": "Link deleted"})
+}
+
+// UnlinkTransaction removes all the links of a transaction
+func UnlinkTransaction(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	txID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unlinking transaction"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
 		return
 	}
 
-	_, err = tx.Exec(`UPDATE transactions SET linked_to = NULL WHERE id = $1`, *linkedTo)
+	removed, err := services.UnlinkTransaction(userID, txID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unlinking transaction"})
 		return
 	}
-
-	if err = tx.Commit(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error committing transaction"})
+	if removed == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Transaction is not linked"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Transaction unlinked successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Transaction unlinked successfully", "removed": removed})
 }
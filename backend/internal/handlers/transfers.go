@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/warren/finance-app/internal/services"
+)
+
+// GetTransfers returns the user's transfers (param: status)
+func GetTransfers(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	status := c.Query("status")
+	if status != "" && status != services.TransferSuggested && status != services.TransferConfirmed &&
+		status != services.TransferRejected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be suggested, confirmed or rejected"})
+		return
+	}
+
+	transfers, err := services.ListTransfers(userID, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching transfers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, transfers)
+}
+
+// CreateTransfer records a confirmed transfer between two transactions
+func CreateTransfer(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		TransactionID1 int `json:"transaction_id_1" binding:"required"`
+		TransactionID2 int `json:"transaction_id_2" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Both transaction IDs are required"})
+		return
+	}
+
+	transfer, err := services.CreateTransfer(userID, req.TransactionID1, req.TransactionID2)
+	var invalid *services.InvalidTransferError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+		return
+	}
+	if err == services.ErrTransferConflict {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating transfer"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, transfer)
+}
+
+// ConfirmTransfer confirms a suggested (or previously rejected) transfer
+func ConfirmTransfer(c *gin.Context) {
+	setTransferStatus(c, services.TransferConfirmed)
+}
+
+// RejectTransfer rejects a transfer; detection won't suggest the pair again
+func RejectTransfer(c *gin.Context) {
+	setTransferStatus(c, services.TransferRejected)
+}
+
+func setTransferStatus(c *gin.Context, status string) {
+	userID := c.GetInt("user_id")
+	transferID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transfer ID"})
+		return
+	}
+
+	transfer, err := services.SetTransferStatus(userID, transferID, status)
+	if err == services.ErrTransferNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+	if err == services.ErrTransferConflict {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating transfer"})
+		return
+	}
+
+	c.JSON(http.StatusOK, transfer)
+}
+
+// DetectTransfers looks for transfers among the user's transactions (all of
+// them unless start_date or end_date are given) and suggests them
+func DetectTransfers(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req struct {
+		StartDate string `json:"start_date"`
+		EndDate   string `json:"end_date"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	detected, err := services.DetectTransfers(userID, req.StartDate, req.EndDate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error detecting transfers"})
+		return
+	}
+
+	transfers, err := services.ListTransfers(userID, services.TransferSuggested)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching transfers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"detected":  detected,
+		"suggested": transfers,
+	})
+}
@@ -32,13 +32,31 @@ type Transaction struct {
 	Type        string    `json:"type"`     // income, expense
 	Date        string    `json:"date"`
 	Source      string    `json:"source"` // manual, excel, image
+	ImportID    *int      `json:"import_id,omitempty"` // Import that created the transaction
 	RawText     *string   `json:"raw_text,omitempty"`
-	LinkedTo    *int      `json:"linked_to,omitempty"` // ID of linked transaction (for reimbursements)
+	IsTransfer  bool      `json:"is_transfer"` // Between own accounts; left out of totals
+	Ignored     bool      `json:"ignored"`     // Left out of totals
+	MerchantID  *int      `json:"merchant_id,omitempty"`
+	Merchant    *string   `json:"merchant,omitempty"` // Merchant name
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 	Tags        []Tag     `json:"tags"`
 	Account     *Account  `json:"account,omitempty"`
-	LinkedTx    *Transaction `json:"linked_transaction,omitempty"` // The linked transaction details
+
+	Splits    []TransactionSplit `json:"splits,omitempty"`
+	TagAmount *float64           `json:"tag_amount,omitempty"` // Part of the amount under the filtered tags
+
+	LinkedAmount float64 `json:"linked_amount,omitempty"` // Part of the amount linked to reimbursements
+}
+
+// TransactionSplit is a line item of a transaction with its own amount and
+// tags; the splits of a transaction add up to its amount
+type TransactionSplit struct {
+	ID            int     `json:"id"`
+	TransactionID int     `json:"transaction_id"`
+	Amount        float64 `json:"amount"`
+	Detail        *string `json:"detail,omitempty"`
+	Tags          []Tag   `json:"tags"`
 }
 
 type Account struct {
@@ -50,14 +68,55 @@ type Account struct {
 type Import struct {
 	ID                    int       `json:"id"`
 	UserID                int       `json:"user_id"`
+	AccountID             *int      `json:"account_id,omitempty"`
 	Filename              string    `json:"filename"`
-	FileType              string    `json:"file_type"` // excel, image
-	Status                string    `json:"status"`    // pending, processing, completed, failed
+	FileType              string    `json:"file_type"` // excel, image, ofx, camt, mt940, pdf
+	FileHash              *string   `json:"file_hash,omitempty"` // SHA-256 of the original file in the blob store
+	FileSize              *int64    `json:"file_size,omitempty"`
+	RowHash               *string   `json:"row_hash,omitempty"`     // Hash of the parsed rows, see services.FingerprintStatement
+	PeriodStart           *string   `json:"period_start,omitempty"` // Dates covered by the statement
+	PeriodEnd             *string   `json:"period_end,omitempty"`
+	Status                string    `json:"status"`    // pending, processing, staged, completed, failed, reverted
 	TotalTransactions     int       `json:"total_transactions"`
 	ProcessedTransactions int       `json:"processed_transactions"`
 	CreatedAt             time.Time `json:"created_at"`
 }
 
+// ImportRow is a parsed statement row staged for review before commit
+type ImportRow struct {
+	ID              int      `json:"id"`
+	ImportID        int      `json:"import_id"`
+	Position        int      `json:"position"`
+	Status          string   `json:"status"` // pending, accepted, skipped, duplicate, error
+	Error           *string  `json:"error,omitempty"`
+	AccountID       *int     `json:"account_id,omitempty"`
+	Description     string   `json:"description"`
+	Detail          *string  `json:"detail,omitempty"`
+	Amount          float64  `json:"amount"`
+	Currency        string   `json:"currency"`
+	Type            string   `json:"type"`
+	Date            *string  `json:"date"` // nil when the date could not be parsed
+	ValueDate       *string  `json:"value_date,omitempty"`
+	RawText         *string  `json:"raw_text,omitempty"`
+	ExternalID      *string  `json:"external_id,omitempty"`
+	Counterparty    *string  `json:"counterparty,omitempty"`
+	Reference       *string  `json:"reference,omitempty"`
+	Balance         *float64 `json:"balance,omitempty"`
+	BalanceMismatch bool     `json:"balance_mismatch"`
+	Sheet           *string  `json:"sheet,omitempty"`
+	SourceRow       *int     `json:"source_row,omitempty"`
+	TagIDs          []int    `json:"tag_ids"`
+	SuggestedTagIDs []int    `json:"suggested_tag_ids"`
+	TransactionID   *int     `json:"transaction_id,omitempty"`
+	// Saved transactions that may be the same movement, and the best match's score (0-1)
+	DuplicateConfidence   float64 `json:"duplicate_confidence"`
+	DuplicateCandidateIDs []int   `json:"duplicate_candidate_ids"`
+	IsTransfer            bool    `json:"is_transfer"`
+	RuleIDs               []int   `json:"rule_ids"` // Rules that matched when staged
+
+	MerchantID *int `json:"merchant_id,omitempty"` // Known merchant when staged; resolved again on commit
+}
+
 // DTOs
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
@@ -83,6 +142,9 @@ type CreateTransactionRequest struct {
 	Currency    string  `json:"currency"` // PEN, USD - defaults to PEN
 	Type        string  `json:"type" binding:"required,oneof=income expense"`
 	Date        string  `json:"date" binding:"required"`
+	IsTransfer  *bool   `json:"is_transfer"` // Unset: decided by the rules on create, unchanged on update
+	Ignored     *bool   `json:"ignored"`
+	MerchantID  *int    `json:"merchant_id"` // Unset: resolved from the description on create, unchanged on update
 }
 
 type DashboardSummary struct {
@@ -92,6 +154,19 @@ type DashboardSummary struct {
 	TransactionCount int           `json:"transaction_count"`
 	ByTag            []TagSummary  `json:"by_tag"`
 	RecentTx         []Transaction `json:"recent_transactions"`
+
+	Upcoming []UpcomingCharge `json:"upcoming"` // Recurring charges and incomes due in the next days
+}
+
+// UpcomingCharge is a future occurrence of a recurring template
+type UpcomingCharge struct {
+	TemplateID int     `json:"template_id"`
+	Name       string  `json:"name"`
+	Amount     float64 `json:"amount"`
+	Currency   string  `json:"currency"`
+	Type       string  `json:"type"` // income, expense
+	Date       string  `json:"date"`
+	Mode       string  `json:"mode"` // create, expect
 }
 
 type TagSummary struct {
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/warren/finance-app/internal/database"
+)
+
+var (
+	// ErrBankConfigNotFound is returned when a user layout doesn't exist or belongs to another user
+	ErrBankConfigNotFound = errors.New("bank layout not found")
+	// ErrBankConfigExists is returned when the user already has a layout with the name
+	ErrBankConfigExists = errors.New("a bank layout with that name already exists")
+)
+
+const bankConfigColumns = `id, name, header_row, date_col, description_col, amount_col, debit_col, credit_col,
+	type_col, balance_col, amount_mode, date_format, number_format, currency_symbol, currency, sign_convention, skip_patterns`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanBankConfig(row rowScanner) (BankConfig, error) {
+	var config BankConfig
+	var id int
+	var skipPatterns pq.StringArray
+
+	err := row.Scan(&id, &config.Name, &config.HeaderRow, &config.DateCol, &config.DescriptionCol,
+		&config.AmountCol, &config.DebitCol, &config.CreditCol, &config.TypeCol, &config.BalanceCol, &config.AmountMode, &config.DateFormat, &config.NumberFormat,
+		&config.CurrencySymbol, &config.Currency, &config.SignConvention, &skipPatterns)
+	if err != nil {
+		return config, err
+	}
+
+	config.ID = strconv.Itoa(id)
+	config.SkipPatterns = []string(skipPatterns)
+	if config.SkipPatterns == nil {
+		config.SkipPatterns = []string{}
+	}
+	config.IsCustom = true
+	return config, nil
+}
+
+// GetBankConfig resolves a bank ID to a layout. Built-in banks are looked up
+// by name; numeric IDs are user-defined layouts stored in bank_configs.
+// Unknown names fall back to the generic auto-detecting layout.
+func GetBankConfig(userID int, bankID string) (BankConfig, error) {
+	if config, ok := BankConfigs[bankID]; ok {
+		return config, nil
+	}
+
+	id, err := strconv.Atoi(bankID)
+	if err != nil {
+		return BankConfigs["generic"], nil
+	}
+
+	config, err := scanBankConfig(database.DB.QueryRow(
+		`SELECT `+bankConfigColumns+` FROM bank_configs WHERE id = $1 AND user_id = $2`, id, userID))
+	if err == sql.ErrNoRows {
+		return BankConfig{}, ErrBankConfigNotFound
+	}
+	if err != nil {
+		return BankConfig{}, fmt.Errorf("error loading bank layout: %w", err)
+	}
+	return config, nil
+}
+
+// ListBankConfigs returns the user's custom layouts
+func ListBankConfigs(userID int) ([]BankConfig, error) {
+	rows, err := database.DB.Query(
+		`SELECT `+bankConfigColumns+` FROM bank_configs WHERE user_id = $1 ORDER BY name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	configs := []BankConfig{}
+	for rows.Next() {
+		config, err := scanBankConfig(rows)
+		if err != nil {
+			continue
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// CreateBankConfig stores a new custom layout for the user
+func CreateBankConfig(userID int, config BankConfig) (BankConfig, error) {
+	created, err := scanBankConfig(database.DB.QueryRow(`
+		INSERT INTO bank_configs (user_id, name, header_row, date_col, description_col, amount_col, debit_col, credit_col,
+		                          type_col, balance_col, amount_mode, date_format, number_format, currency_symbol, currency,
+		                          sign_convention, skip_patterns)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		RETURNING `+bankConfigColumns,
+		userID, config.Name, config.HeaderRow, config.DateCol, config.DescriptionCol, config.AmountCol,
+		config.DebitCol, config.CreditCol, config.TypeCol, config.BalanceCol, config.AmountMode, config.DateFormat, config.NumberFormat, config.CurrencySymbol,
+		config.Currency, config.SignConvention, pq.Array(config.SkipPatterns)))
+	if isUniqueViolation(err) {
+		return BankConfig{}, ErrBankConfigExists
+	}
+	return created, err
+}
+
+// UpdateBankConfig replaces a custom layout. Returns ErrBankConfigNotFound
+// when the layout doesn't belong to the user.
+func UpdateBankConfig(userID int, id int, config BankConfig) (BankConfig, error) {
+	updated, err := scanBankConfig(database.DB.QueryRow(`
+		UPDATE bank_configs
+		SET name = $1, header_row = $2, date_col = $3, description_col = $4, amount_col = $5, debit_col = $6,
+		    credit_col = $7, type_col = $8, balance_col = $9, amount_mode = $10, date_format = $11,
+		    number_format = $12, currency_symbol = $13, currency = $14, sign_convention = $15, skip_patterns = $16,
+		    updated_at = NOW()
+		WHERE id = $17 AND user_id = $18
+		RETURNING `+bankConfigColumns,
+		config.Name, config.HeaderRow, config.DateCol, config.DescriptionCol, config.AmountCol,
+		config.DebitCol, config.CreditCol, config.TypeCol, config.BalanceCol, config.AmountMode, config.DateFormat, config.NumberFormat, config.CurrencySymbol,
+		config.Currency, config.SignConvention, pq.Array(config.SkipPatterns), id, userID))
+	if err == sql.ErrNoRows {
+		return BankConfig{}, ErrBankConfigNotFound
+	}
+	if isUniqueViolation(err) {
+		return BankConfig{}, ErrBankConfigExists
+	}
+	return updated, err
+}
+
+// DeleteBankConfig removes a custom layout
+func DeleteBankConfig(userID int, id int) error {
+	result, err := database.DB.Exec(`DELETE FROM bank_configs WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrBankConfigNotFound
+	}
+	return nil
+}
+
+// Normalize fills defaults and validates a layout before it is stored
+func (c *BankConfig) Normalize() error {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	if c.AmountMode == "" {
+		c.AmountMode = AmountModeSingle
+	}
+	if c.DateFormat == "" {
+		c.DateFormat = "auto"
+	}
+	if c.NumberFormat == "" {
+		c.NumberFormat = NumberFormatAuto
+	}
+	if c.Currency == "" {
+		c.Currency = "PEN"
+	}
+	if c.SignConvention == "" {
+		c.SignConvention = SignAccount
+	}
+	if c.SkipPatterns == nil {
+		c.SkipPatterns = []string{}
+	}
+
+	// header_row -1 means the file has no header and data starts on the first row
+	if c.HeaderRow < -1 {
+		return fmt.Errorf("header_row must be >= -1")
+	}
+	if c.DateCol < 0 || c.DescriptionCol < 0 {
+		return fmt.Errorf("date_col and description_col must be >= 0")
+	}
+
+	switch c.AmountMode {
+	case AmountModeSingle:
+		if c.AmountCol < 0 {
+			return fmt.Errorf("amount_col is required in single amount mode")
+		}
+	case AmountModeDebitCredit:
+		if c.DebitCol < 0 || c.CreditCol < 0 {
+			return fmt.Errorf("debit_col and credit_col are required in debit_credit mode")
+		}
+	default:
+		return fmt.Errorf("amount_mode must be 'single' or 'debit_credit'")
+	}
+
+	if err := ValidateDateFormat(c.DateFormat); err != nil {
+		return err
+	}
+	if err := ValidateNumberFormat(c.NumberFormat); err != nil {
+		return err
+	}
+
+	switch c.SignConvention {
+	case SignAccount, SignNormal, SignInverted:
+	default:
+		return fmt.Errorf("sign_convention must be 'account', 'normal' or 'inverted'")
+	}
+
+	for _, pattern := range c.SkipPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid skip pattern %q: %v", pattern, err)
+		}
+	}
+
+	return nil
+}
@@ -1,49 +1,103 @@
 package services
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
+)
+
+// Amount column modes
+const (
+	AmountModeSingle      = "single"       // One signed amount column
+	AmountModeDebitCredit = "debit_credit" // Separate debit (cargo) and credit (abono) columns
+)
+
+// Sign conventions for single amount columns
+const (
+	SignAccount  = "account"  // Follow the account (BBVA credit cards are inverted)
+	SignNormal   = "normal"   // Negative amounts are expenses
+	SignInverted = "inverted" // Positive amounts are expenses (credit card statements)
 )
 
 // BankConfig defines how to parse Excel files from different banks
 type BankConfig struct {
-	Name           string
-	HeaderRow      int    // Row where headers are (0-indexed)
-	DateCol        int    // Column index for date
-	DescriptionCol int    // Column index for description
-	AmountCol      int    // Column index for amount
-	DateFormat     string // Expected date format
-	CurrencySymbol string // Currency symbol to remove (e.g., "S/", "$")
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	HeaderRow      int      `json:"header_row"`      // Row where headers are (0-indexed)
+	DateCol        int      `json:"date_col"`        // Column index for date
+	DescriptionCol int      `json:"description_col"` // Column index for description
+	AmountCol      int      `json:"amount_col"`      // Column index for amount (single mode)
+	DebitCol       int      `json:"debit_col"`       // Column index for debits (debit_credit mode), -1 if unused
+	CreditCol      int      `json:"credit_col"`      // Column index for credits (debit_credit mode), -1 if unused
+	TypeCol        int      `json:"type_col"`        // Column with an income/expense label, -1 if unused
+	BalanceCol     int      `json:"balance_col"`     // Running balance column used to sanity-check rows, -1 if unused
+	AmountMode     string   `json:"amount_mode"`     // single, debit_credit
+	DateFormat     string   `json:"date_format"`     // auto, dmy, mdy, ymd or a pattern like "dd/mm/yyyy"
+	NumberFormat   string   `json:"number_format"`   // auto, decimal_point (1,234.56), decimal_comma (1.234,56)
+	CurrencySymbol string   `json:"currency_symbol"` // Currency symbol to remove (e.g., "S/", "$")
+	Currency       string   `json:"currency"`        // Default currency when the amount has no symbol
+	SignConvention string   `json:"sign_convention"` // account, normal, inverted
+	SkipPatterns   []string `json:"skip_patterns"`   // Regexes; matching rows are ignored
+	IsCustom       bool     `json:"is_custom"`       // Stored in bank_configs (user-defined)
 }
 
 // Available bank configurations
 var BankConfigs = map[string]BankConfig{
 	"bbva": {
+		ID:             "bbva",
 		Name:           "BBVA",
-		HeaderRow:      4,  // Row 5 in Excel (0-indexed = 4)
-		DateCol:        1,  // Column B
-		DescriptionCol: 2,  // Column C
-		AmountCol:      5,  // Column F
+		HeaderRow:      4, // Row 5 in Excel (0-indexed = 4)
+		DateCol:        1, // Column B
+		DescriptionCol: 2, // Column C
+		AmountCol:      5, // Column F
+		DebitCol:       -1,
+		CreditCol:      -1,
+		TypeCol:        -1,
+		BalanceCol:     -1,
+		AmountMode:     AmountModeSingle,
 		DateFormat:     "dd/mm/yyyy",
+		NumberFormat:   NumberFormatDecimalPoint,
 		CurrencySymbol: "S/",
+		Currency:       "PEN",
+		SignConvention: SignAccount,
 	},
 	"generic": {
+		ID:             "generic",
 		Name:           "Genérico",
 		HeaderRow:      0,
 		DateCol:        0,
 		DescriptionCol: 1,
 		AmountCol:      2,
+		DebitCol:       -1,
+		CreditCol:      -1,
+		TypeCol:        -1,
+		BalanceCol:     -1,
+		AmountMode:     AmountModeSingle,
 		DateFormat:     "auto",
+		NumberFormat:   NumberFormatAuto,
 		CurrencySymbol: "",
+		Currency:       "PEN",
+		SignConvention: SignAccount,
 	},
 }
 
-// GetSupportedBanks returns list of supported banks
-func GetSupportedBanks() []map[string]string {
+// GetSupportedBanks returns list of supported banks, including the user's own layouts
+func GetSupportedBanks(userID int) []map[string]string {
 	banks := []map[string]string{
 		{"id": "bbva", "name": "BBVA"},
 		{"id": "generic", "name": "Genérico (auto-detectar)"},
 	}
+
+	layouts, err := ListBankConfigs(userID)
+	if err != nil {
+		return banks
+	}
+	for _, layout := range layouts {
+		banks = append(banks, map[string]string{"id": layout.ID, "name": layout.Name})
+	}
+
 	return banks
 }
 
@@ -59,6 +113,7 @@ func ParseBBVAAmount(amountStr string) (float64, string, string) {
 	}
 
 	// Remove currency symbols
+	amountStr = strings.ReplaceAll(amountStr, "S/.", "")
 	amountStr = strings.ReplaceAll(amountStr, "S/", "")
 	amountStr = strings.ReplaceAll(amountStr, "US$", "")
 	amountStr = strings.ReplaceAll(amountStr, "USD", "")
@@ -69,53 +124,17 @@ func ParseBBVAAmount(amountStr string) (float64, string, string) {
 	return amount, txType, currency
 }
 
-// ParseBBVADate parses BBVA date format: "16/12/2025"
-func ParseBBVADate(dateStr string) string {
-	dateStr = strings.TrimSpace(dateStr)
-	if dateStr == "" {
-		return ""
-	}
-
-	// Try dd/mm/yyyy format
-	parts := regexp.MustCompile(`(\d{1,2})/(\d{1,2})/(\d{4})`).FindStringSubmatch(dateStr)
-	if len(parts) == 4 {
-		day := parts[1]
-		month := parts[2]
-		year := parts[3]
-
-		// Pad with zeros if needed
-		if len(day) == 1 {
-			day = "0" + day
-		}
-		if len(month) == 1 {
-			month = "0" + month
-		}
-
-		return year + "-" + month + "-" + day
-	}
-
-	return parseDate(dateStr)
-}
-
 // ParseRowByBank parses a row based on bank configuration
-func ParseRowByBank(row []string, bankID string) *ParsedTransaction {
-	config, exists := BankConfigs[bankID]
-	if !exists {
-		config = BankConfigs["generic"]
-	}
-
-	// Check if row has enough columns
-	maxCol := max(config.DateCol, config.DescriptionCol, config.AmountCol)
-	if len(row) <= maxCol {
+func ParseRowByBank(row []string, config BankConfig) *ParsedTransaction {
+	if matchesSkipPattern(row, config.SkipPatterns) {
 		return nil
 	}
 
 	dateStr := safeGet(row, config.DateCol)
 	description := safeGet(row, config.DescriptionCol)
-	amountStr := safeGet(row, config.AmountCol)
 
 	// Skip empty rows or header rows
-	if dateStr == "" || description == "" || amountStr == "" {
+	if dateStr == "" || description == "" {
 		return nil
 	}
 
@@ -125,41 +144,173 @@ func ParseRowByBank(row []string, bankID string) *ParsedTransaction {
 		return nil
 	}
 
-	var date string
 	var amount float64
 	var txType string
 	var currency string
 
-	switch bankID {
-	case "bbva":
-		date = ParseBBVADate(dateStr)
-		amount, txType, currency = ParseBBVAAmount(amountStr)
-	default:
-		date = parseDate(dateStr)
-		amount, txType = parseAmount(amountStr)
-		currency = "PEN" // Default for generic parser
+	if config.AmountMode == AmountModeDebitCredit {
+		debit, _, debitCurrency := parseLayoutAmount(safeGet(row, config.DebitCol), config)
+		credit, _, creditCurrency := parseLayoutAmount(safeGet(row, config.CreditCol), config)
+
+		// The type comes from whichever column is populated
+		switch {
+		case credit != 0 && debit == 0:
+			amount, txType, currency = credit, "income", creditCurrency
+		case debit != 0 && credit == 0:
+			amount, txType, currency = debit, "expense", debitCurrency
+		case debit != 0 && credit != 0:
+			// Both populated: keep the net movement
+			amount, txType, currency = abs(credit-debit), "income", creditCurrency
+			if debit > credit {
+				txType = "expense"
+			}
+		}
+	} else {
+		amountStr := safeGet(row, config.AmountCol)
+		if amountStr == "" {
+			return nil
+		}
+		amount, txType, currency = parseLayoutAmount(amountStr, config)
+		if config.SignConvention == SignInverted {
+			txType = invertType(txType)
+		}
+	}
+
+	// An explicit type column wins over the amount's sign
+	if config.TypeCol >= 0 {
+		if labelType := typeFromLabel(safeGet(row, config.TypeCol)); labelType != "" {
+			txType = labelType
+		}
 	}
 
-	if date == "" || amount == 0 {
+	if amount == 0 {
 		return nil
 	}
 
-	return &ParsedTransaction{
-		Date:        date,
+	tx := &ParsedTransaction{
+		Date:        parseDateWithFormat(dateStr, config.DateFormat),
 		Description: strings.TrimSpace(description),
 		Amount:      amount,
 		Currency:    currency,
 		Type:        txType,
 		RawText:     strings.Join(row, " | "),
+		Balance:     parseLayoutBalance(safeGet(row, config.BalanceCol), config),
+	}
+
+	// A movement with a bad date is reported instead of guessing one.
+	// Cells without digits ("Total", "Saldo final") aren't movements at all.
+	if tx.Date == "" {
+		if !strings.ContainsAny(dateStr, "0123456789") {
+			return nil
+		}
+		tx.Error = fmt.Sprintf("unrecognized date %q", dateStr)
+	}
+
+	return tx
+}
+
+// parseLayoutBalance parses a running balance cell, keeping its sign.
+// Returns nil for cells without a number.
+func parseLayoutBalance(balanceStr string, config BankConfig) *float64 {
+	if !strings.ContainsAny(balanceStr, "0123456789") {
+		return nil
 	}
+	balance, txType, _ := parseLayoutAmount(balanceStr, config)
+	if txType == "expense" {
+		balance = -balance
+	}
+	return &balance
 }
 
-func max(nums ...int) int {
-	m := nums[0]
-	for _, n := range nums {
-		if n > m {
-			m = n
+// parseLayoutAmount parses an amount cell using the layout's currency settings.
+// An explicit US$/S/ marker in the cell wins over the layout's default currency.
+func parseLayoutAmount(amountStr string, config BankConfig) (float64, string, string) {
+	amountStr = strings.TrimSpace(amountStr)
+	if amountStr == "" {
+		return 0, "expense", config.Currency
+	}
+
+	if config.CurrencySymbol != "" && config.CurrencySymbol != "S/" && config.CurrencySymbol != "US$" {
+		amountStr = strings.ReplaceAll(amountStr, config.CurrencySymbol, "")
+	}
+	amountStr = normalizeNumber(amountStr, config.NumberFormat)
+
+	hasSolesMarker := strings.Contains(amountStr, "S/")
+	amount, txType, currency := ParseBBVAAmount(amountStr)
+	if currency == "PEN" && !hasSolesMarker && config.Currency != "" {
+		currency = config.Currency
+	}
+	return amount, txType, currency
+}
+
+// parseDateWithFormat parses a date using a layout format: a day/month order
+// ("dmy") or a pattern like "dd/mm/yyyy". "auto" (or empty) guesses from the
+// value alone. Returns "" when the date doesn't match.
+func parseDateWithFormat(dateStr string, format string) string {
+	format = strings.ToLower(strings.TrimSpace(format))
+	switch format {
+	case "", "auto":
+		return parseDate(dateStr)
+	case DateOrderDMY, DateOrderMDY, DateOrderYMD:
+		return parseDateInOrder(dateStr, format)
+	}
+
+	// Ignore a trailing time component ("16/12/2025 10:30")
+	fields := strings.Fields(dateStr)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	layout := strings.NewReplacer("yyyy", "2006", "yy", "06", "dd", "2", "mm", "1").Replace(format)
+	t, err := time.Parse(layout, fields[0])
+	if err != nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// skipPatternCache holds compiled skip patterns shared across imports
+var skipPatternCache sync.Map
+
+// matchesSkipPattern reports whether the joined row matches any skip pattern
+func matchesSkipPattern(row []string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+
+	line := strings.Join(row, " ")
+	for _, pattern := range patterns {
+		cached, ok := skipPatternCache.Load(pattern)
+		if !ok {
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				continue
+			}
+			cached, _ = skipPatternCache.LoadOrStore(pattern, re)
 		}
+		if cached.(*regexp.Regexp).MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// typeFromLabel maps a type column value ("Abono", "Cargo", "Ingreso"...) to income/expense.
+// Returns "" when the label is not recognized.
+func typeFromLabel(label string) string {
+	label = strings.ToLower(label)
+	if containsAny(label, []string{"ingreso", "income", "abono", "deposito", "depósito", "credito", "crédito"}) {
+		return "income"
+	}
+	if containsAny(label, []string{"gasto", "expense", "cargo", "retiro", "debito", "débito"}) {
+		return "expense"
+	}
+	return ""
+}
+
+func invertType(txType string) string {
+	if txType == "income" {
+		return "expense"
 	}
-	return m
+	return "income"
 }
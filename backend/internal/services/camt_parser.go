@@ -0,0 +1,275 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/warren/finance-app/internal/database"
+)
+
+// camtDocument covers both camt.053 (BkToCstmrStmt) and camt.052 (BkToCstmrAcctRpt).
+// Elements are matched by local name so any schema version namespace works.
+type camtDocument struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+	Reports    []camtStatement `xml:"BkToCstmrAcctRpt>Rpt"`
+}
+
+type camtStatement struct {
+	ID      string        `xml:"Id"`
+	Account camtAccount   `xml:"Acct"`
+	Balance []camtBalance `xml:"Bal"`
+	Entries []camtEntry   `xml:"Ntry"`
+}
+
+type camtAccount struct {
+	IBAN     string `xml:"Id>IBAN"`
+	Other    string `xml:"Id>Othr>Id"`
+	Currency string `xml:"Ccy"`
+}
+
+type camtBalance struct {
+	Code      string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount    camtAmount `xml:"Amt"`
+	CdtDbtInd string     `xml:"CdtDbtInd"`
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+// camtStatus is plain text before camt.053.001.08 and a <Cd> child afterwards
+type camtStatus struct {
+	Value string `xml:",chardata"`
+	Code  string `xml:"Cd"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+type camtEntry struct {
+	Amount      camtAmount      `xml:"Amt"`
+	CdtDbtInd   string          `xml:"CdtDbtInd"`
+	Reversal    bool            `xml:"RvslInd"` // Informational: the entry undoes an earlier booking
+	Status      camtStatus      `xml:"Sts"`
+	BookingDate camtDate        `xml:"BookgDt"`
+	ValueDate   camtDate        `xml:"ValDt"`
+	AcctSvcrRef string          `xml:"AcctSvcrRef"`
+	AddtlInfo   string          `xml:"AddtlNtryInf"`
+	Details     []camtTxDetails `xml:"NtryDtls>TxDtls"`
+}
+
+type camtTxDetails struct {
+	EndToEndID   string   `xml:"Refs>EndToEndId"`
+	AcctSvcrRef  string   `xml:"Refs>AcctSvcrRef"`
+	DebtorName   string   `xml:"RltdPties>Dbtr>Nm"`
+	DebtorPty    string   `xml:"RltdPties>Dbtr>Pty>Nm"`
+	CreditorName string   `xml:"RltdPties>Cdtr>Nm"`
+	CreditorPty  string   `xml:"RltdPties>Cdtr>Pty>Nm"`
+	Unstructured []string `xml:"RmtInf>Ustrd"`
+	AddtlInfo    string   `xml:"AddtlTxInf"`
+}
+
+// ProcessCAMTFile reads and parses an ISO 20022 camt.053/camt.052 statement
+func ProcessCAMTFile(filePath string, userID int) ([]ParsedTransaction, []BalanceCheck, int, error) {
+	transactions, checks, err := ParseCAMTFile(filePath)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var importID int
+	err = database.DB.QueryRow(
+		`INSERT INTO imports (user_id, filename, file_type, status, total_transactions)
+		 VALUES ($1, $2, 'camt', 'completed', $3) RETURNING id`,
+		userID, filePath, len(transactions),
+	).Scan(&importID)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("error creating import record: %w", err)
+	}
+
+	return transactions, checks, importID, nil
+}
+
+// ParseCAMTFile reads and parses a camt file without creating an import
+func ParseCAMTFile(filePath string) ([]ParsedTransaction, []BalanceCheck, error) {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening camt file: %w", err)
+	}
+	return ParseCAMT(raw)
+}
+
+// ParseCAMT extracts booked entries and balance checks from camt.053/camt.052 XML
+func ParseCAMT(data []byte) ([]ParsedTransaction, []BalanceCheck, error) {
+	var doc camtDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid camt file: %w", err)
+	}
+
+	statements := append(doc.Statements, doc.Reports...)
+	if len(statements) == 0 {
+		return nil, nil, fmt.Errorf("invalid camt file: no statements found")
+	}
+
+	var transactions []ParsedTransaction
+	var checks []BalanceCheck
+
+	for _, stmt := range statements {
+		account := stmt.Account.IBAN
+		if account == "" {
+			account = stmt.Account.Other
+		}
+
+		var stmtTransactions []ParsedTransaction
+		for _, entry := range stmt.Entries {
+			tx := parseCAMTEntry(entry, stmt.Account.Currency)
+			if tx != nil {
+				stmtTransactions = append(stmtTransactions, *tx)
+			}
+		}
+		transactions = append(transactions, stmtTransactions...)
+
+		// Opening: OPBD (or PRCD, previously closed); closing: CLBD (or ITBD for intraday reports)
+		opening, hasOpening := camtBalanceByCode(stmt.Balance, "OPBD", "PRCD")
+		closing, hasClosing := camtBalanceByCode(stmt.Balance, "CLBD", "ITBD")
+		if hasOpening && hasClosing {
+			currency := opening.Amount.Currency
+			if currency == "" {
+				currency = stmt.Account.Currency
+			}
+			checks = append(checks, newBalanceCheck(account, currency,
+				camtSignedAmount(opening), camtSignedAmount(closing), stmtTransactions))
+		}
+	}
+
+	return transactions, checks, nil
+}
+
+// parseCAMTEntry maps a booked <Ntry> to a ParsedTransaction
+func parseCAMTEntry(entry camtEntry, accountCurrency string) *ParsedTransaction {
+	status := strings.ToUpper(firstNonEmpty(entry.Status.Code, entry.Status.Value))
+	if status == "PDNG" || status == "INFO" {
+		return nil
+	}
+
+	amount, err := strconv.ParseFloat(strings.TrimSpace(entry.Amount.Value), 64)
+	if err != nil || amount == 0 {
+		return nil
+	}
+
+	// CdtDbtInd is the direction of this entry, reversals included; RvslInd
+	// only says the original booking went the other way
+	isCredit := strings.EqualFold(entry.CdtDbtInd, "CRDT")
+	txType := "expense"
+	if isCredit {
+		txType = "income"
+	}
+
+	bookingDate := camtDateString(entry.BookingDate)
+	valueDate := camtDateString(entry.ValueDate)
+	if bookingDate == "" {
+		bookingDate = valueDate
+	}
+	if bookingDate == "" {
+		return nil
+	}
+
+	currency := entry.Amount.Currency
+	if currency == "" {
+		currency = accountCurrency
+	}
+	if currency == "" {
+		currency = "PEN"
+	}
+
+	var details camtTxDetails
+	if len(entry.Details) > 0 {
+		details = entry.Details[0]
+	}
+
+	// The counterparty is the debtor on credits and the creditor on debits
+	counterparty := firstNonEmpty(details.CreditorName, details.CreditorPty)
+	if isCredit {
+		counterparty = firstNonEmpty(details.DebtorName, details.DebtorPty)
+	}
+
+	description := strings.TrimSpace(strings.Join(details.Unstructured, " "))
+	if description == "" {
+		description = firstNonEmpty(details.AddtlInfo, entry.AddtlInfo)
+	}
+	if counterparty != "" && !strings.Contains(strings.ToLower(description), strings.ToLower(counterparty)) {
+		if description == "" {
+			description = counterparty
+		} else {
+			description = counterparty + " - " + description
+		}
+	}
+	if description == "" {
+		description = "Movimiento bancario"
+	}
+
+	reference := strings.TrimSpace(details.EndToEndID)
+	if strings.EqualFold(reference, "NOTPROVIDED") {
+		reference = ""
+	}
+
+	return &ParsedTransaction{
+		Date:         bookingDate,
+		ValueDate:    valueDate,
+		Description:  truncateDescription(description),
+		Amount:       amount,
+		Currency:     strings.ToUpper(currency),
+		Type:         txType,
+		Counterparty: counterparty,
+		Reference:    reference,
+		ExternalID:   firstNonEmpty(entry.AcctSvcrRef, details.AcctSvcrRef),
+		RawText:      strings.Join(strings.Fields(entry.AddtlInfo+" "+strings.Join(details.Unstructured, " ")), " "),
+	}
+}
+
+// camtBalanceByCode returns the first balance matching any of the given type codes
+func camtBalanceByCode(balances []camtBalance, codes ...string) (camtBalance, bool) {
+	for _, code := range codes {
+		for _, bal := range balances {
+			if strings.EqualFold(bal.Code, code) {
+				return bal, true
+			}
+		}
+	}
+	return camtBalance{}, false
+}
+
+// camtSignedAmount returns the balance amount, negative when it is a debit balance
+func camtSignedAmount(bal camtBalance) float64 {
+	amount, _ := strconv.ParseFloat(strings.TrimSpace(bal.Amount.Value), 64)
+	if strings.EqualFold(bal.CdtDbtInd, "DBIT") {
+		return -amount
+	}
+	return amount
+}
+
+// camtDateString returns YYYY-MM-DD from either <Dt> or <DtTm>
+func camtDateString(d camtDate) string {
+	value := strings.TrimSpace(d.Date)
+	if value == "" {
+		value = strings.TrimSpace(d.DateTime)
+	}
+	if len(value) < 10 {
+		return ""
+	}
+	return value[:10]
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
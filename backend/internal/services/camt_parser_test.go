@@ -0,0 +1,133 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+const camtReversalStatement = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+  <BkToCstmrStmt>
+    <Stmt>
+      <Id>STMT-1</Id>
+      <Acct><Id><IBAN>PE0011112222333344</IBAN></Id><Ccy>PEN</Ccy></Acct>
+      <Bal>
+        <Tp><CdOrPrtry><Cd>OPBD</Cd></CdOrPrtry></Tp>
+        <Amt Ccy="PEN">1000.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+      </Bal>
+      <Bal>
+        <Tp><CdOrPrtry><Cd>CLBD</Cd></CdOrPrtry></Tp>
+        <Amt Ccy="PEN">950.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+      </Bal>
+      <Ntry>
+        <Amt Ccy="PEN">200.00</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <Sts>BOOK</Sts>
+        <BookgDt><Dt>2025-03-03</Dt></BookgDt>
+        <AcctSvcrRef>REF-1</AcctSvcrRef>
+        <NtryDtls><TxDtls>
+          <RltdPties><Cdtr><Nm>TIENDA SAC</Nm></Cdtr></RltdPties>
+          <RmtInf><Ustrd>Compra 123</Ustrd></RmtInf>
+        </TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="PEN">200.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <RvslInd>true</RvslInd>
+        <Sts>BOOK</Sts>
+        <BookgDt><Dt>2025-03-04</Dt></BookgDt>
+        <AcctSvcrRef>REF-2</AcctSvcrRef>
+        <AddtlNtryInf>Extorno compra 123</AddtlNtryInf>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="PEN">50.00</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <Sts>BOOK</Sts>
+        <BookgDt><Dt>2025-03-05</Dt></BookgDt>
+        <AcctSvcrRef>REF-3</AcctSvcrRef>
+        <AddtlNtryInf>Comision</AddtlNtryInf>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="PEN">75.00</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <Sts>PDNG</Sts>
+        <BookgDt><Dt>2025-03-06</Dt></BookgDt>
+      </Ntry>
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>`
+
+func TestParseCAMTReversal(t *testing.T) {
+	transactions, checks, err := ParseCAMT([]byte(camtReversalStatement))
+	if err != nil {
+		t.Fatalf("ParseCAMT: %v", err)
+	}
+
+	want := []struct {
+		date, txType, externalID string
+		amount                   float64
+	}{
+		{"2025-03-03", "expense", "REF-1", 200},
+		{"2025-03-04", "income", "REF-2", 200},
+		{"2025-03-05", "expense", "REF-3", 50},
+	}
+	if len(transactions) != len(want) {
+		t.Fatalf("got %d transactions, want %d (pending entries are skipped)", len(transactions), len(want))
+	}
+	for i, w := range want {
+		tx := transactions[i]
+		if tx.Date != w.date || tx.Type != w.txType || tx.ExternalID != w.externalID || tx.Amount != w.amount {
+			t.Errorf("transaction %d = %s %s %s %.2f, want %s %s %s %.2f", i,
+				tx.Date, tx.Type, tx.ExternalID, tx.Amount, w.date, w.txType, w.externalID, w.amount)
+		}
+	}
+	if transactions[0].Counterparty != "TIENDA SAC" {
+		t.Errorf("counterparty = %q, want TIENDA SAC", transactions[0].Counterparty)
+	}
+
+	if len(checks) != 1 {
+		t.Fatalf("got %d balance checks, want 1", len(checks))
+	}
+	if !checks[0].Matches {
+		t.Errorf("balance check doesn't match: computed %.2f, closing %.2f",
+			checks[0].ComputedClosing, checks[0].ClosingBalance)
+	}
+}
+
+func TestParseCAMTLongDescription(t *testing.T) {
+	// Multibyte runes count once towards the limit
+	purpose := strings.Repeat("Señal ", 60)
+	statement := `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+  <BkToCstmrStmt>
+    <Stmt>
+      <Acct><Id><IBAN>PE0011112222333344</IBAN></Id><Ccy>PEN</Ccy></Acct>
+      <Ntry>
+        <Amt Ccy="PEN">80.00</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <Sts>BOOK</Sts>
+        <BookgDt><Dt>2025-03-03</Dt></BookgDt>
+        <NtryDtls><TxDtls><RmtInf><Ustrd>` + purpose + `</Ustrd></RmtInf></TxDtls></NtryDtls>
+      </Ntry>
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>`
+
+	transactions, _, err := ParseCAMT([]byte(statement))
+	if err != nil {
+		t.Fatalf("ParseCAMT: %v", err)
+	}
+	if len(transactions) != 1 {
+		t.Fatalf("got %d transactions, want 1", len(transactions))
+	}
+	description := transactions[0].Description
+	if n := utf8.RuneCountInString(description); n > maxDescriptionLength {
+		t.Errorf("description has %d runes, want at most %d", n, maxDescriptionLength)
+	}
+	if !utf8.ValidString(description) || !strings.HasPrefix(description, "Señal Señal") {
+		t.Errorf("description = %q, want the start of the remittance text", description)
+	}
+}
@@ -0,0 +1,339 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+	"github.com/warren/finance-app/internal/database"
+)
+
+const (
+	// TagPredictionThreshold is the probability from which the best prediction
+	// is suggested as the row's tag
+	TagPredictionThreshold = 0.5
+	// maxTagPredictions is how many predictions are returned per row
+	maxTagPredictions = 3
+)
+
+// TagPrediction is a tag the classifier predicts for a transaction
+type TagPrediction struct {
+	TagID       int     `json:"tag_id"`
+	Probability float64 `json:"probability"` // 0-1, over all the user's tags
+}
+
+// TagClassifier is a multinomial naive Bayes model of how a user tags
+// transactions. Features are the description's words, the amount bucket, the
+// type and the account. Each tag of a transaction counts as one document of
+// that tag.
+type TagClassifier struct {
+	mu sync.Mutex
+
+	docs          int                    // Tagged transactions
+	tagDocs       map[int]int            // Transactions per tag
+	featureCounts map[int]map[string]int // Feature occurrences per tag
+	tagFeatures   map[int]int            // Total features per tag
+	vocabulary    map[string]int         // Tagged transactions per feature
+
+	trained map[int]taggedDocument // Transactions in the model, so they can be retrained
+}
+
+// taggedDocument is a transaction as the model saw it
+type taggedDocument struct {
+	features []string
+	tagIDs   []int
+}
+
+// tagModels caches one trained classifier per user
+var tagModels = struct {
+	sync.Mutex
+	byUser map[int]*TagClassifier
+}{byUser: make(map[int]*TagClassifier)}
+
+// TagModel returns the user's classifier, training it from their tagged
+// transactions the first time it's needed
+func TagModel(userID int) (*TagClassifier, error) {
+	tagModels.Lock()
+	defer tagModels.Unlock()
+
+	if model, ok := tagModels.byUser[userID]; ok {
+		return model, nil
+	}
+
+	model := newTagClassifier()
+	docs, err := loadTaggedDocuments(userID, nil)
+	if err != nil {
+		return nil, err
+	}
+	for id, doc := range docs {
+		model.add(id, doc)
+	}
+	tagModels.byUser[userID] = model
+	return model, nil
+}
+
+// UpdateTagModel retrains the user's classifier on the current description
+// and tags of the given transactions. Transactions that were deleted or lost
+// their tags are taken out of the model. Does nothing until the model is
+// first used; if the transactions can't be loaded the model is dropped and
+// trained again on next use.
+func UpdateTagModel(userID int, transactionIDs []int) {
+	if len(transactionIDs) == 0 {
+		return
+	}
+
+	tagModels.Lock()
+	model, ok := tagModels.byUser[userID]
+	tagModels.Unlock()
+	if !ok {
+		return
+	}
+
+	docs, err := loadTaggedDocuments(userID, transactionIDs)
+	if err != nil {
+		ForgetTagModel(userID)
+		return
+	}
+
+	model.mu.Lock()
+	defer model.mu.Unlock()
+	for _, id := range transactionIDs {
+		model.remove(id)
+		if doc, ok := docs[id]; ok {
+			model.add(id, doc)
+		}
+	}
+}
+
+// ForgetTagModel drops the user's classifier so it's trained again on next
+// use, e.g. after a tag is deleted
+func ForgetTagModel(userID int) {
+	tagModels.Lock()
+	delete(tagModels.byUser, userID)
+	tagModels.Unlock()
+}
+
+// loadTaggedDocuments loads the user's tagged transactions, only those in ids
+// when given
+func loadTaggedDocuments(userID int, ids []int) (map[int]taggedDocument, error) {
+	query := `
+		SELECT t.id, t.description, t.amount, t.type, COALESCE(t.account_id, 0), array_agg(tt.tag_id ORDER BY tt.tag_id)
+		FROM transactions t
+		JOIN transaction_tags tt ON tt.transaction_id = t.id
+		WHERE t.user_id = $1`
+	args := []interface{}{userID}
+	if ids != nil {
+		query += ` AND t.id = ANY($2)`
+		args = append(args, pq.Array(ids))
+	}
+	query += ` GROUP BY t.id`
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	docs := make(map[int]taggedDocument)
+	for rows.Next() {
+		var id, accountID int
+		var description, txType string
+		var amount float64
+		var tagIDs pq.Int64Array
+		if err := rows.Scan(&id, &description, &amount, &txType, &accountID, &tagIDs); err != nil {
+			return nil, err
+		}
+
+		doc := taggedDocument{features: TagFeatures(description, amount, txType, accountID)}
+		for _, tagID := range tagIDs {
+			doc.tagIDs = append(doc.tagIDs, int(tagID))
+		}
+		docs[id] = doc
+	}
+	return docs, rows.Err()
+}
+
+func newTagClassifier() *TagClassifier {
+	return &TagClassifier{
+		tagDocs:       make(map[int]int),
+		featureCounts: make(map[int]map[string]int),
+		tagFeatures:   make(map[int]int),
+		vocabulary:    make(map[string]int),
+		trained:       make(map[int]taggedDocument),
+	}
+}
+
+// add counts a transaction. The caller holds mu or owns the model.
+func (m *TagClassifier) add(id int, doc taggedDocument) {
+	m.trained[id] = doc
+	m.docs++
+	for _, feature := range uniqueFeatures(doc.features) {
+		m.vocabulary[feature]++
+	}
+	for _, tagID := range doc.tagIDs {
+		m.tagDocs[tagID]++
+		counts := m.featureCounts[tagID]
+		if counts == nil {
+			counts = make(map[string]int)
+			m.featureCounts[tagID] = counts
+		}
+		for _, feature := range doc.features {
+			counts[feature]++
+		}
+		m.tagFeatures[tagID] += len(doc.features)
+	}
+}
+
+// remove undoes add for a transaction, if it's in the model. The caller holds mu.
+func (m *TagClassifier) remove(id int) {
+	doc, ok := m.trained[id]
+	if !ok {
+		return
+	}
+	delete(m.trained, id)
+	m.docs--
+	for _, feature := range uniqueFeatures(doc.features) {
+		if m.vocabulary[feature]--; m.vocabulary[feature] <= 0 {
+			delete(m.vocabulary, feature)
+		}
+	}
+	for _, tagID := range doc.tagIDs {
+		if m.tagDocs[tagID]--; m.tagDocs[tagID] <= 0 {
+			delete(m.tagDocs, tagID)
+			delete(m.featureCounts, tagID)
+			delete(m.tagFeatures, tagID)
+			continue
+		}
+		counts := m.featureCounts[tagID]
+		for _, feature := range doc.features {
+			if counts[feature]--; counts[feature] <= 0 {
+				delete(counts, feature)
+			}
+		}
+		m.tagFeatures[tagID] -= len(doc.features)
+	}
+}
+
+// Predict ranks the user's tags for a transaction, most likely first. Returns
+// nothing when none of the description's words has been seen before, since the
+// prediction would then only reflect which tags are most common.
+func (m *TagClassifier) Predict(description string, amount float64, txType string, accountID int) []TagPrediction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.docs == 0 {
+		return nil
+	}
+
+	features := TagFeatures(description, amount, txType, accountID)
+	known := false
+	for _, feature := range features {
+		if !strings.Contains(feature, ":") && m.vocabulary[feature] > 0 {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return nil
+	}
+
+	// Log-probabilities with Laplace smoothing
+	vocabularySize := float64(len(m.vocabulary))
+	scores := make(map[int]float64, len(m.tagDocs))
+	best := math.Inf(-1)
+	for tagID, docs := range m.tagDocs {
+		score := math.Log(float64(docs) / float64(m.docs))
+		counts := m.featureCounts[tagID]
+		denominator := float64(m.tagFeatures[tagID]) + vocabularySize
+		for _, feature := range features {
+			score += math.Log((float64(counts[feature]) + 1) / denominator)
+		}
+		scores[tagID] = score
+		best = math.Max(best, score)
+	}
+
+	// Normalize to probabilities (softmax, shifted by the best score)
+	total := 0.0
+	for tagID, score := range scores {
+		scores[tagID] = math.Exp(score - best)
+		total += scores[tagID]
+	}
+	predictions := make([]TagPrediction, 0, len(scores))
+	for tagID, score := range scores {
+		predictions = append(predictions, TagPrediction{
+			TagID:       tagID,
+			Probability: math.Round(score/total*1000) / 1000,
+		})
+	}
+
+	sort.Slice(predictions, func(a, b int) bool {
+		if predictions[a].Probability != predictions[b].Probability {
+			return predictions[a].Probability > predictions[b].Probability
+		}
+		return predictions[a].TagID < predictions[b].TagID
+	})
+	if len(predictions) > maxTagPredictions {
+		predictions = predictions[:maxTagPredictions]
+	}
+	return predictions
+}
+
+// TagFeatures reduces a transaction to the classifier's features: the
+// description's words (without numbers such as dates or operation codes), plus
+// "amount:", "type:" and "account:" features
+func TagFeatures(description string, amount float64, txType string, accountID int) []string {
+	var features []string
+	for _, word := range strings.Fields(normalizeDescription(description)) {
+		if len(word) < 2 || isNumber(word) {
+			continue
+		}
+		features = append(features, word)
+	}
+	features = append(features, "amount:"+amountBucket(amount), "type:"+txType)
+	if accountID != 0 {
+		features = append(features, "account:"+strconv.Itoa(accountID))
+	}
+	return features
+}
+
+// amountBucket groups amounts by order of magnitude in steps of 1, 2 and 5
+// (under 1, 1-2, 2-5, 5-10, 10-20...)
+func amountBucket(amount float64) string {
+	amount = math.Abs(amount)
+	if amount < 1 {
+		return "0"
+	}
+	exponent := math.Floor(math.Log10(amount))
+	step := "1"
+	switch leading := amount / math.Pow(10, exponent); {
+	case leading >= 5:
+		step = "5"
+	case leading >= 2:
+		step = "2"
+	}
+	return step + "e" + strconv.Itoa(int(exponent))
+}
+
+func isNumber(word string) bool {
+	for _, r := range word {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func uniqueFeatures(features []string) []string {
+	seen := make(map[string]bool, len(features))
+	result := make([]string, 0, len(features))
+	for _, feature := range features {
+		if !seen[feature] {
+			seen[feature] = true
+			result = append(result, feature)
+		}
+	}
+	return result
+}
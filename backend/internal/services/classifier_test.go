@@ -0,0 +1,128 @@
+package services
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// trainingTransaction is a tagged expense of account 7
+type trainingTransaction struct {
+	description string
+	amount      float64
+	tagIDs      []int
+}
+
+// trainedClassifier returns a classifier trained on the given transactions
+func trainedClassifier(docs map[int]trainingTransaction) *TagClassifier {
+	m := newTagClassifier()
+	for id, d := range docs {
+		m.add(id, taggedDocument{features: TagFeatures(d.description, d.amount, "expense", 7), tagIDs: d.tagIDs})
+	}
+	return m
+}
+
+func TestTagClassifierPredict(t *testing.T) {
+	const groceries, streaming, transport = 1, 2, 3
+	m := trainedClassifier(map[int]trainingTransaction{
+		1: {"PLAZA VEA SAN ISIDRO", 125.40, []int{groceries}},
+		2: {"PLAZA VEA MIRAFLORES", 89.90, []int{groceries}},
+		3: {"TOTTUS LA MOLINA", 150.00, []int{groceries}},
+		4: {"NETFLIX.COM 866-579", 44.90, []int{streaming}},
+		5: {"SPOTIFY P1A2B3", 21.90, []int{streaming}},
+		6: {"UBER *TRIP", 18.50, []int{transport}},
+	})
+
+	tests := []struct {
+		name        string
+		description string
+		amount      float64
+		want        int // Best tag, 0 for no prediction
+	}{
+		{"known store", "PLAZA VEA SURCO", 110.00, groceries},
+		{"known subscription", "NETFLIX.COM 123-456", 44.90, streaming},
+		{"known ride", "UBER *TRIP HELP.UBER.COM", 12.00, transport},
+		{"unknown words", "FARMACIA INKAFARMA", 30.00, 0},
+		{"only numbers", "123456 0001", 30.00, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			predictions := m.Predict(tt.description, tt.amount, "expense", 7)
+			if tt.want == 0 {
+				if len(predictions) != 0 {
+					t.Errorf("predictions = %+v, want none", predictions)
+				}
+				return
+			}
+			if len(predictions) == 0 || predictions[0].TagID != tt.want {
+				t.Fatalf("predictions = %+v, want tag %d first", predictions, tt.want)
+			}
+			if len(predictions) > maxTagPredictions {
+				t.Errorf("got %d predictions, want at most %d", len(predictions), maxTagPredictions)
+			}
+			total := 0.0
+			for i, p := range predictions {
+				total += p.Probability
+				if i > 0 && p.Probability > predictions[i-1].Probability {
+					t.Errorf("predictions aren't ranked: %+v", predictions)
+				}
+			}
+			if math.Abs(total-1) > 0.01 {
+				t.Errorf("probabilities add up to %.3f, want 1", total)
+			}
+		})
+	}
+}
+
+func TestTagClassifierRemove(t *testing.T) {
+	m := newTagClassifier()
+	m.add(1, taggedDocument{features: TagFeatures("PLAZA VEA", 100, "expense", 0), tagIDs: []int{1}})
+	m.add(2, taggedDocument{features: TagFeatures("PLAZA VEA", 100, "expense", 0), tagIDs: []int{2}})
+
+	// Retagging a transaction moves its prediction to the new tag
+	m.remove(1)
+	m.add(1, taggedDocument{features: TagFeatures("PLAZA VEA", 100, "expense", 0), tagIDs: []int{2}})
+	predictions := m.Predict("PLAZA VEA", 100, "expense", 0)
+	if len(predictions) != 1 || predictions[0].TagID != 2 || predictions[0].Probability != 1 {
+		t.Errorf("predictions = %+v, want only tag 2", predictions)
+	}
+
+	// Removing everything leaves an empty model
+	m.remove(1)
+	m.remove(2)
+	m.remove(3)
+	if m.docs != 0 || len(m.vocabulary) != 0 || len(m.tagDocs) != 0 || len(m.featureCounts) != 0 {
+		t.Errorf("model not empty after removing every transaction: %d docs, %d features", m.docs, len(m.vocabulary))
+	}
+	if predictions := m.Predict("PLAZA VEA", 100, "expense", 0); predictions != nil {
+		t.Errorf("empty model predicted %+v", predictions)
+	}
+}
+
+func TestTagFeatures(t *testing.T) {
+	got := TagFeatures("Pago 2025-03 NETFLIX.COM a", -44.90, "expense", 7)
+	want := []string{"pago", "netflix", "com", "amount:2e1", "type:expense", "account:7"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TagFeatures = %q, want %q", got, want)
+	}
+}
+
+func TestAmountBucket(t *testing.T) {
+	tests := map[float64]string{
+		0.5:    "0",
+		1:      "1e0",
+		1.99:   "1e0",
+		2:      "2e0",
+		4.99:   "2e0",
+		5:      "5e0",
+		12.50:  "1e1",
+		-44.90: "2e1",
+		2500:   "2e3",
+	}
+	for amount, want := range tests {
+		if got := amountBucket(amount); got != want {
+			t.Errorf("amountBucket(%v) = %q, want %q", amount, got, want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// csvDelimiters are the separators we try when sniffing a CSV file
+var csvDelimiters = []rune{',', ';', '\t', '|'}
+
+// readCSVFile reads .csv files exported by banks or spreadsheets.
+// Handles BOMs, UTF-8/UTF-16/Windows-1252 encodings, quoted fields
+// and comma, semicolon or tab delimiters.
+func readCSVFile(filePath string) ([][]string, error) {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening csv file: %w", err)
+	}
+
+	content, err := decodeTextContent(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding csv file: %w", err)
+	}
+
+	if strings.TrimSpace(content) == "" {
+		return nil, fmt.Errorf("csv file is empty")
+	}
+
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.Comma = sniffDelimiter(content)
+	reader.FieldsPerRecord = -1 // Bank exports often have banner rows with fewer columns
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	var rows [][]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading csv rows: %w", err)
+		}
+		rows = append(rows, record)
+	}
+
+	return rows, nil
+}
+
+// decodeTextContent converts raw bytes to a UTF-8 string.
+// BOMs take precedence; otherwise valid UTF-8 is kept as is and anything
+// else is treated as Windows-1252 (a superset of Latin-1 used by most
+// Peruvian bank exports).
+func decodeTextContent(raw []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(raw, []byte{0xEF, 0xBB, 0xBF}):
+		return string(raw[3:]), nil
+	case bytes.HasPrefix(raw, []byte{0xFF, 0xFE}):
+		decoded, err := unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder().Bytes(raw)
+		return string(decoded), err
+	case bytes.HasPrefix(raw, []byte{0xFE, 0xFF}):
+		decoded, err := unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder().Bytes(raw)
+		return string(decoded), err
+	}
+
+	if utf8.Valid(raw) {
+		return string(raw), nil
+	}
+
+	decoded, err := charmap.Windows1252.NewDecoder().Bytes(raw)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+// sniffDelimiter picks the delimiter that splits the first lines into the
+// most consistent number of columns. Delimiters inside quotes are ignored.
+func sniffDelimiter(content string) rune {
+	lines := strings.Split(content, "\n")
+	if len(lines) > 20 {
+		lines = lines[:20]
+	}
+
+	best := ','
+	bestScore := 0
+
+	for _, delim := range csvDelimiters {
+		counts := make(map[int]int)
+		for _, line := range lines {
+			line = strings.TrimRight(line, "\r")
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if n := countUnquoted(line, delim); n > 0 {
+				counts[n]++
+			}
+		}
+
+		// Score: how many lines share the most common column count,
+		// weighted slightly by the number of columns it produces
+		score := 0
+		for n, lineCount := range counts {
+			if s := lineCount*10 + n; s > score {
+				score = s
+			}
+		}
+
+		if score > bestScore {
+			best = delim
+			bestScore = score
+		}
+	}
+
+	return best
+}
+
+// countUnquoted counts occurrences of delim outside double-quoted sections
+func countUnquoted(line string, delim rune) int {
+	count := 0
+	inQuotes := false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case r == delim && !inQuotes:
+			count++
+		}
+	}
+	return count
+}
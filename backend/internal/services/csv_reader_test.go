@@ -0,0 +1,98 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSniffDelimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    rune
+	}{
+		{"comma", "Fecha,Descripcion,Importe\n01/03/2025,PLAZA VEA,-12.50\n", ','},
+		{"semicolon with decimal commas", "Fecha;Descripcion;Importe\n01/03/2025;PLAZA VEA;-12,50\n02/03/2025;TAMBO;-3,90\n", ';'},
+		{"tab", "Fecha\tDescripcion\tImporte\n01/03/2025\tPLAZA VEA\t-12.50\n", '\t'},
+		{"pipe", "Fecha|Descripcion|Importe\r\n01/03/2025|PLAZA VEA|-12.50\r\n", '|'},
+		{"quoted commas are ignored", "Fecha;Descripcion;Importe\n01/03/2025;\"PAGO, CUOTA 1, 2, 3\";-12,50\n", ';'},
+		{"banner row before the header", "Movimientos de la cuenta\nFecha;Descripcion;Importe\n01/03/2025;PLAZA VEA;-12,50\n", ';'},
+		{"no delimiter defaults to comma", "solo una columna\n", ','},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffDelimiter(tt.content); got != tt.want {
+				t.Errorf("sniffDelimiter = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTextContent(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+		want string
+	}{
+		{"utf-8", []byte("Descripción"), "Descripción"},
+		{"utf-8 bom", append([]byte{0xEF, 0xBB, 0xBF}, "Año"...), "Año"},
+		{"windows-1252", []byte{'A', 0xF1, 'o', ' ', 0x80}, "Año €"},
+		{"utf-16 little endian", []byte{0xFF, 0xFE, 'S', 0, '/', 0, 0xF1, 0}, "S/ñ"},
+		{"utf-16 big endian", []byte{0xFE, 0xFF, 0, 'S', 0, '/', 0, 0xF1}, "S/ñ"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeTextContent(tt.raw)
+			if err != nil {
+				t.Fatalf("decodeTextContent: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("decodeTextContent = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCSVFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    [][]string
+	}{
+		{
+			name:    "quoted fields with delimiters and newlines",
+			content: "Fecha;Descripcion;Importe\r\n01/03/2025;\"PAGO; CUOTA\nUNO\";-12,50\r\n",
+			want: [][]string{
+				{"Fecha", "Descripcion", "Importe"},
+				{"01/03/2025", "PAGO; CUOTA\nUNO", "-12,50"},
+			},
+		},
+		{
+			name:    "rows with different column counts",
+			content: "\xEF\xBB\xBFBanco\nFecha,Descripcion,Importe\n01/03/2025, TAMBO,-3.90\n",
+			want: [][]string{
+				{"Banco"},
+				{"Fecha", "Descripcion", "Importe"},
+				{"01/03/2025", "TAMBO", "-3.90"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readCSVFile(writeStatement(t, "statement.csv", tt.content))
+			if err != nil {
+				t.Fatalf("readCSVFile: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readCSVFile = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := readCSVFile(writeStatement(t, "empty.csv", " \n")); err == nil {
+		t.Error("expected an error for an empty file")
+	}
+}
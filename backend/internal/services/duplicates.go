@@ -0,0 +1,220 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// DuplicateDateWindow is how many days apart the same movement may be posted
+	// (operation vs. posting date)
+	DuplicateDateWindow = 3
+	// DuplicateThreshold is the confidence from which a row is treated as a duplicate
+	DuplicateThreshold = 0.8
+	// maxDuplicateCandidates is how many candidates are returned per row
+	maxDuplicateCandidates = 3
+)
+
+// Weights of the duplicate score. Amount, currency and type must match for a
+// transaction to be a candidate at all, so they contribute a fixed share.
+const (
+	duplicateWeightAmount      = 0.35
+	duplicateWeightDate        = 0.25
+	duplicateWeightDescription = 0.30
+	duplicateWeightAccount     = 0.10
+)
+
+// ExistingTransaction is a saved transaction that an incoming row may duplicate
+type ExistingTransaction struct {
+	ID          int
+	AccountID   *int
+	Description string
+	Amount      float64
+	Currency    string
+	Type        string
+	Date        string
+	TagIDs      []int
+}
+
+// DuplicateCandidate is an existing transaction scored against an incoming row
+type DuplicateCandidate struct {
+	TransactionID int     `json:"transaction_id"`
+	Score         float64 `json:"score"` // 0-1
+	Description   string  `json:"description"`
+	Date          string  `json:"date"`
+	AccountID     *int    `json:"account_id,omitempty"`
+}
+
+// DuplicateMatch is the outcome of matching one incoming row
+type DuplicateMatch struct {
+	Confidence float64              // Score of the transaction assigned to the row, 0 when none
+	Best       *ExistingTransaction // Transaction assigned to the row
+	Candidates []DuplicateCandidate // Best candidates first
+}
+
+// ScoreDuplicate scores how likely an existing transaction is the same movement
+// as an incoming row of accountID. Returns 0 when it can't be: different
+// amount, currency or type, or dates further apart than DuplicateDateWindow.
+func ScoreDuplicate(tx ParsedTransaction, accountID int, existing ExistingTransaction) float64 {
+	if math.Abs(tx.Amount-existing.Amount) >= 0.005 || tx.Type != existing.Type {
+		return 0
+	}
+	if tx.Currency != "" && existing.Currency != "" && !strings.EqualFold(tx.Currency, existing.Currency) {
+		return 0
+	}
+
+	days, ok := daysBetween(tx.Date, existing.Date)
+	if !ok || days > DuplicateDateWindow {
+		return 0
+	}
+	dateScore := 1 - float64(days)/float64(DuplicateDateWindow+1)
+
+	accountScore := 0.0
+	if existing.AccountID != nil && *existing.AccountID == accountID {
+		accountScore = 1
+	}
+
+	score := duplicateWeightAmount +
+		duplicateWeightDate*dateScore +
+		duplicateWeightDescription*DescriptionSimilarity(tx.Description, existing.Description) +
+		duplicateWeightAccount*accountScore
+	return math.Round(score*1000) / 1000
+}
+
+// MatchDuplicates scores every existing transaction against every row and
+// assigns each existing transaction to at most one row, best scores first,
+// so two identical movements on the same day aren't both matched to a single
+// saved one. Rows use their own account when set, otherwise accountID.
+func MatchDuplicates(transactions []ParsedTransaction, accountID int, existing []ExistingTransaction) []DuplicateMatch {
+	type pair struct {
+		row, existing int
+		score         float64
+	}
+
+	matches := make([]DuplicateMatch, len(transactions))
+	var pairs []pair
+	for i, tx := range transactions {
+		if tx.Error != "" {
+			continue
+		}
+		rowAccountID := accountID
+		if tx.AccountID != 0 {
+			rowAccountID = tx.AccountID
+		}
+
+		var candidates []DuplicateCandidate
+		for j, e := range existing {
+			score := ScoreDuplicate(tx, rowAccountID, e)
+			if score == 0 {
+				continue
+			}
+			pairs = append(pairs, pair{row: i, existing: j, score: score})
+			candidates = append(candidates, DuplicateCandidate{
+				TransactionID: e.ID,
+				Score:         score,
+				Description:   e.Description,
+				Date:          e.Date,
+				AccountID:     e.AccountID,
+			})
+		}
+
+		sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].Score > candidates[b].Score })
+		if len(candidates) > maxDuplicateCandidates {
+			candidates = candidates[:maxDuplicateCandidates]
+		}
+		matches[i].Candidates = candidates
+	}
+
+	sort.SliceStable(pairs, func(a, b int) bool { return pairs[a].score > pairs[b].score })
+	taken := make(map[int]bool)
+	for _, p := range pairs {
+		if matches[p.row].Best != nil || taken[p.existing] {
+			continue
+		}
+		taken[p.existing] = true
+		matches[p.row].Best = &existing[p.existing]
+		matches[p.row].Confidence = p.score
+	}
+
+	for i := range matches {
+		if matches[i].Candidates == nil {
+			matches[i].Candidates = []DuplicateCandidate{}
+		}
+	}
+	return matches
+}
+
+// DescriptionSimilarity compares two descriptions from 0 to 1 by their word
+// trigrams (as pg_trgm does). A description that is a truncation of the
+// other counts as identical, since banks cut long descriptions.
+func DescriptionSimilarity(a, b string) float64 {
+	a, b = normalizeDescription(a), normalizeDescription(b)
+	if a == b {
+		return 1
+	}
+	if a == "" || b == "" {
+		return 0
+	}
+
+	short, long := a, b
+	if len(short) > len(long) {
+		short, long = long, short
+	}
+	if len(short) >= 8 && strings.HasPrefix(long, short) {
+		return 1
+	}
+
+	ta, tb := trigrams(a), trigrams(b)
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	union := len(ta) + len(tb) - shared
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
+
+// normalizeDescription lowercases and keeps letters and digits, one space between words
+func normalizeDescription(s string) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// trigrams returns the trigrams of each word padded with two leading spaces
+// and one trailing space
+func trigrams(s string) map[string]bool {
+	result := make(map[string]bool)
+	for _, word := range strings.Fields(s) {
+		runes := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			result[string(runes[i:i+3])] = true
+		}
+	}
+	return result
+}
+
+// daysBetween returns the absolute number of days between two YYYY-MM-DD dates
+func daysBetween(a, b string) (int, bool) {
+	ta, err := time.Parse("2006-01-02", a)
+	if err != nil {
+		return 0, false
+	}
+	tb, err := time.Parse("2006-01-02", b)
+	if err != nil {
+		return 0, false
+	}
+	days := int(math.Round(ta.Sub(tb).Hours() / 24))
+	if days < 0 {
+		days = -days
+	}
+	return days, true
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"math"
+	"testing"
+)
+
+func TestScoreDuplicate(t *testing.T) {
+	account := 7
+	otherAccount := 8
+	existing := ExistingTransaction{ID: 1, AccountID: &account, Description: "PLAZA VEA SAN ISIDRO", Amount: 125.40,
+		Currency: "PEN", Type: "expense", Date: "2025-03-03"}
+	row := ParsedTransaction{Description: "PLAZA VEA SAN ISIDRO", Amount: 125.40, Currency: "PEN", Type: "expense", Date: "2025-03-03"}
+
+	tests := []struct {
+		name   string
+		modify func(tx *ParsedTransaction, e *ExistingTransaction)
+		want   float64
+	}{
+		{"identical", func(*ParsedTransaction, *ExistingTransaction) {}, 1},
+		{"one day apart", func(tx *ParsedTransaction, _ *ExistingTransaction) { tx.Date = "2025-03-04" }, 0.938},
+		{"at the window edge", func(tx *ParsedTransaction, _ *ExistingTransaction) { tx.Date = "2025-02-28" }, 0.813},
+		{"past the window", func(tx *ParsedTransaction, _ *ExistingTransaction) { tx.Date = "2025-03-07" }, 0},
+		{"other account", func(_ *ParsedTransaction, e *ExistingTransaction) { e.AccountID = &otherAccount }, 0.9},
+		{"no account", func(_ *ParsedTransaction, e *ExistingTransaction) { e.AccountID = nil }, 0.9},
+		{"truncated description", func(tx *ParsedTransaction, _ *ExistingTransaction) { tx.Description = "PLAZA VEA SAN" }, 1},
+		{"unrelated description", func(tx *ParsedTransaction, _ *ExistingTransaction) { tx.Description = "xyz" }, 0.7},
+		{"rounding difference", func(tx *ParsedTransaction, _ *ExistingTransaction) { tx.Amount = 125.404 }, 1},
+		{"different amount", func(tx *ParsedTransaction, _ *ExistingTransaction) { tx.Amount = 125.41 }, 0},
+		{"different type", func(tx *ParsedTransaction, _ *ExistingTransaction) { tx.Type = "income" }, 0},
+		{"different currency", func(tx *ParsedTransaction, _ *ExistingTransaction) { tx.Currency = "USD" }, 0},
+		{"currency case", func(tx *ParsedTransaction, _ *ExistingTransaction) { tx.Currency = "pen" }, 1},
+		{"missing currency", func(tx *ParsedTransaction, _ *ExistingTransaction) { tx.Currency = "" }, 1},
+		{"invalid date", func(tx *ParsedTransaction, _ *ExistingTransaction) { tx.Date = "03/03/2025" }, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, e := row, existing
+			tt.modify(&tx, &e)
+			if got := ScoreDuplicate(tx, account, e); math.Abs(got-tt.want) > 0.001 {
+				t.Errorf("ScoreDuplicate = %.3f, want %.3f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchDuplicates(t *testing.T) {
+	account := 7
+	existing := []ExistingTransaction{
+		{ID: 1, AccountID: &account, Description: "TAMBO", Amount: 3.90, Currency: "PEN", Type: "expense", Date: "2025-03-03"},
+		{ID: 2, AccountID: &account, Description: "NETFLIX.COM", Amount: 15.99, Currency: "USD", Type: "expense", Date: "2025-03-05"},
+	}
+	rows := []ParsedTransaction{
+		// Two identical movements, only one of them saved
+		{Description: "TAMBO", Amount: 3.90, Currency: "PEN", Type: "expense", Date: "2025-03-03"},
+		{Description: "TAMBO", Amount: 3.90, Currency: "PEN", Type: "expense", Date: "2025-03-03"},
+		// Posted a day later than the saved one
+		{Description: "NETFLIX.COM", Amount: 15.99, Currency: "USD", Type: "expense", Date: "2025-03-06"},
+		{Description: "NETFLIX.COM", Error: "unrecognized date"},
+		{Description: "SUELDO", Amount: 2500, Currency: "PEN", Type: "income", Date: "2025-03-01"},
+	}
+
+	matches := MatchDuplicates(rows, account, existing)
+	if len(matches) != len(rows) {
+		t.Fatalf("got %d matches, want %d", len(matches), len(rows))
+	}
+
+	wantBest := []int{1, 0, 2, 0, 0}
+	for i, want := range wantBest {
+		got := 0
+		if matches[i].Best != nil {
+			got = matches[i].Best.ID
+		}
+		if got != want {
+			t.Errorf("row %d matched transaction %d, want %d", i, got, want)
+		}
+		if matches[i].Candidates == nil {
+			t.Errorf("row %d has nil candidates", i)
+		}
+	}
+
+	// The unmatched twin still lists the saved transaction as a candidate
+	if len(matches[1].Candidates) != 1 || matches[1].Candidates[0].TransactionID != 1 || matches[1].Confidence != 0 {
+		t.Errorf("second twin = %+v", matches[1])
+	}
+	if matches[2].Confidence < DuplicateThreshold {
+		t.Errorf("confidence of the late posting = %.3f, want at least %.2f", matches[2].Confidence, DuplicateThreshold)
+	}
+}
+
+func TestDescriptionSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		min, max float64
+	}{
+		{"PLAZA VEA", "plaza-vea", 1, 1},
+		{"NETFLIX.COM 866-579", "NETFLIX.COM", 1, 1},
+		{"PLAZA VEA SAN ISIDRO", "PLAZA VEA MIRAFLORES", 0.3, 0.7},
+		{"TAMBO", "NETFLIX", 0, 0},
+		{"", "TAMBO", 0, 0},
+		{"", "", 1, 1},
+		// Short prefixes aren't treated as truncations
+		{"UBER", "UBER EATS", 0, 0.99},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"|"+tt.b, func(t *testing.T) {
+			got := DescriptionSimilarity(tt.a, tt.b)
+			if got < tt.min || got > tt.max {
+				t.Errorf("DescriptionSimilarity(%q, %q) = %.3f, want between %.2f and %.2f", tt.a, tt.b, got, tt.min, tt.max)
+			}
+			if reverse := DescriptionSimilarity(tt.b, tt.a); reverse != got {
+				t.Errorf("similarity isn't symmetric: %.3f and %.3f", got, reverse)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/warren/finance-app/internal/database"
+)
+
+// Reasons an earlier import matches a new statement
+const (
+	MatchSameFile = "same_file"          // Byte-identical file
+	MatchSameRows = "same_rows"          // Same movements for the account, whatever the file format
+	MatchOverlap  = "overlapping_period" // Different movements covering some of the same dates
+)
+
+// StatementFingerprint identifies a statement by its content rather than its file
+type StatementFingerprint struct {
+	RowHash     string `json:"row_hash,omitempty"`
+	PeriodStart string `json:"period_start,omitempty"`
+	PeriodEnd   string `json:"period_end,omitempty"`
+	Rows        int    `json:"rows"`
+}
+
+// StatementMatch is an earlier import that looks like the same statement
+type StatementMatch struct {
+	ImportID    int       `json:"import_id"`
+	Filename    string    `json:"filename"`
+	Status      string    `json:"status"`
+	PeriodStart *string   `json:"period_start,omitempty"`
+	PeriodEnd   *string   `json:"period_end,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Reason      string    `json:"reason"`
+}
+
+// SameStatement reports whether the match is the statement itself and not
+// just an overlapping period
+func (m StatementMatch) SameStatement() bool {
+	return m.Reason == MatchSameFile || m.Reason == MatchSameRows
+}
+
+// FingerprintStatement hashes the parsed rows. Each row is reduced to its
+// account, date, amount, type and currency and the rows are sorted, so the
+// same statement exported as CSV, Excel or PDF hashes the same. Rows that
+// couldn't be parsed are left out.
+func FingerprintStatement(accountID int, transactions []ParsedTransaction) StatementFingerprint {
+	var fp StatementFingerprint
+
+	lines := make([]string, 0, len(transactions))
+	for _, tx := range transactions {
+		if tx.Error != "" || tx.Date == "" {
+			continue
+		}
+		account := accountID
+		if tx.AccountID != 0 {
+			account = tx.AccountID
+		}
+		lines = append(lines, fmt.Sprintf("%d|%s|%.2f|%s|%s", account, tx.Date, tx.Amount, tx.Type, strings.ToUpper(tx.Currency)))
+
+		if fp.PeriodStart == "" || tx.Date < fp.PeriodStart {
+			fp.PeriodStart = tx.Date
+		}
+		if fp.PeriodEnd == "" || tx.Date > fp.PeriodEnd {
+			fp.PeriodEnd = tx.Date
+		}
+	}
+	if len(lines) == 0 {
+		return fp
+	}
+
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	fp.RowHash = hex.EncodeToString(sum[:])
+	fp.Rows = len(lines)
+	return fp
+}
+
+// FindStatementMatches returns the user's staged or committed imports with
+// the same file, the same rows for the account, or a period of the account
+// overlapping the fingerprint's. excludeID skips the import being checked.
+func FindStatementMatches(userID int, accountID int, fileHash string, fp StatementFingerprint, excludeID int) ([]StatementMatch, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, filename, status, to_char(period_start, 'YYYY-MM-DD'), to_char(period_end, 'YYYY-MM-DD'), created_at,
+		       CASE WHEN file_hash = $3 THEN 'same_file'
+		            WHEN row_hash = NULLIF($4, '') THEN 'same_rows'
+		            ELSE 'overlapping_period' END
+		FROM imports
+		WHERE user_id = $1 AND id <> $7 AND status IN ('staged', 'completed')
+		  AND (file_hash = $3
+		       OR (account_id = $2 AND row_hash = NULLIF($4, ''))
+		       OR (account_id = $2 AND period_start <= NULLIF($6, '')::date AND period_end >= NULLIF($5, '')::date))
+		ORDER BY created_at DESC`,
+		userID, accountID, fileHash, fp.RowHash, fp.PeriodStart, fp.PeriodEnd, excludeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []StatementMatch{}
+	for rows.Next() {
+		var m StatementMatch
+		if err := rows.Scan(&m.ImportID, &m.Filename, &m.Status, &m.PeriodStart, &m.PeriodEnd, &m.CreatedAt, &m.Reason); err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
@@ -0,0 +1,103 @@
+package services
+
+import "testing"
+
+func TestFingerprintStatement(t *testing.T) {
+	base := []ParsedTransaction{
+		{Date: "2025-03-03", Description: "PLAZA VEA", Amount: 125.40, Currency: "PEN", Type: "expense"},
+		{Date: "2025-03-01", Description: "SUELDO", Amount: 2500, Currency: "PEN", Type: "income"},
+		{Date: "2025-03-05", Description: "NETFLIX", Amount: 15.99, Currency: "USD", Type: "expense"},
+	}
+	fp := FingerprintStatement(7, base)
+	if fp.RowHash == "" || fp.Rows != 3 || fp.PeriodStart != "2025-03-01" || fp.PeriodEnd != "2025-03-05" {
+		t.Fatalf("fingerprint = %+v", fp)
+	}
+
+	tests := []struct {
+		name         string
+		accountID    int
+		transactions []ParsedTransaction
+		same         bool
+	}{
+		{
+			name:      "other order, descriptions and currency case",
+			accountID: 7,
+			transactions: []ParsedTransaction{
+				{Date: "2025-03-05", Description: "NETFLIX.COM 866-579", Amount: 15.99, Currency: "usd", Type: "expense", RawText: "pdf"},
+				{Date: "2025-03-03", Description: "PLAZA VEA SAN ISIDRO", Amount: 125.4, Currency: "PEN", Type: "expense"},
+				{Date: "2025-03-01", Description: "ABONO SUELDO", Amount: 2500.001, Currency: "PEN", Type: "income"},
+			},
+			same: true,
+		},
+		{
+			name:      "rows that couldn't be parsed are left out",
+			accountID: 7,
+			transactions: append([]ParsedTransaction{
+				{Error: "unrecognized date", Description: "SALDO"},
+				{Date: "", Amount: 10, Type: "expense"},
+			}, base...),
+			same: true,
+		},
+		{
+			name:         "other account",
+			accountID:    8,
+			transactions: base,
+			same:         false,
+		},
+		{
+			name:      "rows mapped to their own account",
+			accountID: 8,
+			transactions: []ParsedTransaction{
+				{Date: "2025-03-03", Amount: 125.40, Currency: "PEN", Type: "expense", AccountID: 7},
+				{Date: "2025-03-01", Amount: 2500, Currency: "PEN", Type: "income", AccountID: 7},
+				{Date: "2025-03-05", Amount: 15.99, Currency: "USD", Type: "expense", AccountID: 7},
+			},
+			same: true,
+		},
+		{
+			name:      "different amount",
+			accountID: 7,
+			transactions: []ParsedTransaction{
+				base[0], base[1],
+				{Date: "2025-03-05", Amount: 16.99, Currency: "USD", Type: "expense"},
+			},
+			same: false,
+		},
+		{
+			name:      "different type",
+			accountID: 7,
+			transactions: []ParsedTransaction{
+				base[0], base[1],
+				{Date: "2025-03-05", Amount: 15.99, Currency: "USD", Type: "income"},
+			},
+			same: false,
+		},
+		{
+			name:         "missing row",
+			accountID:    7,
+			transactions: base[:2],
+			same:         false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FingerprintStatement(tt.accountID, tt.transactions)
+			if (got.RowHash == fp.RowHash) != tt.same {
+				t.Errorf("same hash = %v, want %v", got.RowHash == fp.RowHash, tt.same)
+			}
+		})
+	}
+
+	if empty := FingerprintStatement(7, []ParsedTransaction{{Error: "bad row"}}); empty.RowHash != "" || empty.Rows != 0 {
+		t.Errorf("fingerprint of unparsed rows = %+v, want empty", empty)
+	}
+}
+
+func TestStatementMatchSameStatement(t *testing.T) {
+	for reason, want := range map[string]bool{MatchSameFile: true, MatchSameRows: true, MatchOverlap: false} {
+		if got := (StatementMatch{Reason: reason}).SameStatement(); got != want {
+			t.Errorf("SameStatement(%s) = %v, want %v", reason, got, want)
+		}
+	}
+}
@@ -3,10 +3,8 @@ package services
 import (
 	"fmt"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/extrame/xls"
 	"github.com/warren/finance-app/internal/database"
@@ -20,71 +18,50 @@ type ParsedTransaction struct {
 	Type        string  `json:"type"`
 	Date        string  `json:"date"`
 	RawText     string  `json:"raw_text"`
+	ExternalID  string  `json:"external_id,omitempty"` // Bank-assigned ID (e.g. OFX FITID)
+
+	// Populated by structured statement formats (camt, MT940)
+	ValueDate    string `json:"value_date,omitempty"`
+	Counterparty string `json:"counterparty,omitempty"`
+	Reference    string `json:"reference,omitempty"` // End-to-end reference
+	// Running balance from the statement row, when the layout has a balance column
+	Balance         *float64 `json:"balance,omitempty"`
+	BalanceMismatch bool     `json:"balance_mismatch,omitempty"` // Balance doesn't follow from the previous row
+	Row             int      `json:"row,omitempty"`              // 1-indexed spreadsheet row
+	Error           string   `json:"error,omitempty"`            // Row-level parse error (e.g. unrecognized date)
+	Sheet           string   `json:"sheet,omitempty"`            // Workbook sheet the row came from
+	AccountID       int      `json:"account_id,omitempty"`       // Target account when the sheet maps to its own account
 }
 
-// ProcessExcelFile reads and parses an Excel file for bank transactions
-// invertSigns should be true for credit card accounts where signs are inverted
-func ProcessExcelFile(filePath string, userID int, bankID string, invertSigns bool) ([]ParsedTransaction, int, error) {
-	ext := strings.ToLower(filepath.Ext(filePath))
-
-	var rows [][]string
-	var err error
-
-	// Use different library based on file extension
-	if ext == ".xls" {
-		rows, err = readXLSFile(filePath)
-	} else {
-		rows, err = readXLSXFile(filePath)
-	}
-
-	if err != nil {
-		return nil, 0, err
-	}
-
-	// Create import record
-	var importID int
-	err = database.DB.QueryRow(
-		`INSERT INTO imports (user_id, filename, file_type, status, total_transactions)
-		 VALUES ($1, $2, 'excel', 'processing', $3) RETURNING id`,
-		userID, filePath, len(rows),
-	).Scan(&importID)
-	if err != nil {
-		return nil, 0, fmt.Errorf("error creating import record: %w", err)
-	}
-
-	// Parse based on bank
-	var transactions []ParsedTransaction
-
-	if bankID == "" || bankID == "generic" {
-		transactions = detectAndParseExcel(rows)
-	} else {
-		transactions = parseExcelByBank(rows, bankID)
+// prepareLayout detects the columns for the generic layout and fixes "auto"
+// date and number formats for the whole file. Per-import formats and currency
+// set on the generic layout survive detection.
+func prepareLayout(rows [][]string, config BankConfig) BankConfig {
+	if config.ID == "generic" {
+		detected := DetectLayout(rows)
+		detected.DateFormat = config.DateFormat
+		detected.NumberFormat = config.NumberFormat
+		detected.Currency = config.Currency
+		config = detected
 	}
+	return resolveLayoutFormats(rows, config)
+}
 
-	// Invert signs for credit card accounts (e.g., BBVA credit cards)
-	// In credit card statements: purchases are positive, payments are negative
-	// We need to flip them: purchases become expenses, payments become income
-	if invertSigns {
-		for i := range transactions {
-			if transactions[i].Type == "income" {
-				transactions[i].Type = "expense"
-			} else {
-				transactions[i].Type = "income"
-			}
-		}
+// ReadSheetRows reads a named sheet ("" = first sheet). CSV files have a single sheet.
+func ReadSheetRows(filePath string, sheetName string) ([][]string, error) {
+	// Use different library based on file extension
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".xls":
+		return readXLSFile(filePath, sheetName)
+	case ".csv":
+		return readCSVFile(filePath)
+	default:
+		return readXLSXFile(filePath, sheetName)
 	}
-
-	// Update import status
-	database.DB.Exec(
-		`UPDATE imports SET status = 'completed', total_transactions = $1 WHERE id = $2`,
-		len(transactions), importID,
-	)
-
-	return transactions, importID, nil
 }
 
-// readXLSXFile reads .xlsx files using excelize
-func readXLSXFile(filePath string) ([][]string, error) {
+// readXLSXFile reads a sheet of an .xlsx file using excelize ("" = first sheet)
+func readXLSXFile(filePath string, sheetName string) ([][]string, error) {
 	f, err := excelize.OpenFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("error opening xlsx file: %w", err)
@@ -95,8 +72,11 @@ func readXLSXFile(filePath string) ([][]string, error) {
 	if len(sheets) == 0 {
 		return nil, fmt.Errorf("no sheets found in Excel file")
 	}
+	if sheetName == "" {
+		sheetName = sheets[0]
+	}
 
-	rows, err := f.GetRows(sheets[0])
+	rows, err := f.GetRows(sheetName)
 	if err != nil {
 		return nil, fmt.Errorf("error reading rows: %w", err)
 	}
@@ -104,14 +84,26 @@ func readXLSXFile(filePath string) ([][]string, error) {
 	return rows, nil
 }
 
-// readXLSFile reads .xls files using extrame/xls
-func readXLSFile(filePath string) ([][]string, error) {
+// readXLSFile reads a sheet of an .xls file using extrame/xls ("" = first sheet)
+func readXLSFile(filePath string, sheetName string) ([][]string, error) {
 	xlFile, err := xls.Open(filePath, "utf-8")
 	if err != nil {
 		return nil, fmt.Errorf("error opening xls file: %w", err)
 	}
 
 	sheet := xlFile.GetSheet(0)
+	if sheetName != "" {
+		sheet = nil
+		for i := 0; i < xlFile.NumSheets(); i++ {
+			if s := xlFile.GetSheet(i); s != nil && s.Name == sheetName {
+				sheet = s
+				break
+			}
+		}
+		if sheet == nil {
+			return nil, fmt.Errorf("sheet %q not found in xls file", sheetName)
+		}
+	}
 	if sheet == nil {
 		return nil, fmt.Errorf("no sheets found in xls file")
 	}
@@ -140,20 +132,16 @@ func readXLSFile(filePath string) ([][]string, error) {
 }
 
 // parseExcelByBank parses Excel using bank-specific configuration
-func parseExcelByBank(rows [][]string, bankID string) []ParsedTransaction {
-	config, exists := BankConfigs[bankID]
-	if !exists {
-		return detectAndParseExcel(rows)
-	}
-
+func parseExcelByBank(rows [][]string, config BankConfig) []ParsedTransaction {
 	var transactions []ParsedTransaction
 
 	// Start from the row after header
 	startRow := config.HeaderRow + 1
 
 	for i := startRow; i < len(rows); i++ {
-		tx := ParseRowByBank(rows[i], bankID)
+		tx := ParseRowByBank(rows[i], config)
 		if tx != nil {
+			tx.Row = i + 1
 			transactions = append(transactions, *tx)
 		}
 	}
@@ -161,144 +149,39 @@ func parseExcelByBank(rows [][]string, bankID string) []ParsedTransaction {
 	return transactions
 }
 
-func detectAndParseExcel(rows [][]string) []ParsedTransaction {
-	if len(rows) < 2 {
-		return nil
-	}
-
-	// Try to detect column positions from header
-	header := rows[0]
-	dateCol, descCol, amountCol, typeCol := -1, -1, -1, -1
-
-	for i, col := range header {
-		colLower := strings.ToLower(col)
-		if containsAny(colLower, []string{"fecha", "date", "dia"}) {
-			dateCol = i
-		} else if containsAny(colLower, []string{"descripcion", "description", "concepto", "detalle"}) {
-			descCol = i
-		} else if containsAny(colLower, []string{"monto", "amount", "importe", "valor", "cargo", "abono"}) {
-			amountCol = i
-		} else if containsAny(colLower, []string{"tipo", "type", "movimiento"}) {
-			typeCol = i
-		}
-	}
-
-	// Default positions if not found
-	if dateCol == -1 {
-		dateCol = 0
-	}
-	if descCol == -1 {
-		descCol = 1
-	}
-	if amountCol == -1 {
-		amountCol = 2
-	}
-
-	var transactions []ParsedTransaction
-
-	for i := 1; i < len(rows); i++ {
-		row := rows[i]
-		if len(row) <= amountCol {
-			continue
-		}
-
-		tx := ParsedTransaction{
-			Date:        parseDate(safeGet(row, dateCol)),
-			Description: safeGet(row, descCol),
-			RawText:     strings.Join(row, " | "),
-		}
-
-		// Parse amount
-		amountStr := safeGet(row, amountCol)
-		tx.Amount, tx.Type = parseAmount(amountStr)
-
-		// Override type if column exists
-		if typeCol != -1 && len(row) > typeCol {
-			typeStr := strings.ToLower(safeGet(row, typeCol))
-			if containsAny(typeStr, []string{"ingreso", "income", "abono", "deposito", "credito"}) {
-				tx.Type = "income"
-			} else if containsAny(typeStr, []string{"gasto", "expense", "cargo", "retiro", "debito"}) {
-				tx.Type = "expense"
-			}
-		}
-
-		if tx.Description != "" && tx.Amount != 0 {
-			transactions = append(transactions, tx)
-		}
+// ProcessImageFile runs OCR on a statement image or mobile-banking screenshot
+// and parses the recognized text into transactions
+func ProcessImageFile(filePath string, userID int) ([]ParsedTransaction, int, error) {
+	transactions, err := ParseImageFile(filePath)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return transactions
-}
-
-// ProcessImageFile parses text from an image (simplified without Tesseract)
-func ProcessImageFile(filePath string, userID int) ([]ParsedTransaction, int, error) {
 	var importID int
-	err := database.DB.QueryRow(
-		`INSERT INTO imports (user_id, filename, file_type, status)
-		 VALUES ($1, $2, 'image', 'completed') RETURNING id`,
-		userID, filePath,
+	err = database.DB.QueryRow(
+		`INSERT INTO imports (user_id, filename, file_type, status, total_transactions)
+		 VALUES ($1, $2, 'image', 'completed', $3) RETURNING id`,
+		userID, filePath, len(transactions),
 	).Scan(&importID)
 	if err != nil {
 		return nil, 0, fmt.Errorf("error creating import record: %w", err)
 	}
 
-	return nil, importID, fmt.Errorf("OCR no disponible. Instala Tesseract: sudo apt-get install tesseract-ocr libtesseract-dev")
+	return transactions, importID, nil
 }
 
-func parseOCRText(text string) []ParsedTransaction {
-	var transactions []ParsedTransaction
-	lines := strings.Split(text, "\n")
-
-	datePattern := regexp.MustCompile(`(\d{1,2}[/-]\d{1,2}[/-]\d{2,4})`)
-	amountPattern := regexp.MustCompile(`\$?\s*([\d,]+\.?\d*)`)
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if len(line) < 10 {
-			continue
-		}
-
-		dateMatch := datePattern.FindString(line)
-		if dateMatch == "" {
-			continue
-		}
-
-		amounts := amountPattern.FindAllStringSubmatch(line, -1)
-		if len(amounts) == 0 {
-			continue
-		}
-
-		lastAmount := amounts[len(amounts)-1][1]
-		amount, txType := parseAmount(lastAmount)
-
-		if amount == 0 {
-			continue
-		}
-
-		desc := line
-		desc = datePattern.ReplaceAllString(desc, "")
-		desc = amountPattern.ReplaceAllString(desc, "")
-		desc = strings.TrimSpace(desc)
-
-		if len(desc) < 3 {
-			desc = "Transacción bancaria"
-		}
-
-		transactions = append(transactions, ParsedTransaction{
-			Date:        parseDate(dateMatch),
-			Description: desc,
-			Amount:      amount,
-			Type:        txType,
-			RawText:     line,
-		})
+// ParseImageFile runs OCR on an image and parses it without creating an import
+func ParseImageFile(filePath string) ([]ParsedTransaction, error) {
+	text, err := DefaultOCR.ExtractText(filePath)
+	if err != nil {
+		return nil, err
 	}
-
-	return transactions
+	return parseOCRText(text), nil
 }
 
 // Helper functions
 func safeGet(slice []string, index int) string {
-	if index < len(slice) {
+	if index >= 0 && index < len(slice) {
 		return strings.TrimSpace(slice[index])
 	}
 	return ""
@@ -313,38 +196,27 @@ func containsAny(s string, substrs []string) bool {
 	return false
 }
 
+// parseDate parses a single date on its own, reading ambiguous numeric dates
+// day first. Returns "" when the value isn't a date.
 func parseDate(dateStr string) string {
 	dateStr = strings.TrimSpace(dateStr)
 	if dateStr == "" {
-		return time.Now().Format("2006-01-02")
+		return ""
 	}
 
-	formats := []string{
-		"2006-01-02",
-		"02/01/2006",
-		"01/02/2006",
-		"02-01-2006",
-		"01-02-2006",
-		"2/1/2006",
-		"1/2/2006",
-		"02/01/06",
-		"01/02/06",
+	order := DetectDateOrder([]string{dateStr})
+	if order == "" {
+		order = DateOrderDMY
 	}
-
-	for _, format := range formats {
-		if t, err := time.Parse(format, dateStr); err == nil {
-			return t.Format("2006-01-02")
-		}
-	}
-
-	return time.Now().Format("2006-01-02")
+	return parseDateInOrder(dateStr, order)
 }
 
+// parseAmount parses a signed amount, guessing the decimal separator from the value
 func parseAmount(amountStr string) (float64, string) {
 	amountStr = strings.TrimSpace(amountStr)
 	amountStr = strings.ReplaceAll(amountStr, "$", "")
-	amountStr = strings.ReplaceAll(amountStr, ",", "")
 	amountStr = strings.ReplaceAll(amountStr, " ", "")
+	amountStr = normalizeNumber(amountStr, NumberFormatAuto)
 
 	isNegative := strings.HasPrefix(amountStr, "-") || strings.HasPrefix(amountStr, "(")
 	amountStr = strings.Trim(amountStr, "-()")
@@ -0,0 +1,355 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/warren/finance-app/internal/database"
+)
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+const (
+	// jobStaleAfter is how long a running job may go without a heartbeat
+	// before it is considered abandoned (e.g. the server restarted) and requeued
+	jobStaleAfter = 10 * time.Minute
+	// jobHeartbeatInterval is how often a running job is marked alive, so long
+	// stages that don't report progress (OCR of a scanned PDF) aren't requeued
+	jobHeartbeatInterval = time.Minute
+	// jobMaxAttempts limits how many times an abandoned job is retried
+	jobMaxAttempts = 3
+	// jobPollInterval is how often idle workers look for queued jobs
+	jobPollInterval = 2 * time.Second
+	// jobWaitTimeout limits how long RunJobInline waits for a job a worker took
+	jobWaitTimeout = 5 * time.Minute
+)
+
+var (
+	// ErrJobNotFound is returned when a job doesn't exist or belongs to another user
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobStillRunning is returned when RunJobInline gives up waiting for a
+	// job that keeps running in the background
+	ErrJobStillRunning = errors.New("job still running")
+)
+
+// Job is a unit of background work stored in the jobs table. Handlers report
+// progress through SetProgress so clients can follow it while it runs.
+type Job struct {
+	ID         int             `json:"id"`
+	UserID     int             `json:"user_id"`
+	Kind       string          `json:"kind"`
+	Status     string          `json:"status"` // queued, running, completed, failed
+	Stage      string          `json:"stage"`  // Handler-defined step, e.g. parsing, matching, staging
+	Progress   int             `json:"progress"`
+	Total      int             `json:"total"`
+	ImportID   *int            `json:"import_id,omitempty"`
+	RowErrors  []RowError      `json:"row_errors"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      *string         `json:"error,omitempty"`
+	Attempts   int             `json:"attempts"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+
+	Params json.RawMessage `json:"-"`
+}
+
+// RowError is a statement row that couldn't be parsed
+type RowError struct {
+	Row   int    `json:"row"`
+	Sheet string `json:"sheet,omitempty"`
+	Error string `json:"error"`
+}
+
+// Done reports whether the job has finished, successfully or not
+func (j *Job) Done() bool {
+	return j.Status == JobCompleted || j.Status == JobFailed
+}
+
+// JobHandler runs a job and returns its result, stored as JSON
+type JobHandler func(job *Job) (interface{}, error)
+
+var (
+	jobHandlers   = map[string]JobHandler{}
+	jobHandlersMu sync.RWMutex
+	jobWake       = make(chan struct{}, 1)
+)
+
+// RegisterJobHandler sets the function that runs jobs of the given kind
+func RegisterJobHandler(kind string, handler JobHandler) {
+	jobHandlersMu.Lock()
+	defer jobHandlersMu.Unlock()
+	jobHandlers[kind] = handler
+}
+
+const jobColumns = `id, user_id, kind, status, stage, progress, total, import_id, row_errors, result, error,
+	attempts, created_at, started_at, finished_at, params`
+
+func scanJob(row rowScanner) (*Job, error) {
+	var job Job
+	var rowErrors, result, params []byte
+
+	err := row.Scan(&job.ID, &job.UserID, &job.Kind, &job.Status, &job.Stage, &job.Progress, &job.Total,
+		&job.ImportID, &rowErrors, &result, &job.Error, &job.Attempts, &job.CreatedAt, &job.StartedAt,
+		&job.FinishedAt, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rowErrors) > 0 {
+		json.Unmarshal(rowErrors, &job.RowErrors)
+	}
+	if job.RowErrors == nil {
+		job.RowErrors = []RowError{}
+	}
+	if len(result) > 0 {
+		job.Result = result
+	}
+	job.Params = params
+	return &job, nil
+}
+
+// EnqueueJob stores a new job for the runner. wake signals an idle worker to
+// take it now; it is false for jobs the caller runs with RunJobInline.
+func EnqueueJob(userID int, kind string, params interface{}, wake bool) (*Job, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding job params: %w", err)
+	}
+
+	job, err := scanJob(database.DB.QueryRow(`
+		INSERT INTO jobs (user_id, kind, params) VALUES ($1, $2, $3)
+		RETURNING `+jobColumns, userID, kind, string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error creating job: %w", err)
+	}
+
+	if wake {
+		select {
+		case jobWake <- struct{}{}:
+		default:
+		}
+	}
+	return job, nil
+}
+
+// GetJob returns one of the user's jobs
+func GetJob(userID int, id int) (*Job, error) {
+	job, err := scanJob(database.DB.QueryRow(
+		`SELECT `+jobColumns+` FROM jobs WHERE id = $1 AND user_id = $2`, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	return job, err
+}
+
+// SetProgress records the job's current stage and how far it got
+func (j *Job) SetProgress(stage string, progress int, total int) error {
+	j.Stage, j.Progress, j.Total = stage, progress, total
+	_, err := database.DB.Exec(
+		`UPDATE jobs SET stage = $1, progress = $2, total = $3, updated_at = NOW() WHERE id = $4`,
+		stage, progress, total, j.ID)
+	return err
+}
+
+// SetImport links the job to the import it created
+func (j *Job) SetImport(importID int) error {
+	j.ImportID = &importID
+	_, err := database.DB.Exec(`UPDATE jobs SET import_id = $1, updated_at = NOW() WHERE id = $2`, importID, j.ID)
+	return err
+}
+
+// SetRowErrors records the rows that couldn't be parsed
+func (j *Job) SetRowErrors(rowErrors []RowError) error {
+	j.RowErrors = rowErrors
+	data, err := json.Marshal(rowErrors)
+	if err != nil {
+		return err
+	}
+	_, err = database.DB.Exec(`UPDATE jobs SET row_errors = $1, updated_at = NOW() WHERE id = $2`, string(data), j.ID)
+	return err
+}
+
+// RunJobInline runs a queued job in the calling goroutine, for callers that
+// want the result right away, and returns it reloaded. If a worker took the
+// job first it waits for it until ctx is done, or returns the job with
+// ErrJobStillRunning after jobWaitTimeout.
+func RunJobInline(ctx context.Context, job *Job) (*Job, error) {
+	claimed, err := scanJob(database.DB.QueryRow(`
+		UPDATE jobs SET status = 'running', attempts = attempts + 1, started_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = 'queued'
+		RETURNING `+jobColumns, job.ID))
+	if err == sql.ErrNoRows {
+		// A worker got to it first
+		return waitForJob(ctx, job.UserID, job.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	runJob(claimed)
+	return GetJob(job.UserID, job.ID)
+}
+
+func waitForJob(ctx context.Context, userID int, id int) (*Job, error) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.NewTimer(jobWaitTimeout)
+	defer deadline.Stop()
+
+	for {
+		job, err := GetJob(userID, id)
+		if err != nil || job.Done() {
+			return job, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-deadline.C:
+			return job, ErrJobStillRunning
+		case <-ticker.C:
+		}
+	}
+}
+
+// StartJobRunner starts the background workers. They stop when ctx is cancelled.
+func StartJobRunner(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go jobWorker(ctx)
+	}
+}
+
+func jobWorker(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := claimJob()
+		if err != nil {
+			log.Printf("jobs: error claiming job: %v", err)
+		}
+		if job != nil {
+			runJob(job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-jobWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimJob takes the oldest queued job, requeueing abandoned ones first.
+// SKIP LOCKED lets several workers (or API instances) share the table.
+func claimJob() (*Job, error) {
+	_, err := database.DB.Exec(`
+		UPDATE jobs
+		SET status = CASE WHEN attempts >= $1 THEN 'failed' ELSE 'queued' END,
+		    error = CASE WHEN attempts >= $1 THEN 'Job abandoned too many times' ELSE error END,
+		    finished_at = CASE WHEN attempts >= $1 THEN NOW() ELSE finished_at END,
+		    updated_at = NOW()
+		WHERE status = 'running' AND updated_at < NOW() - $2 * INTERVAL '1 second'`,
+		jobMaxAttempts, int(jobStaleAfter.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := scanJob(database.DB.QueryRow(`
+		UPDATE jobs SET status = 'running', attempts = attempts + 1, started_at = NOW(), updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs WHERE status = 'queued' ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + jobColumns))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+// runJob runs a claimed job and stores its outcome. A panicking handler fails the job.
+// The outcome is only stored while the job still belongs to this attempt.
+func runJob(job *Job) {
+	jobHandlersMu.RLock()
+	handler, ok := jobHandlers[job.Kind]
+	jobHandlersMu.RUnlock()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go heartbeatJob(job, stop)
+
+	var result interface{}
+	var err error
+	if !ok {
+		err = fmt.Errorf("unknown job kind %q", job.Kind)
+	} else {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("job panicked: %v", r)
+				}
+			}()
+			result, err = handler(job)
+		}()
+	}
+
+	if err != nil {
+		_, dbErr := database.DB.Exec(`
+			UPDATE jobs SET status = 'failed', error = $1, finished_at = NOW(), updated_at = NOW()
+			WHERE id = $2 AND status = 'running' AND attempts = $3`,
+			err.Error(), job.ID, job.Attempts)
+		if dbErr != nil {
+			log.Printf("jobs: error saving failure of job %d: %v", job.ID, dbErr)
+		}
+		return
+	}
+
+	// JSONB parameters go as text; lib/pq would send []byte in binary format
+	var data sql.NullString
+	if encoded, err := json.Marshal(result); err != nil {
+		log.Printf("jobs: error encoding result of job %d: %v", job.ID, err)
+	} else {
+		data = sql.NullString{String: string(encoded), Valid: true}
+	}
+	_, dbErr := database.DB.Exec(`
+		UPDATE jobs SET status = 'completed', result = $1, finished_at = NOW(), updated_at = NOW()
+		WHERE id = $2 AND status = 'running' AND attempts = $3`,
+		data, job.ID, job.Attempts)
+	if dbErr != nil {
+		log.Printf("jobs: error saving result of job %d: %v", job.ID, dbErr)
+	}
+}
+
+// heartbeatJob keeps a running job from being taken as abandoned until stop is closed
+func heartbeatJob(job *Job, stop <-chan struct{}) {
+	ticker := time.NewTicker(jobHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			_, err := database.DB.Exec(`UPDATE jobs SET updated_at = NOW() WHERE id = $1 AND status = 'running' AND attempts = $2`,
+				job.ID, job.Attempts)
+			if err != nil {
+				log.Printf("jobs: error updating heartbeat of job %d: %v", job.ID, err)
+			}
+		}
+	}
+}
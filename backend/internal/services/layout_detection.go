@@ -0,0 +1,280 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// layoutDetectionRows is how many rows are scanned for the header and for column contents
+const layoutDetectionRows = 30
+
+// Header keywords, checked in this order so "Fecha valor" is a date and not an amount
+var (
+	dateHeaderKeywords        = []string{"fecha", "date", "dia", "día"}
+	descriptionHeaderKeywords = []string{"descripcion", "descripción", "description", "concepto", "detalle", "glosa"}
+	balanceHeaderKeywords     = []string{"saldo", "balance"}
+	debitHeaderKeywords       = []string{"cargo", "debito", "débito", "debe", "retiro", "debit"}
+	creditHeaderKeywords      = []string{"abono", "credito", "crédito", "haber", "deposito", "depósito", "credit"}
+	amountHeaderKeywords      = []string{"monto", "amount", "importe", "valor"}
+	typeHeaderKeywords        = []string{"tipo", "type", "movimiento"}
+)
+
+// headerMatch holds the column index of each recognized header, -1 when absent
+type headerMatch struct {
+	date, description, amount, debit, credit, balance, txType int
+}
+
+var (
+	dateCellPattern   = regexp.MustCompile(`^\d{1,4}[/.-]\d{1,2}[/.-]\d{2,4}(\s|$)`)
+	amountCellPattern = regexp.MustCompile(`^\(?-?\s*(?:S/|US\$|\$|PEN|USD)?\s*-?\d[\d.,]*\)?-?$`)
+)
+
+// SpreadsheetPreview is the raw view of a sheet returned before parsing,
+// together with the layout that would be used to parse it
+type SpreadsheetPreview struct {
+	Sheets       []string            `json:"sheets"` // Workbook sheet names (empty for CSV)
+	Sheet        string              `json:"sheet"`  // Previewed sheet
+	Rows         [][]string          `json:"rows"`
+	TotalRows    int                 `json:"total_rows"`
+	Mapping      BankConfig          `json:"mapping"`
+	Detected     bool                `json:"detected"` // Mapping was guessed rather than taken from a layout
+	Transactions []ParsedTransaction `json:"transactions"`
+}
+
+// PreviewSpreadsheet returns the first rows of a spreadsheet and the mapping
+// the import would use: the given layout, or the detected one for the generic
+// layout, with its date and number formats resolved for the file.
+// Transactions holds the first rows parsed with that mapping. sheet "" previews the first sheet.
+func PreviewSpreadsheet(filePath string, sheet string, config BankConfig, limit int) (*SpreadsheetPreview, error) {
+	sheets, err := ListSheets(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if sheet == "" && len(sheets) > 0 {
+		sheet = sheets[0]
+	}
+
+	rows, err := ReadSheetRows(filePath, sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &SpreadsheetPreview{
+		Sheets:    sheets,
+		Sheet:     sheet,
+		TotalRows: len(rows),
+		Detected:  config.ID == "generic",
+	}
+	if preview.Sheets == nil {
+		preview.Sheets = []string{}
+	}
+	config = prepareLayout(rows, config)
+	preview.Mapping = config
+
+	preview.Rows = rows
+	if len(rows) > limit {
+		preview.Rows = rows[:limit]
+	}
+
+	preview.Transactions = parseExcelByBank(rows, config)
+	checkRunningBalance(preview.Transactions, config.SignConvention == SignInverted)
+	if len(preview.Transactions) > limit {
+		preview.Transactions = preview.Transactions[:limit]
+	}
+	if preview.Transactions == nil {
+		preview.Transactions = []ParsedTransaction{}
+	}
+
+	return preview, nil
+}
+
+// DetectLayout guesses the header row and the date, description, amount (or
+// separate debit/credit), balance and type columns of an unknown spreadsheet.
+// Banner rows above the header (bank name, account number, period) are skipped.
+// Columns the header doesn't name are guessed from the cell contents;
+// HeaderRow is -1 when no header is found.
+func DetectLayout(rows [][]string) BankConfig {
+	config := BankConfigs["generic"]
+	config.ID = ""
+	config.Name = "Detectado"
+	config.HeaderRow = -1
+
+	cols := headerMatch{-1, -1, -1, -1, -1, -1, -1}
+	if header := findHeaderRow(rows); header >= 0 {
+		config.HeaderRow = header
+		cols = headerColumns(rows[header])
+	}
+
+	// "Cargo" and "Abono" as separate columns; a lone one is really the amount column
+	if cols.debit != -1 && cols.credit != -1 {
+		config.AmountMode = AmountModeDebitCredit
+	} else if cols.amount == -1 {
+		cols.amount = cols.debit
+		if cols.amount == -1 {
+			cols.amount = cols.credit
+		}
+		cols.debit, cols.credit = -1, -1
+	}
+
+	// Fill the gaps from the data below the header
+	start := config.HeaderRow + 1
+	end := start + layoutDetectionRows
+	if end > len(rows) {
+		end = len(rows)
+	}
+	var sample [][]string
+	if start < end {
+		sample = rows[start:end]
+	}
+
+	used := map[int]bool{}
+	for _, col := range []int{cols.date, cols.description, cols.amount, cols.debit, cols.credit, cols.balance, cols.txType} {
+		used[col] = true
+	}
+	pick := func(col int, score func(cell string) int) int {
+		if col != -1 {
+			return col
+		}
+		col = bestColumn(sample, used, score)
+		used[col] = true
+		return col
+	}
+
+	cols.date = pick(cols.date, func(cell string) int {
+		if dateCellPattern.MatchString(cell) {
+			return 1
+		}
+		return 0
+	})
+	if config.AmountMode == AmountModeSingle {
+		cols.amount = pick(cols.amount, func(cell string) int {
+			if amountCellPattern.MatchString(cell) {
+				return 1
+			}
+			return 0
+		})
+	}
+	cols.description = pick(cols.description, func(cell string) int {
+		// Longer free text is more likely the description than a reference or a type label
+		if dateCellPattern.MatchString(cell) || amountCellPattern.MatchString(cell) {
+			return 0
+		}
+		return countLetters(cell)
+	})
+
+	// Nothing usable in the sheet: keep the historical 0/1/2 defaults
+	if cols.date == -1 {
+		cols.date = 0
+	}
+	if cols.description == -1 {
+		cols.description = 1
+	}
+	if config.AmountMode == AmountModeSingle && cols.amount == -1 {
+		cols.amount = 2
+	}
+
+	config.DateCol = cols.date
+	config.DescriptionCol = cols.description
+	config.AmountCol = cols.amount
+	config.DebitCol = cols.debit
+	config.CreditCol = cols.credit
+	config.BalanceCol = cols.balance
+	config.TypeCol = cols.txType
+	return config
+}
+
+// findHeaderRow returns the first row, among the leading rows, that names the most
+// column kinds (at least two), or -1 when none looks like a header
+func findHeaderRow(rows [][]string) int {
+	best, bestScore := -1, 1
+	for i := 0; i < len(rows) && i < layoutDetectionRows; i++ {
+		cols := headerColumns(rows[i])
+		score := 0
+		for _, col := range []int{cols.date, cols.description, cols.amount, cols.debit, cols.credit, cols.balance, cols.txType} {
+			if col != -1 {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// headerColumns maps header cells to columns. The first matching cell wins;
+// long cells are banner text rather than headers.
+func headerColumns(header []string) headerMatch {
+	cols := headerMatch{-1, -1, -1, -1, -1, -1, -1}
+	set := func(col *int, i int) {
+		if *col == -1 {
+			*col = i
+		}
+	}
+
+	for i, col := range header {
+		colLower := strings.ToLower(strings.TrimSpace(col))
+		if colLower == "" || len(colLower) > 40 {
+			continue
+		}
+
+		switch {
+		case containsAny(colLower, dateHeaderKeywords):
+			set(&cols.date, i)
+		case containsAny(colLower, descriptionHeaderKeywords):
+			set(&cols.description, i)
+		case containsAny(colLower, balanceHeaderKeywords):
+			set(&cols.balance, i)
+		case containsAny(colLower, debitHeaderKeywords):
+			set(&cols.debit, i)
+		case containsAny(colLower, creditHeaderKeywords):
+			set(&cols.credit, i)
+		case containsAny(colLower, amountHeaderKeywords):
+			set(&cols.amount, i)
+		case containsAny(colLower, typeHeaderKeywords):
+			set(&cols.txType, i)
+		}
+	}
+
+	return cols
+}
+
+// bestColumn returns the unused column with the highest total score, or -1.
+// Ties go to the leftmost column.
+func bestColumn(rows [][]string, used map[int]bool, score func(cell string) int) int {
+	totals := map[int]int{}
+	width := 0
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+		for i, cell := range row {
+			cell = strings.TrimSpace(cell)
+			if cell != "" {
+				totals[i] += score(cell)
+			}
+		}
+	}
+
+	best, bestScore := -1, 0
+	for i := 0; i < width; i++ {
+		if used[i] {
+			continue
+		}
+		if totals[i] > bestScore {
+			best, bestScore = i, totals[i]
+		}
+	}
+	return best
+}
+
+func countLetters(s string) int {
+	n := 0
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number formats for amount columns
+const (
+	NumberFormatAuto         = "auto"
+	NumberFormatDecimalPoint = "decimal_point" // 1,234.56
+	NumberFormatDecimalComma = "decimal_comma" // 1.234,56
+)
+
+// Day/month orders for numeric dates. A layout's DateFormat is "auto", one of
+// these orders, or an explicit pattern like "dd/mm/yyyy".
+const (
+	DateOrderDMY = "dmy"
+	DateOrderMDY = "mdy"
+	DateOrderYMD = "ymd"
+)
+
+// The digits of an amount with its separators, without sign or currency
+var amountDigitsPattern = regexp.MustCompile(`\d[\d.,]*`)
+
+// Date parts separated by / - . or spaces; the middle part may be a month name ("16-Dic-2025")
+var datePartsPattern = regexp.MustCompile(`^(\d{1,4})[/.\-\s]+(\d{1,2}|[A-Za-zÁÉÍÓÚáéíóú]{3,10})\.?[/.\-\s]+(\d{1,4})(?:[T\s]|$)`)
+
+// ValidateDateFormat checks a layout or per-import date format
+func ValidateDateFormat(format string) error {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "auto", DateOrderDMY, DateOrderMDY, DateOrderYMD:
+		return nil
+	}
+	if !strings.Contains(format, "dd") || !strings.Contains(format, "mm") || !strings.Contains(format, "yy") {
+		return fmt.Errorf("date_format must be 'auto', 'dmy', 'mdy', 'ymd' or a pattern like 'dd/mm/yyyy'")
+	}
+	return nil
+}
+
+// ValidateNumberFormat checks a layout or per-import number format
+func ValidateNumberFormat(format string) error {
+	switch format {
+	case "", NumberFormatAuto, NumberFormatDecimalPoint, NumberFormatDecimalComma:
+		return nil
+	}
+	return fmt.Errorf("number_format must be 'auto', 'decimal_point' or 'decimal_comma'")
+}
+
+// DetectDateOrder decides the day/month order for a whole column of dates.
+// A single "25/12/2025" settles it for every row, so "03/04/2025" in the same
+// file is read consistently. Returns "" when no value disambiguates the order.
+func DetectDateOrder(values []string) string {
+	votes := map[string]int{}
+	for _, value := range values {
+		parts := datePartsPattern.FindStringSubmatch(strings.TrimSpace(value))
+		if parts == nil {
+			continue
+		}
+		first, _ := strconv.Atoi(parts[1])
+		second, err := strconv.Atoi(parts[2])
+
+		switch {
+		case len(parts[1]) == 4:
+			votes[DateOrderYMD]++
+		case err != nil:
+			// Month name in the middle
+			votes[DateOrderDMY]++
+		case first > 12 && second <= 12:
+			votes[DateOrderDMY]++
+		case second > 12 && first <= 12:
+			votes[DateOrderMDY]++
+		}
+	}
+
+	best, bestVotes := "", 0
+	for _, order := range []string{DateOrderDMY, DateOrderMDY, DateOrderYMD} {
+		if votes[order] > bestVotes {
+			best, bestVotes = order, votes[order]
+		}
+	}
+	return best
+}
+
+// parseDateInOrder parses a numeric date with the given day/month order and
+// returns YYYY-MM-DD, or "" when the value isn't a valid date. Years first
+// ("2025-12-16") are always read as year-month-day.
+func parseDateInOrder(dateStr string, order string) string {
+	parts := datePartsPattern.FindStringSubmatch(strings.TrimSpace(dateStr))
+	if parts == nil {
+		return ""
+	}
+
+	a, b, c := parts[1], parts[2], parts[3]
+	if len(a) == 4 {
+		order = DateOrderYMD
+	}
+
+	var dayStr, yearStr string
+	var month int
+	switch order {
+	case DateOrderYMD:
+		yearStr, dayStr = a, c
+		month = parseMonth(b)
+	case DateOrderMDY:
+		month, dayStr, yearStr = parseMonth(a), b, c
+	default:
+		dayStr, yearStr = a, c
+		month = parseMonth(b)
+	}
+
+	day, _ := strconv.Atoi(dayStr)
+	year, _ := strconv.Atoi(yearStr)
+	if len(yearStr) <= 2 {
+		year += 2000
+	}
+
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	// time.Date normalizes 31/02 into March; reject it instead
+	if month < 1 || month > 12 || day < 1 || t.Day() != day || t.Month() != time.Month(month) {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// parseMonth reads a numeric or Spanish/English month name, 0 when unknown
+func parseMonth(s string) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	name := strings.ToLower(s)
+	if month, ok := spanishMonths[name]; ok {
+		return month
+	}
+	if len(name) >= 3 {
+		if t, err := time.Parse("Jan", strings.ToUpper(name[:1])+name[1:3]); err == nil {
+			return int(t.Month())
+		}
+	}
+	return 0
+}
+
+// DetectNumberFormat decides whether a column uses a decimal point or a
+// decimal comma. Returns "" when no value disambiguates it ("1.234" alone could be either).
+func DetectNumberFormat(values []string) string {
+	votes := map[string]int{}
+	for _, value := range values {
+		if format := numberFormatHint(value); format != "" {
+			votes[format]++
+		}
+	}
+
+	switch {
+	case votes[NumberFormatDecimalComma] > votes[NumberFormatDecimalPoint]:
+		return NumberFormatDecimalComma
+	case votes[NumberFormatDecimalPoint] > 0:
+		return NumberFormatDecimalPoint
+	}
+	return ""
+}
+
+// numberFormatHint guesses the format of a single amount, "" when ambiguous
+func numberFormatHint(value string) string {
+	// Currency markers like "S/." carry dots of their own
+	value = strings.TrimRight(amountDigitsPattern.FindString(value), ".,")
+	lastDot := strings.LastIndex(value, ".")
+	lastComma := strings.LastIndex(value, ",")
+	dots := strings.Count(value, ".")
+	commas := strings.Count(value, ",")
+
+	switch {
+	case dots > 0 && commas > 0:
+		// The last separator is the decimal one
+		if lastComma > lastDot {
+			return NumberFormatDecimalComma
+		}
+		return NumberFormatDecimalPoint
+	case dots > 1:
+		return NumberFormatDecimalComma
+	case commas > 1:
+		return NumberFormatDecimalPoint
+	case commas == 1 && digitsAfter(value, lastComma) != 3:
+		return NumberFormatDecimalComma
+	case dots == 1 && digitsAfter(value, lastDot) != 3:
+		return NumberFormatDecimalPoint
+	}
+	return ""
+}
+
+func digitsAfter(value string, idx int) int {
+	n := 0
+	for _, r := range value[idx+1:] {
+		if r < '0' || r > '9' {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// normalizeNumber rewrites an amount so the decimal separator is "." and
+// thousands separators are gone. NumberFormatAuto guesses from the value itself
+// and treats ambiguous values as decimal point.
+func normalizeNumber(value string, format string) string {
+	if format == "" || format == NumberFormatAuto {
+		format = numberFormatHint(value)
+	}
+
+	loc := amountDigitsPattern.FindStringIndex(value)
+	if loc == nil {
+		return value
+	}
+
+	digits := value[loc[0]:loc[1]]
+	if format == NumberFormatDecimalComma {
+		digits = strings.ReplaceAll(digits, ".", "")
+		digits = strings.ReplaceAll(digits, ",", ".")
+	} else {
+		digits = strings.ReplaceAll(digits, ",", "")
+	}
+	return value[:loc[0]] + digits + value[loc[1]:]
+}
+
+// resolveLayoutFormats fixes "auto" date and number formats for a whole file,
+// so every row is read the same way
+func resolveLayoutFormats(rows [][]string, config BankConfig) BankConfig {
+	start := config.HeaderRow + 1
+	if start < 0 {
+		start = 0
+	}
+
+	if f := strings.ToLower(config.DateFormat); f == "" || f == "auto" {
+		var dates []string
+		for i := start; i < len(rows); i++ {
+			dates = append(dates, safeGet(rows[i], config.DateCol))
+		}
+		config.DateFormat = DetectDateOrder(dates)
+		if config.DateFormat == "" {
+			// Nothing disambiguates: Peruvian banks use day first
+			config.DateFormat = DateOrderDMY
+		}
+	}
+
+	if config.NumberFormat == "" || config.NumberFormat == NumberFormatAuto {
+		var amounts []string
+		for i := start; i < len(rows); i++ {
+			for _, col := range []int{config.AmountCol, config.DebitCol, config.CreditCol, config.BalanceCol} {
+				amounts = append(amounts, safeGet(rows[i], col))
+			}
+		}
+		config.NumberFormat = DetectNumberFormat(amounts)
+		if config.NumberFormat == "" {
+			config.NumberFormat = NumberFormatDecimalPoint
+		}
+	}
+
+	return config
+}
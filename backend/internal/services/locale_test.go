@@ -0,0 +1,125 @@
+package services
+
+import "testing"
+
+func TestDetectDateOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   string
+	}{
+		{"day over twelve settles day first", []string{"03/04/2025", "25/12/2025"}, DateOrderDMY},
+		{"day over twelve in the middle", []string{"03/04/2025", "12/25/2025"}, DateOrderMDY},
+		{"year first", []string{"2025-03-04", "2025-12-25"}, DateOrderYMD},
+		{"month name in the middle", []string{"16-Dic-2025"}, DateOrderDMY},
+		{"ambiguous", []string{"03/04/2025", "05/06/2025"}, ""},
+		{"not dates", []string{"Fecha", ""}, ""},
+		{"majority wins", []string{"25/12/2025", "13/01/2025", "01/13/2025"}, DateOrderDMY},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectDateOrder(tt.values); got != tt.want {
+				t.Errorf("DetectDateOrder(%q) = %q, want %q", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDateInOrder(t *testing.T) {
+	tests := []struct {
+		value string
+		order string
+		want  string
+	}{
+		{"03/04/2025", DateOrderDMY, "2025-04-03"},
+		{"03/04/2025", DateOrderMDY, "2025-03-04"},
+		{"2025-04-03", DateOrderDMY, "2025-04-03"},
+		{"3.4.25", DateOrderDMY, "2025-04-03"},
+		{"16-Dic-2025", DateOrderDMY, "2025-12-16"},
+		{"16 Dec 2025", DateOrderDMY, "2025-12-16"},
+		{"16/12/2025 10:15", DateOrderDMY, "2025-12-16"},
+		{"31/02/2025", DateOrderDMY, ""},
+		{"12/25/2025", DateOrderDMY, ""},
+		{"Fecha", DateOrderDMY, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value+" "+tt.order, func(t *testing.T) {
+			if got := parseDateInOrder(tt.value, tt.order); got != tt.want {
+				t.Errorf("parseDateInOrder(%q, %q) = %q, want %q", tt.value, tt.order, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectNumberFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   string
+	}{
+		{"decimal point with thousands", []string{"1,234.56"}, NumberFormatDecimalPoint},
+		{"decimal comma with thousands", []string{"1.234,56"}, NumberFormatDecimalComma},
+		{"decimal comma only", []string{"-12,5"}, NumberFormatDecimalComma},
+		{"repeated dots are thousands", []string{"1.234.567"}, NumberFormatDecimalComma},
+		{"currency marker dots are ignored", []string{"S/. 12,50"}, NumberFormatDecimalComma},
+		{"three decimals are ambiguous", []string{"1.234", "1,234"}, ""},
+		{"ambiguous values don't vote", []string{"1.234", "12.50"}, NumberFormatDecimalPoint},
+		{"majority wins", []string{"12,50", "3,90", "1.50"}, NumberFormatDecimalComma},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectNumberFormat(tt.values); got != tt.want {
+				t.Errorf("DetectNumberFormat(%q) = %q, want %q", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeNumber(t *testing.T) {
+	tests := []struct {
+		value  string
+		format string
+		want   string
+	}{
+		{"1,234.56", NumberFormatDecimalPoint, "1234.56"},
+		{"1.234,56", NumberFormatDecimalComma, "1234.56"},
+		{"-S/ 1.234,56", NumberFormatAuto, "-S/ 1234.56"},
+		{"1.234", NumberFormatDecimalComma, "1234"},
+		{"1.234", NumberFormatAuto, "1.234"},
+		{"12,50", "", "12.50"},
+		{"n/a", NumberFormatDecimalPoint, "n/a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value+" "+tt.format, func(t *testing.T) {
+			if got := normalizeNumber(tt.value, tt.format); got != tt.want {
+				t.Errorf("normalizeNumber(%q, %q) = %q, want %q", tt.value, tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveLayoutFormats(t *testing.T) {
+	rows := [][]string{
+		{"Fecha", "Descripcion", "Importe"},
+		{"03/04/2025", "PLAZA VEA", "-1.234,50"},
+		{"25/04/2025", "SUELDO", "2.500,00"},
+	}
+	config := BankConfig{HeaderRow: 0, DateCol: 0, DescriptionCol: 1, AmountCol: 2, DebitCol: -1, CreditCol: -1,
+		BalanceCol: -1, DateFormat: "auto", NumberFormat: NumberFormatAuto}
+
+	got := resolveLayoutFormats(rows, config)
+	if got.DateFormat != DateOrderDMY || got.NumberFormat != NumberFormatDecimalComma {
+		t.Errorf("formats = %s %s, want %s %s", got.DateFormat, got.NumberFormat, DateOrderDMY, NumberFormatDecimalComma)
+	}
+
+	// Explicit formats are kept
+	config.DateFormat, config.NumberFormat = DateOrderMDY, NumberFormatDecimalPoint
+	got = resolveLayoutFormats(rows, config)
+	if got.DateFormat != DateOrderMDY || got.NumberFormat != NumberFormatDecimalPoint {
+		t.Errorf("formats = %s %s, want the explicit ones", got.DateFormat, got.NumberFormat)
+	}
+}
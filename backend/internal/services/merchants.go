@@ -0,0 +1,419 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+	"github.com/warren/finance-app/internal/database"
+)
+
+var (
+	// ErrMerchantNotFound is returned when a merchant doesn't exist or belongs to another user
+	ErrMerchantNotFound = errors.New("merchant not found")
+	// ErrMerchantExists is returned when the user already has a merchant with the name
+	ErrMerchantExists = errors.New("a merchant with that name already exists")
+)
+
+const merchantColumns = `id, name, aliases, patterns, default_tag_ids, created_at, updated_at`
+
+// maxMerchantKeyWords is how many words of a description make up its key
+const maxMerchantKeyWords = 3
+
+// merchantProcessorPrefixes are payment processors that prefix the merchant's
+// name in bank descriptions ("MDOPAGO*TIENDA", "PLIN-JUAN PEREZ")
+var merchantProcessorPrefixes = []string{
+	"mdopago", "mercadopago", "mercado pago", "paypal", "dlocal", "dlc", "izipay", "izi", "niubiz", "culqi",
+	"plin", "yape", "pyu", "sq",
+}
+
+// Merchant is the canonical business or person behind bank descriptions
+type Merchant struct {
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	Aliases       []string  `json:"aliases"`  // Description keys, see MerchantKey
+	Patterns      []string  `json:"patterns"` // Case-insensitive regexes on the raw description
+	DefaultTagIDs []int     `json:"default_tag_ids"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	regexes []*regexp.Regexp
+}
+
+// querier runs a query inside or outside a database transaction
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func scanMerchant(row rowScanner) (Merchant, error) {
+	var m Merchant
+	var aliases, patterns pq.StringArray
+	var tagIDs pq.Int64Array
+
+	err := row.Scan(&m.ID, &m.Name, &aliases, &patterns, &tagIDs, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return m, err
+	}
+
+	m.Aliases = []string(aliases)
+	m.Patterns = []string(patterns)
+	m.DefaultTagIDs = make([]int, len(tagIDs))
+	for i, id := range tagIDs {
+		m.DefaultTagIDs[i] = int(id)
+	}
+	for _, pattern := range m.Patterns {
+		// Validated on save; a pattern that no longer compiles matches nothing
+		if regex, err := regexp.Compile("(?i)" + pattern); err == nil {
+			m.regexes = append(m.regexes, regex)
+		}
+	}
+	return m, nil
+}
+
+// ListMerchants returns the user's merchants by name
+func ListMerchants(userID int) ([]Merchant, error) {
+	rows, err := database.DB.Query(
+		`SELECT `+merchantColumns+` FROM merchants WHERE user_id = $1 ORDER BY name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	merchants := []Merchant{}
+	for rows.Next() {
+		merchant, err := scanMerchant(rows)
+		if err != nil {
+			return nil, err
+		}
+		merchants = append(merchants, merchant)
+	}
+	return merchants, rows.Err()
+}
+
+// GetMerchant returns one of the user's merchants
+func GetMerchant(userID int, id int) (Merchant, error) {
+	merchant, err := scanMerchant(database.DB.QueryRow(
+		`SELECT `+merchantColumns+` FROM merchants WHERE id = $1 AND user_id = $2`, id, userID))
+	if err == sql.ErrNoRows {
+		return merchant, ErrMerchantNotFound
+	}
+	return merchant, err
+}
+
+// CreateMerchant stores a new merchant for the user
+func CreateMerchant(userID int, merchant Merchant) (Merchant, error) {
+	created, err := scanMerchant(database.DB.QueryRow(`
+		INSERT INTO merchants (user_id, name, aliases, patterns, default_tag_ids)
+		VALUES ($1, $2, COALESCE($3::text[], '{}'), COALESCE($4::text[], '{}'), COALESCE($5::integer[], '{}'))
+		RETURNING `+merchantColumns,
+		userID, merchant.Name, pq.Array(merchant.Aliases), pq.Array(merchant.Patterns), pq.Array(merchant.DefaultTagIDs)))
+	if isUniqueViolation(err) {
+		return Merchant{}, ErrMerchantExists
+	}
+	return created, err
+}
+
+// UpdateMerchant replaces a merchant. Returns ErrMerchantNotFound when the
+// merchant doesn't belong to the user.
+func UpdateMerchant(userID int, id int, merchant Merchant) (Merchant, error) {
+	updated, err := scanMerchant(database.DB.QueryRow(`
+		UPDATE merchants
+		SET name = $1, aliases = COALESCE($2::text[], '{}'), patterns = COALESCE($3::text[], '{}'),
+		    default_tag_ids = COALESCE($4::integer[], '{}'), updated_at = NOW()
+		WHERE id = $5 AND user_id = $6
+		RETURNING `+merchantColumns,
+		merchant.Name, pq.Array(merchant.Aliases), pq.Array(merchant.Patterns), pq.Array(merchant.DefaultTagIDs), id, userID))
+	if err == sql.ErrNoRows {
+		return Merchant{}, ErrMerchantNotFound
+	}
+	if isUniqueViolation(err) {
+		return Merchant{}, ErrMerchantExists
+	}
+	return updated, err
+}
+
+// DeleteMerchant removes a merchant; its transactions are left without one
+func DeleteMerchant(userID int, id int) error {
+	result, err := database.DB.Exec(`DELETE FROM merchants WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrMerchantNotFound
+	}
+	return nil
+}
+
+// MergeMerchants moves the transactions of the source merchants to the target
+// and deletes the sources. The target takes the sources' names and aliases as
+// aliases, and their patterns and default tags. sourceIDs must be distinct and
+// not include the target.
+func MergeMerchants(userID int, targetID int, sourceIDs []int) (Merchant, error) {
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		return Merchant{}, err
+	}
+	defer dbTx.Rollback()
+
+	target, err := scanMerchant(dbTx.QueryRow(
+		`SELECT `+merchantColumns+` FROM merchants WHERE id = $1 AND user_id = $2 FOR UPDATE`, targetID, userID))
+	if err == sql.ErrNoRows {
+		return Merchant{}, ErrMerchantNotFound
+	}
+	if err != nil {
+		return Merchant{}, err
+	}
+
+	rows, err := dbTx.Query(
+		`SELECT `+merchantColumns+` FROM merchants WHERE id = ANY($1) AND user_id = $2 AND id <> $3 FOR UPDATE`,
+		pq.Array(sourceIDs), userID, targetID)
+	if err != nil {
+		return Merchant{}, err
+	}
+	var sources []Merchant
+	for rows.Next() {
+		source, err := scanMerchant(rows)
+		if err != nil {
+			rows.Close()
+			return Merchant{}, err
+		}
+		sources = append(sources, source)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Merchant{}, err
+	}
+	if len(sources) == 0 || len(sources) != len(sourceIDs) {
+		return Merchant{}, ErrMerchantNotFound
+	}
+
+	ids := make([]int, len(sources))
+	for i, source := range sources {
+		ids[i] = source.ID
+		target.Aliases = append(target.Aliases, MerchantKey(source.Name))
+		target.Aliases = append(target.Aliases, source.Aliases...)
+		target.Patterns = append(target.Patterns, source.Patterns...)
+		target.DefaultTagIDs = append(target.DefaultTagIDs, source.DefaultTagIDs...)
+	}
+	if err := target.Normalize(); err != nil {
+		return Merchant{}, err
+	}
+
+	if _, err := dbTx.Exec(`UPDATE transactions SET merchant_id = $1 WHERE merchant_id = ANY($2) AND user_id = $3`,
+		targetID, pq.Array(ids), userID); err != nil {
+		return Merchant{}, err
+	}
+	if _, err := dbTx.Exec(`UPDATE import_rows SET merchant_id = $1 WHERE merchant_id = ANY($2)`,
+		targetID, pq.Array(ids)); err != nil {
+		return Merchant{}, err
+	}
+	if _, err := dbTx.Exec(`DELETE FROM merchants WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return Merchant{}, err
+	}
+
+	merged, err := scanMerchant(dbTx.QueryRow(`
+		UPDATE merchants
+		SET aliases = $1, patterns = $2, default_tag_ids = $3, updated_at = NOW()
+		WHERE id = $4
+		RETURNING `+merchantColumns,
+		pq.Array(target.Aliases), pq.Array(target.Patterns), pq.Array(target.DefaultTagIDs), targetID))
+	if err != nil {
+		return Merchant{}, err
+	}
+
+	return merged, dbTx.Commit()
+}
+
+// Normalize validates a merchant before it is stored. Aliases are reduced to
+// description keys, so "MDOPAGO*TIENDA X" and "tienda x" are the same alias.
+func (m *Merchant) Normalize() error {
+	m.Name = strings.TrimSpace(m.Name)
+	if m.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(m.Name) > 100 {
+		return fmt.Errorf("name is too long")
+	}
+
+	aliases := []string{}
+	seen := make(map[string]bool)
+	for _, alias := range m.Aliases {
+		key := MerchantKey(alias)
+		if key != "" && !seen[key] {
+			seen[key] = true
+			aliases = append(aliases, key)
+		}
+	}
+	m.Aliases = aliases
+
+	patterns := []string{}
+	seenPatterns := make(map[string]bool)
+	m.regexes = nil
+	for _, pattern := range m.Patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" || seenPatterns[pattern] {
+			continue
+		}
+		regex, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		seenPatterns[pattern] = true
+		patterns = append(patterns, pattern)
+		m.regexes = append(m.regexes, regex)
+	}
+	m.Patterns = patterns
+
+	tagIDs := []int{}
+	seenTags := make(map[int]bool)
+	for _, id := range m.DefaultTagIDs {
+		if !seenTags[id] {
+			seenTags[id] = true
+			tagIDs = append(tagIDs, id)
+		}
+	}
+	m.DefaultTagIDs = tagIDs
+	return nil
+}
+
+// MerchantResolver finds the merchant of descriptions among the user's
+// merchants, creating merchants for new descriptions when asked to
+type MerchantResolver struct {
+	userID    int
+	merchants []Merchant
+}
+
+// NewMerchantResolver loads the user's merchants
+func NewMerchantResolver(userID int) (*MerchantResolver, error) {
+	merchants, err := ListMerchants(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &MerchantResolver{userID: userID, merchants: merchants}, nil
+}
+
+// Match returns the merchant of a description, or nil. Patterns win over
+// aliases; among aliases the longest one the description's key starts with wins.
+func (r *MerchantResolver) Match(description string) *Merchant {
+	for i := range r.merchants {
+		for _, regex := range r.merchants[i].regexes {
+			if regex.MatchString(description) {
+				return &r.merchants[i]
+			}
+		}
+	}
+
+	key := MerchantKey(description)
+	if key == "" {
+		return nil
+	}
+	var best *Merchant
+	bestLength := 0
+	for i := range r.merchants {
+		for _, alias := range r.merchants[i].Aliases {
+			if (key == alias || strings.HasPrefix(key, alias+" ")) && len(alias) > bestLength {
+				best = &r.merchants[i]
+				bestLength = len(alias)
+			}
+		}
+	}
+	return best
+}
+
+// Resolve returns the ID of the description's merchant, creating the merchant
+// from the description's key when none matches. Returns nil when the
+// description has no usable words.
+func (r *MerchantResolver) Resolve(q querier, description string) (*int, error) {
+	if merchant := r.Match(description); merchant != nil {
+		return &merchant.ID, nil
+	}
+
+	key := MerchantKey(description)
+	if key == "" {
+		return nil, nil
+	}
+
+	// A merchant may already have the name without the alias (renamed or created by hand)
+	merchant, err := scanMerchant(q.QueryRow(`
+		INSERT INTO merchants (user_id, name, aliases)
+		VALUES ($1, $2, ARRAY[$3::text])
+		ON CONFLICT (user_id, name) DO UPDATE
+		SET aliases = CASE WHEN $3 = ANY(merchants.aliases) THEN merchants.aliases
+		                   ELSE array_append(merchants.aliases, $3) END,
+		    updated_at = NOW()
+		RETURNING `+merchantColumns,
+		r.userID, MerchantName(key), key))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range r.merchants {
+		if r.merchants[i].ID == merchant.ID {
+			r.merchants[i] = merchant
+			return &merchant.ID, nil
+		}
+	}
+	r.merchants = append(r.merchants, merchant)
+	return &merchant.ID, nil
+}
+
+// MerchantKey reduces a bank description to the words that identify the
+// merchant: lowercase, without markers such as "(P)", payment processor
+// prefixes ("MDOPAGO*", "PLIN-") or words with digits (card numbers, dates,
+// operation codes), keeping the first words.
+func MerchantKey(description string) string {
+	desc := strings.ToLower(strings.TrimSpace(description))
+
+	// Markers like "(P)" or "(C)"
+	for strings.HasPrefix(desc, "(") {
+		idx := strings.Index(desc, ")")
+		if idx <= 0 || idx >= 5 {
+			break
+		}
+		desc = strings.TrimSpace(desc[idx+1:])
+	}
+
+	for _, prefix := range merchantProcessorPrefixes {
+		if !strings.HasPrefix(desc, prefix) {
+			continue
+		}
+		rest := strings.TrimSpace(desc[len(prefix):])
+		if strings.HasPrefix(rest, "*") || strings.HasPrefix(rest, "-") {
+			desc = strings.TrimSpace(rest[1:])
+			break
+		}
+	}
+
+	var words []string
+	for _, word := range strings.Fields(normalizeDescription(desc)) {
+		if strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+			continue
+		}
+		words = append(words, word)
+		if len(words) == maxMerchantKeyWords {
+			break
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// MerchantName turns a description key into a display name ("mercado pago" -> "Mercado Pago")
+func MerchantName(key string) string {
+	words := strings.Fields(key)
+	for i, word := range words {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+	return strings.Join(words, " ")
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,358 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/warren/finance-app/internal/database"
+)
+
+var (
+	mt940TagPattern     = regexp.MustCompile(`^:(\d{2}[A-Z]?):(.*)$`)
+	mt940BalancePattern = regexp.MustCompile(`^([CD])(\d{6})([A-Z]{3})([\d,]+)`)
+	// :61: value date, optional entry date (MMDD), (R)C/(R)D mark, optional funds code,
+	// amount, transaction type, customer reference, optional //bank reference
+	mt940LinePattern = regexp.MustCompile(`^(\d{6})(\d{4})?(R?[CD])([A-Z])?([\d,]+)([NFS][A-Z0-9]{3})?([^/]*)(?://(.*))?$`)
+	mt940EREFPattern = regexp.MustCompile(`(?:EREF\+|/EREF/)([^?/]+)`)
+	mt940NamePattern = regexp.MustCompile(`/NAME/([^/]+)`)
+	mt940RemiPattern = regexp.MustCompile(`/REMI/(?:USTD//)?([^/]+)`)
+	mt940SubfieldTag = regexp.MustCompile(`\?(\d{2})`)
+)
+
+// mt940Statement accumulates one :20:...:62: block
+type mt940Statement struct {
+	Account      string
+	Currency     string
+	Opening      float64
+	Closing      float64
+	HasOpening   bool
+	HasClosing   bool
+	Transactions []ParsedTransaction
+}
+
+// ProcessMT940File reads and parses a SWIFT MT940 statement
+func ProcessMT940File(filePath string, userID int) ([]ParsedTransaction, []BalanceCheck, int, error) {
+	transactions, checks, err := ParseMT940File(filePath)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var importID int
+	err = database.DB.QueryRow(
+		`INSERT INTO imports (user_id, filename, file_type, status, total_transactions)
+		 VALUES ($1, $2, 'mt940', 'completed', $3) RETURNING id`,
+		userID, filePath, len(transactions),
+	).Scan(&importID)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("error creating import record: %w", err)
+	}
+
+	return transactions, checks, importID, nil
+}
+
+// ParseMT940File reads and parses an MT940 file without creating an import
+func ParseMT940File(filePath string) ([]ParsedTransaction, []BalanceCheck, error) {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening mt940 file: %w", err)
+	}
+
+	content, err := decodeTextContent(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding mt940 file: %w", err)
+	}
+
+	return ParseMT940(content)
+}
+
+// ParseMT940 extracts statement lines and balance checks from MT940 text
+func ParseMT940(content string) ([]ParsedTransaction, []BalanceCheck, error) {
+	fields := splitMT940Fields(content)
+	if len(fields) == 0 {
+		return nil, nil, fmt.Errorf("invalid MT940 file: no fields found")
+	}
+
+	var statements []*mt940Statement
+	var current *mt940Statement
+	var lastTx *ParsedTransaction
+
+	for _, f := range fields {
+		switch f.tag {
+		case "20":
+			current = &mt940Statement{}
+			statements = append(statements, current)
+			lastTx = nil
+		case "25":
+			if current != nil {
+				current.Account = strings.TrimSpace(f.value)
+			}
+		case "60F", "60M":
+			if current == nil {
+				continue
+			}
+			if amount, currency, ok := parseMT940Balance(f.value); ok {
+				current.Opening, current.Currency, current.HasOpening = amount, currency, true
+			}
+		case "62F", "62M":
+			if current == nil {
+				continue
+			}
+			if amount, _, ok := parseMT940Balance(f.value); ok {
+				current.Closing, current.HasClosing = amount, true
+			}
+		case "61":
+			if current == nil {
+				continue
+			}
+			tx := parseMT940Line(f.value, current.Currency)
+			if tx == nil {
+				lastTx = nil
+				continue
+			}
+			current.Transactions = append(current.Transactions, *tx)
+			lastTx = &current.Transactions[len(current.Transactions)-1]
+		case "86":
+			if lastTx != nil {
+				applyMT940Information(lastTx, f.value)
+				lastTx = nil
+			}
+		}
+	}
+
+	var transactions []ParsedTransaction
+	var checks []BalanceCheck
+	for _, stmt := range statements {
+		// Lines without supplementary details nor :86: still need a description,
+		// and long :86: fields have to fit the description column
+		for i := range stmt.Transactions {
+			if stmt.Transactions[i].Description == "" {
+				stmt.Transactions[i].Description = "Movimiento bancario"
+			}
+			stmt.Transactions[i].Description = truncateDescription(stmt.Transactions[i].Description)
+		}
+		transactions = append(transactions, stmt.Transactions...)
+		if stmt.HasOpening && stmt.HasClosing {
+			checks = append(checks, newBalanceCheck(stmt.Account, stmt.Currency, stmt.Opening, stmt.Closing, stmt.Transactions))
+		}
+	}
+
+	if len(statements) == 0 {
+		return nil, nil, fmt.Errorf("invalid MT940 file: no statements found")
+	}
+
+	return transactions, checks, nil
+}
+
+type mt940Field struct {
+	tag   string
+	value string
+}
+
+// splitMT940Fields groups lines into :tag: fields, joining continuation lines
+func splitMT940Fields(content string) []mt940Field {
+	var fields []mt940Field
+
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, "\r ")
+		trimmed := strings.TrimSpace(line)
+		// Skip SWIFT envelope blocks and end-of-message markers
+		if trimmed == "" || trimmed == "-" || trimmed == "-}" || strings.HasPrefix(trimmed, "{") {
+			continue
+		}
+
+		if match := mt940TagPattern.FindStringSubmatch(line); match != nil {
+			fields = append(fields, mt940Field{tag: match[1], value: match[2]})
+			continue
+		}
+
+		if len(fields) > 0 {
+			fields[len(fields)-1].value += "\n" + line
+		}
+	}
+
+	return fields
+}
+
+// parseMT940Balance parses "C251216PEN1234,56" into a signed amount and currency
+func parseMT940Balance(value string) (float64, string, bool) {
+	match := mt940BalancePattern.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return 0, "", false
+	}
+	amount := parseMT940Amount(match[4])
+	if match[1] == "D" {
+		amount = -amount
+	}
+	return amount, match[3], true
+}
+
+// parseMT940Line parses a :61: statement line
+func parseMT940Line(value string, currency string) *ParsedTransaction {
+	lines := strings.SplitN(value, "\n", 2)
+	match := mt940LinePattern.FindStringSubmatch(strings.TrimSpace(lines[0]))
+	if match == nil {
+		return nil
+	}
+
+	valueDate := parseMT940Date(match[1])
+	if valueDate == "" {
+		return nil
+	}
+
+	// The entry (booking) date only carries MMDD; take the year from the value date,
+	// adjusting when the two straddle a year boundary
+	bookingDate := valueDate
+	if match[2] != "" {
+		year, _ := strconv.Atoi(valueDate[:4])
+		month := match[2][:2]
+		valueMonth := valueDate[5:7]
+		if month == "12" && valueMonth == "01" {
+			year--
+		} else if month == "01" && valueMonth == "12" {
+			year++
+		}
+		if date, ok := validDate(year, month, match[2][2:]); ok {
+			bookingDate = date
+		}
+	}
+
+	amount := parseMT940Amount(match[5])
+	if amount == 0 {
+		return nil
+	}
+
+	// RC (reversal of credit) is a debit, RD (reversal of debit) is a credit
+	txType := "expense"
+	if match[3] == "C" || match[3] == "RD" {
+		txType = "income"
+	}
+
+	if currency == "" {
+		currency = "PEN"
+	}
+
+	reference := strings.TrimSpace(match[7])
+	if strings.EqualFold(reference, "NONREF") {
+		reference = ""
+	}
+
+	description := ""
+	if len(lines) > 1 {
+		description = strings.TrimSpace(lines[1])
+	}
+
+	// The //bank reference is not guaranteed unique per account, so it stays
+	// in RawText and is not used as ExternalID
+	return &ParsedTransaction{
+		Date:        bookingDate,
+		ValueDate:   valueDate,
+		Description: description,
+		Amount:      amount,
+		Currency:    currency,
+		Type:        txType,
+		Reference:   reference,
+		RawText:     strings.Join(strings.Fields(value), " "),
+	}
+}
+
+// applyMT940Information fills description, counterparty and end-to-end reference
+// from a :86: field. Supports German structured (?20..?33) and /CODE/ formats.
+func applyMT940Information(tx *ParsedTransaction, info string) {
+	info = strings.ReplaceAll(info, "\n", "")
+	tx.RawText = strings.TrimSpace(tx.RawText + " " + info)
+
+	if match := mt940EREFPattern.FindStringSubmatch(info); match != nil {
+		if ref := strings.TrimSpace(match[1]); !strings.EqualFold(ref, "NOTPROVIDED") {
+			tx.Reference = ref
+		}
+	}
+
+	var description string
+	if strings.Contains(info, "?") && mt940SubfieldTag.MatchString(info) {
+		subfields := parseMT940Subfields(info)
+		var purpose []string
+		for code := 20; code <= 29; code++ {
+			if v := subfields[strconv.Itoa(code)]; v != "" && !strings.HasPrefix(v, "EREF+") {
+				purpose = append(purpose, v)
+			}
+		}
+		for code := 60; code <= 63; code++ {
+			if v := subfields[strconv.Itoa(code)]; v != "" {
+				purpose = append(purpose, v)
+			}
+		}
+		description = strings.Join(purpose, " ")
+		tx.Counterparty = strings.TrimSpace(subfields["32"] + " " + subfields["33"])
+	} else {
+		if match := mt940NamePattern.FindStringSubmatch(info); match != nil {
+			tx.Counterparty = strings.TrimSpace(match[1])
+		}
+		description = info
+		if match := mt940RemiPattern.FindStringSubmatch(info); match != nil {
+			description = match[1]
+		}
+	}
+
+	description = strings.Join(strings.Fields(description), " ")
+	if tx.Counterparty != "" && !strings.Contains(strings.ToLower(description), strings.ToLower(tx.Counterparty)) {
+		description = strings.TrimSpace(tx.Counterparty + " - " + description)
+	}
+	if description != "" {
+		tx.Description = description
+	}
+}
+
+// parseMT940Subfields splits "?20foo?21bar" into {"20": "foo", "21": "bar"}
+func parseMT940Subfields(info string) map[string]string {
+	result := make(map[string]string)
+	locs := mt940SubfieldTag.FindAllStringSubmatchIndex(info, -1)
+	for i, loc := range locs {
+		end := len(info)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		code := info[loc[2]:loc[3]]
+		result[code] += strings.TrimSpace(info[loc[1]:end])
+	}
+	return result
+}
+
+// parseMT940Date converts YYMMDD to YYYY-MM-DD
+func parseMT940Date(value string) string {
+	if len(value) != 6 {
+		return ""
+	}
+	year, err := strconv.Atoi(value[:2])
+	if err != nil {
+		return ""
+	}
+	date, _ := validDate(2000+year, value[2:4], value[4:6])
+	return date
+}
+
+// validDate formats year, MM and DD as YYYY-MM-DD when they make a real date
+func validDate(year int, month, day string) (string, bool) {
+	m, errM := strconv.Atoi(month)
+	d, errD := strconv.Atoi(day)
+	if errM != nil || errD != nil {
+		return "", false
+	}
+	t := time.Date(year, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+	if t.Year() != year || int(t.Month()) != m || t.Day() != d {
+		return "", false
+	}
+	return t.Format("2006-01-02"), true
+}
+
+// parseMT940Amount parses SWIFT amounts, which always use a comma as decimal separator
+func parseMT940Amount(value string) float64 {
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", "."), 64)
+	if err != nil {
+		return 0
+	}
+	return amount
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseMT940Date(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"250316", "2025-03-16"},
+		{"240229", "2024-02-29"},
+		{"250229", ""},
+		{"991340", ""},
+		{"250431", ""},
+		{"2503", ""},
+		{"ab0316", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := parseMT940Date(tt.value); got != tt.want {
+				t.Errorf("parseMT940Date(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMT940(t *testing.T) {
+	longPurpose := strings.Repeat("PAGO PROVEEDOR ", 30)
+	statement := ":20:STMT-1\n" +
+		":25:PE0011112222333344\n" +
+		":60F:C250301PEN1000,00\n" +
+		":61:2503030303D200,00NTRFNONREF//B-1\n" +
+		":86:/NAME/TIENDA SAC/REMI/Compra 123\n" +
+		":61:9913401340C50,00NTRFNONREF\n" +
+		":61:2503050305C50,00NTRFNONREF\n" +
+		":61:2503060306D50,00NTRFNONREF\n" +
+		":86:" + longPurpose + "\n" +
+		":62F:C250306PEN800,00\n" +
+		"-\n"
+
+	transactions, checks, err := ParseMT940(statement)
+	if err != nil {
+		t.Fatalf("ParseMT940: %v", err)
+	}
+
+	want := []struct {
+		date, txType string
+		amount       float64
+	}{
+		{"2025-03-03", "expense", 200},
+		{"2025-03-05", "income", 50},
+		{"2025-03-06", "expense", 50},
+	}
+	if len(transactions) != len(want) {
+		t.Fatalf("got %d transactions, want %d (invalid dates are skipped)", len(transactions), len(want))
+	}
+	for i, w := range want {
+		tx := transactions[i]
+		if tx.Date != w.date || tx.Type != w.txType || tx.Amount != w.amount {
+			t.Errorf("transaction %d = %s %s %.2f, want %s %s %.2f", i, tx.Date, tx.Type, tx.Amount, w.date, w.txType, w.amount)
+		}
+	}
+
+	if got := transactions[0].Description; got != "TIENDA SAC - Compra 123" {
+		t.Errorf("description = %q, want TIENDA SAC - Compra 123", got)
+	}
+	if got := transactions[1].Description; got != "Movimiento bancario" {
+		t.Errorf("description without :86: = %q, want Movimiento bancario", got)
+	}
+	if n := utf8.RuneCountInString(transactions[2].Description); n > maxDescriptionLength {
+		t.Errorf("long description has %d runes, want at most %d", n, maxDescriptionLength)
+	}
+
+	if len(checks) != 1 || !checks[0].Matches {
+		t.Errorf("balance checks = %+v, want one matching check", checks)
+	}
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// OCREngine extracts plain text from an image file
+type OCREngine interface {
+	ExtractText(imagePath string) (string, error)
+}
+
+// DefaultOCR is the engine used by ProcessImageFile. Replace it with a
+// FakeOCR to exercise the import pipeline without Tesseract installed.
+var DefaultOCR OCREngine = NewTesseractOCR()
+
+// TesseractOCR runs the tesseract CLI
+type TesseractOCR struct {
+	BinaryPath string // Path to tesseract binary
+	Languages  string // Tesseract language codes, e.g. "spa+eng"
+	PageSeg    string // Page segmentation mode (--psm)
+}
+
+// NewTesseractOCR creates a Tesseract adapter configured from the environment
+func NewTesseractOCR() *TesseractOCR {
+	return &TesseractOCR{
+		BinaryPath: getEnv("TESSERACT_PATH", "tesseract"),
+		Languages:  getEnv("TESSERACT_LANG", "spa+eng"),
+		PageSeg:    getEnv("TESSERACT_PSM", "6"), // Assume a single uniform block of text
+	}
+}
+
+// ExtractText runs tesseract on the image and returns the recognized text
+func (t *TesseractOCR) ExtractText(imagePath string) (string, error) {
+	if _, err := exec.LookPath(t.BinaryPath); err != nil {
+		return "", fmt.Errorf("OCR no disponible. Instala Tesseract: sudo apt-get install tesseract-ocr tesseract-ocr-spa")
+	}
+
+	cmd := exec.Command(t.BinaryPath, imagePath, "stdout", "-l", t.Languages, "--psm", t.PageSeg)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("error running tesseract: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// FakeOCR returns a fixed text (or error) regardless of the image
+type FakeOCR struct {
+	Text string
+	Err  error
+}
+
+// ExtractText returns the configured text
+func (f *FakeOCR) ExtractText(imagePath string) (string, error) {
+	return f.Text, f.Err
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
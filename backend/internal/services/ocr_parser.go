@@ -0,0 +1,275 @@
+package services
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// spanishMonths maps Spanish month names and abbreviations to month numbers
+var spanishMonths = map[string]int{
+	"ene": 1, "enero": 1,
+	"feb": 2, "febrero": 2,
+	"mar": 3, "marzo": 3,
+	"abr": 4, "abril": 4,
+	"may": 5, "mayo": 5,
+	"jun": 6, "junio": 6,
+	"jul": 7, "julio": 7,
+	"ago": 8, "agosto": 8,
+	"set": 9, "sep": 9, "sept": 9, "setiembre": 9, "septiembre": 9,
+	"oct": 10, "octubre": 10,
+	"nov": 11, "noviembre": 11,
+	"dic": 12, "diciembre": 12,
+}
+
+var (
+	ocrNumericDatePattern = regexp.MustCompile(`\b(\d{1,2}[/-]\d{1,2}[/-]\d{2,4})\b`)
+	ocrSpanishDatePattern = regexp.MustCompile(`(?i)\b(\d{1,2})\s*(?:de\s+)?(ene|feb|mar|abr|may|jun|jul|ago|set|sep|oct|nov|dic)[a-záéíóú]*\.?(?:\s*(?:de|del)?\s*(\d{4}))?\b`)
+	ocrTimePattern        = regexp.MustCompile(`(?i)\b\d{1,2}:\d{2}(?::\d{2})?\s*(?:[ap]\.?\s*m\.?)?`)
+	// Optional sign, optional currency (S/, S/., US$, $, PEN, USD), amount with decimals
+	ocrAmountPattern   = regexp.MustCompile(`(?i)([+-])?\s*(S/\.?|US\$|\$|PEN|USD)?\s*([+-])?\s*(\d{1,3}(?:[.,]\d{3})+(?:[.,]\d{2})?|\d+[.,]\d{2}|\d+)\b`)
+	ocrDecimalsPattern = regexp.MustCompile(`[.,]\d{2}$`)
+)
+
+// Keywords that reveal the direction of a mobile-banking movement (Yape/Plin/cards)
+var (
+	ocrIncomeKeywords  = []string{"te yapeó", "te yapeo", "recibiste", "te plineó", "te plineo", "abono", "depósito", "deposito"}
+	ocrExpenseKeywords = []string{"yapeaste", "plineaste", "pagaste", "enviaste", "consumo", "compra", "retiro", "cargo"}
+)
+
+// ocrAmount is an amount found in an OCR line
+type ocrAmount struct {
+	Value       float64
+	Currency    string
+	Negative    bool
+	Positive    bool
+	HasCurrency bool
+	Raw         string
+}
+
+// parseOCRText extracts transactions from OCR output of statements and
+// mobile-banking screenshots. Descriptions may span several lines before
+// the line holding the amount; dates can be numeric or Spanish
+// ("16 dic. 2025", "3 de enero") and act as headers for following rows.
+func parseOCRText(text string) []ParsedTransaction {
+	var transactions []ParsedTransaction
+	lines := strings.Split(text, "\n")
+
+	var descLines []string
+	var rawLines []string
+	currentDate := ""   // Last date seen; applies to following rows (date headers)
+	dateSinceEmit := "" // Date seen since the last emitted transaction
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+
+		date, rest := extractOCRDate(line)
+		rest = ocrTimePattern.ReplaceAllString(rest, " ")
+		if date != "" {
+			currentDate = date
+			dateSinceEmit = date
+		}
+
+		amount, rest := extractOCRAmount(rest)
+		if text := cleanOCRDescription(rest); text != "" {
+			descLines = append(descLines, text)
+		}
+		rawLines = append(rawLines, line)
+
+		if amount == nil {
+			continue
+		}
+
+		// The date may come on the line right after the amount (name, amount, date)
+		txDate := dateSinceEmit
+		if txDate == "" && i+1 < len(lines) {
+			if next, nextRest := extractOCRDate(strings.TrimSpace(lines[i+1])); next != "" && cleanOCRDescription(ocrTimePattern.ReplaceAllString(nextRest, " ")) == "" {
+				txDate = next
+				currentDate = next
+				rawLines = append(rawLines, strings.TrimSpace(lines[i+1]))
+				i++
+			}
+		}
+		if txDate == "" {
+			txDate = currentDate
+		}
+
+		if txDate != "" && amount.Value != 0 {
+			desc := strings.Join(descLines, " ")
+			if len(desc) < 3 {
+				desc = "Transacción bancaria"
+			}
+
+			transactions = append(transactions, ParsedTransaction{
+				Date:        txDate,
+				Description: desc,
+				Amount:      amount.Value,
+				Currency:    amount.Currency,
+				Type:        ocrTransactionType(amount, strings.Join(rawLines, " ")),
+				RawText:     strings.Join(rawLines, "\n"),
+			})
+		}
+
+		descLines = nil
+		rawLines = nil
+		dateSinceEmit = ""
+	}
+
+	return transactions
+}
+
+// extractOCRDate finds a numeric or Spanish date in the line and returns it
+// as YYYY-MM-DD along with the line without the date
+func extractOCRDate(line string) (string, string) {
+	if loc := ocrNumericDatePattern.FindStringIndex(line); loc != nil {
+		date := parseDate(line[loc[0]:loc[1]])
+		return date, line[:loc[0]] + " " + line[loc[1]:]
+	}
+
+	if match := ocrSpanishDatePattern.FindStringSubmatchIndex(line); match != nil {
+		day, _ := strconv.Atoi(line[match[2]:match[3]])
+		month := spanishMonths[strings.ToLower(line[match[4]:match[5]])]
+
+		now := time.Now()
+		year := now.Year()
+		if match[6] != -1 {
+			year, _ = strconv.Atoi(line[match[6]:match[7]])
+		} else if time.Month(month) > now.Month() {
+			// No year shown: a month later than today belongs to last year
+			year--
+		}
+
+		if day < 1 || month == 0 {
+			return "", line
+		}
+		// time.Date normalizes "30 feb" into March; reject it instead
+		date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+		if date.Day() != day || date.Month() != time.Month(month) {
+			return "", line
+		}
+		return date.Format("2006-01-02"), line[:match[0]] + " " + line[match[1]:]
+	}
+
+	return "", line
+}
+
+// extractOCRAmount picks the transaction amount from the line.
+// Amounts with a currency marker win; otherwise a number with decimals is required
+// so that operation numbers and card digits are not mistaken for amounts.
+func extractOCRAmount(line string) (*ocrAmount, string) {
+	matches := ocrAmountPattern.FindAllStringSubmatchIndex(line, -1)
+
+	var best *ocrAmount
+	var bestLoc []int
+	for _, m := range matches {
+		currencyMark := ""
+		if m[4] != -1 {
+			currencyMark = strings.ToUpper(line[m[4]:m[5]])
+		}
+		number := line[m[8]:m[9]]
+		hasDecimals := ocrDecimalsPattern.MatchString(number)
+		if currencyMark == "" && !hasDecimals {
+			continue
+		}
+
+		sign := ""
+		if m[2] != -1 {
+			sign = line[m[2]:m[3]]
+		} else if m[6] != -1 {
+			sign = line[m[6]:m[7]]
+		}
+
+		currency := "PEN"
+		if currencyMark == "US$" || currencyMark == "$" || currencyMark == "USD" {
+			currency = "USD"
+		}
+
+		candidate := &ocrAmount{
+			Value:       normalizeOCRAmount(number),
+			Currency:    currency,
+			Negative:    sign == "-",
+			Positive:    sign == "+",
+			HasCurrency: currencyMark != "",
+			Raw:         line[m[0]:m[1]],
+		}
+
+		// Prefer currency-marked amounts, then the last amount on the line
+		if best == nil || candidate.HasCurrency || !best.HasCurrency {
+			best = candidate
+			bestLoc = m
+		}
+	}
+
+	if best == nil {
+		return nil, line
+	}
+	return best, line[:bestLoc[0]] + " " + line[bestLoc[1]:]
+}
+
+// normalizeOCRAmount parses "1,234.56", "1.234,56", "30,00" or "30.00"
+func normalizeOCRAmount(number string) float64 {
+	lastDot := strings.LastIndex(number, ".")
+	lastComma := strings.LastIndex(number, ",")
+
+	decimalSep := ""
+	if lastDot > lastComma && len(number)-lastDot == 3 {
+		decimalSep = "."
+	} else if lastComma > lastDot && len(number)-lastComma == 3 {
+		decimalSep = ","
+	}
+
+	var b strings.Builder
+	for i, r := range number {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case decimalSep != "" && string(r) == decimalSep && (i == lastDot || i == lastComma):
+			b.WriteRune('.')
+		}
+	}
+
+	value, err := strconv.ParseFloat(b.String(), 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// ocrTransactionType uses the amount sign first, then direction keywords
+func ocrTransactionType(amount *ocrAmount, context string) string {
+	if amount.Negative {
+		return "expense"
+	}
+	if amount.Positive {
+		return "income"
+	}
+
+	contextLower := strings.ToLower(context)
+	if containsAny(contextLower, ocrIncomeKeywords) {
+		return "income"
+	}
+	if containsAny(contextLower, ocrExpenseKeywords) {
+		return "expense"
+	}
+	return "income"
+}
+
+// cleanOCRDescription strips leftover separators and noise from a description fragment
+func cleanOCRDescription(text string) string {
+	text = strings.Trim(strings.Join(strings.Fields(text), " "), " -|:·•")
+	hasLetter := false
+	for _, r := range text {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r > 127 {
+			hasLetter = true
+			break
+		}
+	}
+	if !hasLetter {
+		return ""
+	}
+	return text
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// parseWithFakeOCR runs ParseImageFile with DefaultOCR returning text
+func parseWithFakeOCR(t *testing.T, text string) []ParsedTransaction {
+	t.Helper()
+	previous := DefaultOCR
+	DefaultOCR = &FakeOCR{Text: text}
+	defer func() { DefaultOCR = previous }()
+
+	transactions, err := ParseImageFile("statement.png")
+	if err != nil {
+		t.Fatalf("ParseImageFile: %v", err)
+	}
+	return transactions
+}
+
+func TestParseImageFileOCRError(t *testing.T) {
+	previous := DefaultOCR
+	DefaultOCR = &FakeOCR{Err: errors.New("tesseract missing")}
+	defer func() { DefaultOCR = previous }()
+
+	if _, err := ParseImageFile("statement.png"); err == nil {
+		t.Fatal("expected the OCR error to be returned")
+	}
+}
+
+func TestParseImageFileOCR(t *testing.T) {
+	// Dates without a year take the current one, or the previous one for
+	// months later than today
+	now := time.Now()
+	decemberYear := now.Year()
+	if now.Month() < time.December {
+		decemberYear--
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want []ParsedTransaction
+	}{
+		{
+			name: "numeric dates and signed amounts",
+			text: "16/11/2025 PLAZA VEA SAN ISIDRO -S/ 125.40\n" +
+				"17/11/2025 TRANSFERENCIA RECIBIDA +S/ 1,500.00\n",
+			want: []ParsedTransaction{
+				{Date: "2025-11-16", Description: "PLAZA VEA SAN ISIDRO", Amount: 125.40, Currency: "PEN", Type: "expense"},
+				{Date: "2025-11-17", Description: "TRANSFERENCIA RECIBIDA", Amount: 1500, Currency: "PEN", Type: "income"},
+			},
+		},
+		{
+			name: "spanish month with year and US dollars",
+			text: "16 dic. 2025\n" +
+				"NETFLIX.COM\n" +
+				"Consumo US$ 15.99\n",
+			want: []ParsedTransaction{
+				{Date: "2025-12-16", Description: "NETFLIX.COM Consumo", Amount: 15.99, Currency: "USD", Type: "expense"},
+			},
+		},
+		{
+			name: "yape screenshot with the date after the amount",
+			text: "Te yapeó\n" +
+				"Juan Perez\n" +
+				"S/ 30.00\n" +
+				"3 de enero 2025 10:15 a. m.\n",
+			want: []ParsedTransaction{
+				{Date: "2025-01-03", Description: "Te yapeó Juan Perez", Amount: 30, Currency: "PEN", Type: "income"},
+			},
+		},
+		{
+			name: "date header without year applies to following rows",
+			text: "15 dic\n" +
+				"Yapeaste a Maria\n" +
+				"S/ 12,50\n" +
+				"Pagaste Tambo\n" +
+				"S/ 8.90\n",
+			want: []ParsedTransaction{
+				{Date: fmt.Sprintf("%d-12-15", decemberYear), Description: "Yapeaste a Maria", Amount: 12.50, Currency: "PEN", Type: "expense"},
+				{Date: fmt.Sprintf("%d-12-15", decemberYear), Description: "Pagaste Tambo", Amount: 8.90, Currency: "PEN", Type: "expense"},
+			},
+		},
+		{
+			name: "february 30 is not a date",
+			text: "30 feb 2025\n" +
+				"NETFLIX.COM\n" +
+				"Consumo US$ 15.99\n",
+			want: nil,
+		},
+		{
+			name: "april 31 is not a date",
+			text: "31 abr\n" +
+				"Yapeaste a Maria\n" +
+				"S/ 12,50\n",
+			want: nil,
+		},
+		{
+			name: "operation numbers are not amounts",
+			text: "05/02/2025 Nro. operación 48213977\n" +
+				"Sin movimientos\n",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseWithFakeOCR(t, tt.text)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d transactions %+v, want %d", len(got), got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				g := got[i]
+				if g.Date != want.Date || g.Description != want.Description || g.Amount != want.Amount ||
+					g.Currency != want.Currency || g.Type != want.Type {
+					t.Errorf("transaction %d = {%s %q %.2f %s %s}, want {%s %q %.2f %s %s}", i,
+						g.Date, g.Description, g.Amount, g.Currency, g.Type,
+						want.Date, want.Description, want.Amount, want.Currency, want.Type)
+				}
+				if g.RawText == "" {
+					t.Errorf("transaction %d has no raw text", i)
+				}
+			}
+		})
+	}
+}
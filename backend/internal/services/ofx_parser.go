@@ -0,0 +1,156 @@
+package services
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/warren/finance-app/internal/database"
+)
+
+var (
+	ofxStatementPattern   = regexp.MustCompile(`(?is)<(STMTRS|CCSTMTRS)>(.*?)</(?:STMTRS|CCSTMTRS)>`)
+	ofxInvestmentPattern  = regexp.MustCompile(`(?i)<INVSTMTRS>`)
+	ofxTransactionPattern = regexp.MustCompile(`(?is)<STMTTRN>(.*?)</STMTTRN>`)
+	ofxDatePattern        = regexp.MustCompile(`^(\d{4})(\d{2})(\d{2})`)
+	ofxCurrencyPattern    = regexp.MustCompile(`(?is)<CURRENCY>(.*?)</CURRENCY>`)
+	ofxLeafPattern        = regexp.MustCompile(`<([A-Za-z0-9.]+)>([^<\r\n]*)`)
+)
+
+// ProcessOFXFile reads and parses an OFX/QFX statement (1.x SGML or 2.x XML)
+func ProcessOFXFile(filePath string, userID int) ([]ParsedTransaction, int, error) {
+	transactions, err := ParseOFXFile(filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var importID int
+	err = database.DB.QueryRow(
+		`INSERT INTO imports (user_id, filename, file_type, status, total_transactions)
+		 VALUES ($1, $2, 'ofx', 'completed', $3) RETURNING id`,
+		userID, filePath, len(transactions),
+	).Scan(&importID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error creating import record: %w", err)
+	}
+
+	return transactions, importID, nil
+}
+
+// ParseOFXFile reads and parses an OFX/QFX file without creating an import
+func ParseOFXFile(filePath string) ([]ParsedTransaction, error) {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening ofx file: %w", err)
+	}
+
+	content, err := decodeTextContent(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding ofx file: %w", err)
+	}
+
+	return ParseOFX(content)
+}
+
+// ParseOFX extracts transactions from OFX content.
+// SGML (1.x) leaves leaf elements unclosed while XML (2.x) closes them;
+// both close aggregates like <STMTTRN>, so the same scanner handles both.
+func ParseOFX(content string) ([]ParsedTransaction, error) {
+	if !strings.Contains(strings.ToUpper(content), "<OFX>") {
+		return nil, fmt.Errorf("invalid OFX file: missing <OFX> root")
+	}
+
+	var transactions []ParsedTransaction
+
+	// Investment statements hold positions and trades, not account movements.
+	// Files mixing them with bank statements keep the bank statements only.
+	statements := ofxStatementPattern.FindAllStringSubmatch(content, -1)
+	if len(statements) == 0 && ofxInvestmentPattern.MatchString(content) {
+		return nil, fmt.Errorf("OFX investment statements are not supported")
+	}
+	for _, stmt := range statements {
+		currency := strings.ToUpper(ofxField(stmt[2], "CURDEF"))
+		if currency == "" {
+			currency = "PEN"
+		}
+
+		for _, trn := range ofxTransactionPattern.FindAllStringSubmatch(stmt[2], -1) {
+			tx := parseOFXTransaction(trn[1], currency)
+			if tx != nil {
+				transactions = append(transactions, *tx)
+			}
+		}
+	}
+
+	return transactions, nil
+}
+
+// parseOFXTransaction maps a single <STMTTRN> block to a ParsedTransaction
+func parseOFXTransaction(block string, defaultCurrency string) *ParsedTransaction {
+	date := parseOFXDate(ofxField(block, "DTPOSTED"))
+	if date == "" {
+		return nil
+	}
+
+	amountStr := ofxField(block, "TRNAMT")
+	// OFX allows a comma as decimal separator
+	if strings.Contains(amountStr, ",") && !strings.Contains(amountStr, ".") {
+		amountStr = strings.ReplaceAll(amountStr, ",", ".")
+	}
+	amount, txType := parseAmount(amountStr)
+	if amount == 0 {
+		return nil
+	}
+
+	name := ofxField(block, "NAME")
+	memo := ofxField(block, "MEMO")
+	description := name
+	if description == "" {
+		description = memo
+	} else if memo != "" && !strings.EqualFold(memo, name) {
+		description = name + " - " + memo
+	}
+	if description == "" {
+		description = strings.TrimSpace(ofxField(block, "TRNTYPE"))
+	}
+
+	// The amount is in the <CURRENCY> aggregate's currency when there is one;
+	// with <ORIGCURRENCY> it is already in the statement's currency
+	currency := defaultCurrency
+	if aggregate := ofxCurrencyPattern.FindStringSubmatch(block); aggregate != nil {
+		if cur := strings.ToUpper(ofxField(aggregate[1], "CURSYM")); cur != "" {
+			currency = cur
+		}
+	}
+
+	return &ParsedTransaction{
+		Date:        date,
+		Description: description,
+		Amount:      amount,
+		Currency:    currency,
+		Type:        txType,
+		RawText:     strings.Join(strings.Fields(block), " "),
+		ExternalID:  ofxField(block, "FITID"),
+	}
+}
+
+// ofxField returns the value of a leaf element, with or without closing tag
+func ofxField(block string, tag string) string {
+	for _, match := range ofxLeafPattern.FindAllStringSubmatch(block, -1) {
+		if strings.EqualFold(match[1], tag) {
+			return strings.TrimSpace(html.UnescapeString(match[2]))
+		}
+	}
+	return ""
+}
+
+// parseOFXDate converts OFX datetime (YYYYMMDD[HHMMSS[.XXX]][[TZ]]) to YYYY-MM-DD
+func parseOFXDate(dateStr string) string {
+	parts := ofxDatePattern.FindStringSubmatch(strings.TrimSpace(dateStr))
+	if len(parts) != 4 {
+		return ""
+	}
+	return parts[1] + "-" + parts[2] + "-" + parts[3]
+}
@@ -0,0 +1,158 @@
+package services
+
+import "testing"
+
+// SGML (OFX 1.x) leaves leaf elements unclosed
+const ofxSGMLStatement = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+CHARSET:1252
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>PEN
+<BANKACCTFROM><BANKID>011<ACCTID>0011-0222-33<ACCTTYPE>CHECKING</BANKACCTFROM>
+<BANKTRANLIST>
+<DTSTART>20250301<DTEND>20250331
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20250303120000[-5:EST]
+<TRNAMT>-125.40
+<FITID>T-1
+<NAME>PLAZA VEA
+<MEMO>SAN ISIDRO
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20250305
+<TRNAMT>-15,99
+<FITID>T-2
+<NAME>NETFLIX.COM
+<CURRENCY><CURRATE>3.75<CURSYM>USD</CURRENCY>
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20250306
+<TRNAMT>-60.00
+<FITID>T-3
+<NAME>AMAZON
+<ORIGCURRENCY><CURRATE>3.75<CURSYM>USD</ORIGCURRENCY>
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20250310
+<TRNAMT>2500.00
+<FITID>T-4
+<NAME>SUELDO &amp; BONOS
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>`
+
+// XML (OFX 2.x) closes every element
+const ofxXMLStatement = `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="211" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+  <CREDITCARDMSGSRSV1><CCSTMTTRNRS><CCSTMTRS>
+    <CURDEF>USD</CURDEF>
+    <CCACCTFROM><ACCTID>4111</ACCTID></CCACCTFROM>
+    <BANKTRANLIST>
+      <STMTTRN>
+        <TRNTYPE>DEBIT</TRNTYPE>
+        <DTPOSTED>20250402</DTPOSTED>
+        <TRNAMT>-42.10</TRNAMT>
+        <FITID>CC-1</FITID>
+        <NAME>SPOTIFY</NAME>
+        <MEMO>spotify</MEMO>
+      </STMTTRN>
+      <STMTTRN>
+        <TRNTYPE>DEBIT</TRNTYPE>
+        <DTPOSTED>20250403</DTPOSTED>
+        <TRNAMT>-150.00</TRNAMT>
+        <FITID>CC-2</FITID>
+        <NAME>RIPLEY</NAME>
+        <CURRENCY><CURRATE>0.27</CURRATE><CURSYM>PEN</CURSYM></CURRENCY>
+      </STMTTRN>
+      <STMTTRN>
+        <TRNTYPE>PAYMENT</TRNTYPE>
+        <DTPOSTED>20250410</DTPOSTED>
+        <TRNAMT>300.00</TRNAMT>
+        <FITID>CC-3</FITID>
+      </STMTTRN>
+      <STMTTRN>
+        <TRNTYPE>DEBIT</TRNTYPE>
+        <DTPOSTED>not a date</DTPOSTED>
+        <TRNAMT>-1.00</TRNAMT>
+      </STMTTRN>
+    </BANKTRANLIST>
+  </CCSTMTRS></CCSTMTTRNRS></CREDITCARDMSGSRSV1>
+</OFX>`
+
+func TestParseOFX(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []ParsedTransaction
+	}{
+		{
+			name:    "sgml with currency and origcurrency aggregates",
+			content: ofxSGMLStatement,
+			want: []ParsedTransaction{
+				{Date: "2025-03-03", Description: "PLAZA VEA - SAN ISIDRO", Amount: 125.40, Currency: "PEN", Type: "expense", ExternalID: "T-1"},
+				{Date: "2025-03-05", Description: "NETFLIX.COM", Amount: 15.99, Currency: "USD", Type: "expense", ExternalID: "T-2"},
+				{Date: "2025-03-06", Description: "AMAZON", Amount: 60, Currency: "PEN", Type: "expense", ExternalID: "T-3"},
+				{Date: "2025-03-10", Description: "SUELDO & BONOS", Amount: 2500, Currency: "PEN", Type: "income", ExternalID: "T-4"},
+			},
+		},
+		{
+			name:    "xml credit card statement",
+			content: ofxXMLStatement,
+			want: []ParsedTransaction{
+				{Date: "2025-04-02", Description: "SPOTIFY", Amount: 42.10, Currency: "USD", Type: "expense", ExternalID: "CC-1"},
+				{Date: "2025-04-03", Description: "RIPLEY", Amount: 150, Currency: "PEN", Type: "expense", ExternalID: "CC-2"},
+				{Date: "2025-04-10", Description: "PAYMENT", Amount: 300, Currency: "USD", Type: "income", ExternalID: "CC-3"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOFX(tt.content)
+			if err != nil {
+				t.Fatalf("ParseOFX: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d transactions %+v, want %d", len(got), got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				g := got[i]
+				if g.Date != want.Date || g.Description != want.Description || g.Amount != want.Amount ||
+					g.Currency != want.Currency || g.Type != want.Type || g.ExternalID != want.ExternalID {
+					t.Errorf("transaction %d = {%s %q %.2f %s %s %s}, want {%s %q %.2f %s %s %s}", i,
+						g.Date, g.Description, g.Amount, g.Currency, g.Type, g.ExternalID,
+						want.Date, want.Description, want.Amount, want.Currency, want.Type, want.ExternalID)
+				}
+			}
+		})
+	}
+}
+
+func TestParseOFXErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"missing root", "<STMTRS><CURDEF>PEN</STMTRS>"},
+		{"investment statement", "<OFX><INVSTMTMSGSRSV1><INVSTMTTRNRS><INVSTMTRS>" +
+			"<CURDEF>USD<INVTRANLIST><BUYSTOCK><INVBUY><UNITS>10</INVBUY></BUYSTOCK></INVTRANLIST>" +
+			"</INVSTMTRS></INVSTMTTRNRS></INVSTMTMSGSRSV1></OFX>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseOFX(tt.content); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/warren/finance-app/internal/database"
+)
+
+// PDFExtractor reads the text layer of a PDF and renders pages to images
+type PDFExtractor interface {
+	// PageTexts returns the text layer of every page (empty for scanned pages)
+	PageTexts(pdfPath string) ([]string, error)
+	// RenderPage renders a 1-indexed page to a PNG inside outDir and returns its path
+	RenderPage(pdfPath string, page int, outDir string) (string, error)
+}
+
+// DefaultPDF is the extractor used by ProcessPDFFile
+var DefaultPDF PDFExtractor = NewPopplerPDF()
+
+// PopplerPDF uses the poppler-utils CLIs (pdftotext, pdftoppm)
+type PopplerPDF struct {
+	PdfToTextPath string
+	PdfToPPMPath  string
+}
+
+// NewPopplerPDF creates a poppler adapter configured from the environment
+func NewPopplerPDF() *PopplerPDF {
+	return &PopplerPDF{
+		PdfToTextPath: getEnv("PDFTOTEXT_PATH", "pdftotext"),
+		PdfToPPMPath:  getEnv("PDFTOPPM_PATH", "pdftoppm"),
+	}
+}
+
+// PageTexts runs pdftotext in layout mode; pages are separated by form feeds
+func (p *PopplerPDF) PageTexts(pdfPath string) ([]string, error) {
+	if _, err := exec.LookPath(p.PdfToTextPath); err != nil {
+		return nil, fmt.Errorf("importación PDF no disponible. Instala poppler: sudo apt-get install poppler-utils")
+	}
+
+	out, err := runCommand(p.PdfToTextPath, "-layout", "-enc", "UTF-8", pdfPath, "-")
+	if err != nil {
+		return nil, fmt.Errorf("error extracting pdf text: %w", err)
+	}
+
+	pages := strings.Split(out, "\f")
+	// pdftotext terminates the last page with a form feed too
+	if len(pages) > 1 && strings.TrimSpace(pages[len(pages)-1]) == "" {
+		pages = pages[:len(pages)-1]
+	}
+	return pages, nil
+}
+
+// RenderPage runs pdftoppm for a single page at 300 DPI
+func (p *PopplerPDF) RenderPage(pdfPath string, page int, outDir string) (string, error) {
+	prefix := filepath.Join(outDir, "page-"+strconv.Itoa(page))
+	_, err := runCommand(p.PdfToPPMPath, "-f", strconv.Itoa(page), "-l", strconv.Itoa(page),
+		"-r", "300", "-png", "-singlefile", pdfPath, prefix)
+	if err != nil {
+		return "", fmt.Errorf("error rendering pdf page %d: %w", page, err)
+	}
+	return prefix + ".png", nil
+}
+
+func runCommand(name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// pdfLayoutParsers holds per-bank statement layouts. Banks without a layout
+// fall back to the line-based OCR text parser.
+var pdfLayoutParsers = map[string]func(text string) []ParsedTransaction{
+	"bbva": parseBBVACreditCardStatement,
+}
+
+// ProcessPDFFile extracts and parses a PDF bank statement.
+// Scanned pages without a text layer go through the OCR backend.
+func ProcessPDFFile(filePath string, userID int, bankID string, invertSigns bool) ([]ParsedTransaction, int, error) {
+	transactions, err := ParsePDFFile(filePath, bankID, invertSigns)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var importID int
+	err = database.DB.QueryRow(
+		`INSERT INTO imports (user_id, filename, file_type, status, total_transactions)
+		 VALUES ($1, $2, 'pdf', 'completed', $3) RETURNING id`,
+		userID, filePath, len(transactions),
+	).Scan(&importID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error creating import record: %w", err)
+	}
+
+	return transactions, importID, nil
+}
+
+// ParsePDFFile extracts and parses a PDF statement without creating an import
+func ParsePDFFile(filePath string, bankID string, invertSigns bool) ([]ParsedTransaction, error) {
+	text, err := extractPDFText(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var transactions []ParsedTransaction
+	if layout, ok := pdfLayoutParsers[bankID]; ok {
+		// Layout parsers already know the statement's sign convention
+		transactions = layout(text)
+	} else {
+		transactions = parseOCRText(text)
+		if invertSigns {
+			for i := range transactions {
+				transactions[i].Type = invertType(transactions[i].Type)
+			}
+		}
+	}
+
+	return transactions, nil
+}
+
+// extractPDFText returns the text of all pages, using OCR for pages
+// whose text layer is empty or nearly empty
+func extractPDFText(filePath string) (string, error) {
+	pages, err := DefaultPDF.PageTexts(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	var tmpDir string
+	defer func() {
+		if tmpDir != "" {
+			os.RemoveAll(tmpDir)
+		}
+	}()
+
+	for i, page := range pages {
+		if len(strings.Join(strings.Fields(page), "")) >= 20 {
+			continue
+		}
+
+		if tmpDir == "" {
+			tmpDir, err = os.MkdirTemp("", "pdf-ocr-")
+			if err != nil {
+				return "", fmt.Errorf("error creating temp dir: %w", err)
+			}
+		}
+
+		imagePath, err := DefaultPDF.RenderPage(filePath, i+1, tmpDir)
+		if err != nil {
+			return "", err
+		}
+		ocrText, err := DefaultOCR.ExtractText(imagePath)
+		if err != nil {
+			return "", err
+		}
+		pages[i] = ocrText
+	}
+
+	return strings.Join(pages, "\n"), nil
+}
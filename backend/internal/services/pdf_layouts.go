@@ -0,0 +1,174 @@
+package services
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Row start: operation date and optional processing date ("16/11", "16/11/2025", "16NOV")
+	bbvaStatementRowPattern = regexp.MustCompile(`^\s*(\d{1,2}(?:[/-]\d{1,2}(?:[/-]\d{2,4})?|[A-Za-z]{3}))\s+(?:(\d{1,2}(?:[/-]\d{1,2}(?:[/-]\d{2,4})?|[A-Za-z]{3}))\s+)?(\S.*)$`)
+	bbvaStatementAmount     = regexp.MustCompile(`-?\d{1,3}(?:,\d{3})*\.\d{2}-?`)
+	bbvaCutDatePattern      = regexp.MustCompile(`(?i)(?:fecha\s+de\s+corte|cierre|periodo|período)[^\d]{0,40}(\d{1,2})/(\d{1,2})/(\d{4})`)
+	fullDatePattern         = regexp.MustCompile(`(\d{1,2})/(\d{1,2})/(\d{4})`)
+)
+
+// parseBBVACreditCardStatement parses the BBVA credit card "Estado de Cuenta".
+// Rows start with the operation date, then the processing date, description and
+// the amount in either the soles or the dollars column. Charges are positive and
+// payments/refunds are negative (or carry a trailing minus).
+func parseBBVACreditCardStatement(text string) []ParsedTransaction {
+	var transactions []ParsedTransaction
+
+	cutDate := statementCutDate(text)
+	usdColumn := -1
+
+	for _, line := range strings.Split(text, "\n") {
+		upper := strings.ToUpper(line)
+
+		// The column header tells us where the dollar amounts start
+		if strings.Contains(upper, "DESCRIP") && (strings.Contains(upper, "US$") || strings.Contains(upper, "DÓLARES") || strings.Contains(upper, "DOLARES")) {
+			usdColumn = firstIndexOf(upper, "US$", "DÓLARES", "DOLARES")
+			continue
+		}
+
+		match := bbvaStatementRowPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		date := statementRowDate(match[1], cutDate)
+		if date == "" {
+			continue
+		}
+
+		rest := match[3]
+		restOffset := strings.LastIndex(line, rest)
+		amountLocs := bbvaStatementAmount.FindAllStringIndex(rest, -1)
+		if len(amountLocs) == 0 {
+			continue
+		}
+
+		// The transaction amount is the first amount after the description
+		loc := amountLocs[0]
+		description := strings.TrimSpace(rest[:loc[0]])
+		if description == "" || containsAny(strings.ToUpper(description), []string{"SALDO ANTERIOR", "SALDO ACTUAL", "TOTAL"}) {
+			continue
+		}
+
+		amountStr := rest[loc[0]:loc[1]]
+		isCredit := strings.HasPrefix(amountStr, "-") || strings.HasSuffix(amountStr, "-")
+		amount, _ := parseAmount(strings.Trim(amountStr, "-"))
+		if amount == 0 {
+			continue
+		}
+
+		currency := "PEN"
+		// Amounts are right-aligned, so one ending past the "US$" label is in the dollars column
+		if usdColumn >= 0 && restOffset+loc[1] > usdColumn {
+			currency = "USD"
+		} else if strings.Contains(upper, "US$") {
+			currency = "USD"
+		}
+
+		txType := "expense"
+		if isCredit {
+			txType = "income"
+		}
+
+		transactions = append(transactions, ParsedTransaction{
+			Date:        date,
+			Description: strings.Join(strings.Fields(description), " "),
+			Amount:      amount,
+			Currency:    currency,
+			Type:        txType,
+			RawText:     strings.TrimSpace(line),
+		})
+	}
+
+	return transactions
+}
+
+// statementCutDate finds the statement closing date, falling back to the
+// latest full date in the document and finally today
+func statementCutDate(text string) time.Time {
+	if m := bbvaCutDatePattern.FindStringSubmatch(text); m != nil {
+		if t, err := time.Parse("2/1/2006", m[1]+"/"+m[2]+"/"+m[3]); err == nil {
+			return t
+		}
+	}
+
+	var latest time.Time
+	for _, m := range fullDatePattern.FindAllStringSubmatch(text, -1) {
+		if t, err := time.Parse("2/1/2006", m[1]+"/"+m[2]+"/"+m[3]); err == nil && t.After(latest) {
+			latest = t
+		}
+	}
+	if !latest.IsZero() {
+		return latest
+	}
+	return time.Now()
+}
+
+// statementRowDate converts "16/11", "16/11/25", "16/11/2025" or "16NOV" to YYYY-MM-DD.
+// Dates without a year take it from the cut date; months after the cut month
+// belong to the previous year (statements spanning December-January). Returns
+// "" for dates that don't exist, such as 31/02.
+func statementRowDate(value string, cutDate time.Time) string {
+	value = strings.TrimSpace(value)
+	if full := fullDatePattern.FindString(value); full != "" {
+		value = full
+	}
+
+	var day, month, year int
+	parts := strings.FieldsFunc(value, func(r rune) bool { return r == '/' || r == '-' })
+	switch {
+	case len(parts) == 3:
+		day, _ = strconv.Atoi(parts[0])
+		month, _ = strconv.Atoi(parts[1])
+		year, _ = strconv.Atoi(parts[2])
+		if year < 100 {
+			year += 2000
+		}
+	case len(parts) == 2:
+		day, _ = strconv.Atoi(parts[0])
+		month, _ = strconv.Atoi(parts[1])
+	case len(value) >= 4:
+		// "16NOV"
+		i := 0
+		for i < len(value) && value[i] >= '0' && value[i] <= '9' {
+			i++
+		}
+		day, _ = strconv.Atoi(value[:i])
+		month = spanishMonths[strings.ToLower(value[i:])]
+	}
+
+	if day < 1 || day > 31 || month < 1 || month > 12 {
+		return ""
+	}
+
+	if year == 0 {
+		year = cutDate.Year()
+		if time.Month(month) > cutDate.Month() {
+			year--
+		}
+	}
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if date.Day() != day {
+		return ""
+	}
+	return date.Format("2006-01-02")
+}
+
+// firstIndexOf returns the smallest index of any of the substrings, or -1
+func firstIndexOf(s string, substrs ...string) int {
+	idx := -1
+	for _, sub := range substrs {
+		if i := strings.Index(s, sub); i >= 0 && (idx == -1 || i < idx) {
+			idx = i
+		}
+	}
+	return idx
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatementRowDate(t *testing.T) {
+	cutDate := time.Date(2026, time.January, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"05/01", "2026-01-05"},
+		{"20/12", "2025-12-20"},
+		{"16/11/25", "2025-11-16"},
+		{"16/11/2025", "2025-11-16"},
+		{"28DIC", "2025-12-28"},
+		{"3ene", "2026-01-03"},
+		{"29/02/2024", "2024-02-29"},
+		{"29/02/2025", ""},
+		{"31/02", ""},
+		{"31/04", ""},
+		{"00/01", ""},
+		{"12/13", ""},
+		{"16XYZ", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := statementRowDate(tt.value, cutDate); got != tt.want {
+				t.Errorf("statementRowDate(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBBVACreditCardStatement(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []ParsedTransaction
+	}{
+		{
+			name: "soles and dollars columns across the year boundary",
+			text: "ESTADO DE CUENTA - TARJETA DE CREDITO\n" +
+				"Fecha de corte: 15/01/2026\n" +
+				"FECHA  PROCESO  DESCRIPCION                         S/             US$\n" +
+				"20/12  21/12    PLAZA VEA SAN ISIDRO             125.40\n" +
+				"28DIC  29DIC    NETFLIX.COM                                      15.99\n" +
+				"05/01  05/01    PAGO TARJETA                   1,500.00-\n" +
+				"10/01/2026      DEVOLUCION   TIENDA              -50.00\n",
+			want: []ParsedTransaction{
+				{Date: "2025-12-20", Description: "PLAZA VEA SAN ISIDRO", Amount: 125.40, Currency: "PEN", Type: "expense"},
+				{Date: "2025-12-28", Description: "NETFLIX.COM", Amount: 15.99, Currency: "USD", Type: "expense"},
+				{Date: "2026-01-05", Description: "PAGO TARJETA", Amount: 1500, Currency: "PEN", Type: "income"},
+				{Date: "2026-01-10", Description: "DEVOLUCION TIENDA", Amount: 50, Currency: "PEN", Type: "income"},
+			},
+		},
+		{
+			name: "balances, impossible dates and rows without amounts are skipped",
+			text: "Fecha de corte: 15/03/2025\n" +
+				"01/03  SALDO ANTERIOR                      2,000.00\n" +
+				"31/02  02/03  CARGO FANTASMA                 10.00\n" +
+				"05/03  05/03  SIN IMPORTE\n" +
+				"06/03  07/03  RAPPI*LIMA                     45.90\n" +
+				"15/03  SALDO ACTUAL                        2,045.90\n",
+			want: []ParsedTransaction{
+				{Date: "2025-03-06", Description: "RAPPI*LIMA", Amount: 45.90, Currency: "PEN", Type: "expense"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseBBVACreditCardStatement(tt.text)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d transactions %+v, want %d", len(got), got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				g := got[i]
+				if g.Date != want.Date || g.Description != want.Description || g.Amount != want.Amount ||
+					g.Currency != want.Currency || g.Type != want.Type {
+					t.Errorf("transaction %d = {%s %q %.2f %s %s}, want {%s %q %.2f %s %s}", i,
+						g.Date, g.Description, g.Amount, g.Currency, g.Type,
+						want.Date, want.Description, want.Amount, want.Currency, want.Type)
+				}
+			}
+		})
+	}
+}
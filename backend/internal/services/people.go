@@ -0,0 +1,548 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/warren/finance-app/internal/database"
+)
+
+// Shared expense directions
+const (
+	SharedOwesMe = "owes_me" // The person owes the amount to the user
+	SharedIOwe   = "i_owe"   // The user owes the amount to the person
+)
+
+// Settlement directions
+const (
+	SettlementReceived = "received" // The person paid the user
+	SettlementPaid     = "paid"     // The user paid the person
+)
+
+var (
+	// ErrPersonNotFound is returned when a person doesn't exist or belongs to another user
+	ErrPersonNotFound = errors.New("person not found")
+	// ErrPersonExists is returned when the user already has a person with the name
+	ErrPersonExists = errors.New("a person with that name already exists")
+	// ErrSharedExpenseNotFound is returned when a shared expense doesn't exist or belongs to another user
+	ErrSharedExpenseNotFound = errors.New("shared expense not found")
+	// ErrSettlementNotFound is returned when a settlement doesn't exist or belongs to another user
+	ErrSettlementNotFound = errors.New("settlement not found")
+)
+
+// Person is someone the user shares expenses with
+type Person struct {
+	ID        int             `json:"id"`
+	Name      string          `json:"name"`
+	Note      *string         `json:"note,omitempty"`
+	Balances  []PersonBalance `json:"balances"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// PersonBalance is what a person owes the user in a currency; negative when
+// the user owes the person
+type PersonBalance struct {
+	Currency string  `json:"currency"`
+	Balance  float64 `json:"balance"`
+}
+
+// SharedExpense is the part of an expense owed by or to a person
+type SharedExpense struct {
+	ID            int       `json:"id"`
+	PersonID      int       `json:"person_id"`
+	PersonName    string    `json:"person_name"`
+	TransactionID *int      `json:"transaction_id,omitempty"` // Nil when someone else paid
+	Direction     string    `json:"direction"`                // owes_me, i_owe
+	Amount        float64   `json:"amount"`
+	Currency      string    `json:"currency"`
+	Date          string    `json:"date"`
+	Description   string    `json:"description"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Settlement is a payment between the user and a person that squares shared expenses
+type Settlement struct {
+	ID            int       `json:"id"`
+	PersonID      int       `json:"person_id"`
+	Direction     string    `json:"direction"` // received, paid
+	Amount        float64   `json:"amount"`
+	Currency      string    `json:"currency"`
+	Date          string    `json:"date"`
+	TransactionID *int      `json:"transaction_id,omitempty"` // Transfer that paid it
+	Note          *string   `json:"note,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// PersonMovement is a shared expense or settlement in a person's history
+type PersonMovement struct {
+	Kind          string    `json:"kind"` // shared_expense, settlement
+	ID            int       `json:"id"`
+	Date          string    `json:"date"`
+	Currency      string    `json:"currency"`
+	Amount        float64   `json:"amount"`  // Positive when it increases what the person owes
+	Balance       float64   `json:"balance"` // Balance in the currency after the movement
+	Description   *string   `json:"description,omitempty"`
+	TransactionID *int      `json:"transaction_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// InvalidShareError explains why a shared expense or settlement can't be recorded
+type InvalidShareError struct {
+	Reason string
+}
+
+func (e *InvalidShareError) Error() string {
+	return e.Reason
+}
+
+const personColumns = `id, name, note, created_at, updated_at`
+
+func scanPerson(row rowScanner) (Person, error) {
+	var p Person
+	err := row.Scan(&p.ID, &p.Name, &p.Note, &p.CreatedAt, &p.UpdatedAt)
+	p.Balances = []PersonBalance{}
+	return p, err
+}
+
+// Normalize trims the person's fields and validates them
+func (p *Person) Normalize() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(p.Name) > 100 {
+		return errors.New("name is too long")
+	}
+	if p.Note != nil {
+		note := strings.TrimSpace(*p.Note)
+		p.Note = &note
+		if note == "" {
+			p.Note = nil
+		}
+	}
+	return nil
+}
+
+// loadBalances fills the balances of the people, leaving out settled currencies
+func loadBalances(userID int, people []Person) error {
+	if len(people) == 0 {
+		return nil
+	}
+	ids := make([]int, len(people))
+	index := make(map[int]int, len(people))
+	for i, p := range people {
+		ids[i] = p.ID
+		index[p.ID] = i
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT person_id, currency, SUM(amount)
+		FROM person_movements
+		WHERE user_id = $1 AND person_id = ANY($2)
+		GROUP BY person_id, currency
+		HAVING SUM(amount) <> 0
+		ORDER BY person_id, currency`, userID, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var personID int
+		var balance PersonBalance
+		if err := rows.Scan(&personID, &balance.Currency, &balance.Balance); err != nil {
+			return err
+		}
+		i := index[personID]
+		people[i].Balances = append(people[i].Balances, balance)
+	}
+	return rows.Err()
+}
+
+// ListPeople returns the user's people by name, with their balances
+func ListPeople(userID int) ([]Person, error) {
+	rows, err := database.DB.Query(
+		`SELECT `+personColumns+` FROM people WHERE user_id = $1 ORDER BY name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	people := []Person{}
+	for rows.Next() {
+		person, err := scanPerson(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		people = append(people, person)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadBalances(userID, people); err != nil {
+		return nil, err
+	}
+	return people, nil
+}
+
+// GetPerson returns one of the user's people with their balances
+func GetPerson(userID int, id int) (Person, error) {
+	person, err := scanPerson(database.DB.QueryRow(
+		`SELECT `+personColumns+` FROM people WHERE id = $1 AND user_id = $2`, id, userID))
+	if err == sql.ErrNoRows {
+		return person, ErrPersonNotFound
+	}
+	if err != nil {
+		return person, err
+	}
+
+	people := []Person{person}
+	err = loadBalances(userID, people)
+	return people[0], err
+}
+
+// CreatePerson stores a new person for the user
+func CreatePerson(userID int, person Person) (Person, error) {
+	created, err := scanPerson(database.DB.QueryRow(`
+		INSERT INTO people (user_id, name, note) VALUES ($1, $2, $3)
+		RETURNING `+personColumns, userID, person.Name, person.Note))
+	if isUniqueViolation(err) {
+		return Person{}, ErrPersonExists
+	}
+	return created, err
+}
+
+// UpdatePerson renames a person or changes their note
+func UpdatePerson(userID int, id int, person Person) (Person, error) {
+	_, err := database.DB.Exec(`
+		UPDATE people SET name = $1, note = $2, updated_at = NOW()
+		WHERE id = $3 AND user_id = $4`, person.Name, person.Note, id, userID)
+	if isUniqueViolation(err) {
+		return Person{}, ErrPersonExists
+	}
+	if err != nil {
+		return Person{}, err
+	}
+	return GetPerson(userID, id)
+}
+
+// DeletePerson removes a person with their shared expenses and settlements
+func DeletePerson(userID int, id int) error {
+	result, err := database.DB.Exec(`DELETE FROM people WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrPersonNotFound
+	}
+	return nil
+}
+
+// PersonHistory returns the shared expenses and settlements with a person,
+// newest first, with the balance after each one
+func PersonHistory(userID int, personID int) ([]PersonMovement, error) {
+	if _, err := GetPerson(userID, personID); err != nil {
+		return nil, err
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT kind, id, to_char(date, 'YYYY-MM-DD'), currency, amount,
+		       SUM(amount) OVER (PARTITION BY currency ORDER BY date, created_at, kind, id),
+		       description, transaction_id, created_at
+		FROM person_movements
+		WHERE user_id = $1 AND person_id = $2
+		ORDER BY date DESC, created_at DESC, kind DESC, id DESC`, userID, personID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movements := []PersonMovement{}
+	for rows.Next() {
+		var m PersonMovement
+		if err := rows.Scan(&m.Kind, &m.ID, &m.Date, &m.Currency, &m.Amount, &m.Balance,
+			&m.Description, &m.TransactionID, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		movements = append(movements, m)
+	}
+	return movements, rows.Err()
+}
+
+const sharedExpenseSelect = `
+	SELECT s.id, s.person_id, p.name, s.transaction_id, s.direction, s.amount, s.currency,
+	       to_char(s.date, 'YYYY-MM-DD'), s.description, s.created_at
+	FROM shared_expenses s
+	JOIN people p ON p.id = s.person_id`
+
+func scanSharedExpense(row rowScanner) (SharedExpense, error) {
+	var s SharedExpense
+	err := row.Scan(&s.ID, &s.PersonID, &s.PersonName, &s.TransactionID, &s.Direction, &s.Amount, &s.Currency,
+		&s.Date, &s.Description, &s.CreatedAt)
+	return s, err
+}
+
+// ListTransactionShares returns the shares of one of the user's transactions
+func ListTransactionShares(userID int, transactionID int) ([]SharedExpense, error) {
+	rows, err := database.DB.Query(sharedExpenseSelect+`
+		WHERE s.user_id = $1 AND s.transaction_id = $2
+		ORDER BY p.name`, userID, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []SharedExpense{}
+	for rows.Next() {
+		share, err := scanSharedExpense(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// SetTransactionShares replaces the parts of a transaction owed by or to
+// people. Shares of an expense are owed to the user; shares of an income (money
+// collected for others) are owed by the user. Together they can't exceed the
+// transaction's amount; an empty list removes them.
+func SetTransactionShares(userID int, transactionID int, shares []SharedExpense) ([]SharedExpense, error) {
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer dbTx.Rollback()
+
+	var txType, currency, date, description string
+	var amount float64
+	err = dbTx.QueryRow(`
+		SELECT type, currency, to_char(date, 'YYYY-MM-DD'), COALESCE(NULLIF(detail, ''), description), amount
+		FROM transactions WHERE id = $1 AND user_id = $2 FOR UPDATE`, transactionID, userID).Scan(
+		&txType, &currency, &date, &description, &amount)
+	if err == sql.ErrNoRows {
+		return nil, &InvalidShareError{"transaction not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	direction := SharedOwesMe
+	if txType == "income" {
+		direction = SharedIOwe
+	}
+
+	total := 0.0
+	seen := make(map[int]bool)
+	personIDs := []int{}
+	for i := range shares {
+		shares[i].Amount = math.Round(shares[i].Amount*100) / 100
+		if shares[i].Amount <= 0 {
+			return nil, &InvalidShareError{"share amounts must be positive"}
+		}
+		if seen[shares[i].PersonID] {
+			return nil, &InvalidShareError{"each person can have one share of a transaction"}
+		}
+		seen[shares[i].PersonID] = true
+		personIDs = append(personIDs, shares[i].PersonID)
+		total += shares[i].Amount
+	}
+	if total > amount+0.005 {
+		return nil, &InvalidShareError{fmt.Sprintf("shares add up to %.2f, more than the transaction's %.2f", total, amount)}
+	}
+	if len(personIDs) > 0 {
+		var owned int
+		dbTx.QueryRow(`SELECT COUNT(*) FROM people WHERE user_id = $1 AND id = ANY($2)`,
+			userID, pq.Array(personIDs)).Scan(&owned)
+		if owned != len(personIDs) {
+			return nil, &InvalidShareError{"person not found"}
+		}
+	}
+
+	if _, err := dbTx.Exec(`DELETE FROM shared_expenses WHERE transaction_id = $1`, transactionID); err != nil {
+		return nil, err
+	}
+	for _, share := range shares {
+		_, err := dbTx.Exec(`
+			INSERT INTO shared_expenses (user_id, person_id, transaction_id, direction, amount, currency, date, description)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			userID, share.PersonID, transactionID, direction, share.Amount, currency, date, description)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Shares are part of the transaction, so a re-parse started before this
+	// edit must not overwrite it
+	if _, err := dbTx.Exec(`UPDATE transactions SET updated_at = NOW() WHERE id = $1`, transactionID); err != nil {
+		return nil, err
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		return nil, err
+	}
+	return ListTransactionShares(userID, transactionID)
+}
+
+// normalizeMovement validates the direction, amount, currency and date shared
+// by shared expenses and settlements, defaulting the currency to PEN and the
+// date to today
+func normalizeMovement(direction string, directions []string, amount *float64, currency *string, date *string) error {
+	valid := false
+	for _, d := range directions {
+		valid = valid || direction == d
+	}
+	if !valid {
+		return &InvalidShareError{"direction must be " + strings.Join(directions, " or ")}
+	}
+
+	*amount = math.Round(*amount*100) / 100
+	if *amount <= 0 {
+		return &InvalidShareError{"amount must be positive"}
+	}
+
+	*currency = strings.ToUpper(strings.TrimSpace(*currency))
+	if *currency == "" {
+		*currency = "PEN"
+	}
+	if len(*currency) != 3 {
+		return &InvalidShareError{"invalid currency"}
+	}
+
+	if *date == "" {
+		*date = time.Now().Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", *date); err != nil {
+		return &InvalidShareError{"date must be YYYY-MM-DD"}
+	}
+	return nil
+}
+
+// CreateSharedExpense records an expense shared with a person that isn't one
+// of the user's transactions (e.g. a dinner someone else paid)
+func CreateSharedExpense(userID int, personID int, share SharedExpense) (SharedExpense, error) {
+	if err := normalizeMovement(share.Direction, []string{SharedOwesMe, SharedIOwe},
+		&share.Amount, &share.Currency, &share.Date); err != nil {
+		return SharedExpense{}, err
+	}
+	share.Description = strings.TrimSpace(share.Description)
+	if share.Description == "" {
+		return SharedExpense{}, &InvalidShareError{"description is required"}
+	}
+	if _, err := GetPerson(userID, personID); err != nil {
+		return SharedExpense{}, err
+	}
+
+	var id int
+	err := database.DB.QueryRow(`
+		INSERT INTO shared_expenses (user_id, person_id, direction, amount, currency, date, description)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`, userID, personID, share.Direction, share.Amount, share.Currency, share.Date,
+		share.Description).Scan(&id)
+	if err != nil {
+		return SharedExpense{}, err
+	}
+	return scanSharedExpense(database.DB.QueryRow(sharedExpenseSelect+` WHERE s.id = $1`, id))
+}
+
+// DeleteSharedExpense removes a shared expense
+func DeleteSharedExpense(userID int, id int) error {
+	result, err := database.DB.Exec(`DELETE FROM shared_expenses WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrSharedExpenseNotFound
+	}
+	return nil
+}
+
+const settlementColumns = `id, person_id, direction, amount, currency, to_char(date, 'YYYY-MM-DD'), transaction_id, note, created_at`
+
+func scanSettlement(row rowScanner) (Settlement, error) {
+	var s Settlement
+	err := row.Scan(&s.ID, &s.PersonID, &s.Direction, &s.Amount, &s.Currency, &s.Date, &s.TransactionID, &s.Note,
+		&s.CreatedAt)
+	return s, err
+}
+
+// CreateSettlement records a payment between the user and a person. When it
+// points to a transaction (the Yape or bank transfer), the transaction sets
+// the currency and, unless given, the amount and date: money received is an
+// income, money paid an expense.
+func CreateSettlement(userID int, personID int, settlement Settlement) (Settlement, error) {
+	if _, err := GetPerson(userID, personID); err != nil {
+		return Settlement{}, err
+	}
+
+	if settlement.TransactionID != nil {
+		var txType, currency, date string
+		var amount float64
+		err := database.DB.QueryRow(`
+			SELECT type, currency, to_char(date, 'YYYY-MM-DD'), amount
+			FROM transactions WHERE id = $1 AND user_id = $2`, *settlement.TransactionID, userID).Scan(
+			&txType, &currency, &date, &amount)
+		if err == sql.ErrNoRows {
+			return Settlement{}, &InvalidShareError{"transaction not found"}
+		}
+		if err != nil {
+			return Settlement{}, err
+		}
+
+		switch {
+		case settlement.Direction == SettlementReceived && txType != "income":
+			return Settlement{}, &InvalidShareError{"money received is paid by an income"}
+		case settlement.Direction == SettlementPaid && txType != "expense":
+			return Settlement{}, &InvalidShareError{"money paid is paid by an expense"}
+		case settlement.Amount > amount+0.005:
+			return Settlement{}, &InvalidShareError{fmt.Sprintf("the settlement is more than the transaction's %.2f", amount)}
+		}
+		settlement.Currency = currency
+		if settlement.Amount == 0 {
+			settlement.Amount = amount
+		}
+		if settlement.Date == "" {
+			settlement.Date = date
+		}
+	}
+
+	if err := normalizeMovement(settlement.Direction, []string{SettlementReceived, SettlementPaid},
+		&settlement.Amount, &settlement.Currency, &settlement.Date); err != nil {
+		return Settlement{}, err
+	}
+	if settlement.Note != nil {
+		note := strings.TrimSpace(*settlement.Note)
+		settlement.Note = &note
+		if note == "" {
+			settlement.Note = nil
+		}
+	}
+
+	created, err := scanSettlement(database.DB.QueryRow(`
+		INSERT INTO settlements (user_id, person_id, direction, amount, currency, date, transaction_id, note)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING `+settlementColumns,
+		userID, personID, settlement.Direction, settlement.Amount, settlement.Currency, settlement.Date,
+		settlement.TransactionID, settlement.Note))
+	if isUniqueViolation(err) {
+		return Settlement{}, &InvalidShareError{"the transaction already pays another settlement"}
+	}
+	return created, err
+}
+
+// DeleteSettlement removes a settlement; its transaction is kept
+func DeleteSettlement(userID int, id int) error {
+	result, err := database.DB.Exec(`DELETE FROM settlements WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrSettlementNotFound
+	}
+	return nil
+}
@@ -0,0 +1,1130 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/warren/finance-app/internal/database"
+	"github.com/warren/finance-app/internal/models"
+)
+
+// Recurring frequencies
+const (
+	RecurringWeekly  = "weekly"
+	RecurringMonthly = "monthly"
+	RecurringYearly  = "yearly"
+)
+
+// Recurring modes
+const (
+	RecurringCreate = "create" // Create the transaction when due
+	RecurringExpect = "expect" // Wait for an imported or manual transaction to match
+)
+
+// Recurring template statuses
+const (
+	RecurringActive    = "active"
+	RecurringPaused    = "paused"
+	RecurringSuggested = "suggested"
+	RecurringDismissed = "dismissed"
+)
+
+// Occurrence statuses
+const (
+	OccurrenceExpected = "expected"
+	OccurrenceMatched  = "matched"
+	OccurrenceCreated  = "created"
+	OccurrenceSkipped  = "skipped"
+)
+
+const (
+	// RecurringMatchWindow is how many days a transaction may be away from the
+	// due date of the occurrence it matches
+	RecurringMatchWindow = 5
+	// RecurringUpcomingDays is how far ahead the dashboard lists upcoming occurrences
+	RecurringUpcomingDays = 30
+	// DefaultRecurringTolerance is the amount tolerance of templates that don't set one
+	DefaultRecurringTolerance = 0.1
+
+	// recurringSchedulerInterval is how often due occurrences are generated
+	recurringSchedulerInterval = time.Hour
+	// recurringLookbackDays is how much history detection scans
+	recurringLookbackDays = 800
+	// recurringMaxAmountDeviation is how far from the median a detected series'
+	// amounts may be (utilities vary, subscriptions don't)
+	recurringMaxAmountDeviation = 0.3
+)
+
+var (
+	// ErrRecurringNotFound is returned when a template doesn't exist or belongs to another user
+	ErrRecurringNotFound = errors.New("recurring template not found")
+	// ErrOccurrenceNotFound is returned when an occurrence doesn't exist or belongs to another user
+	ErrOccurrenceNotFound = errors.New("occurrence not found")
+)
+
+// recurringPeriods are the schedules detection recognizes: the typical days
+// between charges and how far an interval may be from them
+var recurringPeriods = []struct {
+	frequency string
+	every     int
+	days      float64
+	tolerance float64
+}{
+	{RecurringWeekly, 1, 7, 1},
+	{RecurringWeekly, 2, 14, 2},
+	{RecurringMonthly, 1, 30.44, 4},
+	{RecurringMonthly, 2, 60.88, 6},
+	{RecurringMonthly, 3, 91.31, 8},
+	{RecurringMonthly, 6, 182.62, 12},
+	{RecurringYearly, 1, 365.25, 15},
+}
+
+// RecurringTemplate is a transaction that repeats on a schedule: weekly from
+// the start date's weekday, or monthly and yearly on a day of the month, every
+// Every weeks, months or years
+type RecurringTemplate struct {
+	ID              int       `json:"id"`
+	UserID          int       `json:"-"`
+	Name            string    `json:"name"`
+	Description     string    `json:"description"`
+	Amount          float64   `json:"amount"`
+	Currency        string    `json:"currency"`
+	Type            string    `json:"type"` // income, expense
+	AccountID       *int      `json:"account_id,omitempty"`
+	MerchantID      *int      `json:"merchant_id,omitempty"`
+	TagIDs          []int     `json:"tag_ids"`
+	Frequency       string    `json:"frequency"` // weekly, monthly, yearly
+	Every           int       `json:"every"`
+	DayOfMonth      *int      `json:"day_of_month,omitempty"` // Monthly and yearly
+	Month           *int      `json:"month,omitempty"`        // Yearly
+	StartDate       string    `json:"start_date"`
+	EndDate         *string   `json:"end_date,omitempty"`
+	Mode            string    `json:"mode"` // create, expect
+	AmountTolerance float64   `json:"amount_tolerance"`
+	Status          string    `json:"status"` // active, paused, suggested, dismissed
+	ResumedOn       *string   `json:"resumed_on,omitempty"`
+	Source          string    `json:"source"` // manual, detected
+	NextDate        *string   `json:"next_date,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// RecurringOccurrence is a due date of a template and what happened on it
+type RecurringOccurrence struct {
+	ID            int       `json:"id"`
+	TemplateID    int       `json:"template_id"`
+	DueDate       string    `json:"due_date"`
+	Amount        float64   `json:"amount"`
+	Status        string    `json:"status"` // expected, matched, created, skipped
+	TransactionID *int      `json:"transaction_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// RecurringDetection is the result of scanning the history for recurring transactions
+type RecurringDetection struct {
+	Detected  int                 `json:"detected"`
+	Suggested []RecurringTemplate `json:"suggested"`
+}
+
+const recurringColumns = `id, user_id, name, description, amount, currency, type, account_id, merchant_id, tag_ids,
+	frequency, every, day_of_month, month, to_char(start_date, 'YYYY-MM-DD'), to_char(end_date, 'YYYY-MM-DD'),
+	mode, amount_tolerance, status, to_char(resumed_on, 'YYYY-MM-DD'), source, created_at, updated_at`
+
+func scanRecurring(row rowScanner) (RecurringTemplate, error) {
+	var t RecurringTemplate
+	var tagIDs pq.Int64Array
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Description, &t.Amount, &t.Currency, &t.Type, &t.AccountID,
+		&t.MerchantID, &tagIDs, &t.Frequency, &t.Every, &t.DayOfMonth, &t.Month, &t.StartDate, &t.EndDate,
+		&t.Mode, &t.AmountTolerance, &t.Status, &t.ResumedOn, &t.Source, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return t, err
+	}
+
+	t.TagIDs = make([]int, len(tagIDs))
+	for i, id := range tagIDs {
+		t.TagIDs[i] = int(id)
+	}
+	if t.Status == RecurringActive {
+		today := dateOf(time.Now())
+		if dates := t.Occurrences(today, today.AddDate(2, 0, 0)); len(dates) > 0 {
+			next := dates[0].Format("2006-01-02")
+			t.NextDate = &next
+		}
+	}
+	return t, nil
+}
+
+// Normalize validates the template and fills in the defaults: today as the
+// start date and the start date's day and month for monthly and yearly schedules
+func (t *RecurringTemplate) Normalize() error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(t.Name) > 100 {
+		return errors.New("name is too long")
+	}
+	t.Description = strings.TrimSpace(t.Description)
+	if t.Description == "" {
+		t.Description = t.Name
+	}
+
+	t.Amount = math.Round(t.Amount*100) / 100
+	if t.Amount <= 0 {
+		return errors.New("amount must be positive")
+	}
+	t.Currency = strings.ToUpper(strings.TrimSpace(t.Currency))
+	if t.Currency == "" {
+		t.Currency = "PEN"
+	}
+	if len(t.Currency) != 3 {
+		return errors.New("invalid currency")
+	}
+	if t.Type != "income" && t.Type != "expense" {
+		return errors.New("type must be income or expense")
+	}
+
+	if t.Every == 0 {
+		t.Every = 1
+	}
+	if t.Every < 1 || t.Every > 12 {
+		return errors.New("every must be between 1 and 12")
+	}
+
+	if t.StartDate == "" {
+		t.StartDate = time.Now().Format("2006-01-02")
+	}
+	start, err := time.Parse("2006-01-02", t.StartDate)
+	if err != nil {
+		return errors.New("start_date must be YYYY-MM-DD")
+	}
+	if t.EndDate != nil && *t.EndDate == "" {
+		t.EndDate = nil
+	}
+	if t.EndDate != nil {
+		end, err := time.Parse("2006-01-02", *t.EndDate)
+		if err != nil {
+			return errors.New("end_date must be YYYY-MM-DD")
+		}
+		if end.Before(start) {
+			return errors.New("end_date is before start_date")
+		}
+	}
+
+	switch t.Frequency {
+	case RecurringWeekly:
+		t.DayOfMonth, t.Month = nil, nil
+	case RecurringMonthly, RecurringYearly:
+		if t.DayOfMonth == nil {
+			day := start.Day()
+			t.DayOfMonth = &day
+		}
+		if *t.DayOfMonth < 1 || *t.DayOfMonth > 31 {
+			return errors.New("day_of_month must be between 1 and 31")
+		}
+		if t.Frequency == RecurringMonthly {
+			t.Month = nil
+			break
+		}
+		if t.Month == nil {
+			month := int(start.Month())
+			t.Month = &month
+		}
+		if *t.Month < 1 || *t.Month > 12 {
+			return errors.New("month must be between 1 and 12")
+		}
+	default:
+		return errors.New("frequency must be weekly, monthly or yearly")
+	}
+
+	if t.Mode == "" {
+		t.Mode = RecurringExpect
+	}
+	if t.Mode != RecurringCreate && t.Mode != RecurringExpect {
+		return errors.New("mode must be create or expect")
+	}
+	if t.AmountTolerance < 0 || t.AmountTolerance > 1 {
+		return errors.New("amount_tolerance must be between 0 and 1")
+	}
+	return nil
+}
+
+// Occurrences returns the template's due dates between from and to, inclusive
+func (t *RecurringTemplate) Occurrences(from time.Time, to time.Time) []time.Time {
+	start, err := time.Parse("2006-01-02", t.StartDate)
+	if err != nil || t.Every < 1 {
+		return nil
+	}
+	if from.Before(start) {
+		from = start
+	}
+	if t.EndDate != nil {
+		if end, err := time.Parse("2006-01-02", *t.EndDate); err == nil && end.Before(to) {
+			to = end
+		}
+	}
+	if to.Before(from) {
+		return nil
+	}
+
+	var dates []time.Time
+	switch t.Frequency {
+	case RecurringWeekly:
+		step := 7 * t.Every
+		d := start
+		if from.After(start) {
+			days := int(from.Sub(start).Hours() / 24)
+			d = start.AddDate(0, 0, days/step*step)
+		}
+		for ; !d.After(to); d = d.AddDate(0, 0, step) {
+			if !d.Before(from) {
+				dates = append(dates, d)
+			}
+		}
+
+	case RecurringMonthly, RecurringYearly:
+		day := start.Day()
+		if t.DayOfMonth != nil {
+			day = *t.DayOfMonth
+		}
+		step := t.Every
+		month := int(start.Month())
+		if t.Frequency == RecurringYearly {
+			step = 12 * t.Every
+			if t.Month != nil {
+				month = *t.Month
+			}
+		}
+
+		// Months counted from year 0, starting close to from
+		index := start.Year()*12 + month - 1
+		if skip := (from.Year()*12 + int(from.Month()) - 1 - index) / step; skip > 1 {
+			index += (skip - 1) * step
+		}
+		for ; ; index += step {
+			d := dayInMonth(index/12, index%12+1, day)
+			if d.After(to) {
+				break
+			}
+			if !d.Before(from) {
+				dates = append(dates, d)
+			}
+		}
+	}
+	return dates
+}
+
+// dayInMonth returns the day of the month, or its last day when the month is shorter
+func dayInMonth(year int, month int, day int) time.Time {
+	last := time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+// dateOf returns the date of t as midnight UTC, the way DATE columns are read
+func dateOf(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// ListRecurringTemplates returns the user's templates by name, optionally only
+// those with a status
+func ListRecurringTemplates(userID int, status string) ([]RecurringTemplate, error) {
+	query := `SELECT ` + recurringColumns + ` FROM recurring_templates WHERE user_id = $1`
+	args := []interface{}{userID}
+	if status != "" {
+		query += ` AND status = $2`
+		args = append(args, status)
+	}
+	query += ` ORDER BY name, id`
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []RecurringTemplate{}
+	for rows.Next() {
+		template, err := scanRecurring(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
+
+// GetRecurringTemplate returns one of the user's templates
+func GetRecurringTemplate(userID int, id int) (RecurringTemplate, error) {
+	template, err := scanRecurring(database.DB.QueryRow(
+		`SELECT `+recurringColumns+` FROM recurring_templates WHERE id = $1 AND user_id = $2`, id, userID))
+	if err == sql.ErrNoRows {
+		return template, ErrRecurringNotFound
+	}
+	return template, err
+}
+
+// CreateRecurringTemplate stores a new template for the user; manual templates
+// start active
+func CreateRecurringTemplate(userID int, t RecurringTemplate) (RecurringTemplate, error) {
+	if t.Status == "" {
+		t.Status = RecurringActive
+	}
+	if t.Source == "" {
+		t.Source = "manual"
+	}
+	return scanRecurring(database.DB.QueryRow(`
+		INSERT INTO recurring_templates (user_id, name, description, amount, currency, type, account_id, merchant_id,
+		                                 tag_ids, frequency, every, day_of_month, month, start_date, end_date, mode,
+		                                 amount_tolerance, status, source)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, COALESCE($9::integer[], '{}'), $10, $11, $12, $13, $14, $15, $16,
+		        $17, $18, $19)
+		RETURNING `+recurringColumns,
+		userID, t.Name, t.Description, t.Amount, t.Currency, t.Type, t.AccountID, t.MerchantID, pq.Array(t.TagIDs),
+		t.Frequency, t.Every, t.DayOfMonth, t.Month, t.StartDate, t.EndDate, t.Mode, t.AmountTolerance, t.Status,
+		t.Source))
+}
+
+// UpdateRecurringTemplate replaces a template's fields (not its status). Its
+// expected occurrences are dropped so they're generated again on the new schedule.
+func UpdateRecurringTemplate(userID int, id int, t RecurringTemplate) (RecurringTemplate, error) {
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		return RecurringTemplate{}, err
+	}
+	defer dbTx.Rollback()
+
+	updated, err := scanRecurring(dbTx.QueryRow(`
+		UPDATE recurring_templates
+		SET name = $1, description = $2, amount = $3, currency = $4, type = $5, account_id = $6, merchant_id = $7,
+		    tag_ids = COALESCE($8::integer[], '{}'), frequency = $9, every = $10, day_of_month = $11, month = $12,
+		    start_date = $13, end_date = $14, mode = $15, amount_tolerance = $16, updated_at = NOW()
+		WHERE id = $17 AND user_id = $18
+		RETURNING `+recurringColumns,
+		t.Name, t.Description, t.Amount, t.Currency, t.Type, t.AccountID, t.MerchantID, pq.Array(t.TagIDs),
+		t.Frequency, t.Every, t.DayOfMonth, t.Month, t.StartDate, t.EndDate, t.Mode, t.AmountTolerance, id, userID))
+	if err == sql.ErrNoRows {
+		return RecurringTemplate{}, ErrRecurringNotFound
+	}
+	if err != nil {
+		return RecurringTemplate{}, err
+	}
+
+	if _, err := dbTx.Exec(`DELETE FROM recurring_occurrences WHERE template_id = $1 AND status = 'expected'`, id); err != nil {
+		return RecurringTemplate{}, err
+	}
+	return updated, dbTx.Commit()
+}
+
+// SetRecurringStatus activates (accepting a suggestion), pauses or dismisses a
+// template. Resuming a paused template records the date, so the occurrences
+// of the paused period are never generated.
+func SetRecurringStatus(userID int, id int, status string) (RecurringTemplate, error) {
+	template, err := scanRecurring(database.DB.QueryRow(`
+		UPDATE recurring_templates
+		SET status = $1, updated_at = NOW(),
+		    resumed_on = CASE WHEN $1 = 'active' AND status = 'paused' THEN CURRENT_DATE ELSE resumed_on END
+		WHERE id = $2 AND user_id = $3
+		RETURNING `+recurringColumns, status, id, userID))
+	if err == sql.ErrNoRows {
+		return template, ErrRecurringNotFound
+	}
+	return template, err
+}
+
+// DeleteRecurringTemplate removes a template with its occurrences; created
+// and matched transactions are kept
+func DeleteRecurringTemplate(userID int, id int) error {
+	result, err := database.DB.Exec(`DELETE FROM recurring_templates WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrRecurringNotFound
+	}
+	return nil
+}
+
+const occurrenceColumns = `id, template_id, to_char(due_date, 'YYYY-MM-DD'), amount, status, transaction_id, created_at, updated_at`
+
+func scanOccurrence(row rowScanner) (RecurringOccurrence, error) {
+	var o RecurringOccurrence
+	err := row.Scan(&o.ID, &o.TemplateID, &o.DueDate, &o.Amount, &o.Status, &o.TransactionID, &o.CreatedAt, &o.UpdatedAt)
+	return o, err
+}
+
+// ListRecurringOccurrences returns the occurrences of one of the user's templates, newest first
+func ListRecurringOccurrences(userID int, templateID int) ([]RecurringOccurrence, error) {
+	if _, err := GetRecurringTemplate(userID, templateID); err != nil {
+		return nil, err
+	}
+
+	rows, err := database.DB.Query(`SELECT `+occurrenceColumns+` FROM recurring_occurrences
+		WHERE template_id = $1 ORDER BY due_date DESC`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	occurrences := []RecurringOccurrence{}
+	for rows.Next() {
+		occurrence, err := scanOccurrence(rows)
+		if err != nil {
+			return nil, err
+		}
+		occurrences = append(occurrences, occurrence)
+	}
+	return occurrences, rows.Err()
+}
+
+// SkipOccurrence marks an expected occurrence as not happening (e.g. a month
+// without the charge), so it isn't matched or listed as pending
+func SkipOccurrence(userID int, id int) (RecurringOccurrence, error) {
+	occurrence, err := scanOccurrence(database.DB.QueryRow(`
+		UPDATE recurring_occurrences SET status = 'skipped', updated_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND status = 'expected'
+		RETURNING `+occurrenceColumns, id, userID))
+	if err == sql.ErrNoRows {
+		return occurrence, ErrOccurrenceNotFound
+	}
+	return occurrence, err
+}
+
+// GenerateRecurring records the due occurrences of the active templates of a
+// user (of every user when userID is 0) up to now. Templates that create
+// transactions create them, from the day the template was created at the
+// earliest; the others get expected occurrences a few days ahead so early
+// transactions can match them. Returns how many occurrences were recorded,
+// along with the errors of the templates that failed.
+func GenerateRecurring(userID int, now time.Time) (int, error) {
+	query := `SELECT ` + recurringColumns + ` FROM recurring_templates WHERE status = 'active'`
+	args := []interface{}{}
+	if userID != 0 {
+		query += ` AND user_id = $1`
+		args = append(args, userID)
+	}
+	rows, err := database.DB.Query(query+` ORDER BY user_id, id`, args...)
+	if err != nil {
+		return 0, err
+	}
+	var templates []RecurringTemplate
+	for rows.Next() {
+		template, err := scanRecurring(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		templates = append(templates, template)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	today := dateOf(now)
+	generated := 0
+	created := make(map[int][]int)
+	expecting := make(map[int]bool)
+	// A failing template is logged and skipped so it can't hold back the
+	// templates after it; the errors are returned together
+	var errs []error
+	failed := func(t *RecurringTemplate, err error) {
+		log.Printf("recurring: error generating occurrences of template %d: %v", t.ID, err)
+		errs = append(errs, fmt.Errorf("template %d: %w", t.ID, err))
+	}
+nextTemplate:
+	for i := range templates {
+		t := &templates[i]
+
+		from, _ := time.Parse("2006-01-02", t.StartDate)
+		var last sql.NullString
+		err := database.DB.QueryRow(`
+			SELECT to_char(MAX(due_date), 'YYYY-MM-DD') FROM recurring_occurrences WHERE template_id = $1`,
+			t.ID).Scan(&last)
+		if err != nil {
+			failed(t, err)
+			continue
+		}
+		if last.Valid {
+			if lastDate, err := time.Parse("2006-01-02", last.String); err == nil {
+				from = lastDate.AddDate(0, 0, 1)
+			}
+		}
+		if t.ResumedOn != nil {
+			if resumed, err := time.Parse("2006-01-02", *t.ResumedOn); err == nil && from.Before(resumed) {
+				from = resumed
+			}
+		}
+
+		horizon := today.AddDate(0, 0, RecurringMatchWindow)
+		if t.Mode == RecurringCreate {
+			horizon = today
+			if createdOn := dateOf(t.CreatedAt); from.Before(createdOn) {
+				from = createdOn
+			}
+		} else {
+			expecting[t.UserID] = true
+		}
+
+		for _, due := range t.Occurrences(from, horizon) {
+			date := due.Format("2006-01-02")
+			if t.Mode == RecurringCreate {
+				txID, err := createRecurringTransaction(t, date)
+				if err != nil {
+					failed(t, err)
+					continue nextTemplate
+				}
+				if txID != 0 {
+					created[t.UserID] = append(created[t.UserID], txID)
+					generated++
+				}
+				continue
+			}
+
+			result, err := database.DB.Exec(`
+				INSERT INTO recurring_occurrences (user_id, template_id, due_date, amount)
+				VALUES ($1, $2, $3, $4)
+				ON CONFLICT (template_id, due_date) DO NOTHING`, t.UserID, t.ID, date, t.Amount)
+			if err != nil {
+				failed(t, err)
+				continue nextTemplate
+			}
+			if n, _ := result.RowsAffected(); n > 0 {
+				generated++
+			}
+		}
+	}
+
+	for user, ids := range created {
+		UpdateTagModel(user, ids)
+	}
+	for user := range expecting {
+		if _, err := MatchRecurring(user, nil); err != nil {
+			log.Printf("recurring: error matching occurrences for user %d: %v", user, err)
+			errs = append(errs, fmt.Errorf("matching user %d: %w", user, err))
+		}
+	}
+	return generated, errors.Join(errs...)
+}
+
+// createRecurringTransaction creates the transaction of a due occurrence.
+// Returns 0 when the occurrence already exists.
+func createRecurringTransaction(t *RecurringTemplate, date string) (int, error) {
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer dbTx.Rollback()
+
+	var occurrenceID int
+	err = dbTx.QueryRow(`
+		INSERT INTO recurring_occurrences (user_id, template_id, due_date, amount, status)
+		VALUES ($1, $2, $3, $4, 'created')
+		ON CONFLICT (template_id, due_date) DO NOTHING
+		RETURNING id`, t.UserID, t.ID, date, t.Amount).Scan(&occurrenceID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var txID int
+	err = dbTx.QueryRow(`
+		INSERT INTO transactions (user_id, account_id, description, detail, amount, currency, type, date, source, merchant_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'recurring', $9)
+		RETURNING id`, t.UserID, t.AccountID, t.Description, t.Name, t.Amount, t.Currency, t.Type, date,
+		t.MerchantID).Scan(&txID)
+	if err != nil {
+		return 0, err
+	}
+	if len(t.TagIDs) > 0 {
+		_, err = dbTx.Exec(`
+			INSERT INTO transaction_tags (transaction_id, tag_id)
+			SELECT $1, id FROM tags WHERE user_id = $2 AND id = ANY($3)`, txID, t.UserID, pq.Array(t.TagIDs))
+		if err != nil {
+			return 0, err
+		}
+	}
+	if _, err := dbTx.Exec(`UPDATE recurring_occurrences SET transaction_id = $1 WHERE id = $2`, txID, occurrenceID); err != nil {
+		return 0, err
+	}
+
+	return txID, dbTx.Commit()
+}
+
+// OccurrenceCandidate is an expected occurrence waiting for its transaction
+type OccurrenceCandidate struct {
+	ID          int
+	DueDate     string
+	Amount      float64
+	Tolerance   float64
+	Type        string
+	Currency    string
+	AccountID   *int
+	MerchantID  *int
+	Description string
+}
+
+// RecurringTransaction is a transaction that may match an expected occurrence
+type RecurringTransaction struct {
+	ID          int
+	Date        string
+	Amount      float64
+	Type        string
+	Currency    string
+	AccountID   *int
+	MerchantID  *int
+	Description string
+}
+
+// MatchRecurring matches the user's expected occurrences with transactions
+// (only the given ones, or any when transactionIDs is nil) and returns how
+// many were matched
+func MatchRecurring(userID int, transactionIDs []int) (int, error) {
+	if transactionIDs != nil && len(transactionIDs) == 0 {
+		return 0, nil
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT o.id, to_char(o.due_date, 'YYYY-MM-DD'), o.amount, rt.amount_tolerance, rt.type, rt.currency,
+		       rt.account_id, rt.merchant_id, rt.description
+		FROM recurring_occurrences o
+		JOIN recurring_templates rt ON rt.id = o.template_id
+		WHERE o.user_id = $1 AND o.status = 'expected'
+		ORDER BY o.due_date, o.id`, userID)
+	if err != nil {
+		return 0, err
+	}
+	var occurrences []OccurrenceCandidate
+	for rows.Next() {
+		var o OccurrenceCandidate
+		if err := rows.Scan(&o.ID, &o.DueDate, &o.Amount, &o.Tolerance, &o.Type, &o.Currency, &o.AccountID,
+			&o.MerchantID, &o.Description); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		occurrences = append(occurrences, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(occurrences) == 0 {
+		return 0, err
+	}
+
+	first, _ := time.Parse("2006-01-02", occurrences[0].DueDate)
+	last, _ := time.Parse("2006-01-02", occurrences[len(occurrences)-1].DueDate)
+	query := `
+		SELECT t.id, to_char(t.date, 'YYYY-MM-DD'), t.amount, t.type, t.currency, t.account_id, t.merchant_id, t.description
+		FROM transactions t
+		WHERE t.user_id = $1 AND t.date BETWEEN $2 AND $3 AND NOT t.is_transfer AND NOT t.ignored
+		  AND NOT EXISTS (SELECT 1 FROM recurring_occurrences o WHERE o.transaction_id = t.id)`
+	args := []interface{}{userID, first.AddDate(0, 0, -RecurringMatchWindow).Format("2006-01-02"),
+		last.AddDate(0, 0, RecurringMatchWindow).Format("2006-01-02")}
+	if transactionIDs != nil {
+		query += ` AND t.id = ANY($4)`
+		args = append(args, pq.Array(transactionIDs))
+	}
+	rows, err = database.DB.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	var transactions []RecurringTransaction
+	for rows.Next() {
+		var t RecurringTransaction
+		if err := rows.Scan(&t.ID, &t.Date, &t.Amount, &t.Type, &t.Currency, &t.AccountID, &t.MerchantID,
+			&t.Description); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		transactions = append(transactions, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	matched := 0
+	for occurrenceID, transactionID := range MatchOccurrences(occurrences, transactions) {
+		result, err := database.DB.Exec(`
+			UPDATE recurring_occurrences SET status = 'matched', transaction_id = $1, updated_at = NOW()
+			WHERE id = $2 AND status = 'expected'`, transactionID, occurrenceID)
+		if isUniqueViolation(err) {
+			continue
+		}
+		if err != nil {
+			return matched, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			matched++
+		}
+	}
+	return matched, nil
+}
+
+// MatchOccurrences pairs expected occurrences, in due date order, with the
+// closest transaction of the same type and currency whose amount is within
+// the tolerance, within RecurringMatchWindow days, and with the occurrence's
+// merchant or description key (see sameDescriptionKey). Returns the transaction ID by occurrence ID.
+func MatchOccurrences(occurrences []OccurrenceCandidate, transactions []RecurringTransaction) map[int]int {
+	matches := make(map[int]int)
+	used := make(map[int]bool)
+	keys := make(map[string]string)
+	key := func(description string) string {
+		k, ok := keys[description]
+		if !ok {
+			k = MerchantKey(description)
+			keys[description] = k
+		}
+		return k
+	}
+
+	for _, o := range occurrences {
+		best := -1
+		bestScore := math.Inf(1)
+		for i, t := range transactions {
+			if used[t.ID] || t.Type != o.Type || t.Currency != o.Currency {
+				continue
+			}
+			if o.AccountID != nil && t.AccountID != nil && *o.AccountID != *t.AccountID {
+				continue
+			}
+			if math.Abs(t.Amount-o.Amount) > o.Amount*o.Tolerance+0.005 {
+				continue
+			}
+			days, ok := daysBetween(t.Date, o.DueDate)
+			if !ok || days > RecurringMatchWindow {
+				continue
+			}
+			sameMerchant := o.MerchantID != nil && t.MerchantID != nil && *o.MerchantID == *t.MerchantID
+			if !sameMerchant && !sameDescriptionKey(key(o.Description), key(t.Description)) {
+				continue
+			}
+
+			score := float64(days) + math.Abs(t.Amount-o.Amount)/o.Amount
+			if score < bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best >= 0 {
+			matches[o.ID] = transactions[best].ID
+			used[transactions[best].ID] = true
+		}
+	}
+	return matches
+}
+
+// sameDescriptionKey reports whether two description keys belong to the same
+// merchant: equal, or one is the other plus more words ("netflix com" and
+// "netflix com lima")
+func sameDescriptionKey(a string, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	return a == b || strings.HasPrefix(b, a+" ") || strings.HasPrefix(a, b+" ")
+}
+
+// UpcomingRecurring returns the occurrences of the user's active templates in
+// the next days that haven't been settled yet, by date
+func UpcomingRecurring(userID int, now time.Time, days int) ([]models.UpcomingCharge, error) {
+	templates, err := ListRecurringTemplates(userID, RecurringActive)
+	if err != nil {
+		return nil, err
+	}
+
+	today := dateOf(now)
+	rows, err := database.DB.Query(`
+		SELECT template_id, to_char(due_date, 'YYYY-MM-DD') FROM recurring_occurrences
+		WHERE user_id = $1 AND status <> 'expected' AND due_date >= $2`, userID, today.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	settled := make(map[string]bool)
+	for rows.Next() {
+		var templateID int
+		var date string
+		if err := rows.Scan(&templateID, &date); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		settled[fmt.Sprintf("%d:%s", templateID, date)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	upcoming := []models.UpcomingCharge{}
+	for i := range templates {
+		t := &templates[i]
+		for _, due := range t.Occurrences(today, today.AddDate(0, 0, days)) {
+			date := due.Format("2006-01-02")
+			if settled[fmt.Sprintf("%d:%s", t.ID, date)] {
+				continue
+			}
+			upcoming = append(upcoming, models.UpcomingCharge{
+				TemplateID: t.ID,
+				Name:       t.Name,
+				Amount:     t.Amount,
+				Currency:   t.Currency,
+				Type:       t.Type,
+				Date:       date,
+				Mode:       t.Mode,
+			})
+		}
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool {
+		if upcoming[i].Date != upcoming[j].Date {
+			return upcoming[i].Date < upcoming[j].Date
+		}
+		return upcoming[i].Name < upcoming[j].Name
+	})
+	return upcoming, nil
+}
+
+// recurringSeries is a group of transactions that may be the same recurring charge
+type recurringSeries struct {
+	transactions []RecurringTransaction
+	name         string
+}
+
+// RecurringPeriod finds the schedule of a series of dates (sorted, at most one
+// per day): the period whose typical interval is closest to the median one,
+// when at least three quarters of the intervals are within its tolerance
+func RecurringPeriod(dates []string) (frequency string, every int, days float64, ok bool) {
+	var intervals []float64
+	for i := 1; i < len(dates); i++ {
+		if d, valid := daysBetween(dates[i], dates[i-1]); valid {
+			intervals = append(intervals, float64(d))
+		}
+	}
+	if len(intervals) == 0 {
+		return "", 0, 0, false
+	}
+	median := medianOf(intervals)
+
+	for _, period := range recurringPeriods {
+		if math.Abs(median-period.days) > period.tolerance {
+			continue
+		}
+		regular := 0
+		for _, interval := range intervals {
+			if math.Abs(interval-period.days) <= period.tolerance {
+				regular++
+			}
+		}
+		if float64(regular) >= 0.75*float64(len(intervals)) {
+			return period.frequency, period.every, period.days, true
+		}
+		return "", 0, 0, false
+	}
+	return "", 0, 0, false
+}
+
+func medianOf(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// DetectRecurring scans the user's history for transactions that repeat on a
+// schedule with a similar amount (subscriptions, rent, utilities) and suggests
+// a template for each series that's still going and isn't covered by one
+func DetectRecurring(userID int, now time.Time, progress func(done int, total int)) (RecurringDetection, error) {
+	detection := RecurringDetection{Suggested: []RecurringTemplate{}}
+	today := dateOf(now)
+
+	rows, err := database.DB.Query(`
+		SELECT t.id, to_char(t.date, 'YYYY-MM-DD'), t.amount, t.type, t.currency, t.account_id, t.merchant_id,
+		       t.description, m.name
+		FROM transactions t
+		LEFT JOIN merchants m ON m.id = t.merchant_id
+		WHERE t.user_id = $1 AND t.date >= $2 AND NOT t.is_transfer AND NOT t.ignored
+		ORDER BY t.date, t.id`, userID, today.AddDate(0, 0, -recurringLookbackDays).Format("2006-01-02"))
+	if err != nil {
+		return detection, err
+	}
+	series := make(map[string]*recurringSeries)
+	var order []string
+	for rows.Next() {
+		var t RecurringTransaction
+		var merchant sql.NullString
+		if err := rows.Scan(&t.ID, &t.Date, &t.Amount, &t.Type, &t.Currency, &t.AccountID, &t.MerchantID,
+			&t.Description, &merchant); err != nil {
+			rows.Close()
+			return detection, err
+		}
+
+		descKey := MerchantKey(t.Description)
+		key := "k:" + descKey
+		name := MerchantName(descKey)
+		if t.MerchantID != nil {
+			key = fmt.Sprintf("m:%d", *t.MerchantID)
+			name = merchant.String
+		}
+		if descKey == "" && t.MerchantID == nil {
+			continue
+		}
+		key = t.Type + "|" + t.Currency + "|" + key
+
+		s, ok := series[key]
+		if !ok {
+			s = &recurringSeries{name: name}
+			series[key] = s
+			order = append(order, key)
+		}
+		// One transaction per day; the same charge twice on a day isn't a schedule
+		if n := len(s.transactions); n > 0 && s.transactions[n-1].Date == t.Date {
+			continue
+		}
+		s.transactions = append(s.transactions, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return detection, err
+	}
+
+	// Series already covered by a template, in any status, aren't suggested again
+	covered := make(map[string]bool)
+	templates, err := ListRecurringTemplates(userID, "")
+	if err != nil {
+		return detection, err
+	}
+	for _, t := range templates {
+		prefix := t.Type + "|" + t.Currency + "|"
+		covered[prefix+"k:"+MerchantKey(t.Description)] = true
+		if t.MerchantID != nil {
+			covered[prefix+fmt.Sprintf("m:%d", *t.MerchantID)] = true
+		}
+	}
+
+	for i, key := range order {
+		if progress != nil && i%50 == 0 {
+			progress(i, len(order))
+		}
+		s := series[key]
+		last := s.transactions[len(s.transactions)-1]
+		if covered[key] || covered[last.Type+"|"+last.Currency+"|k:"+MerchantKey(last.Description)] {
+			continue
+		}
+
+		template, ok := seriesTemplate(s, today)
+		if !ok {
+			continue
+		}
+		var tagIDs pq.Int64Array
+		database.DB.QueryRow(`SELECT ARRAY_AGG(tag_id) FROM transaction_tags WHERE transaction_id = $1`,
+			last.ID).Scan(&tagIDs)
+		for _, id := range tagIDs {
+			template.TagIDs = append(template.TagIDs, int(id))
+		}
+
+		created, err := CreateRecurringTemplate(userID, template)
+		if err != nil {
+			return detection, err
+		}
+		covered[key] = true
+		detection.Detected++
+		detection.Suggested = append(detection.Suggested, created)
+	}
+	if progress != nil {
+		progress(len(order), len(order))
+	}
+	return detection, nil
+}
+
+// seriesTemplate builds the suggested template of a series when its dates
+// follow a schedule, its amounts are alike and its last charge isn't overdue
+func seriesTemplate(s *recurringSeries, today time.Time) (RecurringTemplate, bool) {
+	dates := make([]string, len(s.transactions))
+	amounts := make([]float64, len(s.transactions))
+	for i, t := range s.transactions {
+		dates[i] = t.Date
+		amounts[i] = t.Amount
+	}
+
+	// Two charges a year apart are enough; shorter periods need three
+	frequency, every, periodDays, ok := RecurringPeriod(dates)
+	if !ok || (len(s.transactions) < 3 && frequency != RecurringYearly) {
+		return RecurringTemplate{}, false
+	}
+
+	last := s.transactions[len(s.transactions)-1]
+	lastDate, err := time.Parse("2006-01-02", last.Date)
+	if err != nil || today.Sub(lastDate).Hours()/24 > periodDays*1.5 {
+		return RecurringTemplate{}, false
+	}
+
+	median := medianOf(amounts)
+	deviation := 0.0
+	for _, amount := range amounts {
+		deviation = math.Max(deviation, math.Abs(amount-median)/median)
+	}
+	if median <= 0 || deviation > recurringMaxAmountDeviation {
+		return RecurringTemplate{}, false
+	}
+
+	name := s.name
+	if name == "" {
+		name = last.Description
+	}
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+	template := RecurringTemplate{
+		Name:            name,
+		Description:     last.Description,
+		Amount:          math.Round(median*100) / 100,
+		Currency:        last.Currency,
+		Type:            last.Type,
+		AccountID:       last.AccountID,
+		MerchantID:      last.MerchantID,
+		Frequency:       frequency,
+		Every:           every,
+		StartDate:       last.Date,
+		Mode:            RecurringExpect,
+		AmountTolerance: math.Max(0.05, math.Ceil(deviation*20)/20),
+		Status:          RecurringSuggested,
+		Source:          "detected",
+	}
+	if err := template.Normalize(); err != nil {
+		return RecurringTemplate{}, false
+	}
+	return template, true
+}
+
+// StartRecurringScheduler generates the due occurrences of every user now and
+// then every hour, until ctx is cancelled
+func StartRecurringScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(recurringSchedulerInterval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := GenerateRecurring(0, time.Now()); err != nil {
+				log.Printf("recurring: error generating occurrences: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecurringTemplateOccurrences(t *testing.T) {
+	date := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		name     string
+		template RecurringTemplate
+		from, to string
+		want     []string
+	}{
+		{
+			name:     "weekly from the start weekday",
+			template: RecurringTemplate{Frequency: RecurringWeekly, Every: 1, StartDate: "2025-03-03"},
+			from:     "2025-03-01", to: "2025-03-24",
+			want: []string{"2025-03-03", "2025-03-10", "2025-03-17", "2025-03-24"},
+		},
+		{
+			name:     "every two weeks, from after the start",
+			template: RecurringTemplate{Frequency: RecurringWeekly, Every: 2, StartDate: "2025-01-06"},
+			from:     "2025-03-01", to: "2025-03-31",
+			want: []string{"2025-03-03", "2025-03-17", "2025-03-31"},
+		},
+		{
+			name:     "monthly on the 31st uses the last day of shorter months",
+			template: RecurringTemplate{Frequency: RecurringMonthly, Every: 1, DayOfMonth: intPtr(31), StartDate: "2025-01-31"},
+			from:     "2025-01-01", to: "2025-05-31",
+			want: []string{"2025-01-31", "2025-02-28", "2025-03-31", "2025-04-30", "2025-05-31"},
+		},
+		{
+			name:     "every three months, years after the start",
+			template: RecurringTemplate{Frequency: RecurringMonthly, Every: 3, DayOfMonth: intPtr(15), StartDate: "2020-02-15"},
+			from:     "2025-01-01", to: "2025-12-31",
+			want: []string{"2025-02-15", "2025-05-15", "2025-08-15", "2025-11-15"},
+		},
+		{
+			name:     "monthly day defaults to the start day",
+			template: RecurringTemplate{Frequency: RecurringMonthly, Every: 1, StartDate: "2025-03-10"},
+			from:     "2025-03-11", to: "2025-05-10",
+			want: []string{"2025-04-10", "2025-05-10"},
+		},
+		{
+			name:     "yearly on a month and day, leap day clamped",
+			template: RecurringTemplate{Frequency: RecurringYearly, Every: 1, Month: intPtr(2), DayOfMonth: intPtr(29), StartDate: "2024-01-01"},
+			from:     "2024-01-01", to: "2026-12-31",
+			want: []string{"2024-02-29", "2025-02-28", "2026-02-28"},
+		},
+		{
+			name:     "end date cuts the range",
+			template: RecurringTemplate{Frequency: RecurringMonthly, Every: 1, StartDate: "2025-01-05", EndDate: stringPtr("2025-03-04")},
+			from:     "2025-01-01", to: "2025-12-31",
+			want: []string{"2025-01-05", "2025-02-05"},
+		},
+		{
+			name:     "range before the start",
+			template: RecurringTemplate{Frequency: RecurringMonthly, Every: 1, StartDate: "2025-06-01"},
+			from:     "2025-01-01", to: "2025-05-31",
+			want: nil,
+		},
+		{
+			name:     "invalid every",
+			template: RecurringTemplate{Frequency: RecurringMonthly, Every: 0, StartDate: "2025-01-01"},
+			from:     "2025-01-01", to: "2025-12-31",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, d := range tt.template.Occurrences(date(tt.from), date(tt.to)) {
+				got = append(got, d.Format("2006-01-02"))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Occurrences = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecurringPeriod(t *testing.T) {
+	tests := []struct {
+		name      string
+		dates     []string
+		frequency string
+		every     int
+		ok        bool
+	}{
+		{"weekly", []string{"2025-03-03", "2025-03-10", "2025-03-17", "2025-03-24"}, RecurringWeekly, 1, true},
+		{"biweekly", []string{"2025-03-01", "2025-03-15", "2025-03-29", "2025-04-12"}, RecurringWeekly, 2, true},
+		{"monthly with short months", []string{"2025-01-31", "2025-02-28", "2025-03-31", "2025-04-30"}, RecurringMonthly, 1, true},
+		{"monthly with a late charge", []string{"2025-01-05", "2025-02-05", "2025-03-08", "2025-04-05", "2025-05-05"}, RecurringMonthly, 1, true},
+		{"quarterly", []string{"2024-01-15", "2024-04-15", "2024-07-15", "2024-10-15"}, RecurringMonthly, 3, true},
+		{"yearly", []string{"2023-06-01", "2024-06-03", "2025-06-01"}, RecurringYearly, 1, true},
+		{"irregular", []string{"2025-01-01", "2025-01-20", "2025-03-01", "2025-03-09", "2025-05-30"}, "", 0, false},
+		{"too many outliers", []string{"2025-01-01", "2025-02-01", "2025-02-20", "2025-03-20", "2025-05-01"}, "", 0, false},
+		{"single date", []string{"2025-01-01"}, "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frequency, every, _, ok := RecurringPeriod(tt.dates)
+			if frequency != tt.frequency || every != tt.every || ok != tt.ok {
+				t.Errorf("RecurringPeriod = %s %d %v, want %s %d %v", frequency, every, ok, tt.frequency, tt.every, tt.ok)
+			}
+		})
+	}
+}
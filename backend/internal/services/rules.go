@@ -0,0 +1,312 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/warren/finance-app/internal/database"
+)
+
+// ErrRuleNotFound is returned when a rule doesn't exist or belongs to another user
+var ErrRuleNotFound = errors.New("rule not found")
+
+const ruleColumns = `id, name, priority, enabled, stop_processing, description_contains, description_regex,
+	min_amount, max_amount, type, account_id, currency, day_from, day_to, tag_ids, set_detail, mark_transfer,
+	mark_ignored, created_at, updated_at`
+
+// Rule tags and flags transactions matching all of its conditions. Empty
+// conditions match anything. Rules run by ascending priority.
+type Rule struct {
+	ID             int    `json:"id"`
+	Name           string `json:"name"`
+	Priority       int    `json:"priority"` // Lower runs first
+	Enabled        bool   `json:"enabled"`
+	StopProcessing bool   `json:"stop_processing"` // Skip the remaining rules when this one matches
+
+	// Conditions
+	DescriptionContains string   `json:"description_contains"` // Case-insensitive
+	DescriptionRegex    string   `json:"description_regex"`    // Case-insensitive
+	MinAmount           *float64 `json:"min_amount"`
+	MaxAmount           *float64 `json:"max_amount"`
+	Type                string   `json:"type"` // income, expense or empty
+	AccountID           *int     `json:"account_id"`
+	Currency            string   `json:"currency"`
+	DayFrom             *int     `json:"day_from"` // Day of month range; wraps around when DayFrom > DayTo
+	DayTo               *int     `json:"day_to"`
+
+	// Actions
+	TagIDs       []int   `json:"tag_ids"`
+	SetDetail    *string `json:"set_detail"`
+	MarkTransfer bool    `json:"mark_transfer"` // Movement between own accounts, left out of totals
+	Ignore       bool    `json:"ignore"`        // Skipped on import, left out of totals otherwise
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	regex *regexp.Regexp
+}
+
+// RuleSubject is what rules look at in a transaction or import row
+type RuleSubject struct {
+	Description string
+	Amount      float64
+	Type        string
+	AccountID   int // 0 when the transaction has no account
+	Currency    string
+	Date        string // YYYY-MM-DD
+}
+
+// RuleOutcome is the combined effect of the rules matching a subject
+type RuleOutcome struct {
+	RuleIDs      []int   `json:"rule_ids"`
+	TagIDs       []int   `json:"tag_ids"`
+	Detail       *string `json:"detail,omitempty"` // From the first matching rule that sets one
+	MarkTransfer bool    `json:"mark_transfer"`
+	Ignore       bool    `json:"ignore"`
+}
+
+// Matched reports whether any rule matched
+func (o RuleOutcome) Matched() bool {
+	return len(o.RuleIDs) > 0
+}
+
+func scanRule(row rowScanner) (Rule, error) {
+	var r Rule
+	var contains, regex, ruleType, currency sql.NullString
+	var tagIDs pq.Int64Array
+
+	err := row.Scan(&r.ID, &r.Name, &r.Priority, &r.Enabled, &r.StopProcessing, &contains, &regex,
+		&r.MinAmount, &r.MaxAmount, &ruleType, &r.AccountID, &currency, &r.DayFrom, &r.DayTo, &tagIDs,
+		&r.SetDetail, &r.MarkTransfer, &r.Ignore, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return r, err
+	}
+
+	r.DescriptionContains = contains.String
+	r.DescriptionRegex = regex.String
+	r.Type = ruleType.String
+	r.Currency = currency.String
+	r.TagIDs = make([]int, len(tagIDs))
+	for i, id := range tagIDs {
+		r.TagIDs[i] = int(id)
+	}
+	if r.DescriptionRegex != "" {
+		// Validated on save; a pattern that no longer compiles matches nothing
+		r.regex, _ = regexp.Compile("(?i)" + r.DescriptionRegex)
+	}
+	return r, nil
+}
+
+// ListRules returns the user's rules in the order they run
+func ListRules(userID int) ([]Rule, error) {
+	rows, err := database.DB.Query(
+		`SELECT `+ruleColumns+` FROM rules WHERE user_id = $1 ORDER BY priority, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []Rule{}
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// LoadEnabledRules returns the user's enabled rules in the order they run
+func LoadEnabledRules(userID int) ([]Rule, error) {
+	rules, err := ListRules(userID)
+	if err != nil {
+		return nil, err
+	}
+	enabled := rules[:0]
+	for _, rule := range rules {
+		if rule.Enabled {
+			enabled = append(enabled, rule)
+		}
+	}
+	return enabled, nil
+}
+
+// GetRule returns one of the user's rules
+func GetRule(userID int, id int) (Rule, error) {
+	rule, err := scanRule(database.DB.QueryRow(
+		`SELECT `+ruleColumns+` FROM rules WHERE id = $1 AND user_id = $2`, id, userID))
+	if err == sql.ErrNoRows {
+		return rule, ErrRuleNotFound
+	}
+	return rule, err
+}
+
+// CreateRule stores a new rule for the user
+func CreateRule(userID int, rule Rule) (Rule, error) {
+	return scanRule(database.DB.QueryRow(`
+		INSERT INTO rules (user_id, name, priority, enabled, stop_processing, description_contains, description_regex,
+		                   min_amount, max_amount, type, account_id, currency, day_from, day_to, tag_ids, set_detail,
+		                   mark_transfer, mark_ignored)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8, $9, NULLIF($10, ''), $11, NULLIF($12, ''), $13, $14,
+		        COALESCE($15::integer[], '{}'), $16, $17, $18)
+		RETURNING `+ruleColumns,
+		userID, rule.Name, rule.Priority, rule.Enabled, rule.StopProcessing, rule.DescriptionContains,
+		rule.DescriptionRegex, rule.MinAmount, rule.MaxAmount, rule.Type, rule.AccountID, rule.Currency, rule.DayFrom,
+		rule.DayTo, pq.Array(rule.TagIDs), rule.SetDetail, rule.MarkTransfer, rule.Ignore))
+}
+
+// UpdateRule replaces a rule. Returns ErrRuleNotFound when the rule doesn't
+// belong to the user.
+func UpdateRule(userID int, id int, rule Rule) (Rule, error) {
+	updated, err := scanRule(database.DB.QueryRow(`
+		UPDATE rules
+		SET name = $1, priority = $2, enabled = $3, stop_processing = $4, description_contains = NULLIF($5, ''),
+		    description_regex = NULLIF($6, ''), min_amount = $7, max_amount = $8, type = NULLIF($9, ''), account_id = $10,
+		    currency = NULLIF($11, ''), day_from = $12, day_to = $13, tag_ids = COALESCE($14::integer[], '{}'),
+		    set_detail = $15, mark_transfer = $16, mark_ignored = $17, updated_at = NOW()
+		WHERE id = $18 AND user_id = $19
+		RETURNING `+ruleColumns,
+		rule.Name, rule.Priority, rule.Enabled, rule.StopProcessing, rule.DescriptionContains, rule.DescriptionRegex,
+		rule.MinAmount, rule.MaxAmount, rule.Type, rule.AccountID, rule.Currency, rule.DayFrom, rule.DayTo,
+		pq.Array(rule.TagIDs), rule.SetDetail, rule.MarkTransfer, rule.Ignore, id, userID))
+	if err == sql.ErrNoRows {
+		return Rule{}, ErrRuleNotFound
+	}
+	return updated, err
+}
+
+// DeleteRule removes a rule
+func DeleteRule(userID int, id int) error {
+	result, err := database.DB.Exec(`DELETE FROM rules WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+// Normalize validates a rule before it is stored
+func (r *Rule) Normalize() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	r.DescriptionContains = strings.TrimSpace(r.DescriptionContains)
+	r.Currency = strings.ToUpper(strings.TrimSpace(r.Currency))
+	if r.TagIDs == nil {
+		r.TagIDs = []int{}
+	}
+	if r.SetDetail != nil && strings.TrimSpace(*r.SetDetail) == "" {
+		r.SetDetail = nil
+	}
+
+	if r.DescriptionRegex != "" {
+		regex, err := regexp.Compile("(?i)" + r.DescriptionRegex)
+		if err != nil {
+			return fmt.Errorf("invalid description_regex: %v", err)
+		}
+		r.regex = regex
+	}
+	if r.Type != "" && r.Type != "income" && r.Type != "expense" {
+		return fmt.Errorf("type must be income or expense")
+	}
+	if r.MinAmount != nil && r.MaxAmount != nil && *r.MinAmount > *r.MaxAmount {
+		return fmt.Errorf("min_amount can't be greater than max_amount")
+	}
+	if (r.DayFrom == nil) != (r.DayTo == nil) {
+		return fmt.Errorf("day_from and day_to go together")
+	}
+	if r.DayFrom != nil && (*r.DayFrom < 1 || *r.DayFrom > 31 || *r.DayTo < 1 || *r.DayTo > 31) {
+		return fmt.Errorf("day_from and day_to must be between 1 and 31")
+	}
+
+	if r.DescriptionContains == "" && r.DescriptionRegex == "" && r.MinAmount == nil && r.MaxAmount == nil &&
+		r.Type == "" && r.AccountID == nil && r.Currency == "" && r.DayFrom == nil {
+		return fmt.Errorf("at least one condition is required")
+	}
+	if len(r.TagIDs) == 0 && r.SetDetail == nil && !r.MarkTransfer && !r.Ignore {
+		return fmt.Errorf("at least one action is required")
+	}
+	return nil
+}
+
+// Matches reports whether the subject meets every condition of the rule
+func (r Rule) Matches(s RuleSubject) bool {
+	if r.DescriptionContains != "" && !strings.Contains(strings.ToLower(s.Description), strings.ToLower(r.DescriptionContains)) {
+		return false
+	}
+	if r.DescriptionRegex != "" && (r.regex == nil || !r.regex.MatchString(s.Description)) {
+		return false
+	}
+	if r.MinAmount != nil && s.Amount < *r.MinAmount {
+		return false
+	}
+	if r.MaxAmount != nil && s.Amount > *r.MaxAmount {
+		return false
+	}
+	if r.Type != "" && r.Type != s.Type {
+		return false
+	}
+	if r.AccountID != nil && *r.AccountID != s.AccountID {
+		return false
+	}
+	if r.Currency != "" && !strings.EqualFold(r.Currency, s.Currency) {
+		return false
+	}
+	if r.DayFrom != nil && r.DayTo != nil {
+		date, err := time.Parse("2006-01-02", s.Date)
+		if err != nil {
+			return false
+		}
+		day := date.Day()
+		if *r.DayFrom <= *r.DayTo {
+			if day < *r.DayFrom || day > *r.DayTo {
+				return false
+			}
+		} else if day < *r.DayFrom && day > *r.DayTo {
+			// Wrapping range, e.g. 28 to 3
+			return false
+		}
+	}
+	return true
+}
+
+// ApplyRules runs the rules, in order, on a subject. Tags add up, the first
+// rule that sets a detail wins and a rule with StopProcessing ends the run.
+func ApplyRules(rules []Rule, s RuleSubject) RuleOutcome {
+	outcome := RuleOutcome{RuleIDs: []int{}, TagIDs: []int{}}
+	seen := make(map[int]bool)
+
+	for _, rule := range rules {
+		if !rule.Matches(s) {
+			continue
+		}
+		outcome.RuleIDs = append(outcome.RuleIDs, rule.ID)
+		for _, tagID := range rule.TagIDs {
+			if !seen[tagID] {
+				seen[tagID] = true
+				outcome.TagIDs = append(outcome.TagIDs, tagID)
+			}
+		}
+		if outcome.Detail == nil && rule.SetDetail != nil {
+			outcome.Detail = rule.SetDetail
+		}
+		outcome.MarkTransfer = outcome.MarkTransfer || rule.MarkTransfer
+		outcome.Ignore = outcome.Ignore || rule.Ignore
+		if rule.StopProcessing {
+			break
+		}
+	}
+
+	sort.Ints(outcome.TagIDs)
+	return outcome
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func intPtr(v int) *int           { return &v }
+func floatPtr(v float64) *float64 { return &v }
+func stringPtr(v string) *string  { return &v }
+
+// normalizedRule returns the rule ready to match, as loaded from the database
+func normalizedRule(t *testing.T, r Rule) Rule {
+	t.Helper()
+	if r.Name == "" {
+		r.Name = "rule"
+	}
+	if len(r.TagIDs) == 0 && r.SetDetail == nil && !r.MarkTransfer && !r.Ignore {
+		r.TagIDs = []int{1}
+	}
+	if err := r.Normalize(); err != nil {
+		t.Fatalf("Normalize: %v", err)
+	}
+	return r
+}
+
+func TestRuleMatches(t *testing.T) {
+	subject := RuleSubject{Description: "NETFLIX.COM 866-579", Amount: 44.90, Type: "expense", AccountID: 7, Currency: "PEN", Date: "2025-03-30"}
+
+	tests := []struct {
+		name string
+		rule Rule
+		want bool
+	}{
+		{"contains ignores case", Rule{DescriptionContains: "netflix"}, true},
+		{"contains misses", Rule{DescriptionContains: "spotify"}, false},
+		{"regex ignores case", Rule{DescriptionRegex: `^netflix\.com \d+`}, true},
+		{"regex misses", Rule{DescriptionRegex: `^uber`}, false},
+		{"amount range", Rule{MinAmount: floatPtr(40), MaxAmount: floatPtr(50)}, true},
+		{"amount range bounds are inclusive", Rule{MinAmount: floatPtr(44.90), MaxAmount: floatPtr(44.90)}, true},
+		{"amount below range", Rule{MinAmount: floatPtr(45)}, false},
+		{"amount above range", Rule{MaxAmount: floatPtr(44)}, false},
+		{"type", Rule{Type: "expense"}, true},
+		{"other type", Rule{Type: "income"}, false},
+		{"account", Rule{AccountID: intPtr(7)}, true},
+		{"other account", Rule{AccountID: intPtr(8)}, false},
+		{"currency ignores case", Rule{Currency: "pen"}, true},
+		{"other currency", Rule{Currency: "USD"}, false},
+		{"day range", Rule{DayFrom: intPtr(25), DayTo: intPtr(31)}, true},
+		{"outside day range", Rule{DayFrom: intPtr(1), DayTo: intPtr(15)}, false},
+		{"wrapping day range", Rule{DayFrom: intPtr(28), DayTo: intPtr(3)}, true},
+		{"outside wrapping day range", Rule{DayFrom: intPtr(31), DayTo: intPtr(3)}, false},
+		{"every condition must hold", Rule{DescriptionContains: "netflix", Type: "income"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := normalizedRule(t, tt.rule)
+			if got := rule.Matches(subject); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Day ranges can't match subjects without a valid date
+	rule := normalizedRule(t, Rule{DayFrom: intPtr(1), DayTo: intPtr(31)})
+	if rule.Matches(RuleSubject{Date: "30/03/2025"}) {
+		t.Error("day range matched an invalid date")
+	}
+}
+
+func TestRuleNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr bool
+	}{
+		{"valid", Rule{Name: "Streaming", DescriptionContains: "netflix", TagIDs: []int{1}}, false},
+		{"missing name", Rule{Name: " ", DescriptionContains: "netflix", TagIDs: []int{1}}, true},
+		{"no condition", Rule{Name: "r", TagIDs: []int{1}}, true},
+		{"no action", Rule{Name: "r", DescriptionContains: "netflix"}, true},
+		{"blank detail isn't an action", Rule{Name: "r", DescriptionContains: "netflix", SetDetail: stringPtr(" ")}, true},
+		{"invalid regex", Rule{Name: "r", DescriptionRegex: "(", TagIDs: []int{1}}, true},
+		{"invalid type", Rule{Name: "r", Type: "transfer", TagIDs: []int{1}}, true},
+		{"inverted amount range", Rule{Name: "r", MinAmount: floatPtr(10), MaxAmount: floatPtr(5), TagIDs: []int{1}}, true},
+		{"half a day range", Rule{Name: "r", DayFrom: intPtr(1), TagIDs: []int{1}}, true},
+		{"day out of range", Rule{Name: "r", DayFrom: intPtr(0), DayTo: intPtr(32), TagIDs: []int{1}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.Normalize()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Normalize error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	subject := RuleSubject{Description: "UBER *TRIP", Amount: 18.50, Type: "expense", Currency: "PEN", Date: "2025-03-10"}
+	rules := []Rule{
+		normalizedRule(t, Rule{ID: 1, DescriptionContains: "uber", TagIDs: []int{5, 2}, SetDetail: stringPtr("Taxi")}),
+		normalizedRule(t, Rule{ID: 2, DescriptionContains: "spotify", TagIDs: []int{9}}),
+		normalizedRule(t, Rule{ID: 3, Type: "expense", TagIDs: []int{2, 3}, SetDetail: stringPtr("Gasto")}),
+		normalizedRule(t, Rule{ID: 4, DescriptionRegex: "trip", MarkTransfer: true, StopProcessing: true}),
+		normalizedRule(t, Rule{ID: 5, Currency: "PEN", Ignore: true}),
+	}
+
+	got := ApplyRules(rules, subject)
+	if !reflect.DeepEqual(got.RuleIDs, []int{1, 3, 4}) {
+		t.Errorf("RuleIDs = %v, want [1 3 4] (rule 4 stops processing)", got.RuleIDs)
+	}
+	if !reflect.DeepEqual(got.TagIDs, []int{2, 3, 5}) {
+		t.Errorf("TagIDs = %v, want [2 3 5]", got.TagIDs)
+	}
+	if got.Detail == nil || *got.Detail != "Taxi" {
+		t.Errorf("Detail = %v, want the first rule's", got.Detail)
+	}
+	if !got.MarkTransfer || got.Ignore {
+		t.Errorf("MarkTransfer = %v, Ignore = %v, want true, false", got.MarkTransfer, got.Ignore)
+	}
+
+	none := ApplyRules(rules, RuleSubject{Description: "SUELDO", Type: "income", Currency: "USD", Date: "2025-03-10"})
+	if none.Matched() || none.RuleIDs == nil || none.TagIDs == nil {
+		t.Errorf("outcome without matches = %+v, want empty non-nil lists", none)
+	}
+}
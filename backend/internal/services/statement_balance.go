@@ -0,0 +1,138 @@
+package services
+
+import "math"
+
+// BalanceCheck compares a statement's declared opening/closing balances
+// against the sum of the parsed movements
+type BalanceCheck struct {
+	Account         string  `json:"account"`
+	Currency        string  `json:"currency"`
+	OpeningBalance  float64 `json:"opening_balance"`
+	ClosingBalance  float64 `json:"closing_balance"`
+	ComputedClosing float64 `json:"computed_closing"`
+	Difference      float64 `json:"difference"`
+	Matches         bool    `json:"matches"`
+}
+
+// newBalanceCheck builds a BalanceCheck from the statement balances and its movements
+func newBalanceCheck(account, currency string, opening, closing float64, transactions []ParsedTransaction) BalanceCheck {
+	computed := opening
+	for _, tx := range transactions {
+		if tx.Type == "income" {
+			computed += tx.Amount
+		} else {
+			computed -= tx.Amount
+		}
+	}
+
+	computed = math.Round(computed*100) / 100
+	diff := math.Round((closing-computed)*100) / 100
+
+	return BalanceCheck{
+		Account:         account,
+		Currency:        currency,
+		OpeningBalance:  opening,
+		ClosingBalance:  closing,
+		ComputedClosing: computed,
+		Difference:      diff,
+		Matches:         diff == 0,
+	}
+}
+
+// checkRunningBalance verifies a spreadsheet's running balance column: each
+// row's balance must equal the previous balance plus the row's movement. Rows
+// that don't add up are flagged with BalanceMismatch. Exports listed newest
+// first are detected by trying both orders. invert flips the movement sign for
+// layouts whose types were inverted while parsing. Returns nil when no row has a balance.
+func checkRunningBalance(transactions []ParsedTransaction, invert bool) *BalanceCheck {
+	var chronological []int
+	for i := range transactions {
+		if transactions[i].Balance != nil {
+			chronological = append(chronological, i)
+		}
+	}
+	if len(chronological) == 0 {
+		return nil
+	}
+
+	ascending := make([]int, len(transactions))
+	descending := make([]int, len(transactions))
+	for i := range transactions {
+		ascending[i] = i
+		descending[len(transactions)-1-i] = i
+	}
+
+	order := ascending
+	if len(runningBalanceMismatches(transactions, descending, invert)) < len(runningBalanceMismatches(transactions, ascending, invert)) {
+		order = descending
+	}
+	for _, i := range runningBalanceMismatches(transactions, order, invert) {
+		transactions[i].BalanceMismatch = true
+	}
+
+	// Opening balance is the balance before the first movement that carries one
+	ordered := make([]ParsedTransaction, 0, len(transactions))
+	started := false
+	var opening, closing float64
+	for _, i := range order {
+		tx := transactions[i]
+		if !started {
+			if tx.Balance == nil {
+				continue
+			}
+			started = true
+			opening = *tx.Balance - signedMovement(tx, invert)
+		}
+		if invert {
+			tx.Type = invertType(tx.Type)
+		}
+		ordered = append(ordered, tx)
+		if tx.Balance != nil {
+			closing = *tx.Balance
+		}
+	}
+
+	check := newBalanceCheck("", ordered[0].Currency, math.Round(opening*100)/100, closing, ordered)
+	return &check
+}
+
+// runningBalanceMismatches returns the indexes whose balance doesn't follow
+// from the previous balance when the rows are read in the given order
+func runningBalanceMismatches(transactions []ParsedTransaction, order []int, invert bool) []int {
+	var mismatches []int
+	var running float64
+	started := false
+
+	for _, i := range order {
+		tx := transactions[i]
+		if !started {
+			if tx.Balance != nil {
+				running = *tx.Balance
+				started = true
+			}
+			continue
+		}
+
+		running += signedMovement(tx, invert)
+		if tx.Balance == nil {
+			continue
+		}
+		if math.Abs(running-*tx.Balance) > 0.005 {
+			mismatches = append(mismatches, i)
+		}
+		running = *tx.Balance
+	}
+
+	return mismatches
+}
+
+func signedMovement(tx ParsedTransaction, invert bool) float64 {
+	amount := tx.Amount
+	if tx.Type == "expense" {
+		amount = -amount
+	}
+	if invert {
+		amount = -amount
+	}
+	return amount
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// StatementOptions says how a statement file is parsed
+type StatementOptions struct {
+	BankID      string        // PDF layout
+	Layout      BankConfig    // Spreadsheet layout
+	Sheets      []SheetImport // Spreadsheet sheets; nil parses the first sheet
+	AllSheets   bool          // Parse every sheet of the workbook
+	InvertSigns bool          // Target account is a BBVA credit card
+}
+
+// ParseStatementFile parses any supported statement, picking the format from
+// the extension, without creating an import. Used to re-parse archived files.
+func ParseStatementFile(filePath string, opts StatementOptions) ([]ParsedTransaction, []BalanceCheck, error) {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".png", ".jpg", ".jpeg":
+		transactions, err := ParseImageFile(filePath)
+		return transactions, nil, err
+	case ".ofx", ".qfx":
+		transactions, err := ParseOFXFile(filePath)
+		return transactions, nil, err
+	case ".xml":
+		return ParseCAMTFile(filePath)
+	case ".sta", ".940":
+		return ParseMT940File(filePath)
+	case ".pdf":
+		transactions, err := ParsePDFFile(filePath, opts.BankID, opts.InvertSigns)
+		return transactions, nil, err
+	}
+
+	sheets := opts.Sheets
+	if opts.AllSheets {
+		names, err := ListSheets(filePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		sheets = nil
+		for _, name := range names {
+			sheets = append(sheets, SheetImport{Sheet: name, InvertSigns: opts.InvertSigns})
+		}
+	}
+	if sheets == nil {
+		sheets = []SheetImport{{InvertSigns: opts.InvertSigns}}
+	}
+	return ParseWorkbook(filePath, opts.Layout, sheets)
+}
+
+// maxDescriptionLength is the size of transactions.description
+const maxDescriptionLength = 255
+
+// truncateDescription cuts a description to what transactions.description holds
+func truncateDescription(description string) string {
+	if runes := []rune(description); len(runes) > maxDescriptionLength {
+		return strings.TrimSpace(string(runes[:maxDescriptionLength]))
+	}
+	return description
+}
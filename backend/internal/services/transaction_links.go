@@ -0,0 +1,277 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/warren/finance-app/internal/database"
+)
+
+var (
+	// ErrLinkNotFound is returned when a link doesn't exist or belongs to another user
+	ErrLinkNotFound = errors.New("link not found")
+	// ErrLinkExists is returned when the two transactions are already linked
+	ErrLinkExists = errors.New("the transactions are already linked; update the link instead")
+)
+
+// TransactionLink allocates part of an expense to an income that reimburses it
+type TransactionLink struct {
+	ID        int          `json:"id"`
+	Amount    float64      `json:"amount"` // Part of both transactions covered by the link
+	Expense   TransferSide `json:"expense"`
+	Income    TransferSide `json:"income"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+const linkSelect = `
+	SELECT l.id, l.amount, l.created_at, l.updated_at,
+	       e.id, e.account_id, ea.name, e.description, e.amount, e.currency, to_char(e.date, 'YYYY-MM-DD'),
+	       i.id, i.account_id, ia.name, i.description, i.amount, i.currency, to_char(i.date, 'YYYY-MM-DD')
+	FROM transaction_links l
+	JOIN transactions e ON e.id = l.expense_id
+	LEFT JOIN accounts ea ON ea.id = e.account_id
+	JOIN transactions i ON i.id = l.income_id
+	LEFT JOIN accounts ia ON ia.id = i.account_id`
+
+func scanLink(row rowScanner) (TransactionLink, error) {
+	var l TransactionLink
+	err := row.Scan(&l.ID, &l.Amount, &l.CreatedAt, &l.UpdatedAt,
+		&l.Expense.TransactionID, &l.Expense.AccountID, &l.Expense.AccountName, &l.Expense.Description,
+		&l.Expense.Amount, &l.Expense.Currency, &l.Expense.Date,
+		&l.Income.TransactionID, &l.Income.AccountID, &l.Income.AccountName, &l.Income.Description,
+		&l.Income.Amount, &l.Income.Currency, &l.Income.Date)
+	return l, err
+}
+
+// ListTransactionLinks returns the links of one of the user's transactions
+func ListTransactionLinks(userID int, transactionID int) ([]TransactionLink, error) {
+	rows, err := database.DB.Query(linkSelect+`
+		WHERE l.user_id = $1 AND (l.expense_id = $2 OR l.income_id = $2)
+		ORDER BY l.id`, userID, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []TransactionLink{}
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// GetTransactionLink returns one of the user's links
+func GetTransactionLink(userID int, id int) (TransactionLink, error) {
+	link, err := scanLink(database.DB.QueryRow(linkSelect+` WHERE l.id = $1 AND l.user_id = $2`, id, userID))
+	if err == sql.ErrNoRows {
+		return link, ErrLinkNotFound
+	}
+	return link, err
+}
+
+// linkSide is a transaction being linked, with the part of its amount not yet
+// allocated to other links
+type linkSide struct {
+	id        int
+	txType    string
+	currency  string
+	amount    float64
+	remaining float64
+}
+
+// loadLinkSide locks a transaction and computes what's left of it, leaving out
+// the link being updated (if any)
+func loadLinkSide(dbTx *sql.Tx, userID int, transactionID int, exceptLinkID int) (linkSide, error) {
+	s := linkSide{id: transactionID}
+	err := dbTx.QueryRow(`SELECT type, currency, amount FROM transactions WHERE id = $1 AND user_id = $2 FOR UPDATE`,
+		transactionID, userID).Scan(&s.txType, &s.currency, &s.amount)
+	if err == sql.ErrNoRows {
+		return s, &InvalidLinkError{"transaction not found"}
+	}
+	if err != nil {
+		return s, err
+	}
+
+	var allocated float64
+	err = dbTx.QueryRow(`
+		SELECT COALESCE(SUM(amount), 0) FROM transaction_links
+		WHERE (expense_id = $1 OR income_id = $1) AND id <> $2`, transactionID, exceptLinkID).Scan(&allocated)
+	s.remaining = math.Round((s.amount-allocated)*100) / 100
+	return s, err
+}
+
+// checkAllocation validates allocating amount between an expense and an income
+func checkAllocation(expense linkSide, income linkSide, amount float64) error {
+	switch {
+	case amount <= 0:
+		return &InvalidLinkError{"the linked amount must be positive"}
+	case amount > expense.remaining+0.005:
+		return &InvalidLinkError{fmt.Sprintf("only %.2f of the expense is left to link", expense.remaining)}
+	case amount > income.remaining+0.005:
+		return &InvalidLinkError{fmt.Sprintf("only %.2f of the income is left to link", income.remaining)}
+	}
+	return nil
+}
+
+// CreateTransactionLink links an expense with an income that reimburses it,
+// in either order. Without an amount, the link takes as much as both
+// transactions have left.
+func CreateTransactionLink(userID int, transactionID1 int, transactionID2 int, amount *float64) (TransactionLink, error) {
+	if transactionID1 == transactionID2 {
+		return TransactionLink{}, &InvalidLinkError{"cannot link a transaction to itself"}
+	}
+
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		return TransactionLink{}, err
+	}
+	defer dbTx.Rollback()
+
+	// Lock in id order so concurrent links over the same pair can't deadlock
+	firstID, secondID := transactionID1, transactionID2
+	if firstID > secondID {
+		firstID, secondID = secondID, firstID
+	}
+	expense, err := loadLinkSide(dbTx, userID, firstID, 0)
+	if err != nil {
+		return TransactionLink{}, err
+	}
+	income, err := loadLinkSide(dbTx, userID, secondID, 0)
+	if err != nil {
+		return TransactionLink{}, err
+	}
+	if expense.txType == "income" {
+		expense, income = income, expense
+	}
+
+	switch {
+	case expense.txType != "expense" || income.txType != "income":
+		return TransactionLink{}, &InvalidLinkError{"a link pairs an expense with an income"}
+	case expense.currency != income.currency:
+		return TransactionLink{}, &InvalidLinkError{"both transactions must be in the same currency"}
+	}
+
+	linked := math.Min(expense.remaining, income.remaining)
+	if amount != nil {
+		linked = math.Round(*amount*100) / 100
+	} else if linked <= 0 {
+		return TransactionLink{}, &InvalidLinkError{"one of the transactions is already fully linked"}
+	}
+	if err := checkAllocation(expense, income, linked); err != nil {
+		return TransactionLink{}, err
+	}
+
+	var id int
+	err = dbTx.QueryRow(`
+		INSERT INTO transaction_links (user_id, expense_id, income_id, amount)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`, userID, expense.id, income.id, linked).Scan(&id)
+	if isUniqueViolation(err) {
+		return TransactionLink{}, ErrLinkExists
+	}
+	if err != nil {
+		return TransactionLink{}, err
+	}
+	if _, err := dbTx.Exec(`UPDATE transactions SET updated_at = NOW() WHERE id IN ($1, $2)`, expense.id, income.id); err != nil {
+		return TransactionLink{}, err
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		return TransactionLink{}, err
+	}
+	return GetTransactionLink(userID, id)
+}
+
+// UpdateTransactionLink changes the amount a link allocates
+func UpdateTransactionLink(userID int, id int, amount float64) (TransactionLink, error) {
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		return TransactionLink{}, err
+	}
+	defer dbTx.Rollback()
+
+	var expenseID, incomeID int
+	err = dbTx.QueryRow(`SELECT expense_id, income_id FROM transaction_links WHERE id = $1 AND user_id = $2`,
+		id, userID).Scan(&expenseID, &incomeID)
+	if err == sql.ErrNoRows {
+		return TransactionLink{}, ErrLinkNotFound
+	}
+	if err != nil {
+		return TransactionLink{}, err
+	}
+
+	firstID, secondID := expenseID, incomeID
+	if firstID > secondID {
+		firstID, secondID = secondID, firstID
+	}
+	first, err := loadLinkSide(dbTx, userID, firstID, id)
+	if err != nil {
+		return TransactionLink{}, err
+	}
+	second, err := loadLinkSide(dbTx, userID, secondID, id)
+	if err != nil {
+		return TransactionLink{}, err
+	}
+	expense, income := first, second
+	if expense.id != expenseID {
+		expense, income = second, first
+	}
+
+	amount = math.Round(amount*100) / 100
+	if err := checkAllocation(expense, income, amount); err != nil {
+		return TransactionLink{}, err
+	}
+
+	if _, err := dbTx.Exec(`UPDATE transaction_links SET amount = $1, updated_at = NOW() WHERE id = $2`, amount, id); err != nil {
+		return TransactionLink{}, err
+	}
+	if _, err := dbTx.Exec(`UPDATE transactions SET updated_at = NOW() WHERE id IN ($1, $2)`, expenseID, incomeID); err != nil {
+		return TransactionLink{}, err
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		return TransactionLink{}, err
+	}
+	return GetTransactionLink(userID, id)
+}
+
+// DeleteTransactionLink removes a link
+func DeleteTransactionLink(userID int, id int) error {
+	result, err := database.DB.Exec(`DELETE FROM transaction_links WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrLinkNotFound
+	}
+	return nil
+}
+
+// UnlinkTransaction removes every link of one of the user's transactions and
+// returns how many there were
+func UnlinkTransaction(userID int, transactionID int) (int64, error) {
+	result, err := database.DB.Exec(`
+		DELETE FROM transaction_links
+		WHERE user_id = $1 AND (expense_id = $2 OR income_id = $2)`, userID, transactionID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// InvalidLinkError explains why two transactions can't be linked
+type InvalidLinkError struct {
+	Reason string
+}
+
+func (e *InvalidLinkError) Error() string {
+	return e.Reason
+}
@@ -0,0 +1,501 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/warren/finance-app/internal/database"
+)
+
+// Transfer statuses
+const (
+	TransferSuggested = "suggested"
+	TransferConfirmed = "confirmed"
+	TransferRejected  = "rejected"
+)
+
+// TransferDateWindow is how many days the two sides of a transfer may be apart
+// (the payment may be posted to the card a few days later)
+const TransferDateWindow = 3
+
+// Weights of the transfer score. Amount, currency and opposite types in
+// different accounts are required, so they contribute a fixed share.
+const (
+	transferWeightAmount = 0.60
+	transferWeightDate   = 0.25
+	transferWeightHint   = 0.15
+)
+
+var (
+	// ErrTransferNotFound is returned when a transfer doesn't exist or belongs to another user
+	ErrTransferNotFound = errors.New("transfer not found")
+	// ErrTransferConflict is returned when a transaction already belongs to another transfer
+	ErrTransferConflict = errors.New("a transaction already belongs to another transfer")
+)
+
+// transferHints are description fragments typical of movements between own
+// accounts; they raise the score of a pair
+var transferHints = []string{
+	"pago tarjeta", "pago de tarjeta", "pago tarj", "pago tc", "pago visa", "pago mastercard", "pago amex",
+	"transferencia", "transf", "traspaso", "entre cuentas", "cuenta propia", "ctas propias",
+}
+
+// TransferSide is one of the two transactions of a transfer
+type TransferSide struct {
+	TransactionID int     `json:"transaction_id"`
+	AccountID     *int    `json:"account_id,omitempty"`
+	AccountName   *string `json:"account_name,omitempty"`
+	Description   string  `json:"description"`
+	Amount        float64 `json:"amount"`
+	Currency      string  `json:"currency"`
+	Date          string  `json:"date"`
+}
+
+// Transfer pairs an expense in one account with the income it produced in another
+type Transfer struct {
+	ID        int          `json:"id"`
+	Status    string       `json:"status"` // suggested, confirmed, rejected
+	Source    string       `json:"source"` // detected, manual
+	Score     float64      `json:"score"`
+	From      TransferSide `json:"from"` // Expense side
+	To        TransferSide `json:"to"`   // Income side
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+const transferSelect = `
+	SELECT tr.id, tr.status, tr.source, tr.score, tr.created_at, tr.updated_at,
+	       f.id, f.account_id, fa.name, f.description, f.amount, f.currency, to_char(f.date, 'YYYY-MM-DD'),
+	       t.id, t.account_id, ta.name, t.description, t.amount, t.currency, to_char(t.date, 'YYYY-MM-DD')
+	FROM transfers tr
+	JOIN transactions f ON f.id = tr.from_transaction_id
+	LEFT JOIN accounts fa ON fa.id = f.account_id
+	JOIN transactions t ON t.id = tr.to_transaction_id
+	LEFT JOIN accounts ta ON ta.id = t.account_id`
+
+func scanTransfer(row rowScanner) (Transfer, error) {
+	var tr Transfer
+	err := row.Scan(&tr.ID, &tr.Status, &tr.Source, &tr.Score, &tr.CreatedAt, &tr.UpdatedAt,
+		&tr.From.TransactionID, &tr.From.AccountID, &tr.From.AccountName, &tr.From.Description, &tr.From.Amount,
+		&tr.From.Currency, &tr.From.Date,
+		&tr.To.TransactionID, &tr.To.AccountID, &tr.To.AccountName, &tr.To.Description, &tr.To.Amount,
+		&tr.To.Currency, &tr.To.Date)
+	return tr, err
+}
+
+// ListTransfers returns the user's transfers, newest first, optionally only
+// those with a status
+func ListTransfers(userID int, status string) ([]Transfer, error) {
+	query := transferSelect + ` WHERE tr.user_id = $1`
+	args := []interface{}{userID}
+	if status != "" {
+		query += ` AND tr.status = $2`
+		args = append(args, status)
+	}
+	query += ` ORDER BY f.date DESC, tr.id DESC`
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []Transfer{}
+	for rows.Next() {
+		transfer, err := scanTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, transfer)
+	}
+	return transfers, rows.Err()
+}
+
+// GetTransfer returns one of the user's transfers
+func GetTransfer(userID int, id int) (Transfer, error) {
+	transfer, err := scanTransfer(database.DB.QueryRow(transferSelect+` WHERE tr.id = $1 AND tr.user_id = $2`, id, userID))
+	if err == sql.ErrNoRows {
+		return transfer, ErrTransferNotFound
+	}
+	return transfer, err
+}
+
+// SetTransferStatus confirms or rejects a transfer. Confirming marks both
+// transactions as transfers; rejecting a confirmed transfer unmarks them,
+// except the ones a rule or the user marked. Rejected pairs are kept so detection doesn't suggest them again.
+func SetTransferStatus(userID int, id int, status string) (Transfer, error) {
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		return Transfer{}, err
+	}
+	defer dbTx.Rollback()
+
+	var current string
+	var fromID, toID int
+	err = dbTx.QueryRow(`
+		SELECT status, from_transaction_id, to_transaction_id FROM transfers
+		WHERE id = $1 AND user_id = $2 FOR UPDATE`, id, userID).Scan(&current, &fromID, &toID)
+	if err == sql.ErrNoRows {
+		return Transfer{}, ErrTransferNotFound
+	}
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	if current != status {
+		_, err = dbTx.Exec(`UPDATE transfers SET status = $1, updated_at = NOW() WHERE id = $2`, status, id)
+		if isUniqueViolation(err) {
+			return Transfer{}, ErrTransferConflict
+		}
+		if err != nil {
+			return Transfer{}, err
+		}
+
+		if status == TransferConfirmed || current == TransferConfirmed {
+			if err := refreshTransferFlags(dbTx, fromID, toID); err != nil {
+				return Transfer{}, err
+			}
+		}
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		return Transfer{}, err
+	}
+	return GetTransfer(userID, id)
+}
+
+// CreateTransfer records a confirmed transfer between two of the user's
+// transactions, in either order. Suggestions involving either transaction are
+// rejected in its favor.
+func CreateTransfer(userID int, transactionID1 int, transactionID2 int) (Transfer, error) {
+	dbTx, err := database.DB.Begin()
+	if err != nil {
+		return Transfer{}, err
+	}
+	defer dbTx.Rollback()
+
+	type side struct {
+		id        int
+		accountID *int
+		txType    string
+	}
+	load := func(id int) (side, error) {
+		s := side{id: id}
+		err := dbTx.QueryRow(`SELECT account_id, type FROM transactions WHERE id = $1 AND user_id = $2 FOR UPDATE`,
+			id, userID).Scan(&s.accountID, &s.txType)
+		return s, err
+	}
+	from, err := load(transactionID1)
+	if err == sql.ErrNoRows {
+		return Transfer{}, &InvalidTransferError{"transaction not found"}
+	}
+	if err != nil {
+		return Transfer{}, err
+	}
+	to, err := load(transactionID2)
+	if err == sql.ErrNoRows {
+		return Transfer{}, &InvalidTransferError{"transaction not found"}
+	}
+	if err != nil {
+		return Transfer{}, err
+	}
+	if from.txType == "income" {
+		from, to = to, from
+	}
+
+	switch {
+	case from.id == to.id:
+		return Transfer{}, &InvalidTransferError{"a transfer needs two different transactions"}
+	case from.txType != "expense" || to.txType != "income":
+		return Transfer{}, &InvalidTransferError{"a transfer pairs an expense with an income"}
+	case from.accountID == nil || to.accountID == nil:
+		return Transfer{}, &InvalidTransferError{"both transactions need an account"}
+	case *from.accountID == *to.accountID:
+		return Transfer{}, &InvalidTransferError{"both transactions are in the same account"}
+	}
+
+	// Confirmed transfers aren't replaced; suggestions are
+	var confirmed bool
+	err = dbTx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM transfers
+		              WHERE status = 'confirmed' AND NOT (from_transaction_id = $1 AND to_transaction_id = $2)
+		                AND (from_transaction_id IN ($1, $2) OR to_transaction_id IN ($1, $2)))`,
+		from.id, to.id).Scan(&confirmed)
+	if err != nil {
+		return Transfer{}, err
+	}
+	if confirmed {
+		return Transfer{}, ErrTransferConflict
+	}
+	_, err = dbTx.Exec(`
+		UPDATE transfers SET status = 'rejected', updated_at = NOW()
+		WHERE status = 'suggested' AND NOT (from_transaction_id = $1 AND to_transaction_id = $2)
+		  AND (from_transaction_id IN ($1, $2) OR to_transaction_id IN ($1, $2))`, from.id, to.id)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	var id int
+	err = dbTx.QueryRow(`
+		INSERT INTO transfers (user_id, from_transaction_id, to_transaction_id, status, source, score)
+		VALUES ($1, $2, $3, 'confirmed', 'manual', 1)
+		ON CONFLICT (from_transaction_id, to_transaction_id) DO UPDATE
+		SET status = 'confirmed', source = 'manual', score = 1, updated_at = NOW()
+		RETURNING id`, userID, from.id, to.id).Scan(&id)
+	if isUniqueViolation(err) {
+		return Transfer{}, ErrTransferConflict
+	}
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	if err := refreshTransferFlags(dbTx, from.id, to.id); err != nil {
+		return Transfer{}, err
+	}
+	if err := dbTx.Commit(); err != nil {
+		return Transfer{}, err
+	}
+	return GetTransfer(userID, id)
+}
+
+// InvalidTransferError explains why two transactions can't be a transfer
+type InvalidTransferError struct {
+	Reason string
+}
+
+func (e *InvalidTransferError) Error() string {
+	return e.Reason
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// ReleaseTransfers unmarks the other side of the confirmed transfers of
+// transactions about to be deleted, unless a rule or the user marked it. The
+// transfers themselves go with the transactions (ON DELETE CASCADE). Only the
+// derived flag changes, so updated_at is left alone.
+func ReleaseTransfers(q execer, userID int, transactionIDs []int) error {
+	_, err := q.Exec(`
+		UPDATE transactions t
+		SET is_transfer = t.marked_transfer
+		FROM transfers tr
+		WHERE tr.user_id = $1 AND tr.status = 'confirmed'
+		  AND (tr.from_transaction_id = ANY($2) OR tr.to_transaction_id = ANY($2))
+		  AND t.id IN (tr.from_transaction_id, tr.to_transaction_id)
+		  AND NOT t.id = ANY($2)`, userID, pq.Array(transactionIDs))
+	return err
+}
+
+// refreshTransferFlags recomputes is_transfer for the given transactions: set
+// when a rule or the user marked them, or when they are part of a confirmed transfer
+func refreshTransferFlags(dbTx *sql.Tx, ids ...int) error {
+	_, err := dbTx.Exec(`
+		UPDATE transactions t
+		SET is_transfer = t.marked_transfer OR EXISTS (
+		        SELECT 1 FROM transfers tr
+		        WHERE tr.status = 'confirmed' AND t.id IN (tr.from_transaction_id, tr.to_transaction_id)
+		    ),
+		    updated_at = NOW()
+		WHERE t.id = ANY($1)`, pq.Array(ids))
+	return err
+}
+
+// TransferCandidate is a transaction that may be one side of a transfer
+type TransferCandidate struct {
+	ID          int
+	AccountID   int
+	Description string
+	Amount      float64
+	Currency    string
+	Type        string
+	Date        string
+}
+
+// TransferMatch is a detected pair
+type TransferMatch struct {
+	FromID int
+	ToID   int
+	Score  float64
+}
+
+// ScoreTransfer scores how likely an expense and an income are the two sides
+// of a transfer. Returns 0 when they can't be: same account, different amount
+// or currency, or dates further apart than TransferDateWindow.
+func ScoreTransfer(out TransferCandidate, in TransferCandidate) float64 {
+	if out.Type != "expense" || in.Type != "income" || out.AccountID == in.AccountID {
+		return 0
+	}
+	if math.Abs(out.Amount-in.Amount) >= 0.005 || !strings.EqualFold(out.Currency, in.Currency) {
+		return 0
+	}
+	days, ok := daysBetween(out.Date, in.Date)
+	if !ok || days > TransferDateWindow {
+		return 0
+	}
+
+	hint := 0.0
+	if hasTransferHint(out.Description) || hasTransferHint(in.Description) {
+		hint = 1
+	}
+
+	score := transferWeightAmount +
+		transferWeightDate*(1-float64(days)/float64(TransferDateWindow+1)) +
+		transferWeightHint*hint
+	return math.Round(score*1000) / 1000
+}
+
+// MatchTransfers pairs expenses with incomes, best scores first, each
+// transaction in at most one pair. Pairs in skip are never matched.
+func MatchTransfers(candidates []TransferCandidate, skip map[[2]int]bool) []TransferMatch {
+	var outs, ins []TransferCandidate
+	for _, c := range candidates {
+		switch c.Type {
+		case "expense":
+			outs = append(outs, c)
+		case "income":
+			ins = append(ins, c)
+		}
+	}
+
+	var pairs []TransferMatch
+	for _, out := range outs {
+		for _, in := range ins {
+			if skip[[2]int{out.ID, in.ID}] {
+				continue
+			}
+			if score := ScoreTransfer(out, in); score > 0 {
+				pairs = append(pairs, TransferMatch{FromID: out.ID, ToID: in.ID, Score: score})
+			}
+		}
+	}
+
+	sort.SliceStable(pairs, func(a, b int) bool { return pairs[a].Score > pairs[b].Score })
+	taken := make(map[int]bool)
+	var matches []TransferMatch
+	for _, p := range pairs {
+		if taken[p.FromID] || taken[p.ToID] {
+			continue
+		}
+		taken[p.FromID] = true
+		taken[p.ToID] = true
+		matches = append(matches, p)
+	}
+	return matches
+}
+
+// DetectTransfers suggests transfers among the user's transactions between
+// startDate and endDate (either may be empty) that aren't in a transfer yet.
+// Returns how many were suggested.
+func DetectTransfers(userID int, startDate string, endDate string) (int, error) {
+	return detectTransfers(userID, startDate, endDate, nil)
+}
+
+// DetectTransfersFor suggests transfers involving the given transactions,
+// e.g. the ones an import just created
+func DetectTransfersFor(userID int, transactionIDs []int) (int, error) {
+	if len(transactionIDs) == 0 {
+		return 0, nil
+	}
+	var startDate, endDate sql.NullString
+	err := database.DB.QueryRow(`
+		SELECT to_char(MIN(date), 'YYYY-MM-DD'), to_char(MAX(date), 'YYYY-MM-DD')
+		FROM transactions WHERE user_id = $1 AND id = ANY($2)`,
+		userID, pq.Array(transactionIDs)).Scan(&startDate, &endDate)
+	if err != nil || !startDate.Valid {
+		return 0, err
+	}
+
+	involving := make(map[int]bool, len(transactionIDs))
+	for _, id := range transactionIDs {
+		involving[id] = true
+	}
+	return detectTransfers(userID, startDate.String, endDate.String, involving)
+}
+
+func detectTransfers(userID int, startDate string, endDate string, involving map[int]bool) (int, error) {
+	query := `
+		SELECT t.id, t.account_id, t.description, t.amount, t.currency, t.type, to_char(t.date, 'YYYY-MM-DD')
+		FROM transactions t
+		WHERE t.user_id = $1 AND t.account_id IS NOT NULL AND NOT t.ignored
+		  AND NOT EXISTS (SELECT 1 FROM transfers tr
+		                  WHERE tr.status <> 'rejected' AND t.id IN (tr.from_transaction_id, tr.to_transaction_id))`
+	args := []interface{}{userID}
+
+	// The window extends past the range so pairs straddling its edges are found
+	if start, err := time.Parse("2006-01-02", startDate); err == nil {
+		args = append(args, start.AddDate(0, 0, -TransferDateWindow).Format("2006-01-02"))
+		query += ` AND t.date >= $` + strconv.Itoa(len(args))
+	}
+	if end, err := time.Parse("2006-01-02", endDate); err == nil {
+		args = append(args, end.AddDate(0, 0, TransferDateWindow).Format("2006-01-02"))
+		query += ` AND t.date <= $` + strconv.Itoa(len(args))
+	}
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	var candidates []TransferCandidate
+	for rows.Next() {
+		var c TransferCandidate
+		if err := rows.Scan(&c.ID, &c.AccountID, &c.Description, &c.Amount, &c.Currency, &c.Type, &c.Date); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	rows, err = database.DB.Query(
+		`SELECT from_transaction_id, to_transaction_id FROM transfers WHERE user_id = $1 AND status = 'rejected'`, userID)
+	if err != nil {
+		return 0, err
+	}
+	rejected := make(map[[2]int]bool)
+	for rows.Next() {
+		var pair [2]int
+		if err := rows.Scan(&pair[0], &pair[1]); err == nil {
+			rejected[pair] = true
+		}
+	}
+	rows.Close()
+
+	created := 0
+	for _, match := range MatchTransfers(candidates, rejected) {
+		if involving != nil && !involving[match.FromID] && !involving[match.ToID] {
+			continue
+		}
+		result, err := database.DB.Exec(`
+			INSERT INTO transfers (user_id, from_transaction_id, to_transaction_id, status, source, score)
+			VALUES ($1, $2, $3, 'suggested', 'detected', $4)
+			ON CONFLICT DO NOTHING`, userID, match.FromID, match.ToID, match.Score)
+		if err != nil {
+			return created, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			created++
+		}
+	}
+	return created, nil
+}
+
+func hasTransferHint(description string) bool {
+	desc := strings.ToLower(description)
+	for _, hint := range transferHints {
+		if strings.Contains(desc, hint) {
+			return true
+		}
+	}
+	return false
+}
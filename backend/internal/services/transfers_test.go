@@ -0,0 +1,86 @@
+package services
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestScoreTransfer(t *testing.T) {
+	out := TransferCandidate{ID: 1, AccountID: 7, Description: "COMPRA", Amount: 500, Currency: "PEN", Type: "expense", Date: "2025-03-10"}
+	in := TransferCandidate{ID: 2, AccountID: 8, Description: "ABONO", Amount: 500, Currency: "PEN", Type: "income", Date: "2025-03-10"}
+
+	tests := []struct {
+		name   string
+		modify func(out, in *TransferCandidate)
+		want   float64
+	}{
+		{"same day", func(*TransferCandidate, *TransferCandidate) {}, 0.85},
+		{"same day with a hint", func(out, _ *TransferCandidate) { out.Description = "PAGO TARJETA VISA" }, 1},
+		{"hint on the income side", func(_, in *TransferCandidate) { in.Description = "Transferencia recibida" }, 1},
+		{"posted two days later", func(_, in *TransferCandidate) { in.Date = "2025-03-12" }, 0.725},
+		{"at the window edge", func(_, in *TransferCandidate) { in.Date = "2025-03-07" }, 0.663},
+		{"past the window", func(_, in *TransferCandidate) { in.Date = "2025-03-14" }, 0},
+		{"same account", func(_, in *TransferCandidate) { in.AccountID = 7 }, 0},
+		{"different amount", func(_, in *TransferCandidate) { in.Amount = 499.99 }, 0},
+		{"different currency", func(_, in *TransferCandidate) { in.Currency = "USD" }, 0},
+		{"currency case", func(_, in *TransferCandidate) { in.Currency = "pen" }, 0.85},
+		{"two expenses", func(_, in *TransferCandidate) { in.Type = "expense" }, 0},
+		{"invalid date", func(out, _ *TransferCandidate) { out.Date = "" }, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, i := out, in
+			tt.modify(&o, &i)
+			if got := ScoreTransfer(o, i); math.Abs(got-tt.want) > 0.001 {
+				t.Errorf("ScoreTransfer = %.3f, want %.3f", got, tt.want)
+			}
+		})
+	}
+
+	// The expense always goes first
+	if got := ScoreTransfer(in, out); got != 0 {
+		t.Errorf("ScoreTransfer(income, expense) = %.3f, want 0", got)
+	}
+}
+
+func TestMatchTransfers(t *testing.T) {
+	candidates := []TransferCandidate{
+		{ID: 1, AccountID: 7, Description: "PAGO TARJETA", Amount: 500, Currency: "PEN", Type: "expense", Date: "2025-03-10"},
+		{ID: 2, AccountID: 8, Description: "PAGO RECIBIDO", Amount: 500, Currency: "PEN", Type: "income", Date: "2025-03-12"},
+		{ID: 3, AccountID: 9, Description: "ABONO", Amount: 500, Currency: "PEN", Type: "income", Date: "2025-03-10"},
+		{ID: 4, AccountID: 7, Description: "COMPRA", Amount: 80, Currency: "PEN", Type: "expense", Date: "2025-03-11"},
+		{ID: 5, AccountID: 8, Description: "DEVOLUCION", Amount: 80, Currency: "PEN", Type: "income", Date: "2025-03-11"},
+		{ID: 6, AccountID: 8, Description: "SUELDO", Amount: 2500, Currency: "PEN", Type: "income", Date: "2025-03-01"},
+	}
+
+	tests := []struct {
+		name string
+		skip map[[2]int]bool
+		want []TransferMatch
+	}{
+		{
+			name: "best scores first, each transaction once",
+			want: []TransferMatch{
+				{FromID: 1, ToID: 3, Score: 1},
+				{FromID: 4, ToID: 5, Score: 0.85},
+			},
+		},
+		{
+			name: "rejected pairs are skipped",
+			skip: map[[2]int]bool{{1, 3}: true, {4, 5}: true},
+			want: []TransferMatch{
+				{FromID: 1, ToID: 2, Score: 0.875},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchTransfers(candidates, tt.skip); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MatchTransfers = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/extrame/xls"
+	"github.com/warren/finance-app/internal/database"
+	"github.com/xuri/excelize/v2"
+)
+
+// SheetImport selects a workbook sheet and where its movements go
+type SheetImport struct {
+	Sheet       string `json:"sheet"`                // Sheet name, "" for the first sheet
+	AccountID   int    `json:"account_id,omitempty"` // 0 keeps the upload's account
+	Currency    string `json:"currency,omitempty"`   // Default currency for amounts without a symbol
+	InvertSigns bool   `json:"invert_signs"`         // Target account is a BBVA credit card; set from the account, never the client
+}
+
+// ListSheets returns the sheet names of an .xlsx or .xls workbook.
+// CSV files have a single unnamed sheet and return nil.
+func ListSheets(filePath string) ([]string, error) {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".csv":
+		return nil, nil
+	case ".xls":
+		xlFile, err := xls.Open(filePath, "utf-8")
+		if err != nil {
+			return nil, fmt.Errorf("error opening xls file: %w", err)
+		}
+		var names []string
+		for i := 0; i < xlFile.NumSheets(); i++ {
+			if sheet := xlFile.GetSheet(i); sheet != nil {
+				names = append(names, sheet.Name)
+			}
+		}
+		return names, nil
+	default:
+		f, err := excelize.OpenFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("error opening xlsx file: %w", err)
+		}
+		defer f.Close()
+		return f.GetSheetList(), nil
+	}
+}
+
+// ProcessWorkbook parses one or more sheets of a workbook into a single import.
+// Each sheet is detected and checked on its own (e.g. BBVA soles and dollars
+// sheets) and its movements are tagged with the sheet and target account.
+func ProcessWorkbook(filePath string, userID int, config BankConfig, sheets []SheetImport) ([]ParsedTransaction, []BalanceCheck, int, error) {
+	// Parse first so a bad sheet name fails before the import exists
+	transactions, balanceChecks, err := ParseWorkbook(filePath, config, sheets)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	// Create import record
+	var importID int
+	err = database.DB.QueryRow(
+		`INSERT INTO imports (user_id, filename, file_type, status, total_transactions)
+		 VALUES ($1, $2, 'excel', 'completed', $3) RETURNING id`,
+		userID, filePath, len(transactions),
+	).Scan(&importID)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("error creating import record: %w", err)
+	}
+
+	return transactions, balanceChecks, importID, nil
+}
+
+// ParseWorkbook parses the given sheets of a workbook without creating an import
+func ParseWorkbook(filePath string, config BankConfig, sheets []SheetImport) ([]ParsedTransaction, []BalanceCheck, error) {
+	// Read every sheet first so a bad sheet name fails before parsing
+	sheetRows := make([][][]string, len(sheets))
+	for i, sheet := range sheets {
+		rows, err := ReadSheetRows(filePath, sheet.Sheet)
+		if err != nil {
+			return nil, nil, err
+		}
+		sheetRows[i] = rows
+	}
+
+	var transactions []ParsedTransaction
+	var balanceChecks []BalanceCheck
+
+	for i, sheet := range sheets {
+		rows := sheetRows[i]
+
+		layout := config
+		if sheet.Currency != "" {
+			layout.Currency = sheet.Currency
+		}
+
+		// Parse based on bank
+		layout = prepareLayout(rows, layout)
+		sheetTransactions := parseExcelByBank(rows, layout)
+
+		// The running balance follows the statement's own signs, so check it before any inversion
+		if check := checkRunningBalance(sheetTransactions, layout.SignConvention == SignInverted); check != nil {
+			check.Account = sheet.Sheet
+			balanceChecks = append(balanceChecks, *check)
+		}
+
+		// Invert signs for credit card accounts (e.g., BBVA credit cards)
+		// In credit card statements: purchases are positive, payments are negative
+		// We need to flip them: purchases become expenses, payments become income
+		// Layouts with their own sign convention have already been handled, and
+		// separate cargo/abono columns already give each row its direction.
+		invert := sheet.InvertSigns && layout.SignConvention == SignAccount && layout.AmountMode != AmountModeDebitCredit
+		for j := range sheetTransactions {
+			if invert {
+				sheetTransactions[j].Type = invertType(sheetTransactions[j].Type)
+			}
+			sheetTransactions[j].Sheet = sheet.Sheet
+			sheetTransactions[j].AccountID = sheet.AccountID
+		}
+
+		transactions = append(transactions, sheetTransactions...)
+	}
+
+	return transactions, balanceChecks, nil
+}
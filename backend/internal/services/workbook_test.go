@@ -0,0 +1,68 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeStatement(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseWorkbookCreditCardSigns(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		invert    bool
+		wantTypes []string
+	}{
+		{
+			name: "single amount column is inverted for credit cards",
+			content: "Fecha,Descripcion,Importe\n" +
+				"05/03/2025,PLAZA VEA,125.40\n" +
+				"10/03/2025,PAGO TARJETA,-500.00\n",
+			invert:    true,
+			wantTypes: []string{"expense", "income"},
+		},
+		{
+			name: "single amount column keeps its signs for other accounts",
+			content: "Fecha,Descripcion,Importe\n" +
+				"05/03/2025,PLAZA VEA,-125.40\n" +
+				"10/03/2025,SUELDO,500.00\n",
+			invert:    false,
+			wantTypes: []string{"expense", "income"},
+		},
+		{
+			name: "cargo and abono columns already carry the direction",
+			content: "Fecha,Descripcion,Cargo,Abono\n" +
+				"05/03/2025,PLAZA VEA,125.40,\n" +
+				"10/03/2025,PAGO TARJETA,,500.00\n",
+			invert:    true,
+			wantTypes: []string{"expense", "income"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeStatement(t, "statement.csv", tt.content)
+			transactions, _, err := ParseWorkbook(path, BankConfigs["generic"], []SheetImport{{InvertSigns: tt.invert}})
+			if err != nil {
+				t.Fatalf("ParseWorkbook: %v", err)
+			}
+			if len(transactions) != len(tt.wantTypes) {
+				t.Fatalf("got %d transactions, want %d", len(transactions), len(tt.wantTypes))
+			}
+			for i, want := range tt.wantTypes {
+				if transactions[i].Type != want {
+					t.Errorf("transaction %d (%s) type = %s, want %s", i, transactions[i].Description, transactions[i].Type, want)
+				}
+			}
+		})
+	}
+}
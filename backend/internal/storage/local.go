@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// LocalStore keeps blobs on the filesystem as <dir>/ab/cd/<hash>
+type LocalStore struct {
+	dir string
+}
+
+// NewLocalStore creates the store, making dir if needed
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating blob directory: %w", err)
+	}
+	return &LocalStore{dir: dir}, nil
+}
+
+func (s *LocalStore) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash[2:4], hash)
+}
+
+// Put writes to a temporary file and renames it, so readers never see a partial blob
+func (s *LocalStore) Put(hash string, r io.Reader, size int64) error {
+	if err := validHash(hash); err != nil {
+		return err
+	}
+
+	path := s.path(hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating blob directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing blob: %w", err)
+	}
+	if written != size {
+		return fmt.Errorf("error writing blob: wrote %d of %d bytes", written, size)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalStore) Open(hash string) (io.ReadCloser, error) {
+	if err := validHash(hash); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.path(hash))
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+func (s *LocalStore) Exists(hash string) (bool, error) {
+	if err := validHash(hash); err != nil {
+		return false, err
+	}
+	_, err := os.Stat(s.path(hash))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
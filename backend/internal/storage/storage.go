@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ErrBlobNotFound is returned when no blob has the requested hash
+var ErrBlobNotFound = errors.New("blob not found")
+
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// BlobStore keeps files addressed by the SHA-256 of their content, so the same
+// statement uploaded twice is stored once and names never collide
+type BlobStore interface {
+	// Put stores size bytes read from r under hash. The caller guarantees hash
+	// is the content's SHA-256.
+	Put(hash string, r io.Reader, size int64) error
+	// Open returns the blob's content, or ErrBlobNotFound
+	Open(hash string) (io.ReadCloser, error)
+	// Exists reports whether a blob is stored under hash
+	Exists(hash string) (bool, error)
+}
+
+// Blobs is the configured store, set up by Init
+var Blobs BlobStore
+
+// Init configures Blobs from the environment: BLOB_STORE=local (default, under
+// BLOB_DIR) or BLOB_STORE=s3 for S3-compatible services such as MinIO
+func Init() error {
+	switch getEnv("BLOB_STORE", "local") {
+	case "local":
+		store, err := NewLocalStore(getEnv("BLOB_DIR", "./uploads/blobs"))
+		if err != nil {
+			return err
+		}
+		Blobs = store
+	case "s3":
+		store, err := NewS3Store(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    getEnv("S3_REGION", "us-east-1"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Prefix:    getEnv("S3_PREFIX", "statements/"),
+		})
+		if err != nil {
+			return err
+		}
+		Blobs = store
+	default:
+		return fmt.Errorf("BLOB_STORE must be 'local' or 's3'")
+	}
+	return nil
+}
+
+// SaveFile stores a file in the blob store and returns its SHA-256 and size.
+// Files already stored are not uploaded again.
+func SaveFile(store BlobStore, path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("error hashing file: %w", err)
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	exists, err := store.Exists(hash)
+	if err != nil {
+		return "", 0, err
+	}
+	if exists {
+		return hash, size, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	if err := store.Put(hash, f, size); err != nil {
+		return "", 0, err
+	}
+	return hash, size, nil
+}
+
+// FetchFile copies a blob into a new temporary directory as name (parsers
+// pick the format from the extension). cleanup removes the directory.
+func FetchFile(store BlobStore, hash string, name string) (string, func(), error) {
+	src, err := store.Open(hash)
+	if err != nil {
+		return "", nil, err
+	}
+	defer src.Close()
+
+	dir, err := os.MkdirTemp("", "statement-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	path := filepath.Join(dir, filepath.Base(name))
+	dst, err := os.Create(path)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("error reading blob: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return path, cleanup, nil
+}
+
+func validHash(hash string) error {
+	if !hashPattern.MatchString(hash) {
+		return fmt.Errorf("invalid blob hash %q", hash)
+	}
+	return nil
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,16 @@
+-- OFX/QFX statement import support
+-- OFX transactions carry a bank-assigned FITID that uniquely identifies them within an account
+
+-- Store the bank-assigned transaction ID
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS external_id VARCHAR(255);
+
+-- One external ID per account (used for duplicate detection on import)
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_account_external_id
+    ON transactions(account_id, external_id) WHERE external_id IS NOT NULL;
+
+-- Allow 'ofx' as an import file type
+ALTER TABLE imports DROP CONSTRAINT IF EXISTS imports_file_type_check;
+ALTER TABLE imports ADD CONSTRAINT imports_file_type_check
+    CHECK (file_type IN ('excel', 'image', 'ofx'));
+
+COMMENT ON COLUMN transactions.external_id IS 'Bank-assigned transaction ID (e.g., OFX FITID)';
@@ -0,0 +1,15 @@
+-- ISO 20022 camt.053/camt.052 and SWIFT MT940 statement import
+-- These formats carry value dates, counterparties and end-to-end references
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS value_date DATE;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS counterparty VARCHAR(255);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS reference VARCHAR(255);
+
+-- Allow the new statement formats as import file types
+ALTER TABLE imports DROP CONSTRAINT IF EXISTS imports_file_type_check;
+ALTER TABLE imports ADD CONSTRAINT imports_file_type_check
+    CHECK (file_type IN ('excel', 'image', 'ofx', 'camt', 'mt940'));
+
+COMMENT ON COLUMN transactions.value_date IS 'Value date reported by the bank (may differ from booking date)';
+COMMENT ON COLUMN transactions.counterparty IS 'Counterparty name from structured statements';
+COMMENT ON COLUMN transactions.reference IS 'End-to-end reference from structured statements';
@@ -0,0 +1,6 @@
+-- PDF bank statement import support
+
+-- Allow 'pdf' as an import file type
+ALTER TABLE imports DROP CONSTRAINT IF EXISTS imports_file_type_check;
+ALTER TABLE imports ADD CONSTRAINT imports_file_type_check
+    CHECK (file_type IN ('excel', 'image', 'ofx', 'camt', 'mt940', 'pdf'));
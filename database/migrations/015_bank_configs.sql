@@ -0,0 +1,27 @@
+-- User-defined bank layouts for spreadsheet imports
+-- Built-in layouts (bbva, generic) stay in code; these are resolved by numeric ID
+
+CREATE TABLE IF NOT EXISTS bank_configs (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    header_row INTEGER NOT NULL DEFAULT 0,
+    date_col INTEGER NOT NULL DEFAULT 0,
+    description_col INTEGER NOT NULL DEFAULT 1,
+    amount_col INTEGER NOT NULL DEFAULT -1,
+    debit_col INTEGER NOT NULL DEFAULT -1,
+    credit_col INTEGER NOT NULL DEFAULT -1,
+    amount_mode VARCHAR(20) NOT NULL DEFAULT 'single' CHECK (amount_mode IN ('single', 'debit_credit')),
+    date_format VARCHAR(20) NOT NULL DEFAULT 'auto',
+    currency_symbol VARCHAR(10) NOT NULL DEFAULT '',
+    currency VARCHAR(3) NOT NULL DEFAULT 'PEN',
+    sign_convention VARCHAR(20) NOT NULL DEFAULT 'account' CHECK (sign_convention IN ('account', 'normal', 'inverted')),
+    skip_patterns TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_bank_configs_user_id ON bank_configs(user_id);
+
+-- A user can't have two layouts with the same name
+CREATE UNIQUE INDEX IF NOT EXISTS idx_bank_configs_user_name ON bank_configs(user_id, name);
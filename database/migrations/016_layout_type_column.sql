@@ -0,0 +1,6 @@
+-- Optional income/expense label column for user-defined layouts
+-- header_row = -1 means the sheet has no header row
+
+ALTER TABLE bank_configs ADD COLUMN IF NOT EXISTS type_col INTEGER NOT NULL DEFAULT -1;
+
+COMMENT ON COLUMN bank_configs.type_col IS 'Column with an income/expense label (Cargo/Abono), -1 if unused';
@@ -0,0 +1,6 @@
+-- Running balance column for user-defined layouts
+-- Used to sanity-check each parsed row against the previous balance
+
+ALTER TABLE bank_configs ADD COLUMN IF NOT EXISTS balance_col INTEGER NOT NULL DEFAULT -1;
+
+COMMENT ON COLUMN bank_configs.balance_col IS 'Running balance (Saldo) column, -1 if unused';
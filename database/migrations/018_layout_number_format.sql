@@ -0,0 +1,7 @@
+-- Decimal separator for user-defined layouts
+-- 'auto' detects it per file; date_format also accepts the day/month orders dmy, mdy and ymd
+
+ALTER TABLE bank_configs ADD COLUMN IF NOT EXISTS number_format VARCHAR(20) NOT NULL DEFAULT 'auto'
+    CHECK (number_format IN ('auto', 'decimal_point', 'decimal_comma'));
+
+COMMENT ON COLUMN bank_configs.number_format IS 'auto, decimal_point (1,234.56) or decimal_comma (1.234,56)';
@@ -0,0 +1,49 @@
+-- Server-side staging of parsed import rows
+-- Uploads persist their parsed rows here; the user reviews them (across sessions)
+-- and the import is committed to transactions on the server
+
+-- Account the statement was uploaded for (sheets may still target other accounts)
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS account_id INTEGER REFERENCES accounts(id) ON DELETE SET NULL;
+
+-- 'staged' = parsed and waiting for review, 'completed' = committed
+ALTER TABLE imports DROP CONSTRAINT IF EXISTS imports_status_check;
+ALTER TABLE imports ADD CONSTRAINT imports_status_check
+    CHECK (status IN ('pending', 'processing', 'staged', 'completed', 'failed'));
+
+CREATE TABLE IF NOT EXISTS import_rows (
+    id SERIAL PRIMARY KEY,
+    import_id INTEGER NOT NULL REFERENCES imports(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'accepted', 'skipped', 'duplicate', 'error')),
+    error TEXT,
+    account_id INTEGER REFERENCES accounts(id) ON DELETE SET NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    detail TEXT,
+    amount DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    currency VARCHAR(3) NOT NULL DEFAULT 'PEN',
+    type VARCHAR(20) NOT NULL CHECK (type IN ('income', 'expense')),
+    date DATE,
+    value_date DATE,
+    raw_text TEXT,
+    external_id VARCHAR(255),
+    counterparty VARCHAR(255),
+    reference VARCHAR(255),
+    balance DECIMAL(14, 2),
+    balance_mismatch BOOLEAN NOT NULL DEFAULT FALSE,
+    sheet VARCHAR(100),
+    source_row INTEGER,
+    tag_ids INTEGER[] NOT NULL DEFAULT '{}',
+    suggested_tag_ids INTEGER[] NOT NULL DEFAULT '{}',
+    transaction_id INTEGER REFERENCES transactions(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_rows_import_position ON import_rows(import_id, position);
+CREATE INDEX IF NOT EXISTS idx_import_rows_import_status ON import_rows(import_id, status);
+
+COMMENT ON COLUMN import_rows.position IS 'Order of the row in the parsed statement';
+COMMENT ON COLUMN import_rows.date IS 'NULL when the date could not be parsed (status error)';
+COMMENT ON COLUMN import_rows.source_row IS '1-indexed spreadsheet row the movement came from';
+COMMENT ON COLUMN import_rows.transaction_id IS 'Transaction created when the import was committed';
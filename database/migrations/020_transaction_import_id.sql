@@ -0,0 +1,13 @@
+-- Import provenance: the import that created each transaction
+-- Lets the user list an import's transactions and revert a bad import
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS import_id INTEGER REFERENCES imports(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_transactions_import_id ON transactions(import_id) WHERE import_id IS NOT NULL;
+
+-- 'reverted' = the import's transactions were deleted
+ALTER TABLE imports DROP CONSTRAINT IF EXISTS imports_status_check;
+ALTER TABLE imports ADD CONSTRAINT imports_status_check
+    CHECK (status IN ('pending', 'processing', 'staged', 'completed', 'failed', 'reverted'));
+
+COMMENT ON COLUMN transactions.import_id IS 'Import that created the transaction (NULL for manual transactions)';
@@ -0,0 +1,31 @@
+-- Background jobs
+-- Long-running work (parsing large statements, duplicate detection, suggestions)
+-- runs outside the HTTP request; clients follow it through the job's progress
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued'
+        CHECK (status IN ('queued', 'running', 'completed', 'failed')),
+    stage VARCHAR(50) NOT NULL DEFAULT '',
+    progress INTEGER NOT NULL DEFAULT 0,
+    total INTEGER NOT NULL DEFAULT 0,
+    params JSONB NOT NULL DEFAULT '{}',
+    result JSONB,
+    row_errors JSONB NOT NULL DEFAULT '[]',
+    error TEXT,
+    import_id INTEGER REFERENCES imports(id) ON DELETE SET NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_queued ON jobs(id) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_jobs_user ON jobs(user_id, created_at DESC);
+
+COMMENT ON COLUMN jobs.stage IS 'Current step reported by the job, e.g. parsing, matching, staging';
+COMMENT ON COLUMN jobs.updated_at IS 'Heartbeat; running jobs that stop updating are requeued';
+COMMENT ON COLUMN jobs.row_errors IS 'Statement rows that could not be parsed: [{row, sheet, error}]';
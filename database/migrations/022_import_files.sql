@@ -0,0 +1,11 @@
+-- Original statement files
+-- Each import keeps a reference to its uploaded file, stored once in the blob
+-- store under the SHA-256 of its content (for audits and re-parsing)
+
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS file_hash VARCHAR(64);
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS file_size BIGINT;
+
+CREATE INDEX IF NOT EXISTS idx_imports_file_hash ON imports(user_id, file_hash) WHERE file_hash IS NOT NULL;
+
+COMMENT ON COLUMN imports.filename IS 'Name of the uploaded file';
+COMMENT ON COLUMN imports.file_hash IS 'SHA-256 of the original file, its key in the blob store (NULL for older imports)';
@@ -0,0 +1,7 @@
+-- Import parse parameters
+-- Each import remembers how its archived statement was parsed (bank, layout,
+-- sheets, formats), so it can be re-parsed with a newer parser and corrected
+
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS parse_params JSONB;
+
+COMMENT ON COLUMN imports.parse_params IS 'Options the statement was parsed with (NULL for older imports)';
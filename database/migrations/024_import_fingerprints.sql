@@ -0,0 +1,14 @@
+-- Statement fingerprints
+-- Besides the file hash, each import records a hash of its parsed rows and the
+-- period they cover, so the same statement (or an overlapping one for the same
+-- account) isn't imported twice
+
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS row_hash VARCHAR(64);
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS period_start DATE;
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS period_end DATE;
+
+CREATE INDEX IF NOT EXISTS idx_imports_row_hash ON imports(user_id, account_id, row_hash) WHERE row_hash IS NOT NULL;
+
+COMMENT ON COLUMN imports.row_hash IS 'SHA-256 of the sorted parsed rows (account, date, amount, type, currency)';
+COMMENT ON COLUMN imports.period_start IS 'Earliest movement date of the statement';
+COMMENT ON COLUMN imports.period_end IS 'Latest movement date of the statement';
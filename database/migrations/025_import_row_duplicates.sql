@@ -0,0 +1,9 @@
+-- Fuzzy duplicate detection
+-- Staged rows keep the saved transactions that may be the same movement and
+-- the confidence of the best match (amount, date distance, description, account)
+
+ALTER TABLE import_rows ADD COLUMN IF NOT EXISTS duplicate_confidence DECIMAL(4, 3) NOT NULL DEFAULT 0;
+ALTER TABLE import_rows ADD COLUMN IF NOT EXISTS duplicate_candidate_ids INTEGER[] NOT NULL DEFAULT '{}';
+
+COMMENT ON COLUMN import_rows.duplicate_confidence IS 'Score (0-1) of the saved transaction matched to the row; duplicate from 0.8';
+COMMENT ON COLUMN import_rows.duplicate_candidate_ids IS 'Saved transactions that may be the same movement, best first';
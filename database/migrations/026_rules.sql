@@ -0,0 +1,47 @@
+-- Rule-based auto-tagging
+-- User rules match transactions by description, amount range, type, account,
+-- currency and day of month, and add tags, set the detail, mark the movement
+-- as a transfer or ignore it. They run by priority on import, on manual
+-- creation and on demand over past transactions.
+
+CREATE TABLE IF NOT EXISTS rules (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    priority INTEGER NOT NULL DEFAULT 0,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    stop_processing BOOLEAN NOT NULL DEFAULT FALSE,
+    -- Conditions (NULL matches anything)
+    description_contains VARCHAR(255),
+    description_regex VARCHAR(255),
+    min_amount DECIMAL(12, 2),
+    max_amount DECIMAL(12, 2),
+    type VARCHAR(20) CHECK (type IN ('income', 'expense')),
+    account_id INTEGER REFERENCES accounts(id) ON DELETE CASCADE,
+    currency VARCHAR(3),
+    day_from INTEGER CHECK (day_from BETWEEN 1 AND 31),
+    day_to INTEGER CHECK (day_to BETWEEN 1 AND 31),
+    -- Actions
+    tag_ids INTEGER[] NOT NULL DEFAULT '{}',
+    set_detail TEXT,
+    mark_transfer BOOLEAN NOT NULL DEFAULT FALSE,
+    mark_ignored BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_rules_user_priority ON rules(user_id, priority);
+
+-- Flags set by rules (or by hand); both keep the transaction out of dashboard totals
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS is_transfer BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS ignored BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE import_rows ADD COLUMN IF NOT EXISTS is_transfer BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE import_rows ADD COLUMN IF NOT EXISTS rule_ids INTEGER[] NOT NULL DEFAULT '{}';
+
+COMMENT ON COLUMN rules.priority IS 'Rules run by ascending priority';
+COMMENT ON COLUMN rules.day_from IS 'Day of month range; wraps around the month end when day_from > day_to';
+COMMENT ON COLUMN rules.mark_ignored IS 'Matching import rows are skipped; other transactions are marked ignored';
+COMMENT ON COLUMN transactions.is_transfer IS 'Movement between own accounts, not income or expense';
+COMMENT ON COLUMN transactions.ignored IS 'Left out of dashboard totals';
+COMMENT ON COLUMN import_rows.rule_ids IS 'Rules that matched the row when it was staged';
@@ -0,0 +1,24 @@
+-- Merchants
+-- Canonical merchants behind raw bank descriptions. A description resolves to
+-- a merchant by its normalized key (see services.MerchantKey) or by a pattern;
+-- the merchant's default tags are suggested for its transactions.
+
+CREATE TABLE IF NOT EXISTS merchants (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    aliases TEXT[] NOT NULL DEFAULT '{}',
+    patterns TEXT[] NOT NULL DEFAULT '{}',
+    default_tag_ids INTEGER[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, name)
+);
+
+COMMENT ON COLUMN merchants.aliases IS 'Normalized description keys of the merchant; a description matches when its key is an alias or starts with one';
+COMMENT ON COLUMN merchants.patterns IS 'Case-insensitive regular expressions matched against the raw description';
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS merchant_id INTEGER REFERENCES merchants(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_transactions_merchant ON transactions(merchant_id);
+
+ALTER TABLE import_rows ADD COLUMN IF NOT EXISTS merchant_id INTEGER REFERENCES merchants(id) ON DELETE SET NULL;
@@ -0,0 +1,28 @@
+-- Transfers between own accounts
+-- Pairs an expense in one account with the income it produced in another
+-- (e.g. paying the credit card from savings). Detected pairs are suggested
+-- until the user confirms or rejects them; confirmed transfers mark both
+-- transactions as is_transfer, which keeps them out of dashboard totals.
+
+CREATE TABLE IF NOT EXISTS transfers (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    from_transaction_id INTEGER NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    to_transaction_id INTEGER NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'suggested' CHECK (status IN ('suggested', 'confirmed', 'rejected')),
+    source VARCHAR(20) NOT NULL DEFAULT 'detected' CHECK (source IN ('detected', 'manual')),
+    score DECIMAL(4, 3) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(from_transaction_id, to_transaction_id),
+    CHECK (from_transaction_id <> to_transaction_id)
+);
+
+-- A transaction takes part in at most one transfer that wasn't rejected
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transfers_from_active ON transfers(from_transaction_id) WHERE status <> 'rejected';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transfers_to_active ON transfers(to_transaction_id) WHERE status <> 'rejected';
+CREATE INDEX IF NOT EXISTS idx_transfers_user_status ON transfers(user_id, status);
+
+COMMENT ON COLUMN transfers.from_transaction_id IS 'Expense side, in the account the money left';
+COMMENT ON COLUMN transfers.to_transaction_id IS 'Income side, in the account the money arrived';
+COMMENT ON COLUMN transfers.score IS 'Detection score (0-1): amount, date distance and description hints; 1 for manual transfers';
@@ -0,0 +1,35 @@
+-- Transaction splits
+-- A transaction can be split into line items with their own amount, detail
+-- and tags (e.g. a supermarket bill split into groceries and household). The
+-- splits of a transaction add up to its amount.
+
+CREATE TABLE IF NOT EXISTS transaction_splits (
+    id SERIAL PRIMARY KEY,
+    transaction_id INTEGER NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL CHECK (amount > 0),
+    detail TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_transaction_splits_transaction ON transaction_splits(transaction_id);
+
+CREATE TABLE IF NOT EXISTS split_tags (
+    split_id INTEGER REFERENCES transaction_splits(id) ON DELETE CASCADE,
+    tag_id INTEGER REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (split_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_split_tags_tag ON split_tags(tag_id);
+
+-- Amount of each transaction attributed to each tag: split transactions count
+-- each split under its own tags, other transactions count in full under theirs
+CREATE OR REPLACE VIEW transaction_tag_amounts AS
+SELECT tt.transaction_id, tt.tag_id, t.amount, NULL::INTEGER AS split_id
+FROM transaction_tags tt
+JOIN transactions t ON t.id = tt.transaction_id
+WHERE NOT EXISTS (SELECT 1 FROM transaction_splits s WHERE s.transaction_id = tt.transaction_id)
+UNION ALL
+SELECT s.transaction_id, st.tag_id, s.amount, s.id AS split_id
+FROM transaction_splits s
+JOIN split_tags st ON st.split_id = s.id;
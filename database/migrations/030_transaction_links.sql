@@ -0,0 +1,49 @@
+-- Reimbursement links
+-- Replaces transactions.linked_to: an expense can be reimbursed by several
+-- incomes and an income can reimburse several expenses (e.g. a dinner paid
+-- back by three friends, or an insurance payout covering two bills). Each link
+-- allocates part of both amounts; the allocations of a transaction never add
+-- up to more than its amount.
+
+CREATE TABLE IF NOT EXISTS transaction_links (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    expense_id INTEGER NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    income_id INTEGER NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    amount DECIMAL(12, 2) NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(expense_id, income_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_transaction_links_income ON transaction_links(income_id);
+CREATE INDEX IF NOT EXISTS idx_transaction_links_user ON transaction_links(user_id);
+
+COMMENT ON COLUMN transaction_links.amount IS 'Part of both the expense and the income covered by the link';
+
+-- Existing 1:1 links become a link allocating the smaller of the two amounts
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_name = 'transactions' AND column_name = 'linked_to') THEN
+        INSERT INTO transaction_links (user_id, expense_id, income_id, amount)
+        SELECT e.user_id, e.id, i.id, LEAST(e.amount, i.amount)
+        FROM transactions e
+        JOIN transactions i ON e.linked_to = i.id
+        WHERE e.type = 'expense' AND i.type = 'income' AND LEAST(e.amount, i.amount) > 0
+        ON CONFLICT (expense_id, income_id) DO NOTHING;
+    END IF;
+END $$;
+
+DROP INDEX IF EXISTS idx_transactions_linked_to;
+ALTER TABLE transactions DROP COLUMN IF EXISTS linked_to;
+
+-- Amount of each transaction allocated to its links, on either side
+CREATE OR REPLACE VIEW transaction_link_totals AS
+SELECT transaction_id, SUM(amount) AS allocated
+FROM (
+    SELECT expense_id AS transaction_id, amount FROM transaction_links
+    UNION ALL
+    SELECT income_id AS transaction_id, amount FROM transaction_links
+) l
+GROUP BY transaction_id;
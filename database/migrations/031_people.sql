@@ -0,0 +1,65 @@
+-- People and shared expenses
+-- People the user shares expenses with. Part of a transaction (or an expense
+-- someone else paid) can be owed by or to a person; settlements record the
+-- payments that square it, optionally pointing to the actual transfer. The
+-- balance with a person is the sum of both, per currency.
+
+CREATE TABLE IF NOT EXISTS people (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    note TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS shared_expenses (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    person_id INTEGER NOT NULL REFERENCES people(id) ON DELETE CASCADE,
+    transaction_id INTEGER REFERENCES transactions(id) ON DELETE CASCADE,
+    direction VARCHAR(10) NOT NULL CHECK (direction IN ('owes_me', 'i_owe')),
+    amount DECIMAL(12, 2) NOT NULL CHECK (amount > 0),
+    currency VARCHAR(3) NOT NULL DEFAULT 'PEN',
+    date DATE NOT NULL,
+    description TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(transaction_id, person_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_shared_expenses_person ON shared_expenses(person_id, date);
+
+COMMENT ON COLUMN shared_expenses.transaction_id IS 'Transaction of the user the share is part of; NULL when someone else paid';
+COMMENT ON COLUMN shared_expenses.direction IS 'owes_me: the person owes the amount to the user; i_owe: the user owes it to the person';
+
+CREATE TABLE IF NOT EXISTS settlements (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    person_id INTEGER NOT NULL REFERENCES people(id) ON DELETE CASCADE,
+    direction VARCHAR(10) NOT NULL CHECK (direction IN ('received', 'paid')),
+    amount DECIMAL(12, 2) NOT NULL CHECK (amount > 0),
+    currency VARCHAR(3) NOT NULL DEFAULT 'PEN',
+    date DATE NOT NULL,
+    transaction_id INTEGER UNIQUE REFERENCES transactions(id) ON DELETE SET NULL,
+    note TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_settlements_person ON settlements(person_id, date);
+
+COMMENT ON COLUMN settlements.direction IS 'received: the person paid the user; paid: the user paid the person';
+COMMENT ON COLUMN settlements.transaction_id IS 'Transfer (Yape, Plin, bank) that paid the settlement';
+
+-- Every shared expense and settlement with a person, signed so that a positive
+-- amount increases what the person owes the user
+CREATE OR REPLACE VIEW person_movements AS
+SELECT s.user_id, s.person_id, 'shared_expense' AS kind, s.id, s.date, s.currency,
+       CASE WHEN s.direction = 'owes_me' THEN s.amount ELSE -s.amount END AS amount,
+       s.description, s.transaction_id, s.created_at
+FROM shared_expenses s
+UNION ALL
+SELECT st.user_id, st.person_id, 'settlement' AS kind, st.id, st.date, st.currency,
+       CASE WHEN st.direction = 'paid' THEN st.amount ELSE -st.amount END AS amount,
+       st.note AS description, st.transaction_id, st.created_at
+FROM settlements st;
@@ -0,0 +1,59 @@
+-- Recurring transactions
+-- Templates for charges and incomes that repeat on a schedule (rent on day 5,
+-- a gym every 2 weeks, a yearly insurance). Each occurrence either creates
+-- its transaction when due or is expected until an imported or manual
+-- transaction matches it. Detected templates are suggested until the user
+-- accepts or dismisses them.
+
+CREATE TABLE IF NOT EXISTS recurring_templates (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    description TEXT NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL CHECK (amount > 0),
+    currency VARCHAR(3) NOT NULL DEFAULT 'PEN',
+    type VARCHAR(10) NOT NULL CHECK (type IN ('income', 'expense')),
+    account_id INTEGER REFERENCES accounts(id) ON DELETE SET NULL,
+    merchant_id INTEGER REFERENCES merchants(id) ON DELETE SET NULL,
+    tag_ids INTEGER[] NOT NULL DEFAULT '{}',
+    frequency VARCHAR(10) NOT NULL CHECK (frequency IN ('weekly', 'monthly', 'yearly')),
+    every INTEGER NOT NULL DEFAULT 1 CHECK (every BETWEEN 1 AND 12),
+    day_of_month INTEGER CHECK (day_of_month BETWEEN 1 AND 31),
+    month INTEGER CHECK (month BETWEEN 1 AND 12),
+    start_date DATE NOT NULL,
+    end_date DATE,
+    mode VARCHAR(10) NOT NULL DEFAULT 'expect' CHECK (mode IN ('create', 'expect')),
+    amount_tolerance DECIMAL(5, 4) NOT NULL DEFAULT 0.1,
+    status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'paused', 'suggested', 'dismissed')),
+    resumed_on DATE,
+    source VARCHAR(20) NOT NULL DEFAULT 'manual' CHECK (source IN ('manual', 'detected')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_recurring_templates_user_status ON recurring_templates(user_id, status);
+
+COMMENT ON COLUMN recurring_templates.description IS 'Description of created transactions; expected occurrences match transactions with the same merchant or description key';
+COMMENT ON COLUMN recurring_templates.every IS 'Every how many weeks, months or years';
+COMMENT ON COLUMN recurring_templates.day_of_month IS 'Monthly and yearly: day of the occurrence, moved to the last day of shorter months';
+COMMENT ON COLUMN recurring_templates.month IS 'Yearly: month of the occurrence';
+COMMENT ON COLUMN recurring_templates.start_date IS 'First possible occurrence; weekly schedules repeat from its weekday';
+COMMENT ON COLUMN recurring_templates.mode IS 'create: create the transaction when due; expect: wait for a matching transaction';
+COMMENT ON COLUMN recurring_templates.resumed_on IS 'Last time the template was resumed after a pause; nothing is generated for the paused period'
+COMMENT ON COLUMN recurring_templates.amount_tolerance IS 'Relative difference allowed between the expected and the matched amount';
+
+CREATE TABLE IF NOT EXISTS recurring_occurrences (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    template_id INTEGER NOT NULL REFERENCES recurring_templates(id) ON DELETE CASCADE,
+    due_date DATE NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'expected' CHECK (status IN ('expected', 'matched', 'created', 'skipped')),
+    transaction_id INTEGER REFERENCES transactions(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(template_id, due_date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_recurring_occurrences_expected ON recurring_occurrences(user_id, due_date) WHERE status = 'expected';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_recurring_occurrences_transaction ON recurring_occurrences(transaction_id) WHERE transaction_id IS NOT NULL;
@@ -0,0 +1,14 @@
+-- Keep the two sources of transactions.is_transfer apart
+-- Rules and manual edits set marked_transfer; is_transfer is that flag or a
+-- confirmed transfer, so rejecting a transfer doesn't clear a rule's mark.
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS marked_transfer BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE transactions t SET marked_transfer = TRUE
+WHERE t.is_transfer AND NOT EXISTS (
+    SELECT 1 FROM transfers tr
+    WHERE tr.status = 'confirmed' AND t.id IN (tr.from_transaction_id, tr.to_transaction_id)
+);
+
+COMMENT ON COLUMN transactions.marked_transfer IS 'Marked as a transfer by a rule or by hand, apart from confirmed transfers';
+COMMENT ON COLUMN transactions.is_transfer IS 'marked_transfer or part of a confirmed transfer; left out of totals';
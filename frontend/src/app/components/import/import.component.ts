@@ -15,8 +15,9 @@ import { MatCheckboxModule } from '@angular/material/checkbox';
 import { MatMenuModule } from '@angular/material/menu';
 import { MatDialog, MatDialogModule } from '@angular/material/dialog';
 import { RouterLink } from '@angular/router';
+import { switchMap, takeWhile, timer } from 'rxjs';
 import { ApiService } from '../../services/api.service';
-import { Tag, ParsedTransaction, Account } from '../../models/models';
+import { Tag, ParsedTransaction, Account, ImportResponse } from '../../models/models';
 import { SplitTransactionDialogComponent, SplitDialogResult } from './split-transaction-dialog.component';
 
 export const TAG_COLORS = [
@@ -161,17 +162,20 @@ interface Bank {
                 #fileInput
                 type="file"
                 hidden
-                accept=".xlsx,.xls,.csv"
+                accept=".xlsx,.xls,.csv,.ofx,.qfx,.xml,.sta,.940,.pdf,.png,.jpg,.jpeg"
                 (change)="onFileSelected($event)"
               />
 
               @if (uploading()) {
                 <mat-spinner diameter="48"></mat-spinner>
                 <p>Procesando archivo...</p>
+                @if (uploadProgress()) {
+                  <span class="file-types">{{ uploadProgress() }}</span>
+                }
               } @else {
                 <mat-icon>cloud_upload</mat-icon>
                 <p>Arrastra tu archivo Excel aquí o haz clic para seleccionar</p>
-                <span class="file-types">Formatos: .xlsx, .xls, .csv</span>
+                <span class="file-types">Formatos: .xlsx, .xls, .csv, .ofx, .qfx, .xml, .sta, .pdf, .png, .jpg</span>
               }
             </div>
 
@@ -865,6 +869,15 @@ export class ImportComponent implements OnInit {
 
   step = signal(1);
   uploading = signal(false);
+  uploadProgress = signal('');
+
+  private jobStageLabels: Record<string, string> = {
+    parsing: 'Leyendo archivo',
+    matching: 'Buscando duplicados y sugerencias',
+    staging: 'Guardando filas',
+    done: 'Listo'
+  };
+
   saving = signal(false);
   error = signal('');
   isDragging = signal(false);
@@ -959,31 +972,26 @@ export class ImportComponent implements OnInit {
   processFile(file: File) {
     this.uploading.set(true);
     this.error.set('');
-
-    this.apiService.uploadFile(file, this.selectedBank(), this.selectedAccount()).subscribe({
-      next: (response) => {
-        this.importId.set(response.import_id);
-
-        // Apply suggested tags and detail automatically from previous transactions
-        const transactionsWithSuggestions = response.transactions.map((tx: any) => ({
-          ...tx,
-          tag_ids: tx.suggested_tag_ids || tx.tag_ids || [],
-          detail: tx.detail || tx.suggested_detail || null
-        }));
-
-        // Sort: new transactions first, then duplicates
-        transactionsWithSuggestions.sort((a: any, b: any) => {
-          if (a.is_duplicate === b.is_duplicate) return 0;
-          return a.is_duplicate ? 1 : -1;
-        });
-
-        this.parsedTransactions.set(transactionsWithSuggestions);
-        this.uploading.set(false);
-
-        if (response.transactions.length === 0) {
-          this.error.set('No se encontraron transacciones en el archivo. Verifica el formato.');
-        } else {
-          this.step.set(3);
+    this.uploadProgress.set('');
+
+    // Large statements are processed in the background; poll the job until it finishes
+    this.apiService.uploadFile(file, this.selectedBank(), this.selectedAccount()).pipe(
+      switchMap(({ job_id }) => timer(0, 1000).pipe(switchMap(() => this.apiService.getJob(job_id)))),
+      takeWhile(job => job.status === 'queued' || job.status === 'running', true)
+    ).subscribe({
+      next: (job) => {
+        if (job.status === 'failed') {
+          this.uploading.set(false);
+          this.error.set(job.error || 'Error al procesar el archivo');
+        } else if (job.status === 'completed' && job.result?.status === 'rejected') {
+          this.uploading.set(false);
+          this.error.set(job.result.error || 'Este estado de cuenta ya fue importado');
+        } else if (job.status === 'completed' && job.result) {
+          this.showParsedTransactions(job.result);
+        } else if (job.total > 0) {
+          this.uploadProgress.set(`${this.jobStageLabels[job.stage] || job.stage}: ${job.progress} / ${job.total}`);
+        } else if (job.stage) {
+          this.uploadProgress.set(this.jobStageLabels[job.stage] || job.stage);
         }
       },
       error: (err) => {
@@ -993,6 +1001,34 @@ export class ImportComponent implements OnInit {
     });
   }
 
+  private showParsedTransactions(response: ImportResponse) {
+    this.importId.set(response.import_id);
+
+    // Apply suggested tags and detail automatically from previous transactions
+    const transactionsWithSuggestions = response.transactions.map((tx: any) => ({
+      ...tx,
+      tag_ids: tx.suggested_tag_ids || tx.tag_ids || [],
+      detail: tx.detail || tx.suggested_detail || null,
+      // Rows a rule marked as ignored are skipped like duplicates
+      is_duplicate: tx.is_duplicate || tx.ignored
+    }));
+
+    // Sort: new transactions first, then duplicates
+    transactionsWithSuggestions.sort((a: any, b: any) => {
+      if (a.is_duplicate === b.is_duplicate) return 0;
+      return a.is_duplicate ? 1 : -1;
+    });
+
+    this.parsedTransactions.set(transactionsWithSuggestions);
+    this.uploading.set(false);
+
+    if (response.transactions.length === 0) {
+      this.error.set('No se encontraron transacciones en el archivo. Verifica el formato.');
+    } else {
+      this.step.set(3);
+    }
+  }
+
   taggedProgress(): number {
     const nonDuplicates = this.parsedTransactions().filter(t => !t.is_duplicate);
     const total = nonDuplicates.length;
@@ -1168,6 +1204,7 @@ export class ImportComponent implements OnInit {
       type: originalTransaction.type,
       date: originalTransaction.date,
       raw_text: originalTransaction.raw_text,
+      row_id: originalTransaction.row_id, // The server stages each part as its own row
       tag_ids: part.tag_ids
     }));
 
@@ -309,8 +309,10 @@ export class LinkTransactionDialogComponent implements OnInit {
 
   get netAmount(): number {
     if (!this.selectedTransaction) return 0;
-    const expense = this.data.type === 'expense' ? this.data.amount : this.selectedTransaction.amount;
-    const income = this.data.type === 'income' ? this.data.amount : this.selectedTransaction.amount;
+    // Only what's left of each side after its other links
+    const left = (tx: Transaction) => tx.amount - (tx.linked_amount || 0);
+    const expense = this.data.type === 'expense' ? left(this.data) : left(this.selectedTransaction);
+    const income = this.data.type === 'income' ? left(this.data) : left(this.selectedTransaction);
     return income - expense;
   }
 
@@ -319,14 +321,14 @@ export class LinkTransactionDialogComponent implements OnInit {
   }
 
   loadTransactions() {
-    // Load transactions of opposite type that are not already linked
+    // Load transactions of opposite type with an amount left to link
     const oppositeType = this.data.type === 'expense' ? 'income' : 'expense';
 
     this.apiService.getTransactions({ type: oppositeType }).subscribe({
       next: (transactions) => {
-        // Filter out already linked transactions and the current transaction
+        // Filter out fully linked transactions, other currencies and the current transaction
         const available = transactions.filter(tx =>
-          !tx.linked_to && tx.id !== this.data.id
+          (tx.linked_amount || 0) < tx.amount && tx.currency === this.data.currency && tx.id !== this.data.id
         );
         this.allTransactions.set(available);
         this.filteredTransactions.set(available);
@@ -193,7 +193,7 @@ interface DateFilter {
                 </div>
                 <div class="tx-meta">
                   <span class="tx-tags">
-                    @if (tx.linked_to) {
+                    @if (tx.linked_amount) {
                       <span class="linked-badge" title="Vinculada a otra transacción">
                         <mat-icon>link</mat-icon>
                         Vinculada
@@ -203,7 +203,7 @@ interface DateFilter {
                       @for (tag of tx.tags; track tag.id) {
                         <span class="tag-badge" [style.background-color]="tag.color">{{ tag.name }}</span>
                       }
-                    } @else if (!tx.linked_to) {
+                    } @else if (!tx.linked_amount) {
                       <span class="no-tags">Sin tags</span>
                     }
                   </span>
@@ -218,12 +218,13 @@ interface DateFilter {
                   <mat-icon>edit</mat-icon>
                   Editar
                 </button>
-                @if (tx.linked_to) {
+                @if (tx.linked_amount) {
                   <button mat-menu-item (click)="unlinkTransaction(tx)">
                     <mat-icon>link_off</mat-icon>
                     Desvincular
                   </button>
-                } @else {
+                }
+                @if ((tx.linked_amount || 0) < tx.amount) {
                   <button mat-menu-item (click)="openLinkDialog(tx)">
                     <mat-icon>link</mat-icon>
                     Vincular
@@ -30,15 +30,49 @@ export interface Transaction {
   type: 'income' | 'expense';
   date: string;
   source: string;
+  import_id?: number; // Import that created the transaction
   raw_text?: string;
-  linked_to?: number; // ID of linked transaction (for reimbursements)
-  linked_transaction?: Transaction; // The linked transaction details
+  linked_amount?: number; // Part of the amount linked to reimbursements
+  is_transfer?: boolean; // Movement between own accounts, left out of totals
+  ignored?: boolean; // Left out of totals
+  merchant_id?: number;
+  merchant?: string; // Merchant name
   created_at: string;
   updated_at: string;
   tags: Tag[];
+  splits?: TransactionSplit[]; // Parts of a split transaction, adding up to its amount
+  tag_amount?: number; // Part of the amount under the tag filter
   account?: Account;
 }
 
+export interface TransactionLinkSide {
+  transaction_id: number;
+  account_id?: number;
+  account_name?: string;
+  description: string;
+  amount: number;
+  currency: string;
+  date: string;
+}
+
+// Allocates part of an expense to an income that reimburses it
+export interface TransactionLink {
+  id: number;
+  amount: number;
+  expense: TransactionLinkSide;
+  income: TransactionLinkSide;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface TransactionSplit {
+  id: number;
+  transaction_id: number;
+  amount: number;
+  detail?: string;
+  tags: Tag[];
+}
+
 export interface DashboardSummary {
   total_income: number;
   total_expense: number;
@@ -46,6 +80,7 @@ export interface DashboardSummary {
   transaction_count: number;
   by_tag: TagSummary[];
   recent_transactions: Transaction[];
+  upcoming: UpcomingCharge[];
 }
 
 export interface TagSummary {
@@ -67,11 +102,199 @@ export interface ParsedTransaction {
   type: 'income' | 'expense';
   date: string;
   raw_text: string;
+  external_id?: string;
+  value_date?: string;
+  counterparty?: string;
+  reference?: string;
+  balance?: number;
+  balance_mismatch?: boolean;
+  row?: number;
+  error?: string;
+  sheet?: string;
+  account_id?: number;
   tag_ids?: number[];
   suggested_tag_ids?: number[];
   suggested_detail?: string;
-  is_duplicate?: boolean;
+  is_duplicate?: boolean; // duplicate_confidence >= 0.8
+  duplicate_confidence?: number; // 0-1
+  duplicate_candidates?: DuplicateCandidate[];
   existing_tag_ids?: number[];
+  rule_ids?: number[]; // Rules that matched the row
+  tag_predictions?: TagPrediction[]; // From the learned classifier, most likely first
+  merchant_id?: number; // Known merchant; new ones are created on commit
+  merchant_name?: string;
+  is_transfer?: boolean;
+  ignored?: boolean;
+  row_id?: number;
+  status?: 'pending' | 'accepted' | 'skipped' | 'duplicate' | 'error';
+}
+
+// Expense in one account paired with the income it produced in another
+export interface Transfer {
+  id: number;
+  status: 'suggested' | 'confirmed' | 'rejected';
+  source: 'detected' | 'manual';
+  score: number;
+  from: TransferSide;
+  to: TransferSide;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface TransferSide {
+  transaction_id: number;
+  account_id?: number;
+  account_name?: string;
+  description: string;
+  amount: number;
+  currency: string;
+  date: string;
+}
+
+// Canonical merchant behind bank descriptions
+export interface Merchant {
+  id: number;
+  name: string;
+  aliases: string[];
+  patterns: string[];
+  default_tag_ids: number[];
+  created_at: string;
+  updated_at: string;
+}
+
+// Someone the user shares expenses with
+export interface Person {
+  id: number;
+  name: string;
+  note?: string;
+  balances: PersonBalance[]; // Only currencies not settled
+  created_at: string;
+  updated_at: string;
+}
+
+// Positive: the person owes the user; negative: the user owes the person
+export interface PersonBalance {
+  currency: string;
+  balance: number;
+}
+
+export interface SharedExpense {
+  id: number;
+  person_id: number;
+  person_name: string;
+  transaction_id?: number; // Absent when someone else paid
+  direction: 'owes_me' | 'i_owe';
+  amount: number;
+  currency: string;
+  date: string;
+  description: string;
+  created_at: string;
+}
+
+export interface Settlement {
+  id: number;
+  person_id: number;
+  direction: 'received' | 'paid';
+  amount: number;
+  currency: string;
+  date: string;
+  transaction_id?: number; // Transfer that paid it
+  note?: string;
+  created_at: string;
+}
+
+export interface PersonMovement {
+  kind: 'shared_expense' | 'settlement';
+  id: number;
+  date: string;
+  currency: string;
+  amount: number; // Positive when it increases what the person owes
+  balance: number; // Balance in the currency after the movement
+  description?: string;
+  transaction_id?: number;
+  created_at: string;
+}
+
+export interface RecurringTemplate {
+  id: number;
+  name: string;
+  description: string;
+  amount: number;
+  currency: string;
+  type: 'income' | 'expense';
+  account_id?: number;
+  merchant_id?: number;
+  tag_ids: number[];
+  frequency: 'weekly' | 'monthly' | 'yearly';
+  every: number;
+  day_of_month?: number; // Monthly and yearly
+  month?: number; // Yearly
+  start_date: string;
+  end_date?: string;
+  mode: 'create' | 'expect';
+  amount_tolerance: number;
+  status: 'active' | 'paused' | 'suggested' | 'dismissed';
+  resumed_on?: string; // Nothing is generated for the paused period before it
+  source: 'manual' | 'detected';
+  next_date?: string;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface RecurringOccurrence {
+  id: number;
+  template_id: number;
+  due_date: string;
+  amount: number;
+  status: 'expected' | 'matched' | 'created' | 'skipped';
+  transaction_id?: number;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface UpcomingCharge {
+  template_id: number;
+  name: string;
+  amount: number;
+  currency: string;
+  type: 'income' | 'expense';
+  date: string;
+  mode: 'create' | 'expect';
+}
+
+export interface MerchantSpending {
+  merchant_id: number;
+  name: string;
+  total: number;
+  total_pen: number;
+  total_usd: number;
+  count: number;
+  last_date: string;
+}
+
+// Tag predicted from the user's tagging history
+export interface TagPrediction {
+  tag_id: number;
+  probability: number; // 0-1
+}
+
+// Saved transaction that may be the same movement as an imported row
+export interface DuplicateCandidate {
+  transaction_id: number;
+  score: number;
+  description: string;
+  date: string;
+  account_id?: number;
+}
+
+export interface BalanceCheck {
+  account: string;
+  currency: string;
+  opening_balance: number;
+  closing_balance: number;
+  computed_closing: number;
+  difference: number;
+  matches: boolean;
 }
 
 export interface ImportResponse {
@@ -79,6 +302,40 @@ export interface ImportResponse {
   transactions: ParsedTransaction[];
   count: number;
   message: string;
+  balance_checks?: BalanceCheck[];
+  status?: 'staged' | 'rejected'; // rejected: the statement was already imported
+  error?: string;
+  statement_matches?: StatementMatch[];
+}
+
+// Earlier import of the same statement, or of an overlapping period of the account
+export interface StatementMatch {
+  import_id: number;
+  filename: string;
+  status: string;
+  period_start?: string;
+  period_end?: string;
+  created_at: string;
+  reason: 'same_file' | 'same_rows' | 'overlapping_period';
+}
+
+export interface RowError {
+  row: number;
+  sheet?: string;
+  error: string;
+}
+
+export interface Job<T = any> {
+  id: number;
+  kind: string;
+  status: 'queued' | 'running' | 'completed' | 'failed';
+  stage: string; // parsing, matching, staging, done
+  progress: number;
+  total: number;
+  import_id?: number;
+  row_errors: RowError[];
+  result?: T;
+  error?: string;
 }
 
 export interface Import {
@@ -89,6 +346,7 @@ export interface Import {
   total_transactions: number;
   processed_transactions: number;
   created_at: string;
+  has_file?: boolean; // Original file kept, downloadable from /imports/:id/file
 }
 
 export interface Account {
@@ -5,9 +5,11 @@ import { environment } from '../../environments/environment';
 import {
   Tag,
   Transaction,
+  TransactionLink,
   DashboardSummary,
   ImportResponse,
   Import,
+  Job,
   Account,
   AccountBalance
 } from '../models/models';
@@ -89,13 +91,28 @@ export class ApiService {
     });
   }
 
-  linkTransactions(transactionId1: number, transactionId2: number): Observable<{ message: string }> {
-    return this.http.post<{ message: string }>(`${this.apiUrl}/transactions/link`, {
+  // Without an amount, the link covers as much as both transactions have left
+  linkTransactions(transactionId1: number, transactionId2: number, amount?: number): Observable<TransactionLink> {
+    return this.http.post<TransactionLink>(`${this.apiUrl}/transactions/link`, {
       transaction_id_1: transactionId1,
-      transaction_id_2: transactionId2
+      transaction_id_2: transactionId2,
+      amount
     });
   }
 
+  getTransactionLinks(transactionId: number): Observable<TransactionLink[]> {
+    return this.http.get<TransactionLink[]>(`${this.apiUrl}/transactions/${transactionId}/links`);
+  }
+
+  updateTransactionLink(linkId: number, amount: number): Observable<TransactionLink> {
+    return this.http.put<TransactionLink>(`${this.apiUrl}/transaction-links/${linkId}`, { amount });
+  }
+
+  deleteTransactionLink(linkId: number): Observable<{ message: string }> {
+    return this.http.delete<{ message: string }>(`${this.apiUrl}/transaction-links/${linkId}`);
+  }
+
+  // Removes all the links of the transaction
   unlinkTransaction(transactionId: number): Observable<{ message: string }> {
     return this.http.delete<{ message: string }>(`${this.apiUrl}/transactions/${transactionId}/link`);
   }
@@ -141,12 +158,18 @@ export class ApiService {
   }
 
   // Import
-  uploadFile(file: File, bankId: string, accountId: number): Observable<ImportResponse> {
+  // Queues the file for background processing; follow it with getJob
+  uploadFile(file: File, bankId: string, accountId: number): Observable<{ job_id: number }> {
     const formData = new FormData();
     formData.append('file', file);
     formData.append('bank', bankId);
     formData.append('account_id', accountId.toString());
-    return this.http.post<ImportResponse>(`${this.apiUrl}/import/upload`, formData);
+    formData.append('async', 'true');
+    return this.http.post<{ job_id: number }>(`${this.apiUrl}/import/upload`, formData);
+  }
+
+  getJob(id: number): Observable<Job<ImportResponse>> {
+    return this.http.get<Job<ImportResponse>>(`${this.apiUrl}/jobs/${id}`);
   }
 
   confirmImport(importId: number, accountId: number, transactions: any[]): Observable<any> {